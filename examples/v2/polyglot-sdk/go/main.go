@@ -1,11 +1,22 @@
+// Command greeter implements the greeter world from ../wit/greeter.wit.
+// Build the component with `make` (see Makefile).
 package main
 
-import "fmt"
+//go:generate go run go.bytecodealliance.org/cmd/wit-bindgen-go@v0.6.2 generate -o internal ../wit
+
+import (
+	"fmt"
+
+	"greeter/internal/run/greeter/greeter"
+)
+
+func init() {
+	greeter.Exports.Greet = greet
+}
 
-// greet is a placeholder function for componentization.
-// Bind to the WIT world using your preferred toolchain.
 func greet(name string) string {
 	return fmt.Sprintf("hello %s from go", name)
 }
 
+// main is unused: the component is a library whose only entry point is greet.
 func main() {}
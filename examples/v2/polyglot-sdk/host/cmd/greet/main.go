@@ -0,0 +1,36 @@
+// Command greet loads a greeter component and prints its greeting:
+//
+//	go run ./cmd/greet ../go/greeter.module.wasm world
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Esubaalew/run/examples/v2/polyglot-sdk/host"
+)
+
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		fmt.Fprintln(os.Stderr, "usage: greet <component.wasm> [name]")
+		os.Exit(2)
+	}
+	name := "world"
+	if len(os.Args) == 3 {
+		name = os.Args[2]
+	}
+
+	g, err := host.LoadComponent(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "greet:", err)
+		os.Exit(1)
+	}
+	defer g.Close()
+
+	greeting, err := g.Greet(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "greet:", err)
+		os.Exit(1)
+	}
+	fmt.Println(greeting)
+}
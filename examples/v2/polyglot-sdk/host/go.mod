@@ -0,0 +1,5 @@
+module github.com/Esubaalew/run/examples/v2/polyglot-sdk/host
+
+go 1.22
+
+require github.com/tetratelabs/wazero v1.8.2
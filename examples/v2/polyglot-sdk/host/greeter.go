@@ -0,0 +1,235 @@
+// Package host loads the polyglot SDK's greeter components and calls their
+// shared greet export, so every language binding has one driver.
+//
+// wazero runs core WebAssembly modules rather than the component model, so
+// LoadComponent accepts either a core module (the <name>.module.wasm that
+// `run v2 build` writes for Go, or `make module` in ../go) or a component, in
+// which case the embedded core module that exports greet is used. WASI
+// preview 1 imports are provided; a module that needs anything else fails to
+// instantiate. greet is called through the component model's canonical ABI.
+package host
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Greeter is a loaded greeter component.
+type Greeter interface {
+	// Greet calls the component's greet export.
+	Greet(name string) (string, error)
+	// Close releases the runtime backing the component.
+	Close() error
+}
+
+// Core export names greet can have: a world-level function, or one exported
+// through the greeter interface.
+var greetExports = []string{"greet", "run:greeter/greeter#greet"}
+
+var (
+	magic          = []byte("\x00asm")
+	coreVersion    = []byte{0x01, 0x00, 0x00, 0x00}
+	componentLayer = []byte{0x0d, 0x00, 0x01, 0x00}
+)
+
+// LoadComponent loads the component or core module at path.
+func LoadComponent(path string) (Greeter, error) {
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g, err := Load(context.Background(), wasm)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return g, nil
+}
+
+// Load instantiates a greeter from component or core module bytes.
+func Load(ctx context.Context, wasm []byte) (Greeter, error) {
+	modules, err := coreModules(wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := wazero.NewRuntime(ctx)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		rt.Close(ctx)
+		return nil, err
+	}
+	for _, bin := range modules {
+		compiled, err := rt.CompileModule(ctx, bin)
+		if err != nil {
+			rt.Close(ctx)
+			return nil, err
+		}
+		export, ok := findGreet(compiled)
+		if !ok {
+			continue
+		}
+		config := wazero.NewModuleConfig().
+			WithStartFunctions("_initialize").
+			WithStdout(os.Stdout).
+			WithStderr(os.Stderr)
+		mod, err := rt.InstantiateModule(ctx, compiled, config)
+		if err != nil {
+			rt.Close(ctx)
+			// Typically a wasip2 build importing WASI 0.2 interfaces.
+			return nil, fmt.Errorf("instantiate (only WASI preview 1 is provided; for the Go greeter use `make module`): %w", err)
+		}
+		g := &greeter{
+			runtime:   rt,
+			module:    mod,
+			greet:     mod.ExportedFunction(export),
+			postGreet: mod.ExportedFunction("cabi_post_" + export),
+			realloc:   mod.ExportedFunction("cabi_realloc"),
+		}
+		if g.realloc == nil || mod.Memory() == nil {
+			rt.Close(ctx)
+			return nil, errors.New("module does not export cabi_realloc and memory")
+		}
+		return g, nil
+	}
+	rt.Close(ctx)
+	return nil, errors.New("no core module exports greet(string) -> string")
+}
+
+// findGreet reports the export name of a greet function with the flat
+// canonical ABI signature (ptr, len i32) -> retptr i32.
+func findGreet(compiled wazero.CompiledModule) (string, bool) {
+	exported := compiled.ExportedFunctions()
+	for _, name := range greetExports {
+		def, ok := exported[name]
+		if !ok {
+			continue
+		}
+		params, results := def.ParamTypes(), def.ResultTypes()
+		if len(params) == 2 && params[0] == api.ValueTypeI32 && params[1] == api.ValueTypeI32 &&
+			len(results) == 1 && results[0] == api.ValueTypeI32 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+type greeter struct {
+	mu        sync.Mutex
+	runtime   wazero.Runtime
+	module    api.Module
+	greet     api.Function
+	postGreet api.Function
+	realloc   api.Function
+}
+
+func (g *greeter) Greet(name string) (string, error) {
+	ctx := context.Background()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	mem := g.module.Memory()
+	res, err := g.realloc.Call(ctx, 0, 0, 1, uint64(len(name)))
+	if err != nil {
+		return "", fmt.Errorf("cabi_realloc: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !mem.Write(ptr, []byte(name)) {
+		return "", errors.New("argument does not fit in guest memory")
+	}
+
+	res, err = g.greet.Call(ctx, uint64(ptr), uint64(len(name)))
+	if err != nil {
+		return "", fmt.Errorf("greet: %w", err)
+	}
+	retptr := uint32(res[0])
+	strPtr, ok := mem.ReadUint32Le(retptr)
+	strLen, ok2 := mem.ReadUint32Le(retptr + 4)
+	if !ok || !ok2 {
+		return "", errors.New("greet returned an out-of-range result pointer")
+	}
+	data, ok := mem.Read(strPtr, strLen)
+	if !ok {
+		return "", errors.New("greet returned an out-of-range string")
+	}
+	// Copy before post-return lets the guest free its buffer.
+	greeting := string(data)
+
+	if g.postGreet != nil {
+		if _, err := g.postGreet.Call(ctx, uint64(retptr)); err != nil {
+			return "", fmt.Errorf("cabi_post_greet: %w", err)
+		}
+	}
+	return greeting, nil
+}
+
+func (g *greeter) Close() error {
+	return g.runtime.Close(context.Background())
+}
+
+// coreModules returns wasm itself for a core module, or the core modules
+// embedded in a component (including nested components), in order.
+func coreModules(wasm []byte) ([][]byte, error) {
+	if len(wasm) < 8 || !bytes.Equal(wasm[:4], magic) {
+		return nil, errors.New("not a WebAssembly binary")
+	}
+	switch {
+	case bytes.Equal(wasm[4:8], coreVersion):
+		return [][]byte{wasm}, nil
+	case bytes.Equal(wasm[4:8], componentLayer):
+	default:
+		return nil, fmt.Errorf("unsupported WebAssembly version % x", wasm[4:8])
+	}
+
+	const (
+		coreModuleSection = 1
+		componentSection  = 4
+	)
+	var modules [][]byte
+	rest := wasm[8:]
+	for len(rest) > 0 {
+		id := rest[0]
+		size, n, err := readU32(rest[1:])
+		if err != nil {
+			return nil, err
+		}
+		start := 1 + n
+		if uint64(start)+uint64(size) > uint64(len(rest)) {
+			return nil, errors.New("truncated component section")
+		}
+		payload := rest[start : start+int(size)]
+		switch id {
+		case coreModuleSection:
+			modules = append(modules, payload)
+		case componentSection:
+			nested, err := coreModules(payload)
+			if err != nil {
+				return nil, err
+			}
+			modules = append(modules, nested...)
+		}
+		rest = rest[start+int(size):]
+	}
+	if len(modules) == 0 {
+		return nil, errors.New("component has no core modules")
+	}
+	return modules, nil
+}
+
+// readU32 decodes an unsigned LEB128 u32, returning it and its length.
+func readU32(b []byte) (uint32, int, error) {
+	var value uint32
+	for i := 0; i < 5 && i < len(b); i++ {
+		value |= uint32(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return value, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("malformed section size")
+}
@@ -0,0 +1,78 @@
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCoreModulesExtractsEmbeddedModules(t *testing.T) {
+	core := append(append([]byte{}, magic...), coreVersion...)
+	section := func(id byte, payload []byte) []byte {
+		return append([]byte{id, byte(len(payload))}, payload...)
+	}
+	nested := append(append(append([]byte{}, magic...), componentLayer...), section(1, core)...)
+	component := append(append([]byte{}, magic...), componentLayer...)
+	component = append(component, section(1, core)...)
+	component = append(component, section(0, []byte("custom"))...)
+	component = append(component, section(4, nested)...)
+
+	modules, err := coreModules(component)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 2 || !bytes.Equal(modules[0], core) || !bytes.Equal(modules[1], core) {
+		t.Fatalf("got %d modules: %x", len(modules), modules)
+	}
+
+	modules, err = coreModules(core)
+	if err != nil || len(modules) != 1 {
+		t.Fatalf("core module: %v, %d modules", err, len(modules))
+	}
+	if _, err := coreModules([]byte("not wasm")); err == nil {
+		t.Fatal("expected an error for non-wasm input")
+	}
+}
+
+// Each language binding that implements greet gets a row; components that
+// have not been built on this machine are skipped.
+func TestComponentsGreet(t *testing.T) {
+	cases := []struct {
+		language string
+		paths    []string
+	}{
+		{"go", []string{"../go/greeter.module.wasm", "../target/wasm/greeter-go.module.wasm"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.language, func(t *testing.T) {
+			path := firstExisting(tc.paths)
+			if path == "" {
+				t.Skipf("no %s build found (tried %v)", tc.language, tc.paths)
+			}
+			g, err := LoadComponent(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer g.Close()
+
+			got, err := g.Greet("world")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("hello world from %s", tc.language); got != want {
+				t.Fatalf("Greet(%q) = %q, want %q", "world", got, want)
+			}
+		})
+	}
+}
+
+func firstExisting(paths []string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(filepath.FromSlash(path)); err == nil {
+			return path
+		}
+	}
+	return ""
+}
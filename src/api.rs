@@ -0,0 +1,294 @@
+//! Library entry point for running code without going through the CLI.
+//!
+//! ```no_run
+//! use std::time::Duration;
+//!
+//! let outcome = run::run(
+//!     run::Request::new("print(input().upper())")
+//!         .language("python")
+//!         .stdin("hello\n")
+//!         .timeout(Duration::from_secs(5)),
+//! )?;
+//! assert_eq!(outcome.stdout, "HELLO\n");
+//! # Ok::<(), anyhow::Error>(())
+//! ```
+
+use std::io::Write;
+use std::path::PathBuf;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Arc, OnceLock};
+use std::time::Duration;
+
+use anyhow::{Context, Result};
+use tempfile::NamedTempFile;
+
+use crate::engine::{
+    CommandLog, ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageRegistry, OutputStream,
+    RunOverrides, SandboxLimits, default_language, detect_language_for_source,
+    ensure_known_language, preflight, with_run_overrides,
+};
+use crate::language::LanguageSpec;
+
+/// A program to run plus the settings for that one run.
+#[derive(Debug, Clone)]
+pub struct Request {
+    language: Option<String>,
+    payload: ExecutionPayload,
+    stdin: Option<Vec<u8>>,
+    env: Vec<(String, String)>,
+    clean_env: bool,
+    timeout: Option<Duration>,
+    cwd: Option<PathBuf>,
+    sandbox: Option<SandboxLimits>,
+    stream: Option<OutputStream>,
+    dry_run: Option<CommandLog>,
+    flags: Vec<String>,
+    cancel: Option<Arc<AtomicBool>>,
+}
+
+impl Request {
+    /// Run `code` inline. Without [`Request::language`] the language is
+    /// detected from the code, falling back to the default language.
+    pub fn new(code: impl Into<String>) -> Self {
+        Self::from_payload(ExecutionPayload::Inline {
+            code: code.into(),
+            args: Vec::new(),
+        })
+    }
+
+    /// Run a source file; its language is inferred from the extension or
+    /// `#!` line unless [`Request::language`] is set.
+    pub fn file(path: impl Into<PathBuf>) -> Self {
+        Self::from_payload(ExecutionPayload::File {
+            path: path.into(),
+            args: Vec::new(),
+        })
+    }
+
+    pub(crate) fn from_payload(payload: ExecutionPayload) -> Self {
+        Self {
+            language: None,
+            payload,
+            stdin: None,
+            env: Vec::new(),
+            clean_env: false,
+            timeout: None,
+            cwd: None,
+            sandbox: None,
+            stream: None,
+            dry_run: None,
+            flags: Vec::new(),
+            cancel: None,
+        }
+    }
+
+    /// Language id or alias, e.g. `python`, `py`, `rust`.
+    pub fn language(mut self, language: impl Into<String>) -> Self {
+        self.language = Some(language.into());
+        self
+    }
+
+    /// Arguments passed to the program.
+    pub fn args<I, S>(mut self, args: I) -> Self
+    where
+        I: IntoIterator<Item = S>,
+        S: Into<String>,
+    {
+        let target = match &mut self.payload {
+            ExecutionPayload::Inline { args, .. }
+            | ExecutionPayload::File { args, .. }
+            | ExecutionPayload::Stdin { args, .. } => args,
+        };
+        target.extend(args.into_iter().map(Into::into));
+        self
+    }
+
+    /// Set an environment variable for the program, on top of this
+    /// process's environment.
+    pub fn env(mut self, key: impl Into<String>, value: impl Into<String>) -> Self {
+        self.env.push((key.into(), value.into()));
+        self
+    }
+
+    /// Do not inherit this process's environment: the program sees only the
+    /// variables set with [`Request::env`] plus `PATH`.
+    pub fn clean_env(mut self, clean: bool) -> Self {
+        self.clean_env = clean;
+        self
+    }
+
+    /// Data the program reads from stdin. Without it the program gets this
+    /// process's stdin.
+    pub fn stdin(mut self, input: impl Into<Vec<u8>>) -> Self {
+        self.stdin = Some(input.into());
+        self
+    }
+
+    /// Directory the program (and its compile step) runs in. Without it each
+    /// engine picks its own, often the temp dir holding the generated source.
+    pub fn cwd(mut self, dir: impl Into<PathBuf>) -> Self {
+        self.cwd = Some(dir.into());
+        self
+    }
+
+    /// Run the program's processes under `limits` (Linux only; ignored
+    /// elsewhere, see [`SandboxLimits::SUPPORTED`]).
+    pub fn sandbox(mut self, limits: SandboxLimits) -> Self {
+        self.sandbox = Some(limits);
+        self
+    }
+
+    /// Copy the program's output to `stream` while it runs, e.g.
+    /// [`OutputStream::terminal`]. The outcome still carries all of it.
+    /// Stderr stays buffered when the engine wrapped the snippet, so its line
+    /// numbers can be mapped back before anyone sees them.
+    pub fn stream(mut self, stream: OutputStream) -> Self {
+        self.stream = Some(stream);
+        self
+    }
+
+    /// Record the compile and run commands in `log` instead of running them.
+    /// Engines stop where the program would start, so the outcome (or error)
+    /// of a dry run says nothing about the program.
+    pub fn dry_run(mut self, log: CommandLog) -> Self {
+        self.dry_run = Some(log);
+        self
+    }
+
+    /// Extra arguments for the compiler or interpreter, e.g. `["-O2"]` for
+    /// C or `["-X", "dev"]` for Python. Each engine puts them where its
+    /// toolchain takes options; compiled languages cache per set of flags.
+    pub fn flags<I, S>(mut self, flags: I) -> Self
+    where
+        I: IntoIterator<Item = S>,
+        S: Into<String>,
+    {
+        self.flags.extend(flags.into_iter().map(Into::into));
+        self
+    }
+
+    /// Kill the program (and everything it started) after `timeout`.
+    pub fn timeout(mut self, timeout: Duration) -> Self {
+        self.timeout = Some(timeout);
+        self
+    }
+
+    /// Cancel the run once `flag` is set, e.g. from another thread: whatever
+    /// it is running (and everything that started) is killed and [`run`]
+    /// returns an error. Nothing runs if the flag is already set.
+    pub fn cancel_on(mut self, flag: Arc<AtomicBool>) -> Self {
+        self.cancel = Some(flag);
+        self
+    }
+}
+
+/// An engine as returned by [`engines`] and [`engine_for`].
+pub type Engine = dyn LanguageEngine + Send + Sync;
+
+/// Registry behind [`engines`] and [`engine_for`], bootstrapped on first use.
+/// Binary overrides (`RUN_BINARY_<LANG>`) are read at that point. Threads
+/// racing on the first call wait for the one that initializes it, and after
+/// that it is only read.
+fn shared_registry() -> &'static LanguageRegistry {
+    static REGISTRY: OnceLock<LanguageRegistry> = OnceLock::new();
+    REGISTRY.get_or_init(LanguageRegistry::bootstrap)
+}
+
+/// Every registered engine, sorted by language id. Listing them runs
+/// nothing; [`LanguageEngine::available`] and
+/// [`LanguageEngine::toolchain_version`] probe the toolchain when called.
+///
+/// ```no_run
+/// for engine in run::engines() {
+///     println!("{} {:?} {}", engine.id(), engine.extensions(), engine.available());
+/// }
+/// ```
+pub fn engines() -> Vec<&'static Engine> {
+    let registry = shared_registry();
+    registry
+        .known_languages()
+        .iter()
+        .filter_map(|id| registry.resolve_by_id(id))
+        .collect()
+}
+
+/// The engine for a language id or alias (`python`, `py`, `js`), if one is
+/// registered.
+pub fn engine_for(language: &str) -> Option<&'static Engine> {
+    shared_registry().resolve_by_id(language)
+}
+
+/// Run a request with the registry behind [`engines`], so toolchains are
+/// looked up once per process. To pick up one installed (or a
+/// `RUN_BINARY_<LANG>` set) since then, pass a freshly bootstrapped registry
+/// to [`run_with_registry`].
+pub fn run(request: Request) -> Result<ExecutionOutcome> {
+    run_with_registry(shared_registry(), request)
+}
+
+/// Run a request against an existing registry. A program that runs and fails
+/// is still `Ok`; check [`ExecutionOutcome::exit_code`]. Errors mean the
+/// program could not be run at all: unknown language, missing toolchain (a
+/// [`ToolchainMissing`](crate::engine::ToolchainMissing) you can downcast to),
+/// or a failure to spawn it.
+pub fn run_with_registry(
+    registry: &LanguageRegistry,
+    request: Request,
+) -> Result<ExecutionOutcome> {
+    let language = match request.language.clone() {
+        Some(language) => {
+            let spec = LanguageSpec::new(language);
+            ensure_known_language(&spec, registry)?;
+            spec
+        }
+        None => detect_language_for_source(&request.payload, registry)
+            .unwrap_or_else(|| LanguageSpec::new(default_language())),
+    };
+    let engine = registry
+        .resolve(&language)
+        .with_context(|| format!("no engine registered for '{}'", language.canonical_id()))?;
+    preflight(engine)?;
+    execute(engine, request)
+}
+
+/// Run a request on an engine the caller has already resolved and validated.
+pub(crate) fn execute(engine: &dyn LanguageEngine, request: Request) -> Result<ExecutionOutcome> {
+    let stdin_file = request.stdin.as_deref().map(write_stdin_file).transpose()?;
+    let lines = engine.snippet_lines(&request.payload);
+    let stream = match request.stream {
+        Some(stream) if lines.is_some() => Some(stream.without_stderr()),
+        stream => stream,
+    };
+    let overrides = RunOverrides {
+        env: request.env,
+        clean_env: request.clean_env,
+        stdin: stdin_file.as_ref().map(|file| file.path().to_path_buf()),
+        timeout: request.timeout,
+        cwd: request.cwd,
+        sandbox: request.sandbox,
+        stream,
+        dry_run: request.dry_run,
+        flags: request.flags,
+        cancel: request.cancel.clone(),
+    };
+    let result = with_run_overrides(overrides, || engine.execute(&request.payload));
+    if request
+        .cancel
+        .is_some_and(|flag| flag.load(Ordering::SeqCst))
+    {
+        anyhow::bail!("run cancelled");
+    }
+    let mut outcome = result?;
+    if let Some(lines) = lines {
+        lines.apply(&mut outcome);
+    }
+    Ok(outcome)
+}
+
+fn write_stdin_file(input: &[u8]) -> Result<NamedTempFile> {
+    let mut file = NamedTempFile::new().context("failed to create stdin file for run")?;
+    file.write_all(input)
+        .context("failed to write stdin for run")?;
+    file.flush()?;
+    Ok(file)
+}
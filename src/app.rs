@@ -1,33 +1,66 @@
-use std::io::{self, Write};
+use std::borrow::Cow;
+use std::io::{self, IsTerminal, Write};
 use std::path::Path;
-use std::time::SystemTime;
+use std::time::{Duration, Instant, SystemTime};
 
 use anyhow::{Context, Result};
+use serde::Serialize;
 
-use crate::cli::{Command, ExecutionSpec};
+use crate::api::{self, Request};
+use crate::cli::{Command, ExecutionSpec, ProgramEnv};
 use crate::engine::{
-    ExecutionPayload, LanguageRegistry, build_install_command, default_language,
-    detect_language_for_source, ensure_known_language, perf_reset, perf_snapshot,
+    CommandLog, ExecutionOutcome, ExecutionPayload, InterruptMode, LanguageEngine,
+    LanguageRegistry, OutputStream, RunOverrides, RunTempRoot, SandboxLimits,
+    TOOLCHAIN_MISSING_EXIT_CODE, ToolchainMissing, build_install_command, clear_compile_cache,
+    compile_cache_dir, default_language, detect_language_for_source, ensure_known_language,
+    install_interrupt_handler, interrupt_exit_code, interrupted, known_extensions, perf_reset,
+    perf_snapshot, pip_packages, preflight, save_terminal_mode, set_interrupt_mode,
+    set_stdin_passthrough, temp_builder, with_run_overrides,
 };
 use crate::language::LanguageSpec;
-use crate::output;
+use crate::output::{self, Stream};
 use crate::repl;
 use crate::version;
 
 pub fn run(command: Command) -> Result<i32> {
+    // Taken before the REPL's line editor has touched the terminal.
+    save_terminal_mode();
+    // Until a mode says otherwise, a signal cleans up and exits.
+    install_interrupt_handler();
     let registry = LanguageRegistry::bootstrap();
+    // Everything written to the temp dir from here on goes into this run's
+    // own directory, removed when `run` returns.
+    let _temp_root = RunTempRoot::install()?;
 
     match command {
-        Command::Execute(spec) => execute_once(spec, &registry),
+        Command::Execute(spec) => unless_interrupted(execute_once(spec, &registry)),
         Command::Repl {
             initial_language,
             detect_language,
+            env,
         } => {
             let language = resolve_language(initial_language, detect_language, None, &registry)?;
-            repl::run_repl(language, registry, detect_language)
+            // Piped REPL input is the script itself; don't hand it to snippets.
+            set_stdin_passthrough(io::stdin().is_terminal());
+            // Sessions keep running inside their own workspace; --cwd only
+            // moves the REPL itself, e.g. for relative :load paths.
+            if let Some(dir) = &env.cwd {
+                std::env::set_current_dir(dir)
+                    .with_context(|| format!("failed to change to {}", dir.display()))?;
+            }
+            let overrides = RunOverrides {
+                cwd: None,
+                ..program_overrides(&env)
+            };
+            with_run_overrides(overrides, || {
+                repl::run_repl(language, registry, detect_language)
+            })
         }
-        Command::ShowVersion => {
+        Command::ShowVersion { engines } => {
             println!("{}", version::describe());
+            if engines {
+                print_engine_versions(&registry);
+            }
             Ok(0)
         }
         Command::CheckToolchains => check_toolchains(&registry),
@@ -36,8 +69,14 @@ pub fn run(command: Command) -> Result<i32> {
             let lang = language.unwrap_or_else(|| LanguageSpec::new(default_language()));
             install_package(&lang, &package)
         }
-        Command::Bench { spec, iterations } => bench_run(spec, &registry, iterations),
-        Command::Watch { spec } => watch_run(spec, &registry),
+        Command::Bench { spec, iterations } => {
+            unless_interrupted(with_run_overrides(program_overrides(&spec.env), || {
+                bench_run(spec, &registry, iterations)
+            }))
+        }
+        Command::Watch { spec } => {
+            with_run_overrides(program_overrides(&spec.env), || watch_run(spec, &registry))
+        }
         Command::PerfReport => {
             print_perf_report();
             Ok(0)
@@ -47,6 +86,308 @@ pub fn run(command: Command) -> Result<i32> {
             eprintln!("\x1b[2m[perf] counters reset\x1b[0m");
             Ok(0)
         }
+        Command::ListEngines { json } => list_engines(&registry, json),
+        Command::Init {
+            language,
+            path,
+            force,
+        } => init_starter(&registry, &language, path.as_deref(), force),
+        Command::ConfigPath => {
+            let path = crate::config::user_config_path()
+                .context("cannot locate the config directory; set HOME or XDG_CONFIG_HOME")?;
+            println!("{}", path.display());
+            Ok(0)
+        }
+        Command::CacheClear => {
+            let removed = clear_compile_cache()?;
+            println!(
+                "Removed {removed} cached build(s) from {}",
+                compile_cache_dir().display()
+            );
+            Ok(0)
+        }
+    }
+}
+
+/// One row of `run list`.
+#[derive(Serialize)]
+struct EngineListing {
+    language: &'static str,
+    name: &'static str,
+    extensions: Vec<&'static str>,
+    binary: Option<String>,
+    version: Option<String>,
+    available: bool,
+}
+
+impl EngineListing {
+    fn probe(engine: &dyn LanguageEngine) -> Self {
+        let available = engine.available();
+        let version = if available {
+            engine.toolchain_version().ok().flatten()
+        } else {
+            None
+        };
+        Self {
+            language: engine.id(),
+            name: engine.display_name(),
+            extensions: engine.extensions(),
+            binary: engine.binary_path().map(|path| path.display().to_string()),
+            version,
+            available,
+        }
+    }
+}
+
+/// Every engine's listing, sorted by language id. Toolchains are probed in
+/// parallel since some (JVM, .NET) take a while to report their version.
+fn probe_engines(registry: &LanguageRegistry) -> Vec<EngineListing> {
+    let languages = registry.known_languages();
+    std::thread::scope(|scope| {
+        let probes: Vec<_> = languages
+            .iter()
+            .filter_map(|id| registry.resolve(&LanguageSpec::new(id.clone())))
+            .map(|engine| scope.spawn(move || EngineListing::probe(engine)))
+            .collect();
+        probes
+            .into_iter()
+            .filter_map(|probe| probe.join().ok())
+            .collect()
+    })
+}
+
+/// The `engines:` section of `run version --engines`: one line per installed
+/// toolchain, then the ids of the ones that are missing.
+fn print_engine_versions(registry: &LanguageRegistry) {
+    let (available, missing): (Vec<_>, Vec<_>) = probe_engines(registry)
+        .into_iter()
+        .partition(|listing| listing.available);
+    let width = available
+        .iter()
+        .map(|listing| listing.language.len())
+        .max()
+        .unwrap_or(0);
+    println!("engines:");
+    for listing in &available {
+        println!(
+            "  {:<width$}  {}",
+            listing.language,
+            listing.version.as_deref().unwrap_or("unknown version")
+        );
+    }
+    if !missing.is_empty() {
+        let ids: Vec<&str> = missing.iter().map(|listing| listing.language).collect();
+        println!("not installed: {}", ids.join(", "));
+    }
+}
+
+/// `run init`: write the engine's starter program to `path`, or print it.
+/// An existing file is only replaced with `--force`.
+fn init_starter(
+    registry: &LanguageRegistry,
+    language: &LanguageSpec,
+    path: Option<&Path>,
+    force: bool,
+) -> Result<i32> {
+    ensure_known_language(language, registry).map_err(usage)?;
+    let engine = registry
+        .resolve(language)
+        .context("language is registered")?;
+    let name = path
+        .and_then(|path| path.file_stem())
+        .and_then(|stem| stem.to_str())
+        .unwrap_or("Main");
+    let Some(program) = engine.starter_program(name) else {
+        return Err(usage(anyhow::anyhow!(
+            "no starter program for {}",
+            engine.display_name()
+        )));
+    };
+
+    let Some(path) = path else {
+        print!("{program}");
+        return Ok(0);
+    };
+    let extension = path.extension().and_then(|ext| ext.to_str());
+    let run_command = if extension.is_some_and(|ext| engine.extensions().contains(&ext)) {
+        format!("run {}", path.display())
+    } else {
+        eprintln!(
+            "warning: {} does not have a {} extension ({})",
+            path.display(),
+            engine.display_name(),
+            engine.extensions().join(", ")
+        );
+        format!("run --lang {} {}", engine.id(), path.display())
+    };
+    if let Some(parent) = path
+        .parent()
+        .filter(|parent| !parent.as_os_str().is_empty())
+    {
+        std::fs::create_dir_all(parent)
+            .with_context(|| format!("failed to create {}", parent.display()))?;
+    }
+    let mut options = std::fs::OpenOptions::new();
+    options.write(true);
+    if force {
+        options.create(true).truncate(true);
+    } else {
+        options.create_new(true);
+    }
+    let mut file = match options.open(path) {
+        Err(err) if err.kind() == io::ErrorKind::AlreadyExists => {
+            return Err(usage(anyhow::anyhow!(
+                "{} already exists; pass --force to overwrite it",
+                path.display()
+            )));
+        }
+        result => result.with_context(|| format!("failed to create {}", path.display()))?,
+    };
+    file.write_all(program.as_bytes())
+        .with_context(|| format!("failed to write {}", path.display()))?;
+    println!(
+        "Wrote a {} starter to {}; run it with '{run_command}'",
+        engine.display_name(),
+        path.display()
+    );
+    Ok(0)
+}
+
+/// Longer version strings (Perl, Bash) are cut so the table stays readable;
+/// `--json` has the full text.
+const MAX_VERSION_WIDTH: usize = 32;
+
+/// `run list`. A missing toolchain only marks its row.
+fn list_engines(registry: &LanguageRegistry, json: bool) -> Result<i32> {
+    let listings = probe_engines(registry);
+
+    if json {
+        let json = serde_json::to_string(&listings).context("failed to serialize engine list")?;
+        println!("{json}");
+        return Ok(0);
+    }
+
+    let version_column = |listing: &EngineListing| {
+        let version = listing.version.as_deref().unwrap_or("-");
+        match version.char_indices().nth(MAX_VERSION_WIDTH) {
+            Some((cut, _)) => format!("{}...", &version[..cut]),
+            None => version.to_string(),
+        }
+    };
+    let rows: Vec<[String; 4]> = listings
+        .iter()
+        .map(|listing| {
+            [
+                listing.language.to_string(),
+                listing.extensions.join(","),
+                listing.binary.clone().unwrap_or_else(|| "-".to_string()),
+                version_column(listing),
+            ]
+        })
+        .collect();
+    let header = ["LANGUAGE", "EXTENSIONS", "BINARY", "VERSION"];
+    let widths: Vec<usize> = (0..header.len())
+        .map(|column| {
+            rows.iter()
+                .map(|row| row[column].chars().count())
+                .chain([header[column].len()])
+                .max()
+                .unwrap_or(0)
+        })
+        .collect();
+    let line = |cells: [&str; 4], status: &str| {
+        let mut line = String::new();
+        for (cell, width) in cells.iter().zip(&widths) {
+            line.push_str(&format!("{cell:<width$}  "));
+        }
+        line.push_str(status);
+        line
+    };
+
+    println!("{}", line(header, "STATUS"));
+    for (listing, row) in listings.iter().zip(&rows) {
+        let status = if listing.available {
+            output::paint(Stream::Stdout, "32", "available")
+        } else {
+            output::paint(Stream::Stdout, "31", "unavailable")
+        };
+        println!("{}", line(row.each_ref().map(String::as_str), &status));
+    }
+    let available = listings.iter().filter(|listing| listing.available).count();
+    println!("\n{available} of {} languages available", listings.len());
+    Ok(0)
+}
+
+/// Exit code for a bad invocation: unknown flag or language, conflicting
+/// options, a file that cannot be read. Same as clap's own usage errors.
+pub const USAGE_EXIT_CODE: i32 = 2;
+
+/// Exit code when run itself fails while doing its job, such as being unable
+/// to spawn the program or write its source. Together with
+/// [`TIMEOUT_EXIT_CODE`](crate::engine::TIMEOUT_EXIT_CODE) (124) and
+/// [`TOOLCHAIN_MISSING_EXIT_CODE`] (127) this keeps 124-127 for run; every
+/// other code is the program's own, and a program killed by signal N exits
+/// with 128 + N as it would under a shell.
+pub const INTERNAL_EXIT_CODE: i32 = 125;
+
+/// Exit code of a one-shot run stopped with Ctrl-C, 128 + SIGINT. SIGTERM
+/// and SIGHUP likewise give 143 and 129.
+pub const INTERRUPTED_EXIT_CODE: i32 = 130;
+
+/// `result`, unless a signal stopped the run; then whatever failed because
+/// the program was killed is not reported and run exits with 128 + the
+/// signal, [`INTERRUPTED_EXIT_CODE`] for Ctrl-C.
+fn unless_interrupted(result: Result<i32>) -> Result<i32> {
+    if interrupted() {
+        return Ok(interrupt_exit_code());
+    }
+    result
+}
+
+/// An error in how run was invoked rather than in running the program; exits
+/// with [`USAGE_EXIT_CODE`].
+#[derive(Debug)]
+pub struct UsageError(String);
+
+impl std::fmt::Display for UsageError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&self.0)
+    }
+}
+
+impl std::error::Error for UsageError {}
+
+fn usage(err: anyhow::Error) -> anyhow::Error {
+    UsageError(format!("{err:#}")).into()
+}
+
+/// Process exit code for an error returned by [`run`].
+pub fn exit_code_for_error(err: &anyhow::Error) -> i32 {
+    if err.is::<UsageError>() {
+        USAGE_EXIT_CODE
+    } else {
+        INTERNAL_EXIT_CODE
+    }
+}
+
+fn report_missing_toolchain(missing: &ToolchainMissing) -> i32 {
+    eprintln!(
+        "{} {missing}",
+        output::paint(Stream::Stderr, "31", "Error:")
+    );
+    TOOLCHAIN_MISSING_EXIT_CODE
+}
+
+/// Run settings for the REPL, bench and watch modes, which drive engines
+/// directly rather than through [`api::execute`].
+fn program_overrides(env: &ProgramEnv) -> RunOverrides {
+    RunOverrides {
+        env: env.vars.clone(),
+        clean_env: env.clean,
+        cwd: env.cwd.clone(),
+        sandbox: env.sandbox.then(SandboxLimits::from_env),
+        flags: env.flags.clone(),
+        ..RunOverrides::default()
     }
 }
 
@@ -170,9 +511,10 @@ fn show_versions(registry: &LanguageRegistry, language: Option<LanguageSpec>) ->
 
 fn execute_once(spec: ExecutionSpec, registry: &LanguageRegistry) -> Result<i32> {
     let payload = ExecutionPayload::from_input_source(&spec.source, &spec.args)
-        .context("failed to materialize execution payload")?;
+        .context("failed to materialize execution payload")
+        .map_err(usage)?;
     let language = resolve_language(
-        spec.language,
+        spec.language.clone(),
         spec.detect_language,
         Some(&payload),
         registry,
@@ -182,37 +524,120 @@ fn execute_once(spec: ExecutionSpec, registry: &LanguageRegistry) -> Result<i32>
         .resolve(&language)
         .context("failed to resolve language engine")?;
 
-    if let Err(e) = engine.validate() {
-        let display = engine.display_name();
-        let id = engine.id();
-        eprintln!(
-            "Warning: {display} ({id}) toolchain not found: {e:#}\n\
-             Install the required toolchain and ensure it is on your PATH."
-        );
-        return Err(e.context(format!("{display} is not available")));
+    if let Err(missing) = preflight(engine) {
+        return Ok(report_missing_toolchain(&missing));
     }
+    if engine.id() != "python" && !pip_packages().is_empty() {
+        return Err(usage(anyhow::anyhow!(
+            "--pip installs Python packages and cannot be used with {}",
+            engine.display_name()
+        )));
+    }
+    // From here Ctrl-C kills the program rather than run, and run returns on
+    // its own. Not earlier: a program typed on stdin is abandoned right away.
+    set_interrupt_mode(InterruptMode::Return);
+    execute_resolved(engine, payload, spec)
+}
 
-    let outcome = engine.execute(&payload)?;
-
-    if !outcome.stdout.is_empty() {
-        print!("{}", outcome.stdout);
-        io::stdout().flush().ok();
+/// Second half of a one-shot run, once the engine is known to work: run the
+/// payload with the spec's environment and print the outcome. `--watch`
+/// calls this for every re-run.
+fn execute_resolved(
+    engine: &dyn LanguageEngine,
+    payload: ExecutionPayload,
+    spec: ExecutionSpec,
+) -> Result<i32> {
+    // Output is printed as the program writes it, except under --json.
+    // Whatever did not go through the stream (compiler output, stderr of a
+    // wrapped snippet) is printed from the outcome afterwards.
+    let stream = (!spec.json && !spec.dry_run).then(|| {
+        OutputStream::default()
+            .stdout(io::stdout())
+            .stderr(output::ProgramStderr::default())
+    });
+    let mut request = Request::from_payload(payload).clean_env(spec.env.clean);
+    if let Some(stream) = &stream {
+        request = request.stream(stream.clone());
+    }
+    let dry_run = spec.dry_run.then(CommandLog::default);
+    if let Some(log) = &dry_run {
+        request = request.dry_run(log.clone());
+    }
+    if spec.env.sandbox {
+        request = request.sandbox(SandboxLimits::from_env());
+    }
+    // A sandboxed program without --cwd starts in an empty directory of its
+    // own, removed when the run is over.
+    let scratch = (spec.env.sandbox && spec.env.cwd.is_none())
+        .then(|| {
+            temp_builder()
+                .prefix("run-sandbox")
+                .tempdir()
+                .context("failed to create sandbox working directory")
+        })
+        .transpose()?;
+    if let Some(dir) = &scratch {
+        request = request.cwd(dir.path());
+    }
+    if let Some(dir) = spec.env.cwd {
+        request = request.cwd(dir);
+    }
+    for (key, value) in spec.env.vars {
+        request = request.env(key, value);
+    }
+    request = request.flags(spec.env.flags);
+    let wall_start = Instant::now();
+    let result = api::execute(engine, request);
+    if let Some(log) = dry_run {
+        return print_dry_run(engine, &log, result);
     }
-    if !outcome.stderr.is_empty() {
-        let formatted =
-            output::format_stderr(engine.display_name(), &outcome.stderr, outcome.success());
-        eprint!("{formatted}");
-        io::stderr().flush().ok();
+    let outcome = result?;
+    if interrupted() {
+        // The program was killed; its output so far has been streamed.
+        return Ok(interrupt_exit_code());
     }
+    let timings = spec
+        .timings
+        .then(|| PhaseTimings::new(&outcome, wall_start.elapsed()));
 
-    // Show timing on stderr if RUN_TIMING=1 or if execution was slow (>1s)
-    let show_timing = std::env::var("RUN_TIMING").is_ok_and(|v| v == "1" || v == "true");
-    if show_timing || outcome.duration.as_millis() > 1000 {
-        eprintln!(
-            "\x1b[2m[{} {}ms]\x1b[0m",
-            engine.display_name(),
-            outcome.duration.as_millis()
-        );
+    if spec.json {
+        print_json_outcome(engine, &outcome, timings)?;
+    } else {
+        let streamed = stream.as_ref();
+        if !outcome.stdout.is_empty() && !streamed.is_some_and(OutputStream::wrote_stdout) {
+            print!("{}", outcome.stdout);
+            io::stdout().flush().ok();
+        }
+        let stderr = if streamed.is_some_and(OutputStream::wrote_stderr) {
+            Cow::Borrowed(outcome.compile_stderr.as_deref().unwrap_or_default())
+        } else {
+            outcome.combined_stderr()
+        };
+        if !stderr.is_empty() {
+            let formatted =
+                output::format_stderr(engine.display_name(), &stderr, outcome.success());
+            eprint!("{}", output::paint(Stream::Stderr, "31", &formatted));
+            io::stderr().flush().ok();
+        }
+
+        // Show timing on stderr if RUN_TIMING=1 or if execution was slow (>1s)
+        let show_timing = std::env::var("RUN_TIMING").is_ok_and(|v| v == "1" || v == "true");
+        if show_timing || outcome.duration.as_millis() > 1000 {
+            let line = format!(
+                "[{} {}ms]",
+                engine.display_name(),
+                outcome.duration.as_millis()
+            );
+            eprintln!("{}", output::paint(Stream::Stderr, "2", &line));
+        }
+        if let Some(timings) = timings {
+            let binary = engine
+                .binary_path()
+                .map(|path| path.display().to_string())
+                .unwrap_or_else(|| "-".to_string());
+            let line = format!("[timings] {timings} engine_binary={binary}");
+            eprintln!("{}", output::paint(Stream::Stderr, "2", &line));
+        }
     }
 
     if std::env::var("RUN_PERF_REPORT").is_ok_and(|v| v == "1" || v == "true") {
@@ -222,9 +647,117 @@ fn execute_once(spec: ExecutionSpec, registry: &LanguageRegistry) -> Result<i32>
         }
     }
 
-    Ok(outcome
+    Ok(outcome_exit_code(&outcome))
+}
+
+/// `--dry-run` report on stderr: each command with its stage, preceded by
+/// the source files generated for it, which are gone by the time this runs.
+/// An engine that failed before recording anything has its error reported
+/// instead.
+fn print_dry_run(
+    engine: &dyn LanguageEngine,
+    log: &CommandLog,
+    result: Result<ExecutionOutcome>,
+) -> Result<i32> {
+    let commands = log.commands();
+    if commands.is_empty() {
+        result?;
+        anyhow::bail!(
+            "{} did not construct any command for this run",
+            engine.display_name()
+        );
+    }
+    let mut shown = std::collections::HashSet::new();
+    for command in &commands {
+        for (path, source) in &command.sources {
+            if shown.insert(path) {
+                eprintln!("# {}", path.display());
+                eprint!("{source}");
+                if !source.ends_with('\n') {
+                    eprintln!();
+                }
+            }
+        }
+        eprintln!("{}: {}", command.stage, command.command_line);
+    }
+    Ok(0)
+}
+
+/// What run exits with after the program ran: its own exit code, or 0/1 for
+/// the rare outcome without one.
+fn outcome_exit_code(outcome: &ExecutionOutcome) -> i32 {
+    outcome
         .exit_code
-        .unwrap_or(if outcome.success() { 0 } else { 1 }))
+        .unwrap_or(if outcome.success() { 0 } else { 1 })
+}
+
+/// One JSON object per run for `--json`; the only thing written to stdout.
+#[derive(Serialize)]
+struct JsonOutcome<'a> {
+    stdout: &'a str,
+    stderr: &'a str,
+    /// run's own exit code for this run, i.e. the program's.
+    exit_code: i32,
+    duration_ms: u64,
+    language: &'a str,
+    engine_version: Option<String>,
+    /// Interpreter or compiler that ran the program.
+    engine_binary: Option<String>,
+    compile_stderr: Option<&'a str>,
+    /// Only present with `--timings`.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    timings: Option<PhaseTimings>,
+}
+
+/// Compile/run breakdown for `--timings`. `compile_ms` is left out when
+/// nothing was compiled (interpreted languages, compile cache hits).
+#[derive(Debug, Clone, Copy, Serialize)]
+struct PhaseTimings {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    compile_ms: Option<u64>,
+    run_ms: u64,
+    total_ms: u64,
+}
+
+impl PhaseTimings {
+    fn new(outcome: &ExecutionOutcome, wall: Duration) -> Self {
+        Self {
+            compile_ms: outcome.compile_duration.map(|d| d.as_millis() as u64),
+            run_ms: outcome.run_duration().as_millis() as u64,
+            total_ms: wall.as_millis() as u64,
+        }
+    }
+}
+
+impl std::fmt::Display for PhaseTimings {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        if let Some(compile_ms) = self.compile_ms {
+            write!(f, "compile_ms={compile_ms} ")?;
+        }
+        write!(f, "run_ms={} total_ms={}", self.run_ms, self.total_ms)
+    }
+}
+
+fn print_json_outcome(
+    engine: &dyn LanguageEngine,
+    outcome: &ExecutionOutcome,
+    timings: Option<PhaseTimings>,
+) -> Result<()> {
+    let report = JsonOutcome {
+        stdout: &outcome.stdout,
+        stderr: &outcome.stderr,
+        exit_code: outcome_exit_code(outcome),
+        duration_ms: outcome.duration.as_millis() as u64,
+        language: engine.id(),
+        engine_version: engine.toolchain_version().ok().flatten(),
+        engine_binary: engine.binary_path().map(|path| path.display().to_string()),
+        compile_stderr: outcome.compile_stderr.as_deref(),
+        timings,
+    };
+    let json = serde_json::to_string(&report).context("failed to serialize execution result")?;
+    println!("{json}");
+    io::stdout().flush().ok();
+    Ok(())
 }
 
 fn install_package(language: &LanguageSpec, package: &str) -> Result<i32> {
@@ -291,62 +824,118 @@ fn bench_run(spec: ExecutionSpec, registry: &LanguageRegistry, iterations: u32)
         .resolve(&language)
         .context("failed to resolve language engine")?;
 
-    engine
-        .validate()
-        .with_context(|| format!("{} is not available", engine.display_name()))?;
+    if let Err(missing) = preflight(engine) {
+        return Ok(report_missing_toolchain(&missing));
+    }
 
-    eprintln!(
-        "\x1b[1mBenchmark:\x1b[0m {} — {} iteration{}",
-        engine.display_name(),
-        iterations,
-        if iterations == 1 { "" } else { "s" }
-    );
+    if !spec.json {
+        eprintln!(
+            "{} {} — {} iteration{}",
+            output::paint(Stream::Stderr, "1", "Benchmark:"),
+            engine.display_name(),
+            iterations,
+            if iterations == 1 { "" } else { "s" }
+        );
+    }
 
-    // Warmup run (not counted)
+    set_interrupt_mode(InterruptMode::Return);
+    // Warmup run (not counted). For compiled languages this is the one that
+    // compiles; the timed runs reuse the cached binary.
     let warmup = engine.execute(&payload)?;
     if !warmup.success() {
-        eprintln!("\x1b[31mError:\x1b[0m Code failed during warmup run");
-        if !warmup.stderr.is_empty() {
-            eprint!("{}", warmup.stderr);
+        eprintln!(
+            "{} Code failed during warmup run",
+            output::paint(Stream::Stderr, "31", "Error:")
+        );
+        let stderr = warmup.combined_stderr();
+        if !stderr.is_empty() {
+            eprint!("{stderr}");
         }
         return Ok(1);
     }
-    eprintln!("\x1b[2m  warmup: {}ms\x1b[0m", warmup.duration.as_millis());
+    if !spec.json {
+        let line = match warmup.compile_duration {
+            Some(compile) => format!(
+                "  warmup: {}ms (compile {}ms)",
+                warmup.duration.as_millis(),
+                compile.as_millis()
+            ),
+            None => format!("  warmup: {}ms", warmup.duration.as_millis()),
+        };
+        eprintln!("{}", output::paint(Stream::Stderr, "2", &line));
+    }
 
     let mut times: Vec<f64> = Vec::with_capacity(iterations as usize);
 
     for i in 0..iterations {
         let outcome = engine.execute(&payload)?;
-        let ms = outcome.duration.as_secs_f64() * 1000.0;
+        if !outcome.success() {
+            eprintln!(
+                "{} Code failed during run {}",
+                output::paint(Stream::Stderr, "31", "Error:"),
+                i + 1
+            );
+            let stderr = outcome.combined_stderr();
+            if !stderr.is_empty() {
+                eprint!("{stderr}");
+            }
+            return Ok(1);
+        }
+        // Only the run phase counts, so a cache miss does not skew the numbers.
+        let ms = outcome.run_duration().as_secs_f64() * 1000.0;
         times.push(ms);
 
-        if i < 3 || i == iterations - 1 || (i + 1) % 10 == 0 {
-            eprintln!("\x1b[2m  run {}: {:.2}ms\x1b[0m", i + 1, ms);
+        if !spec.json && (i < 3 || i == iterations - 1 || (i + 1) % 10 == 0) {
+            let line = format!("  run {}: {:.2}ms", i + 1, ms);
+            eprintln!("{}", output::paint(Stream::Stderr, "2", &line));
         }
     }
 
-    times.sort_by(|a, b| a.partial_cmp(b).unwrap_or(std::cmp::Ordering::Equal));
-    let total: f64 = times.iter().sum();
-    let avg = total / times.len() as f64;
-    let min = times.first().copied().unwrap_or(0.0);
-    let max = times.last().copied().unwrap_or(0.0);
-    let median = if times.len().is_multiple_of(2) && times.len() >= 2 {
-        (times[times.len() / 2 - 1] + times[times.len() / 2]) / 2.0
-    } else {
-        times[times.len() / 2]
-    };
+    let stats = BenchStats::new(&times);
 
-    // Standard deviation
-    let variance: f64 = times.iter().map(|t| (t - avg).powi(2)).sum::<f64>() / times.len() as f64;
-    let stddev = variance.sqrt();
+    if spec.json {
+        let report = BenchReport {
+            language: engine.id(),
+            runs: iterations,
+            compile_ms: warmup.compile_duration.map(|d| d.as_millis() as u64),
+            stats,
+            samples_ms: &times,
+        };
+        let json =
+            serde_json::to_string(&report).context("failed to serialize benchmark result")?;
+        println!("{json}");
+        io::stdout().flush().ok();
+        return Ok(0);
+    }
 
     eprintln!();
-    eprintln!("\x1b[1mResults ({} runs):\x1b[0m", iterations);
-    eprintln!("  min:    \x1b[32m{:.2}ms\x1b[0m", min);
-    eprintln!("  max:    \x1b[33m{:.2}ms\x1b[0m", max);
-    eprintln!("  avg:    \x1b[36m{:.2}ms\x1b[0m", avg);
-    eprintln!("  median: \x1b[36m{:.2}ms\x1b[0m", median);
-    eprintln!("  stddev: {:.2}ms", stddev);
+    eprintln!(
+        "{} run time only",
+        output::paint(
+            Stream::Stderr,
+            "1",
+            &format!("Results ({iterations} runs):")
+        )
+    );
+    eprintln!(
+        "  {:>10}  {:>10}  {:>10}  {:>10}  {:>10}",
+        "min", "max", "mean", "median", "stddev"
+    );
+    let cell = |style: &str, ms: f64| {
+        output::paint(
+            Stream::Stderr,
+            style,
+            &format!("{:>10}", format!("{ms:.2}ms")),
+        )
+    };
+    eprintln!(
+        "  {}  {}  {}  {}  {:>10}",
+        cell("32", stats.min_ms),
+        cell("33", stats.max_ms),
+        cell("36", stats.mean_ms),
+        cell("36", stats.median_ms),
+        format!("{:.2}ms", stats.stddev_ms),
+    );
 
     if !warmup.stdout.is_empty() {
         print!("{}", warmup.stdout);
@@ -356,20 +945,81 @@ fn bench_run(spec: ExecutionSpec, registry: &LanguageRegistry, iterations: u32)
     Ok(0)
 }
 
+/// Summary of the timed runs of `run bench`, in milliseconds.
+#[derive(Debug, Clone, Copy, Serialize)]
+struct BenchStats {
+    min_ms: f64,
+    max_ms: f64,
+    mean_ms: f64,
+    median_ms: f64,
+    /// Population standard deviation.
+    stddev_ms: f64,
+}
+
+impl BenchStats {
+    fn new(times: &[f64]) -> Self {
+        let mut sorted = times.to_vec();
+        sorted.sort_by(|a, b| a.partial_cmp(b).unwrap_or(std::cmp::Ordering::Equal));
+        let count = sorted.len().max(1) as f64;
+        let mean = sorted.iter().sum::<f64>() / count;
+        let median = match sorted.len() {
+            0 => 0.0,
+            len if len.is_multiple_of(2) => (sorted[len / 2 - 1] + sorted[len / 2]) / 2.0,
+            len => sorted[len / 2],
+        };
+        let variance = sorted.iter().map(|t| (t - mean).powi(2)).sum::<f64>() / count;
+        Self {
+            min_ms: sorted.first().copied().unwrap_or(0.0),
+            max_ms: sorted.last().copied().unwrap_or(0.0),
+            mean_ms: mean,
+            median_ms: median,
+            stddev_ms: variance.sqrt(),
+        }
+    }
+}
+
+/// `run bench --json` output.
+#[derive(Serialize)]
+struct BenchReport<'a> {
+    language: &'a str,
+    runs: u32,
+    /// Compile time of the warmup run; absent for interpreted languages and
+    /// compile cache hits.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    compile_ms: Option<u64>,
+    #[serde(flatten)]
+    stats: BenchStats,
+    samples_ms: &'a [f64],
+}
+
+/// How often `--watch` looks at the files, and how long they must stay
+/// unchanged after a save before the program runs again. Editors often write
+/// a file in several steps; the quiet period turns that into one run.
+const WATCH_POLL_INTERVAL: Duration = Duration::from_millis(100);
+const WATCH_DEBOUNCE: Duration = Duration::from_millis(200);
+
 fn watch_run(spec: ExecutionSpec, registry: &LanguageRegistry) -> Result<i32> {
     use crate::cli::InputSource;
 
     let file_path = match &spec.source {
         InputSource::File(p) => p.clone(),
-        _ => anyhow::bail!("--watch requires a file path (use -f or pass a file as argument)"),
+        _ => {
+            return Err(usage(anyhow::anyhow!(
+                "--watch requires a file path (use -f or pass a file as argument)"
+            )));
+        }
     };
 
     if !file_path.exists() {
-        anyhow::bail!("File not found: {}", file_path.display());
+        return Err(usage(anyhow::anyhow!(
+            "File not found: {}",
+            file_path.display()
+        )));
     }
 
     let payload = ExecutionPayload::from_input_source(&spec.source, &spec.args)
-        .context("failed to materialize execution payload")?;
+        .context("failed to materialize execution payload")
+        .map_err(usage)?;
     let language = resolve_language(
         spec.language.clone(),
         spec.detect_language,
@@ -381,70 +1031,119 @@ fn watch_run(spec: ExecutionSpec, registry: &LanguageRegistry) -> Result<i32> {
         .resolve(&language)
         .context("failed to resolve language engine")?;
 
-    engine
-        .validate()
-        .with_context(|| format!("{} is not available", engine.display_name()))?;
+    if let Err(missing) = preflight(engine) {
+        return Ok(report_missing_toolchain(&missing));
+    }
 
+    let siblings = watched_files(&file_path).len().saturating_sub(1);
+    let also = match siblings {
+        0 => String::new(),
+        1 => " and 1 neighbouring file".to_string(),
+        n => format!(" and {n} neighbouring files"),
+    };
     eprintln!(
-        "\x1b[1m[watch]\x1b[0m Watching \x1b[36m{}\x1b[0m ({}). Press Ctrl+C to stop.",
-        file_path.display(),
+        "{} Watching {}{also} ({}). Press Ctrl+C to stop.",
+        output::paint(Stream::Stderr, "1", "[watch]"),
+        output::paint(Stream::Stderr, "36", &file_path.display().to_string()),
         engine.display_name()
     );
 
-    fn get_mtime(path: &Path) -> Option<SystemTime> {
-        std::fs::metadata(path).ok()?.modified().ok()
-    }
-
-    let mut last_mtime = get_mtime(&file_path);
+    set_interrupt_mode(InterruptMode::Return);
+    let clear_screen = io::stdout().is_terminal();
+    let mut snapshot = watch_snapshot(&file_path);
     let mut run_count = 0u32;
-
-    // Initial run
-    run_count += 1;
-    eprintln!("\n\x1b[2m--- run #{run_count} ---\x1b[0m");
-    run_file_once(&file_path, engine, &spec.args);
-
     loop {
-        std::thread::sleep(std::time::Duration::from_millis(300));
-
-        let current_mtime = get_mtime(&file_path);
-        if current_mtime != last_mtime {
-            last_mtime = current_mtime;
-            run_count += 1;
-
-            eprintln!("\n\x1b[2m--- run #{run_count} ---\x1b[0m");
+        run_count += 1;
+        if run_count > 1 && clear_screen {
+            print!("\x1b[2J\x1b[H");
+            io::stdout().flush().ok();
+        }
+        let separator = format!(
+            "--- run #{run_count} at {} ---",
+            clock_time(SystemTime::now())
+        );
+        eprintln!("{}", output::paint(Stream::Stderr, "2", &separator));
+        let payload = ExecutionPayload::from_input_source(&spec.source, &spec.args)
+            .context("failed to materialize execution payload")?;
+        if let Err(e) = execute_resolved(engine, payload, spec.clone()) {
+            eprintln!("{} {e:#}", output::paint(Stream::Stderr, "31", "Error:"));
+        }
 
-            run_file_once(&file_path, engine, &spec.args);
+        // Wait for a change, then for the files to settle.
+        let mut last_change = None;
+        loop {
+            if interrupted() {
+                eprintln!("{} stopped", output::paint(Stream::Stderr, "1", "[watch]"));
+                return Ok(0);
+            }
+            std::thread::sleep(WATCH_POLL_INTERVAL);
+            let current = watch_snapshot(&file_path);
+            if current != snapshot {
+                snapshot = current;
+                last_change = Some(Instant::now());
+            } else if last_change.is_some_and(|at| at.elapsed() >= WATCH_DEBOUNCE) {
+                break;
+            }
         }
     }
 }
 
-fn run_file_once(file_path: &Path, engine: &dyn crate::engine::LanguageEngine, args: &[String]) {
-    let payload = ExecutionPayload::File {
-        path: file_path.to_path_buf(),
-        args: args.to_vec(),
+/// The file being run plus the files next to it with the same extension, as
+/// a best-effort stand-in for what it imports (Go packages, Python modules,
+/// C headers live beside the source more often than not).
+fn watched_files(file: &Path) -> Vec<std::path::PathBuf> {
+    let mut files = vec![file.to_path_buf()];
+    let (Some(dir), Some(ext)) = (file.parent(), file.extension()) else {
+        return files;
     };
-    match engine.execute(&payload) {
-        Ok(outcome) => {
-            if !outcome.stdout.is_empty() {
-                print!("{}", outcome.stdout);
-                io::stdout().flush().ok();
-            }
-            if !outcome.stderr.is_empty() {
-                eprint!("\x1b[31m{}\x1b[0m", outcome.stderr);
-                io::stderr().flush().ok();
-            }
-            let ms = outcome.duration.as_millis();
-            let status = if outcome.success() {
-                "\x1b[32mOK\x1b[0m"
-            } else {
-                "\x1b[31mFAIL\x1b[0m"
-            };
-            eprintln!("\x1b[2m[{status} {ms}ms]\x1b[0m");
-        }
-        Err(e) => {
-            eprintln!("\x1b[31mError:\x1b[0m {e:#}");
+    if let Ok(entries) = std::fs::read_dir(dir) {
+        let mut siblings: Vec<_> = entries
+            .flatten()
+            .map(|entry| entry.path())
+            .filter(|path| {
+                path.is_file()
+                    && path.as_path() != file
+                    && path
+                        .extension()
+                        .is_some_and(|other| other.eq_ignore_ascii_case(ext))
+            })
+            .collect();
+        siblings.sort();
+        files.extend(siblings);
+    }
+    files
+}
+
+/// Modification time and size of every watched file; any difference between
+/// two snapshots (including files appearing or disappearing) is a change.
+fn watch_snapshot(file: &Path) -> Vec<(std::path::PathBuf, Option<SystemTime>, u64)> {
+    watched_files(file)
+        .into_iter()
+        .map(|path| {
+            let meta = std::fs::metadata(&path).ok();
+            let modified = meta.as_ref().and_then(|m| m.modified().ok());
+            let len = meta.map_or(0, |m| m.len());
+            (path, modified, len)
+        })
+        .collect()
+}
+
+/// `HH:MM:SS` in local time (UTC where the local zone is unavailable).
+fn clock_time(time: SystemTime) -> String {
+    let secs = time
+        .duration_since(SystemTime::UNIX_EPOCH)
+        .map_or(0, |d| d.as_secs());
+    #[cfg(unix)]
+    {
+        let raw = secs as libc::time_t;
+        // SAFETY: localtime_r only writes into the tm we hand it.
+        let mut tm: libc::tm = unsafe { std::mem::zeroed() };
+        if !unsafe { libc::localtime_r(&raw, &mut tm) }.is_null() {
+            return format!("{:02}:{:02}:{:02}", tm.tm_hour, tm.tm_min, tm.tm_sec);
         }
     }
+    let day = secs % 86_400;
+    format!("{:02}:{:02}:{:02}", day / 3600, day % 3600 / 60, day % 60)
 }
 
 fn resolve_language(
@@ -454,7 +1153,7 @@ fn resolve_language(
     registry: &LanguageRegistry,
 ) -> Result<LanguageSpec> {
     if let Some(spec) = explicit {
-        ensure_known_language(&spec, registry)?;
+        ensure_known_language(&spec, registry).map_err(usage)?;
         return Ok(spec);
     }
 
@@ -465,7 +1164,19 @@ fn resolve_language(
         return Ok(detected);
     }
 
+    if allow_detect && let Some(path) = payload.and_then(|p| p.as_file_path()) {
+        let reason = match path.extension().and_then(|ext| ext.to_str()) {
+            Some(ext) => format!("unknown extension '.{ext}'"),
+            None => "no file extension or recognized #! line".to_string(),
+        };
+        return Err(usage(anyhow::anyhow!(
+            "cannot infer the language of {} ({reason}); pass --lang or use a recognized extension: {}",
+            path.display(),
+            known_extensions().join(", ")
+        )));
+    }
+
     let default = LanguageSpec::new(default_language());
-    ensure_known_language(&default, registry)?;
+    ensure_known_language(&default, registry).map_err(usage)?;
     Ok(default)
 }
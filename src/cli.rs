@@ -1,10 +1,13 @@
+use std::ffi::OsString;
 use std::io::IsTerminal;
 use std::path::{Path, PathBuf};
+use std::time::Duration;
 
-use anyhow::{Result, ensure};
+use anyhow::{Result, bail, ensure};
 use clap::{Parser, ValueHint, builder::NonEmptyStringValueParser};
 
 use crate::language::LanguageSpec;
+use crate::output::ColorChoice;
 
 #[derive(Debug, Clone, PartialEq, Eq)]
 pub enum InputSource {
@@ -13,12 +16,36 @@ pub enum InputSource {
     Stdin,
 }
 
+/// Environment for spawned programs, from `--env`, `--env-file`, `--clean-env`,
+/// `--cwd`, `--sandbox` and `--flags`.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct ProgramEnv {
+    /// Variables in the order given; env files come first, so `--env` wins.
+    pub vars: Vec<(String, String)>,
+    /// Pass only `vars` (plus `PATH`) instead of inheriting the environment.
+    pub clean: bool,
+    /// Working directory for the program and its compile step.
+    pub cwd: Option<PathBuf>,
+    /// Run under [`SandboxLimits`](crate::engine::SandboxLimits), in a
+    /// scratch directory unless `cwd` is set.
+    pub sandbox: bool,
+    /// Extra compiler or interpreter arguments, already split.
+    pub flags: Vec<String>,
+}
+
 #[derive(Debug, Clone, PartialEq, Eq)]
 pub struct ExecutionSpec {
     pub language: Option<LanguageSpec>,
     pub source: InputSource,
     pub detect_language: bool,
     pub args: Vec<String>,
+    /// Emit a single JSON object instead of the program's raw output.
+    pub json: bool,
+    /// Report compile and run time separately after the run.
+    pub timings: bool,
+    /// Print the commands the run would execute instead of running them.
+    pub dry_run: bool,
+    pub env: ProgramEnv,
 }
 
 #[derive(Debug, Clone, PartialEq, Eq)]
@@ -27,8 +54,13 @@ pub enum Command {
     Repl {
         initial_language: Option<LanguageSpec>,
         detect_language: bool,
+        env: ProgramEnv,
+    },
+    /// `--version`, or `run version [--engines]` which adds every installed
+    /// engine's toolchain version.
+    ShowVersion {
+        engines: bool,
     },
-    ShowVersion,
     CheckToolchains,
     ShowVersions {
         language: Option<LanguageSpec>,
@@ -46,13 +78,47 @@ pub enum Command {
     },
     PerfReport,
     PerfReset,
+    CacheClear,
+    /// `run config path`: where the user config file is read from.
+    ConfigPath,
+    /// `run list`: every registered engine and whether its toolchain works.
+    ListEngines {
+        json: bool,
+    },
+    /// `run init <lang> <path>`: write the engine's starter program to `path`,
+    /// or print it when `path` is `None` (`--stdout`).
+    Init {
+        language: LanguageSpec,
+        path: Option<PathBuf>,
+        force: bool,
+    },
 }
 
 pub fn parse() -> Result<Command> {
-    let cli = Cli::parse();
+    let (mut cli_args, program_args) = split_program_args(std::env::args_os())?;
+    // `run bench ...` is `--bench N` as a subcommand, with `--runs N`. It is
+    // taken off before parsing so the flags after it are not treated as
+    // trailing arguments.
+    let bench_command = cli_args.get(1).is_some_and(|arg| arg == "bench");
+    if bench_command {
+        cli_args.remove(1);
+    }
+    let cli = Cli::parse_from(cli_args);
+    // Inline code can also come after the language (`run py -c CODE`), so
+    // positional arguments get the same UTF-8 check as `--code`.
+    let args = cli
+        .args
+        .iter()
+        .enumerate()
+        .map(|(index, raw)| {
+            let after_code =
+                index > 0 && matches!(cli.args[index - 1].to_str(), Some("-c" | "--code"));
+            utf8_argument(raw, if after_code { "--code" } else { "argument" })
+        })
+        .collect::<Result<Vec<_>>>()?;
 
     if cli.version {
-        return Ok(Command::ShowVersion);
+        return Ok(Command::ShowVersion { engines: false });
     }
     if cli.perf_report {
         return Ok(Command::PerfReport);
@@ -63,16 +129,90 @@ pub fn parse() -> Result<Command> {
     if cli.check {
         return Ok(Command::CheckToolchains);
     }
+    if bench_command {
+        ensure!(
+            !(cli.dry_run || cli.timings || cli.watch || cli.interactive),
+            "'run bench' cannot be combined with --dry-run, --timings, --watch or --interactive"
+        );
+    }
+    ensure!(
+        bench_command || cli.runs.is_none(),
+        "--runs only applies to 'run bench'"
+    );
+    if cli.code.is_empty()
+        && cli.file.is_none()
+        && cli.lang.is_none()
+        && args.len() == 2
+        && args[0] == "cache"
+    {
+        ensure!(
+            args[1] == "clear",
+            "Unknown cache command '{}'; expected 'run cache clear'",
+            args[1]
+        );
+        return Ok(Command::CacheClear);
+    }
+    if cli.code.is_empty()
+        && cli.file.is_none()
+        && cli.lang.is_none()
+        && args.len() == 2
+        && args[0] == "config"
+    {
+        ensure!(
+            args[1] == "path",
+            "Unknown config command '{}'; expected 'run config path'",
+            args[1]
+        );
+        return Ok(Command::ConfigPath);
+    }
+    if cli.code.is_empty()
+        && cli.file.is_none()
+        && cli.lang.is_none()
+        && args.first().is_some_and(|arg| arg == "list")
+    {
+        // `--json` after `list` lands in the trailing args.
+        let rest = &args[1..];
+        ensure!(
+            rest.iter().all(|arg| arg == "--json"),
+            "Unexpected arguments after 'run list': {}",
+            rest.join(" ")
+        );
+        return Ok(Command::ListEngines {
+            json: cli.json || !rest.is_empty(),
+        });
+    }
+    if cli.code.is_empty()
+        && cli.file.is_none()
+        && cli.lang.is_none()
+        && args.first().is_some_and(|arg| arg == "init")
+    {
+        return parse_init(&args[1..]);
+    }
+    if cli.code.is_empty()
+        && cli.file.is_none()
+        && cli.lang.is_none()
+        && args.first().is_some_and(|arg| arg == "version")
+    {
+        let rest = &args[1..];
+        ensure!(
+            rest.iter().all(|arg| arg == "--engines"),
+            "Unexpected arguments after 'run version': {}",
+            rest.join(" ")
+        );
+        return Ok(Command::ShowVersion {
+            engines: !rest.is_empty(),
+        });
+    }
     if cli.versions {
         ensure!(
-            cli.code.is_none() && cli.file.is_none(),
+            cli.code.is_empty() && cli.file.is_none(),
             "--versions does not accept --code or --file"
         );
         let mut language = cli
             .lang
             .as_ref()
             .map(|value| LanguageSpec::new(value.to_string()));
-        let mut trailing = cli.args.clone();
+        let mut trailing = args.clone();
         if language.is_none()
             && trailing.len() == 1
             && crate::language::is_language_token(&trailing[0])
@@ -99,9 +239,17 @@ pub fn parse() -> Result<Command> {
     }
 
     // Apply --timeout if provided
-    if let Some(secs) = cli.timeout {
+    if let Some(timeout) = cli.timeout {
+        // Microseconds, the finest unit RUN_TIMEOUT_SECS takes, so a
+        // sub-millisecond timeout is not rounded away.
+        // SAFETY: called at startup before any threads are spawned
+        unsafe { std::env::set_var("RUN_TIMEOUT_SECS", format!("{}us", timeout.as_micros())) };
+    }
+
+    // Apply --max-output if provided
+    if let Some(bytes) = cli.max_output {
         // SAFETY: called at startup before any threads are spawned
-        unsafe { std::env::set_var("RUN_TIMEOUT_SECS", secs.to_string()) };
+        unsafe { std::env::set_var("RUN_MAX_OUTPUT", bytes.to_string()) };
     }
 
     // Apply --timing if provided
@@ -110,7 +258,75 @@ pub fn parse() -> Result<Command> {
         unsafe { std::env::set_var("RUN_TIMING", "1") };
     }
 
-    if let Some(code) = cli.code.as_ref() {
+    // Apply --no-history if provided
+    if cli.no_history {
+        // SAFETY: called at startup before any threads are spawned
+        unsafe { std::env::set_var("RUN_NO_HISTORY", "1") };
+    }
+
+    // Apply --color; --json output never carries escape codes
+    let color = if cli.json {
+        Some(ColorChoice::Never)
+    } else {
+        cli.color
+    };
+    if let Some(color) = color {
+        // SAFETY: called at startup before any threads are spawned
+        unsafe { std::env::set_var("RUN_COLOR", color.as_str()) };
+    }
+
+    // Apply --no-cache if provided; a dry run shows the compile step too
+    if cli.no_cache || cli.dry_run {
+        // SAFETY: called at startup before any threads are spawned
+        unsafe { std::env::set_var("RUN_NO_CACHE", "1") };
+    }
+    if cli.keep_temp {
+        // SAFETY: called at startup before any threads are spawned
+        unsafe { std::env::set_var("RUN_KEEP_TEMP", "1") };
+    }
+
+    if cli.sandbox && !crate::engine::SandboxLimits::SUPPORTED {
+        eprintln!("warning: --sandbox is only enforced on Linux; running without resource limits");
+    }
+    let mut program_env = ProgramEnv {
+        vars: Vec::new(),
+        clean: cli.clean_env,
+        cwd: None,
+        sandbox: cli.sandbox && crate::engine::SandboxLimits::SUPPORTED,
+        flags: Vec::new(),
+    };
+    for raw in &cli.flags {
+        program_env
+            .flags
+            .extend(crate::engine::parse_toolchain_flags(raw)?);
+    }
+    if let Some(dir) = cli.cwd.as_ref() {
+        ensure!(dir.is_dir(), "--cwd {} is not a directory", dir.display());
+        program_env.cwd = Some(std::path::absolute(dir)?);
+    }
+    for path in &cli.env_file {
+        program_env.vars.extend(crate::engine::load_env_file(path)?);
+    }
+    program_env.vars.extend(cli.env.iter().cloned());
+
+    // Repeated --code fragments form one program, in flag order.
+    let fragments = cli
+        .code
+        .iter()
+        .enumerate()
+        .map(|(index, raw)| {
+            let which = if cli.code.len() > 1 {
+                format!("--code value {}", index + 1)
+            } else {
+                "--code".to_string()
+            };
+            let fragment = utf8_argument(raw, &which)?;
+            ensure!(!fragment.is_empty(), "{which} must not be empty");
+            Ok(fragment)
+        })
+        .collect::<Result<Vec<_>>>()?;
+    let code = (!fragments.is_empty()).then(|| fragments.join("\n"));
+    if let Some(code) = code.as_ref() {
         ensure!(
             !code.trim().is_empty(),
             "Inline code provided via --code must not be empty"
@@ -118,7 +334,7 @@ pub fn parse() -> Result<Command> {
     }
 
     let mut detect_language = !cli.no_detect;
-    let mut trailing = cli.args.clone();
+    let mut trailing = args.clone();
     let mut script_args: Vec<String> = Vec::new();
 
     let mut language = cli
@@ -136,16 +352,13 @@ pub fn parse() -> Result<Command> {
 
     let mut source: Option<InputSource> = None;
 
-    if let Some(code) = cli.code {
+    if let Some(code) = code {
         ensure!(
             cli.file.is_none(),
-            "--code/--inline cannot be combined with --file"
+            "--code and --file cannot be used together; pass the program inline or as a file"
         );
         source = Some(InputSource::Inline(code));
         script_args = trailing;
-        if script_args.first().map(|token| token.as_str()) == Some("--") {
-            script_args.remove(0);
-        }
         trailing = Vec::new();
     }
 
@@ -154,9 +367,6 @@ pub fn parse() -> Result<Command> {
     {
         source = Some(InputSource::File(path));
         script_args = trailing;
-        if script_args.first().map(|token| token.as_str()) == Some("--") {
-            script_args.remove(0);
-        }
         trailing = Vec::new();
     }
 
@@ -164,14 +374,11 @@ pub fn parse() -> Result<Command> {
         match trailing.first().map(|token| token.as_str()) {
             Some("-c") | Some("--code") => {
                 trailing.remove(0);
-                let (code_tokens, extra_args) = split_at_double_dash(&trailing);
                 ensure!(
-                    !code_tokens.is_empty(),
+                    !trailing.is_empty(),
                     "--code/--inline requires a code argument"
                 );
-                let joined = join_tokens(&code_tokens);
-                source = Some(InputSource::Inline(joined));
-                script_args = extra_args;
+                source = Some(InputSource::Inline(join_tokens(&trailing)));
                 trailing.clear();
             }
             Some("-f") | Some("--file") => {
@@ -179,9 +386,6 @@ pub fn parse() -> Result<Command> {
                 ensure!(!trailing.is_empty(), "--file requires a path argument");
                 let path = trailing.remove(0);
                 source = Some(InputSource::File(PathBuf::from(path)));
-                if trailing.first().map(|token| token.as_str()) == Some("--") {
-                    trailing.remove(0);
-                }
                 script_args = trailing.clone();
                 trailing.clear();
             }
@@ -194,17 +398,11 @@ pub fn parse() -> Result<Command> {
         match first.as_str() {
             "-" => {
                 source = Some(InputSource::Stdin);
-                if trailing.first().map(|token| token.as_str()) == Some("--") {
-                    trailing.remove(0);
-                }
                 script_args = trailing.clone();
                 trailing.clear();
             }
             _ if looks_like_path(&first) => {
                 source = Some(InputSource::File(PathBuf::from(first)));
-                if trailing.first().map(|token| token.as_str()) == Some("--") {
-                    trailing.remove(0);
-                }
                 script_args = trailing.clone();
                 trailing.clear();
             }
@@ -212,14 +410,30 @@ pub fn parse() -> Result<Command> {
                 let mut all_tokens = Vec::with_capacity(trailing.len() + 1);
                 all_tokens.push(first);
                 all_tokens.append(&mut trailing);
-                let (code_tokens, extra_args) = split_at_double_dash(&all_tokens);
-                let joined = join_tokens(&code_tokens);
-                source = Some(InputSource::Inline(joined));
-                script_args = extra_args;
+                source = Some(InputSource::Inline(join_tokens(&all_tokens)));
             }
         }
     }
 
+    if !program_args.is_empty() {
+        ensure!(
+            source.is_some() || !std::io::stdin().is_terminal(),
+            "arguments after -- are passed to the program; provide one with --code or --file"
+        );
+        script_args.extend(program_args);
+    }
+
+    if let Some(InputSource::Inline(code)) = source.as_mut() {
+        if cli.normalize {
+            *code = normalize_typography(code);
+        } else if let Some((ch, line, column)) = first_typographic_char(code) {
+            eprintln!(
+                "warning: inline code contains '{ch}' (U+{:04X}) at line {line}, column {column}, likely from a copy-paste; --normalize replaces typographic quotes and dashes with ASCII",
+                ch as u32
+            );
+        }
+    }
+
     if source.is_none() && !cli.interactive {
         let stdin = std::io::stdin();
         if !stdin.is_terminal() {
@@ -227,10 +441,52 @@ pub fn parse() -> Result<Command> {
         }
     }
 
+    // One-shot runs happen next to the file, or where run was started for
+    // inline code, rather than in the temp dir holding the generated source.
+    // The file path is made absolute since the program no longer starts in
+    // the directory it was given relative to.
+    if let Some(InputSource::File(path)) = source.as_mut() {
+        *path = std::path::absolute(&*path)?;
+    }
+    if !cli.interactive && !program_env.sandbox && program_env.cwd.is_none() {
+        program_env.cwd = match &source {
+            Some(InputSource::File(path)) => path.parent().map(Path::to_path_buf),
+            Some(_) => std::env::current_dir().ok(),
+            None => None,
+        };
+    }
+
+    // Apply --interpreter to the engine of the language being run
+    if let Some(binary) = cli.interpreter.as_ref() {
+        let target = language
+            .as_ref()
+            .map(|spec| spec.canonical_id().to_string())
+            .or_else(|| match &source {
+                Some(InputSource::File(path)) => path
+                    .extension()
+                    .and_then(|ext| ext.to_str())
+                    .and_then(crate::engine::extension_to_language)
+                    .map(str::to_string),
+                _ => None,
+            });
+        let Some(target) = target else {
+            bail!("--interpreter needs --lang (or a file whose extension names the language)");
+        };
+        // SAFETY: called at startup before any threads are spawned
+        unsafe { std::env::set_var(crate::engine::binary_override_key(&target), binary) };
+    }
+
+    // Apply --pip; the Python engine installs these into a cached virtualenv
+    if !cli.pip.is_empty() {
+        // SAFETY: called at startup before any threads are spawned
+        unsafe { std::env::set_var("RUN_PIP_PACKAGES", cli.pip.join("\n")) };
+    }
+
     if cli.interactive {
         return Ok(Command::Repl {
             initial_language: language,
             detect_language,
+            env: program_env,
         });
     }
 
@@ -244,8 +500,17 @@ pub fn parse() -> Result<Command> {
             source,
             detect_language,
             args: script_args,
+            json: cli.json,
+            timings: cli.timings,
+            dry_run: cli.dry_run,
+            env: program_env,
         };
-        if let Some(n) = cli.bench {
+        let iterations = if bench_command {
+            Some(cli.runs.or(cli.bench).unwrap_or_else(default_bench_runs))
+        } else {
+            cli.bench
+        };
+        if let Some(n) = iterations {
             return Ok(Command::Bench {
                 spec,
                 iterations: n.max(1),
@@ -257,9 +522,15 @@ pub fn parse() -> Result<Command> {
         return Ok(Command::Execute(spec));
     }
 
+    ensure!(
+        !bench_command,
+        "'run bench' needs a program; pass --code, --file or a file path"
+    );
+
     Ok(Command::Repl {
         initial_language: language,
         detect_language,
+        env: program_env,
     })
 }
 
@@ -291,25 +562,59 @@ struct Cli {
     )]
     file: Option<PathBuf>,
 
+    /// Code to run; repeat to join several fragments with newlines, in order
     #[arg(
         short = 'c',
         long = "code",
         value_name = "CODE",
-        value_parser = NonEmptyStringValueParser::new()
+        action = clap::ArgAction::Append,
+        value_parser = clap::value_parser!(OsString)
     )]
-    code: Option<String>,
+    code: Vec<OsString>,
+
+    /// Replace typographic quotes, dashes and spaces in inline code with their ASCII forms
+    #[arg(long = "normalize", action = clap::ArgAction::SetTrue)]
+    normalize: bool,
 
     #[arg(long = "no-detect", action = clap::ArgAction::SetTrue)]
     no_detect: bool,
 
-    /// Maximum execution time in seconds (default: 60, override with RUN_TIMEOUT_SECS)
-    #[arg(long = "timeout", value_name = "SECS")]
-    timeout: Option<u64>,
+    /// Maximum execution time, e.g. 5s or 500ms; bare numbers are seconds (default: 60s, override with RUN_TIMEOUT_SECS)
+    #[arg(long = "timeout", value_name = "DURATION", value_parser = parse_timeout)]
+    timeout: Option<Duration>,
+
+    /// Kill the program once it has written more than this much to stdout and stderr, e.g. 4096, 64k or 1M (default: unlimited, override with RUN_MAX_OUTPUT)
+    #[arg(long = "max-output", value_name = "BYTES", value_parser = parse_max_output)]
+    max_output: Option<usize>,
 
     /// Show execution timing after each run
     #[arg(long = "timing", action = clap::ArgAction::SetTrue)]
     timing: bool,
 
+    /// Always recompile instead of reusing cached binaries (same as RUN_NO_CACHE=1)
+    #[arg(long = "no-cache", action = clap::ArgAction::SetTrue)]
+    no_cache: bool,
+
+    /// Keep the generated sources, build directories and binaries and print where they are
+    #[arg(long = "keep-temp", action = clap::ArgAction::SetTrue)]
+    keep_temp: bool,
+
+    /// Color stderr red and dim status lines: auto (terminal and no NO_COLOR), always, never
+    #[arg(long = "color", value_name = "WHEN", value_enum)]
+    color: Option<ColorChoice>,
+
+    /// Install a Python package into a cached virtualenv before running (repeatable; Python only)
+    #[arg(long = "pip", value_name = "PKG", value_parser = NonEmptyStringValueParser::new())]
+    pip: Vec<String>,
+
+    /// Executable to run instead of the engine's default, e.g. python3.11 or /opt/gcc/bin/gcc
+    #[arg(long = "interpreter", value_name = "BIN", value_parser = NonEmptyStringValueParser::new())]
+    interpreter: Option<String>,
+
+    /// Working directory for the program (default: the --file's directory, else the current one)
+    #[arg(long = "cwd", value_name = "DIR", value_hint = ValueHint::DirPath)]
+    cwd: Option<PathBuf>,
+
     /// Check which language toolchains are available
     #[arg(long = "check", action = clap::ArgAction::SetTrue)]
     check: bool,
@@ -322,10 +627,14 @@ struct Cli {
     #[arg(long = "install", value_name = "PACKAGE")]
     install: Option<String>,
 
-    /// Benchmark: run code N times and report min/max/avg timing
+    /// Benchmark: run code N times and report min/max/mean/median/stddev timing
     #[arg(long = "bench", value_name = "N")]
     bench: Option<u32>,
 
+    /// Number of timed runs for `run bench` (default: bench_iterations from the config, or 10)
+    #[arg(long = "runs", value_name = "N")]
+    runs: Option<u32>,
+
     /// Watch a file and re-execute on changes
     #[arg(short = 'w', long = "watch", action = clap::ArgAction::SetTrue)]
     watch: bool,
@@ -338,26 +647,200 @@ struct Cli {
     #[arg(long = "perf-reset", action = clap::ArgAction::SetTrue)]
     perf_reset: bool,
 
+    /// Print one JSON object per run (stdout, stderr, exit_code, duration_ms, ...)
+    #[arg(long = "json", action = clap::ArgAction::SetTrue, conflicts_with_all = ["watch", "interactive"])]
+    json: bool,
+
+    /// Report compile_ms, run_ms and total_ms for the run (on stderr, or in the --json object)
+    #[arg(long = "timings", action = clap::ArgAction::SetTrue, conflicts_with_all = ["bench", "watch", "interactive"])]
+    timings: bool,
+
+    /// Print the generated source and the compile and run commands instead of running them (implies --no-cache)
+    #[arg(long = "dry-run", action = clap::ArgAction::SetTrue, conflicts_with_all = ["bench", "watch", "interactive", "json", "timings"])]
+    dry_run: bool,
+
+    /// Set an environment variable for the program (repeatable)
+    #[arg(long = "env", value_name = "KEY=VALUE", value_parser = parse_env_var)]
+    env: Vec<(String, String)>,
+
+    /// Load environment variables for the program from a KEY=VALUE file (repeatable)
+    #[arg(long = "env-file", value_name = "PATH", value_hint = ValueHint::FilePath)]
+    env_file: Vec<PathBuf>,
+
+    /// Extra compiler or interpreter flags, split like a shell would (repeatable), e.g. --flags "-std=c++20 -O2"
+    #[arg(long = "flags", value_name = "FLAGS", allow_hyphen_values = true)]
+    flags: Vec<String>,
+
+    /// Start the program with only --env/--env-file variables and PATH
+    #[arg(long = "clean-env", action = clap::ArgAction::SetTrue)]
+    clean_env: bool,
+
+    /// Limit the program's CPU time, memory, open files and processes and run it in a scratch directory (Linux only)
+    #[arg(long = "sandbox", action = clap::ArgAction::SetTrue)]
+    sandbox: bool,
+
+    /// Don't read or write REPL history for this session
+    #[arg(long = "no-history", action = clap::ArgAction::SetTrue)]
+    no_history: bool,
+
     /// Force REPL (interactive) mode even when stdin is not a TTY (e.g. piped input)
     #[arg(short = 'i', long = "interactive", action = clap::ArgAction::SetTrue)]
     interactive: bool,
 
-    #[arg(value_name = "ARGS", trailing_var_arg = true)]
-    args: Vec<String>,
+    #[arg(
+        value_name = "ARGS",
+        trailing_var_arg = true,
+        value_parser = clap::value_parser!(OsString)
+    )]
+    args: Vec<OsString>,
+}
+
+/// `run init [--force] [--stdout] <lang> [path]`. The flags land in the
+/// trailing args with the rest, so they are picked out here and may come in
+/// any position.
+fn parse_init(rest: &[String]) -> Result<Command> {
+    let (flags, positional): (Vec<&String>, Vec<&String>) =
+        rest.iter().partition(|arg| arg.starts_with("--"));
+    let mut force = false;
+    let mut stdout = false;
+    for flag in flags {
+        match flag.as_str() {
+            "--force" => force = true,
+            "--stdout" => stdout = true,
+            other => bail!("Unknown option '{other}' for 'run init'; expected --force or --stdout"),
+        }
+    }
+    let usage = "usage: run init <language> <path>, or run init --stdout <language>";
+    let (language, path) = match (positional.as_slice(), stdout) {
+        ([language], true) => (language, None),
+        ([language, path], false) => (language, Some(PathBuf::from(path.as_str()))),
+        ([_], false) => bail!("'run init' needs a path to write to ({usage})"),
+        ([_, _], true) => bail!(
+            "--stdout prints the starter program instead of writing a file; drop the path ({usage})"
+        ),
+        _ => bail!("{usage}"),
+    };
+    ensure!(
+        !(force && stdout),
+        "--force only applies when 'run init' writes a file"
+    );
+    Ok(Command::Init {
+        language: LanguageSpec::new(language.to_string()),
+        path,
+        force,
+    })
+}
+
+/// Timed runs for `run bench` without `--runs`: `RUN_BENCH_RUNS` (set from
+/// `bench_iterations` in the config), or 10.
+fn default_bench_runs() -> u32 {
+    std::env::var("RUN_BENCH_RUNS")
+        .ok()
+        .and_then(|value| value.trim().parse().ok())
+        .unwrap_or(10)
+}
+
+fn parse_timeout(raw: &str) -> Result<Duration, String> {
+    let timeout = crate::engine::parse_duration(raw).map_err(|err| err.to_string())?;
+    if timeout < Duration::from_micros(1) {
+        return Err(format!("timeout must be at least 1us: '{raw}'"));
+    }
+    Ok(timeout)
+}
+
+fn parse_max_output(raw: &str) -> Result<usize, String> {
+    crate::engine::parse_byte_size(raw).map_err(|err| err.to_string())
+}
+
+fn parse_env_var(raw: &str) -> Result<(String, String), String> {
+    crate::engine::parse_env_assignment(raw).map_err(|err| err.to_string())
+}
+
+/// A `--code` value or positional argument as UTF-8. Invalid bytes are an error naming their offset,
+/// since passing them on (or replacing them) only makes the engine fail in a
+/// more confusing way.
+fn utf8_argument(raw: &OsString, what: &str) -> Result<String> {
+    let bytes = raw.as_encoded_bytes();
+    let code = match std::str::from_utf8(bytes) {
+        Ok(code) => code,
+        Err(err) => {
+            let offset = err.valid_up_to();
+            let before = String::from_utf8_lossy(&bytes[..offset]);
+            let line = before.matches('\n').count() + 1;
+            bail!(
+                "{what} is not valid UTF-8: byte 0x{:02X} at offset {offset} (line {line}) does not start a UTF-8 character; re-save the code as UTF-8",
+                bytes[offset]
+            );
+        }
+    };
+    Ok(code.to_string())
+}
+
+/// Typographic characters that editors, word processors and chat apps put
+/// in place of what was typed, and the ASCII `--normalize` restores.
+const TYPOGRAPHIC: &[(char, &str)] = &[
+    ('\u{201C}', "\""),
+    ('\u{201D}', "\""),
+    ('\u{201E}', "\""),
+    ('\u{2033}', "\""),
+    ('\u{2018}', "'"),
+    ('\u{2019}', "'"),
+    ('\u{201A}', "'"),
+    ('\u{2032}', "'"),
+    ('\u{2013}', "-"),
+    ('\u{2014}', "--"),
+    ('\u{2212}', "-"),
+    ('\u{2026}', "..."),
+    ('\u{00A0}', " "),
+    ('\u{202F}', " "),
+    ('\u{200B}', ""),
+    ('\u{FEFF}', ""),
+];
+
+fn normalize_typography(code: &str) -> String {
+    let mut normalized = String::with_capacity(code.len());
+    for ch in code.chars() {
+        match TYPOGRAPHIC.iter().find(|(from, _)| *from == ch) {
+            Some((_, to)) => normalized.push_str(to),
+            None => normalized.push(ch),
+        }
+    }
+    normalized
+}
+
+/// The first character [`normalize_typography`] would replace, with its
+/// 1-based line and column.
+fn first_typographic_char(code: &str) -> Option<(char, usize, usize)> {
+    code.lines().enumerate().find_map(|(line, text)| {
+        text.chars()
+            .enumerate()
+            .find(|(_, ch)| TYPOGRAPHIC.iter().any(|(from, _)| from == ch))
+            .map(|(column, ch)| (ch, line + 1, column + 1))
+    })
 }
 
 fn join_tokens(tokens: &[String]) -> String {
     tokens.join(" ")
 }
 
-fn split_at_double_dash(tokens: &[String]) -> (Vec<String>, Vec<String>) {
-    if let Some(index) = tokens.iter().position(|token| token == "--") {
-        let before = tokens[..index].to_vec();
-        let after = tokens[index + 1..].to_vec();
-        (before, after)
-    } else {
-        (tokens.to_vec(), Vec::new())
-    }
+/// Split argv at the first `--`: what comes before is parsed as run's own
+/// flags, everything after is handed to the program untouched (no re-joining
+/// or re-quoting, so arguments with spaces arrive as single argv entries).
+fn split_program_args(
+    args: impl IntoIterator<Item = OsString>,
+) -> Result<(Vec<OsString>, Vec<String>)> {
+    let mut cli_args: Vec<OsString> = args.into_iter().collect();
+    let Some(index) = cli_args.iter().skip(1).position(|arg| arg == "--") else {
+        return Ok((cli_args, Vec::new()));
+    };
+    let program_args = cli_args
+        .split_off(index + 1)
+        .iter()
+        .skip(1)
+        .enumerate()
+        .map(|(index, arg)| utf8_argument(arg, &format!("program argument {}", index + 1)))
+        .collect::<Result<_>>()?;
+    Ok((cli_args, program_args))
 }
 
 fn looks_like_path(token: &str) -> bool {
@@ -383,21 +866,11 @@ fn looks_like_path(token: &str) -> bool {
         return false;
     }
 
-    if let Some(ext) = path.extension().and_then(|ext| ext.to_str()) {
-        let ext_lower = ext.to_ascii_lowercase();
-        if KNOWN_CODE_EXTENSIONS
-            .iter()
-            .any(|candidate| candidate == &ext_lower.as_str())
-        {
-            return true;
-        }
+    if let Some(ext) = path.extension().and_then(|ext| ext.to_str())
+        && crate::engine::extension_to_language(ext).is_some()
+    {
+        return true;
     }
 
     false
 }
-
-const KNOWN_CODE_EXTENSIONS: &[&str] = &[
-    "py", "pyw", "rs", "rlib", "go", "js", "mjs", "cjs", "ts", "tsx", "jsx", "rb", "lua", "sh",
-    "bash", "zsh", "ps1", "php", "java", "kt", "swift", "scala", "clj", "fs", "cs", "c", "cc",
-    "cpp", "h", "hpp", "pl", "jl", "ex", "exs", "ml", "hs",
-];
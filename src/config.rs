@@ -1,8 +1,13 @@
+use std::collections::BTreeMap;
 use std::path::{Path, PathBuf};
 
 use serde::Deserialize;
 
-/// Project-level configuration loaded from `run.toml` or `.runrc`.
+use crate::output::ColorChoice;
+
+/// Configuration loaded from the user's `config.toml` (see [`user_config_path`])
+/// and the project's `run.toml` or `.runrc`. Values only fill in what the
+/// environment and command-line flags leave unset.
 #[derive(Debug, Default, Deserialize)]
 #[serde(default)]
 pub struct RunConfig {
@@ -10,16 +15,36 @@ pub struct RunConfig {
     pub language: Option<String>,
     /// Execution timeout in seconds.
     pub timeout: Option<u64>,
+    /// Output cap in bytes; the program is killed once it writes more.
+    pub max_output: Option<u64>,
     /// Always show execution timing.
     pub timing: Option<bool>,
+    /// Default for `--color`: `auto`, `always` or `never`.
+    pub color: Option<ColorChoice>,
     /// Default benchmark iterations.
     pub bench_iterations: Option<u32>,
+    /// Entries kept in each REPL history file.
+    pub history_size: Option<usize>,
+    /// Use one REPL history for all languages instead of one per language.
+    pub shared_history: Option<bool>,
+    /// Executable per language, e.g. `python = "python3.11"` under `[binaries]`.
+    pub binaries: BTreeMap<String, String>,
 }
 
 impl RunConfig {
+    /// The user config layered under the nearest project config, so a
+    /// project's `run.toml` wins over `~/.config/run/config.toml`.
+    pub fn discover() -> Self {
+        let user = user_config_path()
+            .filter(|path| path.is_file())
+            .and_then(|path| Self::load(&path).ok())
+            .unwrap_or_default();
+        user.overlay(Self::discover_project())
+    }
+
     /// Search for a config file in the current directory and ancestors.
     /// Checks `run.toml`, then `.runrc` (TOML format).
-    pub fn discover() -> Self {
+    pub fn discover_project() -> Self {
         let cwd = std::env::current_dir().ok();
         let cwd = match cwd {
             Some(ref p) => p.as_path(),
@@ -40,6 +65,23 @@ impl RunConfig {
         Self::default()
     }
 
+    /// `other`'s values where it sets them, `self`'s otherwise. `[binaries]`
+    /// entries are merged per language.
+    pub fn overlay(mut self, other: Self) -> Self {
+        self.binaries.extend(other.binaries);
+        Self {
+            language: other.language.or(self.language),
+            timeout: other.timeout.or(self.timeout),
+            max_output: other.max_output.or(self.max_output),
+            timing: other.timing.or(self.timing),
+            color: other.color.or(self.color),
+            bench_iterations: other.bench_iterations.or(self.bench_iterations),
+            history_size: other.history_size.or(self.history_size),
+            shared_history: other.shared_history.or(self.shared_history),
+            binaries: self.binaries,
+        }
+    }
+
     pub fn load(path: &Path) -> Result<Self, String> {
         let content = std::fs::read_to_string(path)
             .map_err(|e| format!("failed to read {}: {e}", path.display()))?;
@@ -55,6 +97,14 @@ impl RunConfig {
                 std::env::set_var("RUN_TIMEOUT_SECS", secs.to_string());
             }
         }
+        if let Some(bytes) = self.max_output
+            && std::env::var("RUN_MAX_OUTPUT").is_err()
+        {
+            // SAFETY: called once at startup before any threads are spawned.
+            unsafe {
+                std::env::set_var("RUN_MAX_OUTPUT", bytes.to_string());
+            }
+        }
         if let Some(true) = self.timing
             && std::env::var("RUN_TIMING").is_err()
         {
@@ -63,6 +113,47 @@ impl RunConfig {
                 std::env::set_var("RUN_TIMING", "1");
             }
         }
+        if let Some(color) = self.color
+            && std::env::var("RUN_COLOR").is_err()
+        {
+            // SAFETY: called once at startup before any threads are spawned.
+            unsafe {
+                std::env::set_var("RUN_COLOR", color.as_str());
+            }
+        }
+        if let Some(runs) = self.bench_iterations
+            && std::env::var("RUN_BENCH_RUNS").is_err()
+        {
+            // SAFETY: called once at startup before any threads are spawned.
+            unsafe {
+                std::env::set_var("RUN_BENCH_RUNS", runs.to_string());
+            }
+        }
+        if let Some(size) = self.history_size
+            && std::env::var("RUN_HISTORY_SIZE").is_err()
+        {
+            // SAFETY: called once at startup before any threads are spawned.
+            unsafe {
+                std::env::set_var("RUN_HISTORY_SIZE", size.to_string());
+            }
+        }
+        if let Some(true) = self.shared_history
+            && std::env::var("RUN_HISTORY_SHARED").is_err()
+        {
+            // SAFETY: called once at startup before any threads are spawned.
+            unsafe {
+                std::env::set_var("RUN_HISTORY_SHARED", "1");
+            }
+        }
+        for (language, binary) in &self.binaries {
+            let key = crate::engine::binary_override_key(language);
+            if std::env::var_os(&key).is_none() {
+                // SAFETY: called once at startup before any threads are spawned.
+                unsafe {
+                    std::env::set_var(key, binary);
+                }
+            }
+        }
     }
 
     pub fn find_config_path() -> Option<PathBuf> {
@@ -78,3 +169,22 @@ impl RunConfig {
         None
     }
 }
+
+/// Where the user config is read from: `config.toml` in [`config_dir`].
+pub fn user_config_path() -> Option<PathBuf> {
+    config_dir().map(|dir| dir.join("config.toml"))
+}
+
+/// Per-user directory for run's own files: `$XDG_CONFIG_HOME/run`, falling
+/// back to `~/.config/run` (`%APPDATA%\run` on Windows).
+pub fn config_dir() -> Option<PathBuf> {
+    if let Some(base) = std::env::var_os("XDG_CONFIG_HOME").filter(|v| !v.is_empty()) {
+        return Some(PathBuf::from(base).join("run"));
+    }
+    #[cfg(windows)]
+    if let Some(appdata) = std::env::var_os("APPDATA") {
+        return Some(PathBuf::from(appdata).join("run"));
+    }
+    let home = std::env::var_os("HOME")?;
+    Some(PathBuf::from(home).join(".config").join("run"))
+}
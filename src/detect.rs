@@ -7,6 +7,9 @@ pub fn detect_language_from_snippet(code: &str) -> Option<&'static str> {
         return None;
     }
 
+    if SQL_SIGNATURE.is_match(trimmed) {
+        return Some("sql");
+    }
     if PYTHON_SIGNATURE.is_match(trimmed) {
         return Some("python");
     }
@@ -86,6 +89,14 @@ pub fn detect_language_from_snippet(code: &str) -> Option<&'static str> {
     None
 }
 
+// Only the first statement counts: SQL keywords are common words elsewhere.
+static SQL_SIGNATURE: Lazy<Regex> = Lazy::new(|| {
+    Regex::new(
+        r"(?i)^(select\s[^;]*\bfrom\s|create\s+(temp\s+|temporary\s+)?(table|view|index)\s|insert\s+into\s|with\s+\w+\s+as\s*\()",
+    )
+    .expect("valid sql regex")
+});
+
 static PYTHON_SIGNATURE: Lazy<Regex> = Lazy::new(|| {
     Regex::new(
         r"(?m)^(from\s+[\w\.]+\s+import|import\s+[\w\.]+|def\s+[A-Za-z_][\w]*\(|class\s+[A-Za-z_])",
@@ -5,10 +5,12 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    child_stdin, run_version_command, run_with_timeout, spawn_program, temp_builder,
+    toolchain_flags,
 };
 
 pub struct BashEngine {
@@ -34,6 +36,13 @@ impl BashEngine {
     fn run_command(&self) -> Command {
         Command::new(self.binary())
     }
+
+    /// Like `run_command`, with `--flags` ahead of the script.
+    fn program_command(&self) -> Command {
+        let mut cmd = self.run_command();
+        cmd.args(toolchain_flags());
+        cmd
+    }
 }
 
 impl LanguageEngine for BashEngine {
@@ -72,29 +81,35 @@ impl LanguageEngine for BashEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        Some(self.executable.clone())
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let args = payload.args();
         let output = match payload {
             ExecutionPayload::Inline { code, .. } => {
-                let mut cmd = self.run_command();
-                cmd.arg("-c").arg(code).args(args);
-                cmd.stdin(Stdio::inherit());
-                cmd.output()
+                let mut cmd = self.program_command();
+                // With -c the first argument becomes $0; keep "bash" there so
+                // program arguments start at $1 like they do for files.
+                cmd.arg("-c").arg(code).arg("bash").args(args);
+                cmd.stdin(child_stdin());
+                run_with_timeout(&mut cmd)
             }
             ExecutionPayload::File { path, .. } => {
-                let mut cmd = self.run_command();
+                let mut cmd = self.program_command();
                 cmd.arg(path).args(args);
-                cmd.stdin(Stdio::inherit());
-                cmd.output()
+                cmd.stdin(child_stdin());
+                run_with_timeout(&mut cmd)
             }
             ExecutionPayload::Stdin { code, .. } => {
-                let mut cmd = self.run_command();
-                cmd.args(args);
+                let mut cmd = self.program_command();
+                cmd.arg("-s").arg("--").args(args);
                 cmd.stdin(Stdio::piped())
                     .stdout(Stdio::piped())
                     .stderr(Stdio::piped());
-                let mut child = cmd.spawn().with_context(|| {
+                let mut child = spawn_program(&mut cmd).with_context(|| {
                     format!(
                         "failed to start {} for stdin execution",
                         self.binary().display()
@@ -117,15 +132,24 @@ impl LanguageEngine for BashEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("#!/usr/bin/env bash\n\necho \"Hello, world!\"\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         Ok(Box::new(BashSession::new(self.executable.clone())?))
     }
 }
 
 fn resolve_bash_binary() -> PathBuf {
+    if let Some(path) = binary_override("bash") {
+        return path;
+    }
     let candidates = ["bash", "sh"];
     for name in candidates {
         if let Ok(path) = which::which(name) {
@@ -146,7 +170,7 @@ struct BashSession {
 
 impl BashSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-bash-repl")
             .tempdir()
             .context("failed to create temporary directory for bash repl")?;
@@ -207,6 +231,8 @@ impl BashSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -218,7 +244,7 @@ impl BashSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.dir.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute bash session script {} with {}",
                 self.script_path.display(),
@@ -262,6 +288,8 @@ impl LanguageSession for BashSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -273,6 +301,8 @@ impl LanguageSession for BashSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -285,6 +315,8 @@ impl LanguageSession for BashSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -292,6 +324,10 @@ impl LanguageSession for BashSession {
         self.run_snippet(snippet)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
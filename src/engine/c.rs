@@ -5,11 +5,13 @@ use std::process::{Command, Stdio};
 use std::time::Instant;
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, cache_lookup, cache_store,
-    compiler_command, hash_source, perf_record, run_version_command, try_cached_execution,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, SnippetLines,
+    binary_override, build_step_output, cache_lookup, cache_store, child_stdin,
+    compile_cache_enabled, compile_cache_key, compiler_command, perf_record, run_version_command,
+    run_with_timeout, temp_builder, toolchain_flags, try_cached_execution,
 };
 
 pub struct CEngine {
@@ -37,6 +39,12 @@ impl CEngine {
         })
     }
 
+    /// Compile-cache key for `source`, tied to this compiler's version.
+    fn cache_key(&self, source: &str) -> Result<u64> {
+        let compiler = self.ensure_compiler()?;
+        Ok(compile_cache_key(self.id(), compiler, "--version", source))
+    }
+
     fn write_source(&self, code: &str, dir: &Path) -> Result<PathBuf> {
         let source_path = dir.join("main.c");
         let prepared = prepare_inline_source(code);
@@ -64,15 +72,17 @@ impl CEngine {
     fn compile(&self, source: &Path, output: &Path) -> Result<std::process::Output> {
         let compiler = self.ensure_compiler()?;
         let mut cmd = compiler_command(compiler);
+        // `--flags` come after the defaults, so `-std=` and `-O` in them win.
         cmd.arg(source)
             .arg("-std=c11")
             .arg("-O0")
             .arg("-w")
+            .args(toolchain_flags())
             .arg("-o")
             .arg(output)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.output().with_context(|| {
+        build_step_output(&mut cmd).with_context(|| {
             format!(
                 "failed to invoke {} to compile {}",
                 compiler.display(),
@@ -84,8 +94,8 @@ impl CEngine {
     fn run_binary(&self, binary: &Path, args: &[String]) -> Result<std::process::Output> {
         let mut cmd = Command::new(binary);
         cmd.args(args).stdout(Stdio::piped()).stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
-        cmd.output()
+        cmd.stdin(child_stdin());
+        run_with_timeout(&mut cmd)
             .with_context(|| format!("failed to execute compiled binary {}", binary.display()))
     }
 
@@ -105,7 +115,7 @@ impl CEngine {
     fn execute_file_incremental(&self, source: &Path, args: &[String]) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let source_text = fs::read_to_string(source).unwrap_or_default();
-        let source_hash = hash_source(&source_text);
+        let source_hash = self.cache_key(&source_text)?;
 
         let compiler = self.ensure_compiler()?;
         let source_key = source
@@ -113,7 +123,7 @@ impl CEngine {
             .unwrap_or_else(|_| source.to_path_buf());
         let workspace = std::env::temp_dir().join(format!(
             "run-c-inc-{:016x}",
-            hash_source(&source_key.to_string_lossy())
+            self.cache_key(&source_key.to_string_lossy())?
         ));
         fs::create_dir_all(&workspace).with_context(|| {
             format!(
@@ -125,7 +135,7 @@ impl CEngine {
         let dep = workspace.join("main.d");
         let bin = workspace.join("run_c_incremental_binary");
 
-        let needs_compile = c_needs_recompile(source, &obj, &dep);
+        let needs_compile = !compile_cache_enabled() || c_needs_recompile(source, &obj, &dep);
         if !needs_compile && bin.exists() {
             perf_record("c", "file.workspace_hit");
             cache_store("c-file", source_hash, &bin);
@@ -136,6 +146,8 @@ impl CEngine {
                 stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
                 stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -149,18 +161,23 @@ impl CEngine {
                 stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
                 stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
         perf_record("c", "file.cache_miss");
 
+        let mut compile_duration = None;
         if needs_compile {
             perf_record("c", "file.compile");
+            let compile_start = Instant::now();
             let mut compile = compiler_command(compiler);
             compile
                 .arg(source)
                 .arg("-std=c11")
                 .arg("-O0")
                 .arg("-w")
+                .args(toolchain_flags())
                 .arg("-c")
                 .arg("-MMD")
                 .arg("-MF")
@@ -169,7 +186,7 @@ impl CEngine {
                 .arg(&obj)
                 .stdout(Stdio::piped())
                 .stderr(Stdio::piped());
-            let compile_out = compile.output().with_context(|| {
+            let compile_out = build_step_output(&mut compile).with_context(|| {
                 format!(
                     "failed to invoke {} for incremental C compile",
                     compiler.display()
@@ -181,8 +198,10 @@ impl CEngine {
                     language: self.id().to_string(),
                     exit_code: compile_out.status.code(),
                     stdout: String::from_utf8_lossy(&compile_out.stdout).into_owned(),
-                    stderr: String::from_utf8_lossy(&compile_out.stderr).into_owned(),
+                    stderr: String::new(),
                     duration: start.elapsed(),
+                    compile_duration: Some(compile_start.elapsed()),
+                    compile_stderr: Some(String::from_utf8_lossy(&compile_out.stderr).into_owned()),
                 });
             }
 
@@ -191,9 +210,10 @@ impl CEngine {
             link.arg(&obj)
                 .arg("-o")
                 .arg(&bin)
+                .args(toolchain_flags())
                 .stdout(Stdio::piped())
                 .stderr(Stdio::piped());
-            let link_out = link.output().with_context(|| {
+            let link_out = build_step_output(&mut link).with_context(|| {
                 format!(
                     "failed to invoke {} for incremental C link",
                     compiler.display()
@@ -205,10 +225,13 @@ impl CEngine {
                     language: self.id().to_string(),
                     exit_code: link_out.status.code(),
                     stdout: String::from_utf8_lossy(&link_out.stdout).into_owned(),
-                    stderr: String::from_utf8_lossy(&link_out.stderr).into_owned(),
+                    stderr: String::new(),
                     duration: start.elapsed(),
+                    compile_duration: Some(compile_start.elapsed()),
+                    compile_stderr: Some(String::from_utf8_lossy(&link_out.stderr).into_owned()),
                 });
             }
+            compile_duration = Some(compile_start.elapsed());
             cache_store("c-file", source_hash, &bin);
         } else {
             // Rehydrate persistent cache even when incremental workspace is already up-to-date.
@@ -223,6 +246,8 @@ impl CEngine {
             stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration,
+            compile_stderr: None,
         })
     }
 }
@@ -265,6 +290,15 @@ impl LanguageEngine for CEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.compiler.clone()
+    }
+
+    fn snippet_lines(&self, payload: &ExecutionPayload) -> Option<SnippetLines> {
+        let code = payload.as_inline()?;
+        SnippetLines::new("main.c", code, &prepare_inline_source(code))
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         // Try cache for inline/stdin payloads
         let args = payload.args();
@@ -279,22 +313,24 @@ impl LanguageEngine for CEngine {
             _ => None,
         } {
             let prepared = prepare_inline_source(code);
-            let src_hash = hash_source(&prepared);
-            if let Some(output) = try_cached_execution("c", src_hash) {
+            let src_hash = self.cache_key(&prepared)?;
+            let start = Instant::now();
+            if let Some(output) = try_cached_execution("c", src_hash, args) {
                 perf_record("c", "inline.cache_hit");
-                let start = Instant::now();
                 return Ok(ExecutionOutcome {
                     language: self.id().to_string(),
                     exit_code: output.status.code(),
                     stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
                     stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
                     duration: start.elapsed(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 });
             }
             perf_record("c", "inline.cache_miss");
         }
 
-        let temp_dir = Builder::new()
+        let temp_dir = temp_builder()
             .prefix("run-c")
             .tempdir()
             .context("failed to create temporary directory for c build")?;
@@ -303,7 +339,7 @@ impl LanguageEngine for CEngine {
         let (source_path, cache_key) = match payload {
             ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
                 let prepared = prepare_inline_source(code);
-                let h = hash_source(&prepared);
+                let h = self.cache_key(&prepared)?;
                 (self.write_source(code, dir_path)?, Some(h))
             }
             ExecutionPayload::File { path, .. } => (self.copy_source(path, dir_path)?, None),
@@ -313,13 +349,16 @@ impl LanguageEngine for CEngine {
         let start = Instant::now();
 
         let compile_output = self.compile(&source_path, &binary_path)?;
+        let compile_duration = start.elapsed();
         if !compile_output.status.success() {
             return Ok(ExecutionOutcome {
                 language: self.id().to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: String::from_utf8_lossy(&compile_output.stdout).into_owned(),
-                stderr: String::from_utf8_lossy(&compile_output.stderr).into_owned(),
+                stderr: String::new(),
                 duration: start.elapsed(),
+                compile_duration: Some(compile_duration),
+                compile_stderr: Some(String::from_utf8_lossy(&compile_output.stderr).into_owned()),
             });
         }
 
@@ -334,9 +373,15 @@ impl LanguageEngine for CEngine {
             stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: Some(compile_duration),
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some(prepare_inline_source("printf(\"Hello, world!\\n\");"))
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let compiler = self.ensure_compiler()?.to_path_buf();
         let session = CSession::new(compiler)?;
@@ -392,7 +437,9 @@ enum CSnippetKind {
 
 impl CSession {
     fn new(compiler: PathBuf) -> Result<Self> {
-        let workspace = TempDir::new().context("failed to create C session workspace")?;
+        let workspace = temp_builder()
+            .tempdir()
+            .context("failed to create C session workspace")?;
         let session = Self {
             compiler,
             workspace,
@@ -502,7 +549,7 @@ impl CSession {
     fn run_binary_path(&self, binary: &Path) -> Result<std::process::Output> {
         let mut cmd = Command::new(binary);
         cmd.stdout(Stdio::piped()).stderr(Stdio::piped());
-        cmd.output()
+        run_with_timeout(&mut cmd)
             .with_context(|| format!("failed to execute compiled binary {}", binary.display()))
     }
 
@@ -545,8 +592,10 @@ impl CSession {
                 language: self.language_id().to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: Self::normalize_output(&compile_output.stdout),
-                stderr: Self::normalize_output(&compile_output.stderr),
+                stderr: String::new(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: Some(Self::normalize_output(&compile_output.stderr)),
             });
         }
 
@@ -557,6 +606,8 @@ impl CSession {
             stdout: Self::normalize_output(&run_output.stdout),
             stderr: Self::normalize_output(&run_output.stderr),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
@@ -641,6 +692,8 @@ impl CSession {
                     stdout: String::new(),
                     stderr: String::new(),
                     duration: Default::default(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 },
                 true,
             ));
@@ -657,8 +710,10 @@ impl CSession {
                 language: self.language_id().to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: Self::normalize_output(&compile_output.stdout),
-                stderr: Self::normalize_output(&compile_output.stderr),
+                stderr: String::new(),
                 duration,
+                compile_duration: None,
+                compile_stderr: Some(Self::normalize_output(&compile_output.stderr)),
             };
             return Ok((outcome, false));
         }
@@ -680,6 +735,8 @@ impl CSession {
                 stdout,
                 stderr,
                 duration,
+                compile_duration: None,
+                compile_stderr: None,
             };
             return Ok((outcome, true));
         }
@@ -691,6 +748,8 @@ impl CSession {
             stdout,
             stderr,
             duration,
+            compile_duration: None,
+            compile_stderr: None,
         };
         Ok((outcome, false))
     }
@@ -730,6 +789,8 @@ impl LanguageSession for CSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Instant::now().elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -741,6 +802,8 @@ impl LanguageSession for CSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Default::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -753,6 +816,8 @@ impl LanguageSession for CSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Default::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -781,6 +846,10 @@ impl LanguageSession for CSession {
         Ok(outcome)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -1154,6 +1223,9 @@ fn indent_snippet(snippet: &str) -> String {
 }
 
 fn resolve_c_compiler() -> Option<PathBuf> {
+    if let Some(path) = binary_override("c") {
+        return Some(path);
+    }
     ["cc", "clang", "gcc"]
         .into_iter()
         .find_map(|candidate| which::which(candidate).ok())
@@ -5,11 +5,13 @@ use std::sync::{Mutex, OnceLock};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, cache_lookup, cache_store,
-    compiler_command, hash_source, perf_record, run_version_command, try_cached_execution,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    build_step_output, cache_lookup, cache_store, child_stdin, compile_cache_dir,
+    compile_cache_enabled, compile_cache_key, compiler_command, perf_record, run_version_command,
+    run_with_timeout, temp_builder, toolchain_flags, try_cached_execution,
 };
 
 pub struct CppEngine {
@@ -37,6 +39,12 @@ impl CppEngine {
         })
     }
 
+    /// Compile-cache key for `source`, tied to this compiler's version.
+    fn cache_key(&self, source: &str) -> Result<u64> {
+        let compiler = self.ensure_compiler()?;
+        Ok(compile_cache_key(self.id(), compiler, "--version", source))
+    }
+
     fn write_source(&self, code: &str, dir: &Path) -> Result<PathBuf> {
         let source_path = dir.join("main.cpp");
         std::fs::write(&source_path, code).with_context(|| {
@@ -63,10 +71,12 @@ impl CppEngine {
     fn compile(&self, source: &Path, output: &Path) -> Result<std::process::Output> {
         let compiler = self.ensure_compiler()?;
         let mut cmd = compiler_command(compiler);
+        // `--flags` come after the defaults, so `-std=` and `-O` in them win.
         cmd.arg(source)
             .arg("-std=c++17")
             .arg("-O0")
             .arg("-w")
+            .args(toolchain_flags())
             .arg("-o")
             .arg(output)
             .stdout(Stdio::piped())
@@ -74,7 +84,7 @@ impl CppEngine {
         if let Some(pch_header) = ensure_global_cpp_pch(compiler) {
             cmd.arg("-include").arg(pch_header);
         }
-        cmd.output().with_context(|| {
+        build_step_output(&mut cmd).with_context(|| {
             format!(
                 "failed to invoke {} to compile {}",
                 compiler.display(),
@@ -86,8 +96,8 @@ impl CppEngine {
     fn run_binary(&self, binary: &Path, args: &[String]) -> Result<std::process::Output> {
         let mut cmd = Command::new(binary);
         cmd.args(args).stdout(Stdio::piped()).stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
-        cmd.output()
+        cmd.stdin(child_stdin());
+        run_with_timeout(&mut cmd)
             .with_context(|| format!("failed to execute compiled binary {}", binary.display()))
     }
 
@@ -107,7 +117,7 @@ impl CppEngine {
     fn execute_file_incremental(&self, source: &Path, args: &[String]) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let source_text = fs::read_to_string(source).unwrap_or_default();
-        let source_hash = hash_source(&source_text);
+        let source_hash = self.cache_key(&source_text)?;
 
         let compiler = self.ensure_compiler()?;
         let source_key = source
@@ -115,7 +125,7 @@ impl CppEngine {
             .unwrap_or_else(|_| source.to_path_buf());
         let workspace = std::env::temp_dir().join(format!(
             "run-cpp-inc-{:016x}",
-            hash_source(&source_key.to_string_lossy())
+            self.cache_key(&source_key.to_string_lossy())?
         ));
         fs::create_dir_all(&workspace).with_context(|| {
             format!(
@@ -127,7 +137,7 @@ impl CppEngine {
         let dep = workspace.join("main.d");
         let bin = workspace.join("run_cpp_incremental_binary");
 
-        let needs_compile = cpp_needs_recompile(source, &obj, &dep);
+        let needs_compile = !compile_cache_enabled() || cpp_needs_recompile(source, &obj, &dep);
         if !needs_compile && bin.exists() {
             perf_record("cpp", "file.workspace_hit");
             cache_store("cpp-file", source_hash, &bin);
@@ -138,6 +148,8 @@ impl CppEngine {
                 stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
                 stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -151,18 +163,23 @@ impl CppEngine {
                 stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
                 stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
         perf_record("cpp", "file.cache_miss");
 
+        let mut compile_duration = None;
         if needs_compile {
             perf_record("cpp", "file.compile");
+            let compile_start = Instant::now();
             let mut compile = compiler_command(compiler);
             compile
                 .arg(source)
                 .arg("-std=c++17")
                 .arg("-O0")
                 .arg("-w")
+                .args(toolchain_flags())
                 .arg("-c")
                 .arg("-MMD")
                 .arg("-MF")
@@ -171,7 +188,7 @@ impl CppEngine {
                 .arg(&obj)
                 .stdout(Stdio::piped())
                 .stderr(Stdio::piped());
-            let compile_out = compile.output().with_context(|| {
+            let compile_out = build_step_output(&mut compile).with_context(|| {
                 format!(
                     "failed to invoke {} for incremental C++ compile",
                     compiler.display()
@@ -183,8 +200,10 @@ impl CppEngine {
                     language: self.id().to_string(),
                     exit_code: compile_out.status.code(),
                     stdout: String::from_utf8_lossy(&compile_out.stdout).into_owned(),
-                    stderr: String::from_utf8_lossy(&compile_out.stderr).into_owned(),
+                    stderr: String::new(),
                     duration: start.elapsed(),
+                    compile_duration: Some(compile_start.elapsed()),
+                    compile_stderr: Some(String::from_utf8_lossy(&compile_out.stderr).into_owned()),
                 });
             }
 
@@ -193,9 +212,10 @@ impl CppEngine {
             link.arg(&obj)
                 .arg("-o")
                 .arg(&bin)
+                .args(toolchain_flags())
                 .stdout(Stdio::piped())
                 .stderr(Stdio::piped());
-            let link_out = link.output().with_context(|| {
+            let link_out = build_step_output(&mut link).with_context(|| {
                 format!(
                     "failed to invoke {} for incremental C++ link",
                     compiler.display()
@@ -207,10 +227,13 @@ impl CppEngine {
                     language: self.id().to_string(),
                     exit_code: link_out.status.code(),
                     stdout: String::from_utf8_lossy(&link_out.stdout).into_owned(),
-                    stderr: String::from_utf8_lossy(&link_out.stderr).into_owned(),
+                    stderr: String::new(),
                     duration: start.elapsed(),
+                    compile_duration: Some(compile_start.elapsed()),
+                    compile_stderr: Some(String::from_utf8_lossy(&link_out.stderr).into_owned()),
                 });
             }
+            compile_duration = Some(compile_start.elapsed());
             cache_store("cpp-file", source_hash, &bin);
         } else {
             // Rehydrate persistent cache even when incremental workspace is already up-to-date.
@@ -225,6 +248,8 @@ impl CppEngine {
             stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration,
+            compile_stderr: None,
         })
     }
 }
@@ -267,6 +292,10 @@ impl LanguageEngine for CppEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.compiler.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let args = payload.args();
         if let ExecutionPayload::File { path, .. } = payload {
@@ -280,22 +309,24 @@ impl LanguageEngine for CppEngine {
             }
             _ => None,
         } {
-            let src_hash = hash_source(code);
-            if let Some(output) = try_cached_execution("cpp", src_hash) {
+            let src_hash = self.cache_key(code)?;
+            let start = Instant::now();
+            if let Some(output) = try_cached_execution("cpp", src_hash, args) {
                 perf_record("cpp", "inline.cache_hit");
-                let start = Instant::now();
                 return Ok(ExecutionOutcome {
                     language: self.id().to_string(),
                     exit_code: output.status.code(),
                     stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
                     stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
                     duration: start.elapsed(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 });
             }
             perf_record("cpp", "inline.cache_miss");
         }
 
-        let temp_dir = Builder::new()
+        let temp_dir = temp_builder()
             .prefix("run-cpp")
             .tempdir()
             .context("failed to create temporary directory for cpp build")?;
@@ -303,7 +334,7 @@ impl LanguageEngine for CppEngine {
 
         let (source_path, cache_key) = match payload {
             ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
-                let h = hash_source(code);
+                let h = self.cache_key(code)?;
                 (self.write_source(code, dir_path)?, Some(h))
             }
             ExecutionPayload::File { path, .. } => (self.copy_source(path, dir_path)?, None),
@@ -313,13 +344,16 @@ impl LanguageEngine for CppEngine {
         let start = Instant::now();
 
         let compile_output = self.compile(&source_path, &binary_path)?;
+        let compile_duration = start.elapsed();
         if !compile_output.status.success() {
             return Ok(ExecutionOutcome {
                 language: self.id().to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: String::from_utf8_lossy(&compile_output.stdout).into_owned(),
-                stderr: String::from_utf8_lossy(&compile_output.stderr).into_owned(),
+                stderr: String::new(),
                 duration: start.elapsed(),
+                compile_duration: Some(compile_duration),
+                compile_stderr: Some(String::from_utf8_lossy(&compile_output.stderr).into_owned()),
             });
         }
 
@@ -334,13 +368,22 @@ impl LanguageEngine for CppEngine {
             stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: Some(compile_duration),
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some(
+            "#include <iostream>\n\nint main()\n{\n    std::cout << \"Hello, world!\" << std::endl;\n    return 0;\n}\n"
+                .to_string(),
+        )
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let compiler = self.ensure_compiler().map(Path::to_path_buf)?;
 
-        let temp_dir = Builder::new()
+        let temp_dir = temp_builder()
             .prefix("run-cpp-repl")
             .tempdir()
             .context("failed to create temporary directory for cpp repl")?;
@@ -362,6 +405,9 @@ impl LanguageEngine for CppEngine {
 }
 
 fn resolve_cpp_compiler() -> Option<PathBuf> {
+    if let Some(path) = binary_override("cpp") {
+        return Some(path);
+    }
     ["c++", "clang++", "g++"]
         .into_iter()
         .find_map(|candidate| which::which(candidate).ok())
@@ -479,8 +525,10 @@ impl CppSession {
                 language: "cpp".to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: normalize_output(&compile_output.stdout),
-                stderr: normalize_output(&compile_output.stderr),
+                stderr: String::new(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: Some(normalize_output(&compile_output.stderr)),
             });
         }
 
@@ -491,6 +539,8 @@ impl CppSession {
             stdout: normalize_output(&run_output.stdout),
             stderr: normalize_output(&run_output.stderr),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
@@ -525,6 +575,8 @@ impl CppSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration,
+            compile_duration: None,
+            compile_stderr: None,
         }
     }
 
@@ -559,6 +611,8 @@ impl LanguageSession for CppSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Instant::now().elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -570,6 +624,8 @@ impl LanguageSession for CppSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -582,6 +638,8 @@ impl LanguageSession for CppSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -621,6 +679,10 @@ impl LanguageSession for CppSession {
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -1104,7 +1166,7 @@ fn invoke_cpp_compiler(
 fn run_cpp_binary(binary: &Path) -> Result<std::process::Output> {
     let mut cmd = Command::new(binary);
     cmd.stdout(Stdio::piped()).stderr(Stdio::piped());
-    cmd.output()
+    run_with_timeout(&mut cmd)
         .with_context(|| format!("failed to execute compiled binary {}", binary.display()))
 }
 
@@ -1113,7 +1175,7 @@ fn ensure_global_cpp_pch(compiler: &Path) -> Option<PathBuf> {
     let lock = PCH_BUILD_LOCK.get_or_init(|| Mutex::new(()));
     let _guard = lock.lock().ok()?;
 
-    let cache_dir = std::env::temp_dir().join("run-compile-cache");
+    let cache_dir = compile_cache_dir();
     std::fs::create_dir_all(&cache_dir).ok()?;
     let header = cache_dir.join("run_cpp_pch.hpp");
     let gch = cache_dir.join("run_cpp_pch.hpp.gch");
@@ -4,10 +4,11 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    child_stdin, run_version_command, run_with_timeout, temp_builder, toolchain_flags,
 };
 
 pub struct CrystalEngine {
@@ -36,7 +37,7 @@ impl CrystalEngine {
     }
 
     fn write_temp_source(&self, code: &str) -> Result<(TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-crystal")
             .tempdir()
             .context("failed to create temporary directory for Crystal source")?;
@@ -58,6 +59,7 @@ impl CrystalEngine {
         let executable = self.ensure_executable()?;
         let mut cmd = Command::new(executable);
         cmd.arg("run")
+            .args(toolchain_flags())
             .arg(source)
             .arg("--no-color")
             .stdout(Stdio::piped())
@@ -65,11 +67,11 @@ impl CrystalEngine {
         if !args.is_empty() {
             cmd.arg("--").args(args);
         }
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
         if let Some(dir) = source.parent() {
             cmd.current_dir(dir);
         }
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} with source {}",
                 executable.display(),
@@ -117,6 +119,10 @@ impl LanguageEngine for CrystalEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let (temp_dir, source_path) = match payload {
@@ -136,9 +142,15 @@ impl LanguageEngine for CrystalEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("puts \"Hello, world!\"\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let executable = self.ensure_executable()?.to_path_buf();
         Ok(Box::new(CrystalSession::new(executable)?))
@@ -146,6 +158,9 @@ impl LanguageEngine for CrystalEngine {
 }
 
 fn resolve_crystal_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("crystal") {
+        return Some(path);
+    }
     which::which("crystal").ok()
 }
 
@@ -159,7 +174,9 @@ struct CrystalSession {
 
 impl CrystalSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let workspace = TempDir::new().context("failed to create Crystal session workspace")?;
+        let workspace = temp_builder()
+            .tempdir()
+            .context("failed to create Crystal session workspace")?;
         let session = Self {
             executable,
             workspace,
@@ -205,7 +222,7 @@ impl CrystalSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Crystal session",
                 self.executable.display()
@@ -236,6 +253,8 @@ impl CrystalSession {
             stdout,
             stderr,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -252,7 +271,7 @@ impl CrystalSession {
         Ok((outcome, success))
     }
 
-    fn reset(&mut self) -> Result<()> {
+    fn reset_state(&mut self) -> Result<()> {
         self.snippets.clear();
         self.last_stdout.clear();
         self.last_stderr.clear();
@@ -287,17 +306,21 @@ impl LanguageSession for CrystalSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.reset()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: "crystal".to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -309,6 +332,8 @@ impl LanguageSession for CrystalSession {
                     .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -321,6 +346,10 @@ impl LanguageSession for CrystalSession {
         Ok(outcome)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
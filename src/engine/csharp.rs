@@ -4,15 +4,21 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result, bail};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    build_step_with_timeout, child_stdin, compile_cache_dir, compile_cache_enabled,
+    compile_cache_key, run_version_command, run_with_timeout, temp_builder, toolchain_flags,
 };
 
+/// File name of the assembly `dotnet build` produces for `Run.csproj`.
+const ASSEMBLY_NAME: &str = "Run.dll";
+
 pub struct CSharpEngine {
     runtime: Option<PathBuf>,
     target_framework: Option<String>,
+    script_runner: Option<PathBuf>,
 }
 
 impl Default for CSharpEngine {
@@ -30,6 +36,7 @@ impl CSharpEngine {
         Self {
             runtime,
             target_framework,
+            script_runner: which::which("dotnet-script").ok(),
         }
     }
 
@@ -99,33 +106,131 @@ impl CSharpEngine {
         Ok(project_path)
     }
 
-    fn run_project(
+    /// Build the project into `out_dir` without running it, so the assembly
+    /// can be cached and later started with plain `dotnet <dll>`.
+    fn build_project(
         &self,
         runtime: &Path,
         project: &Path,
-        workdir: &Path,
-        args: &[String],
+        out_dir: &Path,
     ) -> Result<std::process::Output> {
         let mut cmd = Command::new(runtime);
-        cmd.arg("run")
-            .arg("--project")
+        cmd.arg("build")
             .arg(project)
             .arg("--nologo")
+            .arg("-v")
+            .arg("quiet")
+            .arg("-clp:NoSummary")
+            .arg("-c")
+            .arg("Release")
+            .arg("-o")
+            .arg(out_dir)
+            .args(toolchain_flags())
+            .stdin(Stdio::null());
+        cmd.env("DOTNET_CLI_TELEMETRY_OPTOUT", "1");
+        cmd.env("DOTNET_SKIP_FIRST_TIME_EXPERIENCE", "1");
+        build_step_with_timeout(&mut cmd).with_context(|| {
+            format!(
+                "failed to execute dotnet build for project {} using {}",
+                project.display(),
+                runtime.display()
+            )
+        })
+    }
+
+    fn run_assembly(
+        &self,
+        runtime: &Path,
+        out_dir: &Path,
+        workdir: &Path,
+        args: &[String],
+    ) -> Result<std::process::Output> {
+        let assembly = out_dir.join(ASSEMBLY_NAME);
+        let mut cmd = Command::new(runtime);
+        cmd.arg(&assembly)
+            .args(args)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(workdir);
+        cmd.stdin(child_stdin());
+        cmd.env("DOTNET_CLI_TELEMETRY_OPTOUT", "1");
+        run_with_timeout(&mut cmd).with_context(|| {
+            format!(
+                "failed to run {} using {}",
+                assembly.display(),
+                runtime.display()
+            )
+        })
+    }
+
+    /// Snippets and `.csx` files go to `dotnet-script` when it is installed:
+    /// it starts faster than a project build and understands `#r` / `#load`.
+    /// Plain `.cs` files may declare their own `Main`, so they always build.
+    fn script_runner_for(&self, payload: &ExecutionPayload) -> Option<&Path> {
+        let runner = self.script_runner.as_deref()?;
+        match payload {
+            ExecutionPayload::File { path, .. } => path
+                .extension()
+                .and_then(|ext| ext.to_str())
+                .is_some_and(|ext| ext.eq_ignore_ascii_case("csx"))
+                .then_some(runner),
+            _ => Some(runner),
+        }
+    }
+
+    fn execute_script(
+        &self,
+        script_runner: &Path,
+        payload: &ExecutionPayload,
+    ) -> Result<ExecutionOutcome> {
+        let temp_dir = temp_builder()
+            .prefix("run-csharp-script")
+            .tempdir()
+            .context("failed to create temporary directory for dotnet-script")?;
+        let script_path = match payload {
+            ExecutionPayload::File { path, .. } => path.clone(),
+            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
+                let path = temp_dir.path().join("snippet.csx");
+                let mut contents = code.to_string();
+                if !contents.ends_with('\n') {
+                    contents.push('\n');
+                }
+                fs::write(&path, contents).with_context(|| {
+                    format!("failed to write temporary C# script to {}", path.display())
+                })?;
+                path
+            }
+        };
+
+        let mut cmd = Command::new(script_runner);
+        cmd.args(toolchain_flags())
+            .arg(&script_path)
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .current_dir(temp_dir.path());
+        let args = payload.args();
         if !args.is_empty() {
             cmd.arg("--").args(args);
         }
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
         cmd.env("DOTNET_CLI_TELEMETRY_OPTOUT", "1");
-        cmd.env("DOTNET_SKIP_FIRST_TIME_EXPERIENCE", "1");
-        cmd.output().with_context(|| {
+        let start = Instant::now();
+        let output = run_with_timeout(&mut cmd).with_context(|| {
             format!(
-                "failed to execute dotnet run for project {} using {}",
-                project.display(),
-                runtime.display()
+                "failed to execute {} with {}",
+                script_path.display(),
+                script_runner.display()
             )
+        })?;
+
+        Ok(ExecutionOutcome {
+            language: self.id().to_string(),
+            exit_code: output.status.code(),
+            stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
+            stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
+            duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 }
@@ -170,38 +275,94 @@ impl LanguageEngine for CSharpEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.runtime.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let runtime = self.ensure_runtime()?;
+        if let Some(script_runner) = self.script_runner_for(payload) {
+            return self.execute_script(script_runner, payload);
+        }
         let tfm = self.ensure_target_framework()?;
+        let args = payload.args();
 
-        let build_dir = Builder::new()
+        let build_dir = temp_builder()
             .prefix("run-csharp")
             .tempdir()
             .context("failed to create temporary directory for csharp build")?;
         let dir_path = build_dir.path();
 
-        self.write_project_file(dir_path, tfm)?;
-        self.prepare_source(payload, dir_path)?;
+        let project_path = self.write_project_file(dir_path, tfm)?;
+        let source_path = self.prepare_source(payload, dir_path)?;
+
+        // `dotnet run` spends seconds in MSBuild even for an unchanged
+        // program, so the built assembly is cached by source and SDK.
+        let cache_dir = if compile_cache_enabled() {
+            let source = fs::read_to_string(&source_path)
+                .with_context(|| format!("failed to read {}", source_path.display()))?;
+            let key =
+                compile_cache_key(self.id(), runtime, "--version", &format!("{tfm}\0{source}"));
+            Some(compile_cache_dir().join(format!("csharp-{key:016x}")))
+        } else {
+            None
+        };
 
-        let project_path = dir_path.join("Run.csproj");
         let start = Instant::now();
+        if let Some(cached) = cache_dir.as_deref()
+            && cached.join(ASSEMBLY_NAME).exists()
+        {
+            let output = self.run_assembly(runtime, cached, dir_path, args)?;
+            return Ok(ExecutionOutcome {
+                language: self.id().to_string(),
+                exit_code: output.status.code(),
+                stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
+                stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
+                duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
+            });
+        }
 
-        let output = self.run_project(runtime, &project_path, dir_path, payload.args())?;
+        let out_dir = dir_path.join("out");
+        let build_output = self.build_project(runtime, &project_path, &out_dir)?;
+        let compile_duration = start.elapsed();
+        if !build_output.status.success() {
+            return Ok(ExecutionOutcome {
+                language: self.id().to_string(),
+                exit_code: build_output.status.code(),
+                stdout: String::from_utf8_lossy(&build_output.stdout).into_owned(),
+                stderr: String::new(),
+                duration: start.elapsed(),
+                compile_duration: Some(compile_duration),
+                compile_stderr: Some(String::from_utf8_lossy(&build_output.stderr).into_owned()),
+            });
+        }
+        if let Some(cached) = cache_dir.as_deref() {
+            store_build_output(&out_dir, cached);
+        }
 
+        let output = self.run_assembly(runtime, &out_dir, dir_path, args)?;
         Ok(ExecutionOutcome {
             language: self.id().to_string(),
             exit_code: output.status.code(),
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: Some(compile_duration),
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("Console.WriteLine(\"Hello, world!\");\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let runtime = self.ensure_runtime()?.to_path_buf();
         let tfm = self.ensure_target_framework()?.to_string();
 
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-csharp-repl")
             .tempdir()
             .context("failed to create temporary directory for csharp repl")?;
@@ -280,6 +441,8 @@ impl CSharpSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -318,6 +481,8 @@ impl LanguageSession for CSharpSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Instant::now().elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -329,6 +494,8 @@ impl LanguageSession for CSharpSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -341,6 +508,8 @@ impl LanguageSession for CSharpSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -357,6 +526,10 @@ impl LanguageSession for CSharpSession {
         Ok(outcome)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -576,9 +749,29 @@ fn prepare_statement(code: &str) -> String {
 }
 
 fn resolve_dotnet_runtime() -> Option<PathBuf> {
+    if let Some(path) = binary_override("csharp") {
+        return Some(path);
+    }
     which::which("dotnet").ok()
 }
 
+/// Copy a finished build into the cache. The files are staged next to the
+/// entry and renamed into place so a concurrent run never starts a
+/// half-copied assembly.
+fn store_build_output(out_dir: &Path, cache_dir: &Path) {
+    let staging = cache_dir.with_extension(format!("tmp{}", std::process::id()));
+    let copied = fs::create_dir_all(&staging).is_ok()
+        && fs::read_dir(out_dir).is_ok_and(|entries| {
+            entries.flatten().all(|entry| {
+                !entry.path().is_file()
+                    || fs::copy(entry.path(), staging.join(entry.file_name())).is_ok()
+            })
+        });
+    if !copied || fs::rename(&staging, cache_dir).is_err() {
+        let _ = fs::remove_dir_all(&staging);
+    }
+}
+
 fn detect_target_framework(dotnet: &Path) -> Result<String> {
     let output = Command::new(dotnet)
         .arg("--list-sdks")
@@ -633,13 +826,12 @@ fn run_dotnet_project(
     cmd.arg("run")
         .arg("--project")
         .arg(project)
-        .arg("--nologo")
         .stdout(Stdio::piped())
         .stderr(Stdio::piped())
         .current_dir(workdir);
     cmd.env("DOTNET_CLI_TELEMETRY_OPTOUT", "1");
     cmd.env("DOTNET_SKIP_FIRST_TIME_EXPERIENCE", "1");
-    cmd.output().with_context(|| {
+    run_with_timeout(&mut cmd).with_context(|| {
         format!(
             "failed to execute dotnet run for project {} using {}",
             project.display(),
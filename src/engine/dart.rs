@@ -5,10 +5,12 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, SnippetLines,
+    binary_override, child_stdin, run_version_command, run_with_timeout, temp_builder,
+    toolchain_flags,
 };
 
 pub struct DartEngine {
@@ -63,7 +65,7 @@ impl DartEngine {
     }
 
     fn write_temp_source(&self, code: &str) -> Result<(TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-dart")
             .tempdir()
             .context("failed to create temporary directory for Dart source")?;
@@ -84,21 +86,15 @@ impl DartEngine {
             .arg("--enable-asserts")
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
 
+        // Pass the whole path; with --cwd the run starts somewhere else.
         if let Some(parent) = path.parent() {
             cmd.current_dir(parent);
-            if let Some(file_name) = path.file_name() {
-                cmd.arg(file_name);
-            } else {
-                cmd.arg(path);
-            }
-            cmd.args(args);
-        } else {
-            cmd.arg(path).args(args);
         }
+        cmd.args(toolchain_flags()).arg(path).args(args);
 
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to invoke {} to run {}",
                 executable.display(),
@@ -146,6 +142,15 @@ impl LanguageEngine for DartEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
+    fn snippet_lines(&self, payload: &ExecutionPayload) -> Option<SnippetLines> {
+        let code = payload.as_inline()?;
+        SnippetLines::new("main.dart", code, &Self::prepare_inline_source(code))
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let (temp_dir, path) = match payload {
@@ -169,9 +174,15 @@ impl LanguageEngine for DartEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("void main() {\n  print('Hello, world!');\n}\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let executable = self.ensure_executable()?.to_path_buf();
         Ok(Box::new(DartSession::new(executable)?))
@@ -179,6 +190,9 @@ impl LanguageEngine for DartEngine {
 }
 
 fn resolve_dart_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("dart") {
+        return Some(path);
+    }
     which::which("dart").ok()
 }
 
@@ -199,7 +213,7 @@ struct DartSession {
 
 impl DartSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let workspace = Builder::new()
+        let workspace = temp_builder()
             .prefix("run-dart-repl")
             .tempdir()
             .context("failed to create temporary directory for Dart repl")?;
@@ -267,7 +281,7 @@ impl DartSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Dart session",
                 self.executable.display()
@@ -288,7 +302,7 @@ impl DartSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        let output = cmd.output().with_context(|| {
+        let output = run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Dart standalone program",
                 self.executable.display()
@@ -301,6 +315,8 @@ impl DartSession {
             stdout: normalize_output(&output.stdout),
             stderr: normalize_output(&output.stderr),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         let _ = fs::remove_file(&path);
@@ -329,6 +345,8 @@ impl DartSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -358,6 +376,8 @@ impl DartSession {
                     stdout: String::new(),
                     stderr: String::new(),
                     duration: Duration::default(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 },
                 true,
             ));
@@ -417,7 +437,7 @@ impl DartSession {
         Ok((outcome, success))
     }
 
-    fn reset(&mut self) -> Result<()> {
+    fn reset_state(&mut self) -> Result<()> {
         self.imports.clear();
         self.declarations.clear();
         self.statements.clear();
@@ -441,17 +461,21 @@ impl LanguageSession for DartSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.reset()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: "dart".to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -464,6 +488,8 @@ impl LanguageSession for DartSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -494,6 +520,10 @@ impl LanguageSession for DartSession {
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -5,10 +5,12 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    child_stdin, line_looks_incomplete, run_version_command, run_with_timeout, temp_builder,
+    toolchain_flags,
 };
 
 pub struct ElixirEngine {
@@ -37,12 +39,12 @@ impl ElixirEngine {
     }
 
     fn write_temp_source(&self, code: &str) -> Result<(TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-elixir")
             .tempdir()
             .context("failed to create temporary directory for Elixir source")?;
         let path = dir.path().join("snippet.exs");
-        let mut contents = code.to_string();
+        let mut contents = prepare_snippet(code);
         if !contents.ends_with('\n') {
             contents.push('\n');
         }
@@ -55,19 +57,34 @@ impl ElixirEngine {
         Ok((dir, path))
     }
 
+    fn execute_inline(&self, code: &str) -> Result<std::process::Output> {
+        let executable = self.ensure_executable()?;
+        let mut cmd = Command::new(executable);
+        cmd.arg("--no-color")
+            .args(toolchain_flags())
+            .arg("-e")
+            .arg(prepare_snippet(code))
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped());
+        cmd.stdin(child_stdin());
+        run_with_timeout(&mut cmd)
+            .with_context(|| format!("failed to invoke {} -e", executable.display()))
+    }
+
     fn execute_path(&self, path: &Path, args: &[String]) -> Result<std::process::Output> {
         let executable = self.ensure_executable()?;
         let mut cmd = Command::new(executable);
         cmd.arg("--no-color")
+            .args(toolchain_flags())
             .arg(path)
             .args(args)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
         if let Some(parent) = path.parent() {
             cmd.current_dir(parent);
         }
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} with script {}",
                 executable.display(),
@@ -94,6 +111,10 @@ impl LanguageEngine for ElixirEngine {
         self.executable.is_some()
     }
 
+    fn is_input_complete(&self, buffer: &str) -> bool {
+        !needs_more_input(buffer)
+    }
+
     fn validate(&self) -> Result<()> {
         let executable = self.ensure_executable()?;
         let mut cmd = Command::new(executable);
@@ -115,9 +136,29 @@ impl LanguageEngine for ElixirEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let (temp_dir, path) = match payload {
+            // With `-e`, elixir takes the first argument as a script to run,
+            // so snippets with arguments go through a file.
+            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. }
+                if payload.args().is_empty() =>
+            {
+                let output = self.execute_inline(code)?;
+                return Ok(ExecutionOutcome {
+                    language: self.id().to_string(),
+                    exit_code: output.status.code(),
+                    stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
+                    stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
+                    duration: start.elapsed(),
+                    compile_duration: None,
+                    compile_stderr: None,
+                });
+            }
             ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
                 let (dir, path) = self.write_temp_source(code)?;
                 (Some(dir), path)
@@ -134,9 +175,15 @@ impl LanguageEngine for ElixirEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("IO.puts(\"Hello, world!\")\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let executable = self.ensure_executable()?.to_path_buf();
         Ok(Box::new(ElixirSession::new(executable)?))
@@ -144,6 +191,9 @@ impl LanguageEngine for ElixirEngine {
 }
 
 fn resolve_elixir_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("elixir") {
+        return Some(path);
+    }
     which::which("elixir").ok()
 }
 
@@ -164,7 +214,7 @@ struct ElixirSession {
 
 impl ElixirSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let workspace = Builder::new()
+        let workspace = temp_builder()
             .prefix("run-elixir-repl")
             .tempdir()
             .context("failed to create temporary directory for Elixir repl")?;
@@ -227,7 +277,7 @@ impl ElixirSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Elixir session",
                 self.executable.display()
@@ -256,6 +306,8 @@ impl ElixirSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -282,6 +334,8 @@ impl ElixirSession {
                     stdout: String::new(),
                     stderr: String::new(),
                     duration: Duration::default(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 },
                 true,
             ));
@@ -334,7 +388,7 @@ impl ElixirSession {
         Ok((outcome, success))
     }
 
-    fn reset(&mut self) -> Result<()> {
+    fn reset_state(&mut self) -> Result<()> {
         self.state.directives.clear();
         self.state.declarations.clear();
         self.state.statements.clear();
@@ -358,17 +412,21 @@ impl LanguageSession for ElixirSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.reset()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: "elixir".to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -381,6 +439,8 @@ impl LanguageSession for ElixirSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -404,6 +464,10 @@ impl LanguageSession for ElixirSession {
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -589,6 +653,164 @@ fn wrap_expression(code: &str) -> String {
     format!("IO.inspect(({}))\n", code.trim())
 }
 
+/// One-shot snippets that are a single expression (`1 + 2`,
+/// `Enum.map([1, 2], &(&1 * 2))`) get their value inspected. `:ok` is not
+/// printed, since that is what `IO.puts` and most side-effecting calls return,
+/// and `IO.*` calls are left alone so `IO.inspect(x)` prints once.
+fn prepare_snippet(code: &str) -> String {
+    if should_wrap_expression(code) && !code.trim_start().starts_with("IO.") {
+        format!(
+            "case ({}) do\n  :ok -> :ok\n  run_value -> IO.inspect(run_value)\nend\n",
+            code.trim()
+        )
+    } else {
+        code.to_string()
+    }
+}
+
+/// REPL completeness: more input is needed while a string, heredoc or sigil
+/// is open, while brackets are unbalanced, while a `do` or `fn` lacks its
+/// `end`, or while the last line ends in an operator.
+fn needs_more_input(code: &str) -> bool {
+    let Some(cleaned) = strip_strings_and_comments(code) else {
+        return true;
+    };
+
+    let mut brackets = 0i32;
+    let mut blocks = 0i32;
+    let mut word = String::new();
+    let mut before_word = ' ';
+    let mut prev = ' ';
+    for ch in cleaned.chars().chain(std::iter::once(' ')) {
+        if ch.is_alphanumeric() || ch == '_' {
+            if word.is_empty() {
+                before_word = prev;
+            }
+            word.push(ch);
+            prev = ch;
+            continue;
+        }
+        // `do:` is the one-line keyword form, and `:end` or `x.end` are not
+        // block keywords either.
+        if ch != ':' && !matches!(before_word, ':' | '.') {
+            match word.as_str() {
+                "do" | "fn" => blocks += 1,
+                "end" => blocks -= 1,
+                _ => {}
+            }
+        }
+        word.clear();
+        match ch {
+            '(' | '[' | '{' => brackets += 1,
+            ')' | ']' | '}' => brackets -= 1,
+            _ => {}
+        }
+        prev = ch;
+    }
+
+    brackets > 0
+        || blocks > 0
+        || line_looks_incomplete(&cleaned)
+        || ends_with_operator_word(&cleaned)
+}
+
+fn ends_with_operator_word(code: &str) -> bool {
+    code.split_whitespace()
+        .last()
+        .is_some_and(|token| matches!(token, "and" | "or" | "not" | "in" | "when" | "|>"))
+}
+
+/// Blank out strings, heredocs, sigils, char literals (`?a`) and comments so
+/// keyword and bracket counting only sees code. `None` while one of them is
+/// still open at the end of `code`.
+fn strip_strings_and_comments(code: &str) -> Option<String> {
+    let chars: Vec<char> = code.chars().collect();
+    let mut out = String::with_capacity(code.len());
+    let mut i = 0;
+    while i < chars.len() {
+        let ch = chars[i];
+        match ch {
+            '#' => {
+                while i < chars.len() && chars[i] != '\n' {
+                    i += 1;
+                }
+                continue;
+            }
+            // `?a` is a char literal, but `empty?(` ends a function name.
+            '?' if chars.get(i + 1).is_some_and(|next| !next.is_whitespace())
+                && !i
+                    .checked_sub(1)
+                    .is_some_and(|p| chars[p].is_alphanumeric() || chars[p] == '_') =>
+            {
+                i += if chars.get(i + 1) == Some(&'\\') {
+                    3
+                } else {
+                    2
+                };
+                out.push('0');
+                continue;
+            }
+            '"' | '\'' if chars.get(i + 1) == Some(&ch) && chars.get(i + 2) == Some(&ch) => {
+                i = skip_heredoc(&chars, i + 3, ch)?;
+            }
+            '"' | '\'' => i = skip_delimited(&chars, i + 1, ch)?,
+            '~' if chars.get(i + 1).is_some_and(|c| c.is_ascii_alphabetic()) => {
+                let mut start = i + 1;
+                while chars.get(start).is_some_and(|c| c.is_ascii_alphabetic()) {
+                    start += 1;
+                }
+                let close = match chars.get(start) {
+                    Some('(') => ')',
+                    Some('[') => ']',
+                    Some('{') => '}',
+                    Some('<') => '>',
+                    Some(&open @ ('/' | '|' | '"' | '\'')) => open,
+                    _ => {
+                        out.push(ch);
+                        i += 1;
+                        continue;
+                    }
+                };
+                i = skip_delimited(&chars, start + 1, close)?;
+            }
+            _ => {
+                out.push(ch);
+                i += 1;
+                continue;
+            }
+        }
+        out.push_str("\"\"");
+    }
+    Some(out)
+}
+
+/// Index just past `close`, starting inside the literal at `start` and
+/// honouring backslash escapes, or `None` if it is not closed.
+fn skip_delimited(chars: &[char], start: usize, close: char) -> Option<usize> {
+    let mut i = start;
+    loop {
+        match chars.get(i) {
+            None => return None,
+            Some('\\') => i += 2,
+            Some(&c) if c == close => return Some(i + 1),
+            Some(_) => i += 1,
+        }
+    }
+}
+
+/// Index just past a heredoc whose body starts at `start`, closed by `quote`
+/// three times, or `None` if it is not closed.
+fn skip_heredoc(chars: &[char], start: usize, quote: char) -> Option<usize> {
+    let mut i = start;
+    while i + 2 < chars.len() {
+        if chars[i] == quote && chars[i + 1] == quote && chars[i + 2] == quote {
+            return Some(i + 3);
+        }
+        i += 1;
+    }
+    None
+}
+
 fn diff_output(previous: &str, current: &str) -> String {
     if let Some(stripped) = current.strip_prefix(previous) {
         stripped.to_string()
@@ -602,3 +824,44 @@ fn normalize_output(bytes: &[u8]) -> String {
         .replace("\r\n", "\n")
         .replace('\r', "")
 }
+
+#[cfg(test)]
+mod tests {
+    use super::{needs_more_input, prepare_snippet};
+
+    #[test]
+    fn one_shot_expressions_are_inspected() {
+        let wrapped = prepare_snippet("1 + 2");
+        assert!(wrapped.starts_with("case (1 + 2) do"));
+        assert!(wrapped.contains("IO.inspect(run_value)"));
+        assert_eq!(prepare_snippet("x = 1"), "x = 1");
+        assert_eq!(prepare_snippet("IO.inspect([1])"), "IO.inspect([1])");
+        assert_eq!(
+            prepare_snippet("defmodule M do\n  def f, do: 1\nend"),
+            "defmodule M do\n  def f, do: 1\nend"
+        );
+    }
+
+    #[test]
+    fn blocks_buffer_until_end() {
+        assert!(needs_more_input("defmodule Math do\n"));
+        assert!(needs_more_input(
+            "defmodule Math do\n  def add(a, b) do\n    a + b\n  end\n"
+        ));
+        assert!(!needs_more_input(
+            "defmodule Math do\n  def add(a, b) do\n    a + b\n  end\nend\n"
+        ));
+        assert!(!needs_more_input("def double(x), do: x * 2\n"));
+        assert!(needs_more_input("Enum.map([1, 2], fn x ->\n"));
+        assert!(!needs_more_input("Enum.map([1, 2], fn x -> x * 2 end)\n"));
+        assert!(!needs_more_input("IO.puts(\"do you want to end?\") # do\n"));
+        assert!(!needs_more_input("[do: 1, end: 2]\n"));
+        assert!(!needs_more_input("c = ?d\n"));
+        assert!(!needs_more_input("Enum.empty?([])\n"));
+        assert!(needs_more_input("doc = \"\"\"\nsome text\n"));
+        assert!(!needs_more_input("doc = \"\"\"\nsome do text\n\"\"\"\n"));
+        assert!(!needs_more_input("r = ~r/do(x)/\n"));
+        assert!(needs_more_input("[1, 2]\n|>\n"));
+        assert!(needs_more_input("x = true and\n"));
+    }
+}
@@ -5,12 +5,13 @@ use std::process::{Command, Stdio};
 use std::time::Instant;
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, cache_store,
-    execution_timeout, hash_source, perf_record, run_version_command, try_cached_execution,
-    wait_with_timeout,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    build_step_output, cache_store, child_stdin, compile_cache_key, execution_timeout,
+    isolate_process_group, perf_record, run_version_command, run_with_timeout, spawn_program,
+    temp_builder, toolchain_flags, try_cached_execution, wait_with_timeout,
 };
 
 pub struct GoEngine {
@@ -38,8 +39,14 @@ impl GoEngine {
         })
     }
 
+    /// Compile-cache key for `source`, tied to this compiler's version.
+    fn cache_key(&self, source: &str) -> Result<u64> {
+        let compiler = self.ensure_executable()?;
+        Ok(compile_cache_key(self.id(), compiler, "version", source))
+    }
+
     fn write_temp_source(&self, code: &str) -> Result<(tempfile::TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-go")
             .tempdir()
             .context("failed to create temporary directory for go source")?;
@@ -62,23 +69,19 @@ impl GoEngine {
     ) -> Result<std::process::Output> {
         let mut cmd = Command::new(binary);
         cmd.arg("run")
+            .args(toolchain_flags())
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .env("GO111MODULE", "off");
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
 
+        // Full path: `--cwd` may move the program out of the source's directory.
         if let Some(parent) = source.parent() {
             cmd.current_dir(parent);
-            if let Some(file_name) = source.file_name() {
-                cmd.arg(file_name);
-            } else {
-                cmd.arg(source);
-            }
-            cmd.args(args);
-        } else {
-            cmd.arg(source).args(args);
         }
-        let child = cmd.spawn().with_context(|| {
+        cmd.arg(source).args(args);
+        isolate_process_group(&mut cmd);
+        let child = spawn_program(&mut cmd).with_context(|| {
             format!(
                 "failed to invoke {} to run {}",
                 binary.display(),
@@ -127,14 +130,18 @@ impl LanguageEngine for GoEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         // Try cache for inline/stdin payloads
         let args = payload.args();
         if let ExecutionPayload::File { path, .. } = payload {
             let start = Instant::now();
             let source_text = fs::read_to_string(path).unwrap_or_default();
-            let src_hash = hash_source(&source_text);
-            if let Some(output) = try_cached_execution("go-file", src_hash) {
+            let src_hash = self.cache_key(&source_text)?;
+            if let Some(output) = try_cached_execution("go-file", src_hash, args) {
                 perf_record("go", "file.cache_hit");
                 return Ok(ExecutionOutcome {
                     language: self.id().to_string(),
@@ -142,37 +149,46 @@ impl LanguageEngine for GoEngine {
                     stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
                     stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
                     duration: start.elapsed(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 });
             }
             perf_record("go", "file.cache_miss");
 
             let binary = self.ensure_executable()?;
-            let temp_dir = Builder::new()
+            let temp_dir = temp_builder()
                 .prefix("run-go-file")
                 .tempdir()
                 .context("failed to create temporary directory for go file build")?;
             let bin_path = temp_dir.path().join("run_go_file_binary");
+            let build_start = Instant::now();
             let mut build_cmd = Command::new(binary);
             perf_record("go", "file.build");
             build_cmd
                 .arg("build")
+                .args(toolchain_flags())
                 .arg("-o")
                 .arg(&bin_path)
                 .arg(path)
                 .env("GO111MODULE", "off")
                 .stdout(Stdio::piped())
                 .stderr(Stdio::piped());
-            let build_output = build_cmd.output().with_context(|| {
+            let build_output = build_step_output(&mut build_cmd).with_context(|| {
                 format!("failed to invoke {} to build Go source", binary.display())
             })?;
+            let compile_duration = build_start.elapsed();
             if !build_output.status.success() {
                 perf_record("go", "file.build_fail");
                 return Ok(ExecutionOutcome {
                     language: self.id().to_string(),
                     exit_code: build_output.status.code(),
                     stdout: String::from_utf8_lossy(&build_output.stdout).into_owned(),
-                    stderr: String::from_utf8_lossy(&build_output.stderr).into_owned(),
+                    stderr: String::new(),
                     duration: start.elapsed(),
+                    compile_duration: Some(compile_duration),
+                    compile_stderr: Some(
+                        String::from_utf8_lossy(&build_output.stderr).into_owned(),
+                    ),
                 });
             }
 
@@ -183,8 +199,9 @@ impl LanguageEngine for GoEngine {
                 .args(args)
                 .stdout(Stdio::piped())
                 .stderr(Stdio::piped())
-                .stdin(Stdio::inherit());
-            let child = run_cmd.spawn().with_context(|| {
+                .stdin(child_stdin());
+            isolate_process_group(&mut run_cmd);
+            let child = spawn_program(&mut run_cmd).with_context(|| {
                 format!(
                     "failed to execute compiled Go binary {}",
                     cached_bin.display()
@@ -197,6 +214,8 @@ impl LanguageEngine for GoEngine {
                 stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
                 stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
                 duration: start.elapsed(),
+                compile_duration: Some(compile_duration),
+                compile_stderr: None,
             });
         }
 
@@ -206,16 +225,18 @@ impl LanguageEngine for GoEngine {
             }
             _ => None,
         } {
-            let src_hash = hash_source(code);
-            if let Some(output) = try_cached_execution("go", src_hash) {
+            let src_hash = self.cache_key(code)?;
+            let start = Instant::now();
+            if let Some(output) = try_cached_execution("go", src_hash, args) {
                 perf_record("go", "inline.cache_hit");
-                let start = Instant::now();
                 return Ok(ExecutionOutcome {
                     language: self.id().to_string(),
                     exit_code: output.status.code(),
                     stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
                     stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
                     duration: start.elapsed(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 });
             }
             perf_record("go", "inline.cache_miss");
@@ -226,12 +247,12 @@ impl LanguageEngine for GoEngine {
 
         let (temp_dir, source_path, cache_key) = match payload {
             ExecutionPayload::Inline { code, .. } => {
-                let h = hash_source(code);
+                let h = self.cache_key(code)?;
                 let (dir, path) = self.write_temp_source(code)?;
                 (Some(dir), path, Some(h))
             }
             ExecutionPayload::Stdin { code, .. } => {
-                let h = hash_source(code);
+                let h = self.cache_key(code)?;
                 let (dir, path) = self.write_temp_source(code)?;
                 (Some(dir), path, Some(h))
             }
@@ -242,9 +263,11 @@ impl LanguageEngine for GoEngine {
         if let Some(h) = cache_key {
             let dir = source_path.parent().unwrap_or(std::path::Path::new("."));
             let bin_path = dir.join("run_go_binary");
+            let build_start = Instant::now();
             let mut build_cmd = Command::new(binary);
             build_cmd
                 .arg("build")
+                .args(toolchain_flags())
                 .arg("-o")
                 .arg(&bin_path)
                 .env("GO111MODULE", "off")
@@ -256,17 +279,22 @@ impl LanguageEngine for GoEngine {
                 build_cmd.arg(&source_path);
             }
 
-            let build_output = build_cmd.output().with_context(|| {
+            let build_output = build_step_output(&mut build_cmd).with_context(|| {
                 format!("failed to invoke {} to build Go source", binary.display())
             })?;
+            let compile_duration = build_start.elapsed();
 
             if !build_output.status.success() {
                 return Ok(ExecutionOutcome {
                     language: self.id().to_string(),
                     exit_code: build_output.status.code(),
                     stdout: String::from_utf8_lossy(&build_output.stdout).into_owned(),
-                    stderr: String::from_utf8_lossy(&build_output.stderr).into_owned(),
+                    stderr: String::new(),
                     duration: start.elapsed(),
+                    compile_duration: Some(compile_duration),
+                    compile_stderr: Some(
+                        String::from_utf8_lossy(&build_output.stderr).into_owned(),
+                    ),
                 });
             }
 
@@ -277,8 +305,9 @@ impl LanguageEngine for GoEngine {
                 .args(args)
                 .stdout(Stdio::piped())
                 .stderr(Stdio::piped())
-                .stdin(Stdio::inherit());
-            let child = run_cmd.spawn().with_context(|| {
+                .stdin(child_stdin());
+            isolate_process_group(&mut run_cmd);
+            let child = spawn_program(&mut run_cmd).with_context(|| {
                 format!(
                     "failed to execute compiled Go binary {}",
                     bin_path.display()
@@ -293,6 +322,8 @@ impl LanguageEngine for GoEngine {
                 stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
                 stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
                 duration: start.elapsed(),
+                compile_duration: Some(compile_duration),
+                compile_stderr: None,
             });
         }
 
@@ -305,9 +336,18 @@ impl LanguageEngine for GoEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some(
+            "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"Hello, world!\")\n}\n"
+                .to_string(),
+        )
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let binary = self.ensure_executable()?.to_path_buf();
         let session = GoSession::new(binary)?;
@@ -316,6 +356,9 @@ impl LanguageEngine for GoEngine {
 }
 
 fn resolve_go_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("go") {
+        return Some(path);
+    }
     which::which("go").ok()
 }
 
@@ -340,13 +383,19 @@ struct GoSession {
 
 enum GoSnippetKind {
     Import(Option<String>),
-    Item,
+    /// A new item, plus the earlier declaration of the same name it replaced
+    /// (and where it sat) so a failed build can put it back.
+    Item(Option<(usize, String)>),
     Statement,
+    /// A statement whose `:=` became `=` because its variables already exist.
+    Reassignment,
 }
 
 impl GoSession {
     fn new(go_binary: PathBuf) -> Result<Self> {
-        let workspace = TempDir::new().context("failed to create Go session workspace")?;
+        let workspace = temp_builder()
+            .tempdir()
+            .context("failed to create Go session workspace")?;
         let mut imports = BTreeSet::new();
         imports.insert("\"fmt\"".to_string());
         let session = Self {
@@ -370,6 +419,16 @@ impl GoSession {
         self.workspace.path().join(SESSION_MAIN_FILE)
     }
 
+    fn reset_state(&mut self) -> Result<()> {
+        self.imports.clear();
+        self.imports.insert("\"fmt\"".to_string());
+        self.items.clear();
+        self.statements.clear();
+        self.last_stdout.clear();
+        self.last_stderr.clear();
+        self.persist_source()
+    }
+
     fn persist_source(&self) -> Result<()> {
         let source = self.render_source();
         fs::write(self.source_path(), source)
@@ -432,7 +491,7 @@ impl GoSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Go session",
                 self.go_binary.display()
@@ -488,7 +547,7 @@ impl GoSession {
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
 
-        let output = cmd.output().with_context(|| {
+        let output = run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Go standalone program",
                 self.go_binary.display()
@@ -501,6 +560,8 @@ impl GoSession {
             stdout: Self::normalize_output(&output.stdout),
             stderr: Self::normalize_output(&output.stderr),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         let _ = fs::remove_file(&standalone_path);
@@ -522,14 +583,49 @@ impl GoSession {
         if !snippet.ends_with('\n') {
             snippet.push('\n');
         }
+        // Redeclaring a func, type, var or const replaces the earlier
+        // declaration instead of clashing with it.
+        let replaced = go_item_name(code).and_then(|name| {
+            let index = self
+                .items
+                .iter()
+                .position(|item| go_item_name(item).as_deref() == Some(name.as_str()))?;
+            Some((index, self.items.remove(index)))
+        });
         self.items.push(snippet);
-        GoSnippetKind::Item
+        GoSnippetKind::Item(replaced)
     }
 
     fn add_statement(&mut self, code: &str) -> GoSnippetKind {
-        let snippet = sanitize_statement(code);
+        let (snippet, kind) = match self.redeclared_assignment(code) {
+            Some(assignment) => (sanitize_statement(&assignment), GoSnippetKind::Reassignment),
+            None => (sanitize_statement(code), GoSnippetKind::Statement),
+        };
         self.statements.push(snippet);
-        GoSnippetKind::Statement
+        kind
+    }
+
+    /// `x := 2` after an earlier `x := 1` would not compile ("no new
+    /// variables on left side of :="), so it becomes `x = 2`.
+    fn redeclared_assignment(&self, code: &str) -> Option<String> {
+        let trimmed = code.trim();
+        let names = short_var_names(trimmed)?;
+        let declared: BTreeSet<&str> = self
+            .statements
+            .iter()
+            .filter(|snippet| snippet.lines().skip(1).all(|line| line.starts_with("_ = ")))
+            .filter_map(|snippet| snippet.lines().next().and_then(short_var_names))
+            .flatten()
+            .collect();
+        let all_declared = names
+            .iter()
+            .filter(|name| **name != "_")
+            .all(|name| declared.contains(name));
+        if !all_declared {
+            return None;
+        }
+        let (lhs, rhs) = trimmed.split_once(":=")?;
+        Some(format!("{} = {}", lhs.trim_end(), rhs.trim_start()))
     }
 
     fn add_expression(&mut self, code: &str) -> GoSnippetKind {
@@ -544,10 +640,13 @@ impl GoSession {
                 self.imports.remove(&spec);
             }
             GoSnippetKind::Import(None) => {}
-            GoSnippetKind::Item => {
+            GoSnippetKind::Item(replaced) => {
                 self.items.pop();
+                if let Some((index, item)) = replaced {
+                    self.items.insert(index, item);
+                }
             }
-            GoSnippetKind::Statement => {
+            GoSnippetKind::Statement | GoSnippetKind::Reassignment => {
                 self.statements.pop();
             }
         }
@@ -577,6 +676,8 @@ impl GoSession {
                     stdout: String::new(),
                     stderr: String::new(),
                     duration: Default::default(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 },
                 true,
             )),
@@ -601,6 +702,8 @@ impl GoSession {
                         stdout,
                         stderr,
                         duration,
+                        compile_duration: None,
+                        compile_stderr: None,
                     };
                     return Ok((outcome, true));
                 }
@@ -615,11 +718,22 @@ impl GoSession {
                             stdout: String::new(),
                             stderr: String::new(),
                             duration,
+                            compile_duration: None,
+                            compile_stderr: None,
                         },
                         true,
                     ));
                 }
 
+                let mut stderr = stderr;
+                if matches!(other_kind, GoSnippetKind::Reassignment) {
+                    // Most likely a new type for the variable, which an
+                    // assignment cannot give it.
+                    if !stderr.is_empty() && !stderr.ends_with('\n') {
+                        stderr.push('\n');
+                    }
+                    stderr.push_str(REASSIGNMENT_NOTE);
+                }
                 self.rollback(other_kind)?;
                 let outcome = ExecutionOutcome {
                     language: self.language_id().to_string(),
@@ -627,6 +741,8 @@ impl GoSession {
                     stdout,
                     stderr,
                     duration,
+                    compile_duration: None,
+                    compile_stderr: None,
                 };
                 Ok((outcome, false))
             }
@@ -668,6 +784,8 @@ impl LanguageSession for GoSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Instant::now().elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -678,6 +796,8 @@ impl LanguageSession for GoSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Instant::now().elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -707,6 +827,10 @@ impl LanguageSession for GoSession {
         Ok(outcome)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -770,6 +894,58 @@ fn wrap_expression(code: &str) -> String {
     format!("__print({});\n", code)
 }
 
+/// Name a top-level declaration introduces: `Name` for funcs, types, vars and
+/// consts, `Type.Name` for methods. Grouped `var (...)` blocks have none.
+fn go_item_name(code: &str) -> Option<String> {
+    let line = code
+        .lines()
+        .map(str::trim)
+        .find(|line| !line.is_empty() && !line.starts_with("//"))?;
+    let (keyword, rest) = line.split_once(char::is_whitespace)?;
+    let mut rest = rest.trim_start();
+    let mut receiver = None;
+    match keyword {
+        "func" => {
+            if let Some(stripped) = rest.strip_prefix('(') {
+                let close = stripped.find(')')?;
+                let ty = stripped[..close].split_whitespace().last()?;
+                let ty = ty.trim_start_matches('*');
+                receiver = Some(ty.split('[').next().unwrap_or(ty).to_string());
+                rest = stripped[close + 1..].trim_start();
+            }
+        }
+        "type" | "var" | "const" => {}
+        _ => return None,
+    }
+    let name: String = rest
+        .chars()
+        .take_while(|ch| ch.is_alphanumeric() || *ch == '_')
+        .collect();
+    if name.is_empty() {
+        return None;
+    }
+    Some(match receiver {
+        Some(ty) => format!("{ty}.{name}"),
+        None => name,
+    })
+}
+
+/// Names on the left of a single-line `a, b := ...`, if every one of them is
+/// a plain identifier (so `for i := ...` and `if v, ok := ...` don't count).
+/// Added to the errors of a failed [`GoSnippetKind::Reassignment`].
+const REASSIGNMENT_NOTE: &str = "note: `:=` for a variable the session already has runs as `=`, so the variable keeps its type; use a new name or :reset to change it\n";
+
+fn short_var_names(line: &str) -> Option<Vec<&str>> {
+    let (lhs, _) = line.trim().split_once(":=")?;
+    let names: Vec<&str> = lhs.split(',').map(str::trim).collect();
+    let plain = names.iter().all(|name| {
+        !name.is_empty()
+            && !name.starts_with(|ch: char| ch.is_ascii_digit())
+            && name.chars().all(|ch| ch.is_alphanumeric() || ch == '_')
+    });
+    plain.then_some(names)
+}
+
 fn sanitize_statement(code: &str) -> String {
     let mut snippet = code.to_string();
     if !snippet.ends_with('\n') {
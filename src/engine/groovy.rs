@@ -6,16 +6,156 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    build_step_with_timeout, child_stdin, run_version_command, run_with_timeout, temp_builder,
+    toolchain_flags,
 };
 
 pub struct GroovyEngine {
-    executable: Option<PathBuf>,
+    toolchain: Option<GroovyToolchain>,
 }
 
+/// How Groovy code gets run: through the `groovy` launcher when present,
+/// otherwise by compiling with `groovyc` and starting the class on `java`.
+#[derive(Clone)]
+enum GroovyToolchain {
+    Launcher(PathBuf),
+    Compiler {
+        groovyc: PathBuf,
+        java: PathBuf,
+        lib_dir: Option<PathBuf>,
+    },
+}
+
+impl GroovyToolchain {
+    fn resolve() -> Option<Self> {
+        if let Some(groovy) = resolve_groovy_binary() {
+            return Some(Self::Launcher(groovy));
+        }
+        let groovyc = resolve_groovyc_binary()?;
+        let java = which::which("java").ok()?;
+        let lib_dir = groovy_lib_dir(&groovyc);
+        Some(Self::Compiler {
+            groovyc,
+            java,
+            lib_dir,
+        })
+    }
+
+    fn binary(&self) -> &Path {
+        match self {
+            Self::Launcher(groovy) => groovy,
+            Self::Compiler { groovyc, .. } => groovyc,
+        }
+    }
+
+    /// Runs the script at `script`, compiling it into `workdir` first when
+    /// only `groovyc` is available. A failed compilation is returned as the
+    /// output so callers surface groovyc's diagnostics like any other error.
+    fn run_script(
+        &self,
+        script: &Path,
+        args: &[String],
+        workdir: &Path,
+        stdin: Stdio,
+    ) -> Result<std::process::Output> {
+        match self {
+            Self::Launcher(groovy) => {
+                let mut cmd = Command::new(groovy);
+                cmd.args(toolchain_flags())
+                    .arg(script)
+                    .args(args)
+                    .stdin(stdin);
+                run_with_timeout(&mut cmd).with_context(|| {
+                    format!(
+                        "failed to execute {} for Groovy script {}",
+                        groovy.display(),
+                        script.display()
+                    )
+                })
+            }
+            Self::Compiler {
+                groovyc,
+                java,
+                lib_dir,
+            } => {
+                let classes = workdir.join("classes");
+                fs::create_dir_all(&classes).with_context(|| {
+                    format!(
+                        "failed to create Groovy class output directory {}",
+                        classes.display()
+                    )
+                })?;
+                let stem = script
+                    .file_stem()
+                    .and_then(|stem| stem.to_str())
+                    .context("Groovy script path has no usable file name")?;
+                // The script class is named after the file, so a stem that is
+                // not a Java identifier (`my-script`) is compiled from a copy
+                // whose name is one, or `java` could not load the class.
+                let class_name = script_class_name(stem);
+                let source = if class_name == stem {
+                    script.to_path_buf()
+                } else {
+                    let copy = workdir.join(format!("{class_name}.groovy"));
+                    fs::copy(script, &copy).with_context(|| {
+                        format!(
+                            "failed to copy Groovy script {} to {}",
+                            script.display(),
+                            copy.display()
+                        )
+                    })?;
+                    copy
+                };
+                let mut compile = Command::new(groovyc);
+                compile
+                    .args(toolchain_flags())
+                    .arg("-d")
+                    .arg(&classes)
+                    .arg(&source);
+                compile.stdin(Stdio::null());
+                let compiled = build_step_with_timeout(&mut compile).with_context(|| {
+                    format!(
+                        "failed to invoke {} to compile {}",
+                        groovyc.display(),
+                        script.display()
+                    )
+                })?;
+                if !compiled.status.success() {
+                    return Ok(compiled);
+                }
+
+                let mut classpath = classes.into_os_string();
+                if let Some(lib_dir) = lib_dir {
+                    classpath.push(CLASSPATH_SEPARATOR);
+                    classpath.push(lib_dir.join("*"));
+                }
+                let mut cmd = Command::new(java);
+                cmd.arg("-cp")
+                    .arg(classpath)
+                    .arg(&class_name)
+                    .args(args)
+                    .stdin(stdin);
+                run_with_timeout(&mut cmd).with_context(|| {
+                    format!(
+                        "failed to execute {} for compiled Groovy script {}",
+                        java.display(),
+                        script.display()
+                    )
+                })
+            }
+        }
+    }
+}
+
+#[cfg(windows)]
+const CLASSPATH_SEPARATOR: &str = ";";
+#[cfg(not(windows))]
+const CLASSPATH_SEPARATOR: &str = ":";
+
 impl Default for GroovyEngine {
     fn default() -> Self {
         Self::new()
@@ -24,14 +164,15 @@ impl Default for GroovyEngine {
 
 impl GroovyEngine {
     pub fn new() -> Self {
-        let executable = resolve_groovy_binary();
-        Self { executable }
+        Self {
+            toolchain: GroovyToolchain::resolve(),
+        }
     }
 
-    fn ensure_binary(&self) -> Result<&Path> {
-        self.executable.as_deref().ok_or_else(|| {
+    fn ensure_toolchain(&self) -> Result<&GroovyToolchain> {
+        self.toolchain.as_ref().ok_or_else(|| {
             anyhow::anyhow!(
-                "Groovy support requires the `groovy` executable. Install it from https://groovy-lang.org/download.html and make sure it is available on your PATH."
+                "Groovy support requires the `groovy` executable (or `groovyc` together with `java`). Install it from https://groovy-lang.org/download.html and make sure it is available on your PATH or under GROOVY_HOME."
             )
         })
     }
@@ -51,11 +192,11 @@ impl LanguageEngine for GroovyEngine {
     }
 
     fn supports_sessions(&self) -> bool {
-        self.executable.is_some()
+        self.toolchain.is_some()
     }
 
     fn validate(&self) -> Result<()> {
-        let binary = self.ensure_binary()?;
+        let binary = self.ensure_toolchain()?.binary();
         let mut cmd = Command::new(binary);
         cmd.arg("--version")
             .stdout(Stdio::null())
@@ -68,70 +209,73 @@ impl LanguageEngine for GroovyEngine {
     }
 
     fn toolchain_version(&self) -> Result<Option<String>> {
-        let binary = self.ensure_binary()?;
+        let binary = self.ensure_toolchain()?.binary();
         let mut cmd = Command::new(binary);
         cmd.arg("--version");
         let context = format!("{}", binary.display());
-        run_version_command(cmd, &context)
+        let version = run_version_command(cmd, &context)?;
+        Ok(version.map(|line| tidy_version_line(&line)))
+    }
+
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.toolchain
+            .as_ref()
+            .map(|toolchain| toolchain.binary().to_path_buf())
     }
 
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
-        let binary = self.ensure_binary()?;
+        let toolchain = self.ensure_toolchain()?;
         let start = Instant::now();
         let args = payload.args();
-        let output = match payload {
-            ExecutionPayload::Inline { code, .. } => {
+        let output = match (toolchain, payload) {
+            (GroovyToolchain::Launcher(binary), ExecutionPayload::Inline { code, .. }) => {
                 let prepared = prepare_groovy_source(code);
                 let mut cmd = Command::new(binary);
-                cmd.arg("-e").arg(prepared.as_ref()).args(args);
-                cmd.stdin(Stdio::inherit());
-                cmd.output().with_context(|| {
+                cmd.args(toolchain_flags())
+                    .arg("-e")
+                    .arg(prepared.as_ref())
+                    .args(args);
+                cmd.stdin(child_stdin());
+                run_with_timeout(&mut cmd).with_context(|| {
                     format!(
                         "failed to execute {} for inline Groovy snippet",
                         binary.display()
                     )
                 })
             }
-            ExecutionPayload::File { path, .. } => {
-                let mut cmd = Command::new(binary);
-                cmd.arg(path).args(args);
-                cmd.stdin(Stdio::inherit());
-                cmd.output().with_context(|| {
-                    format!(
-                        "failed to execute {} for Groovy script {}",
-                        binary.display(),
-                        path.display()
-                    )
-                })
+            (GroovyToolchain::Launcher(_), ExecutionPayload::File { path, .. }) => {
+                let workdir = path.parent().unwrap_or_else(|| Path::new("."));
+                toolchain.run_script(path, args, workdir, child_stdin())
+            }
+            (GroovyToolchain::Compiler { .. }, ExecutionPayload::File { path, .. }) => {
+                let dir = groovy_temp_dir()?;
+                toolchain.run_script(path, args, dir.path(), child_stdin())
             }
-            ExecutionPayload::Stdin { code, .. } => {
-                let mut script = Builder::new()
-                    .prefix("run-groovy-stdin")
-                    .suffix(".groovy")
-                    .tempfile()
-                    .context("failed to create temporary Groovy script for stdin input")?;
+            (_, ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. }) => {
+                let dir = groovy_temp_dir()?;
+                let script_path = dir.path().join("script.groovy");
                 let mut prepared = prepare_groovy_source(code).into_owned();
                 if !prepared.ends_with('\n') {
                     prepared.push('\n');
                 }
-                script
-                    .write_all(prepared.as_bytes())
-                    .context("failed to write piped Groovy source")?;
-                script.flush()?;
-
-                let script_path = script.path().to_path_buf();
-                let mut cmd = Command::new(binary);
-                cmd.arg(&script_path).args(args);
-                cmd.stdin(Stdio::null());
-                let output = cmd.output().with_context(|| {
+                let mut script = fs::File::create(&script_path).with_context(|| {
                     format!(
-                        "failed to execute {} for Groovy stdin script {}",
-                        binary.display(),
+                        "failed to create temporary Groovy script {}",
                         script_path.display()
                     )
                 })?;
+                script
+                    .write_all(prepared.as_bytes())
+                    .context("failed to write Groovy source")?;
+                script.flush()?;
                 drop(script);
-                Ok(output)
+
+                let stdin = if matches!(payload, ExecutionPayload::Stdin { .. }) {
+                    Stdio::null()
+                } else {
+                    child_stdin()
+                };
+                toolchain.run_script(&script_path, args, dir.path(), stdin)
             }
         }?;
 
@@ -141,21 +285,101 @@ impl LanguageEngine for GroovyEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("println \"Hello, world!\"\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
-        let executable = self.ensure_binary()?.to_path_buf();
-        Ok(Box::new(GroovySession::new(executable)?))
+        let toolchain = self.ensure_toolchain()?.clone();
+        Ok(Box::new(GroovySession::new(toolchain)?))
     }
 }
 
+/// `stem` with every character a Java identifier cannot hold replaced by
+/// `_`, and a leading `_` when it starts with a digit.
+fn script_class_name(stem: &str) -> String {
+    let mut name: String = stem
+        .chars()
+        .map(|ch| {
+            if ch.is_alphanumeric() || ch == '_' || ch == '$' {
+                ch
+            } else {
+                '_'
+            }
+        })
+        .collect();
+    if !name.starts_with(|ch: char| ch.is_alphabetic() || ch == '_' || ch == '$') {
+        name.insert(0, '_');
+    }
+    name
+}
+
+fn groovy_temp_dir() -> Result<TempDir> {
+    temp_builder()
+        .prefix("run-groovy")
+        .tempdir()
+        .context("failed to create temporary directory for Groovy execution")
+}
+
 fn resolve_groovy_binary() -> Option<PathBuf> {
-    which::which("groovy").ok()
+    if let Some(path) = binary_override("groovy") {
+        return Some(path);
+    }
+    which::which("groovy")
+        .ok()
+        .or_else(|| groovy_home_binary("groovy"))
+}
+
+fn resolve_groovyc_binary() -> Option<PathBuf> {
+    which::which("groovyc")
+        .ok()
+        .or_else(|| groovy_home_binary("groovyc"))
+}
+
+fn groovy_home_binary(name: &str) -> Option<PathBuf> {
+    let home = std::env::var_os("GROOVY_HOME")?;
+    let bin = PathBuf::from(home).join("bin");
+    which::which_in(name, Some(&bin), &bin).ok()
+}
+
+/// Finds the distribution's `lib/` directory next to `bin/groovyc`, following
+/// symlinks such as the ones package managers drop into `/usr/bin`.
+fn groovy_lib_dir(groovyc: &Path) -> Option<PathBuf> {
+    if let Some(home) = std::env::var_os("GROOVY_HOME") {
+        let lib = PathBuf::from(home).join("lib");
+        if lib.is_dir() {
+            return Some(lib);
+        }
+    }
+    let resolved = fs::canonicalize(groovyc).ok()?;
+    let lib = resolved.parent()?.parent()?.join("lib");
+    lib.is_dir().then_some(lib)
+}
+
+/// `groovy --version` prints `Groovy Version: 4.0.21 JVM: 17.0.10 Vendor: ...`;
+/// keep the Groovy and JVM parts and drop the vendor/OS noise.
+fn tidy_version_line(line: &str) -> String {
+    let trimmed = line.trim();
+    let Some(rest) = trimmed.strip_prefix("Groovy Version:") else {
+        return trimmed.to_string();
+    };
+    let mut parts = rest.split_whitespace();
+    let Some(version) = parts.next() else {
+        return trimmed.to_string();
+    };
+    match (parts.next(), parts.next()) {
+        (Some("JVM:"), Some(jvm)) => format!("Groovy {version} (JVM {jvm})"),
+        _ => format!("Groovy {version}"),
+    }
 }
 
 struct GroovySession {
-    executable: PathBuf,
+    toolchain: GroovyToolchain,
     dir: TempDir,
     source_path: PathBuf,
     statements: Vec<String>,
@@ -164,8 +388,8 @@ struct GroovySession {
 }
 
 impl GroovySession {
-    fn new(executable: PathBuf) -> Result<Self> {
-        let dir = Builder::new()
+    fn new(toolchain: GroovyToolchain) -> Result<Self> {
+        let dir = temp_builder()
             .prefix("run-groovy-repl")
             .tempdir()
             .context("failed to create temporary directory for groovy repl")?;
@@ -178,7 +402,7 @@ impl GroovySession {
         })?;
 
         Ok(Self {
-            executable,
+            toolchain,
             dir,
             source_path,
             statements: Vec::new(),
@@ -230,24 +454,16 @@ impl GroovySession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
     }
 
     fn run_script(&self) -> Result<std::process::Output> {
-        let mut cmd = Command::new(&self.executable);
-        cmd.arg(&self.source_path)
-            .stdout(Stdio::piped())
-            .stderr(Stdio::piped())
-            .current_dir(self.dir.path());
-        cmd.output().with_context(|| {
-            format!(
-                "failed to run groovy session script {} with {}",
-                self.source_path.display(),
-                self.executable.display()
-            )
-        })
+        self.toolchain
+            .run_script(&self.source_path, &[], self.dir.path(), Stdio::null())
     }
 
     fn run_snippet(&mut self, snippet: String) -> Result<ExecutionOutcome> {
@@ -285,6 +501,8 @@ impl LanguageSession for GroovySession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -296,6 +514,8 @@ impl LanguageSession for GroovySession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -308,6 +528,8 @@ impl LanguageSession for GroovySession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -322,6 +544,10 @@ impl LanguageSession for GroovySession {
         self.run_snippet(snippet)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -359,7 +585,7 @@ fn should_treat_as_expression(code: &str) -> bool {
     }
 
     let lowered = without_trailing_semicolon.to_ascii_lowercase();
-    const STATEMENT_PREFIXES: [&str; 15] = [
+    const STATEMENT_PREFIXES: [&str; 16] = [
         "import ",
         "package ",
         "class ",
@@ -375,6 +601,7 @@ fn should_treat_as_expression(code: &str) -> bool {
         "finally",
         "return ",
         "throw ",
+        "assert ",
     ];
     if STATEMENT_PREFIXES
         .iter()
@@ -405,9 +632,40 @@ fn should_treat_as_expression(code: &str) -> bool {
         return false;
     }
 
+    if is_command_expression(without_trailing_semicolon) {
+        return false;
+    }
+
     true
 }
 
+/// Detects Groovy's paren-less call syntax (`sleep 100`, `log.info "x"`), which
+/// is only legal as a statement and cannot be wrapped in `(...)`.
+fn is_command_expression(code: &str) -> bool {
+    let head_len = code
+        .find(|c: char| !(c.is_alphanumeric() || c == '_' || c == '.' || c == '$'))
+        .unwrap_or(code.len());
+    let head = &code[..head_len];
+    if head.is_empty() || head.starts_with(|c: char| c.is_ascii_digit()) {
+        return false;
+    }
+    if matches!(head, "new" | "true" | "false" | "null" | "this" | "super") {
+        return false;
+    }
+    let rest = &code[head_len..];
+    let arg = rest.trim_start();
+    if arg.len() == rest.len() || arg.is_empty() {
+        return false;
+    }
+    const BINARY_KEYWORDS: [&str; 4] = ["in ", "as ", "instanceof ", "!in "];
+    if BINARY_KEYWORDS.iter().any(|kw| arg.starts_with(kw)) {
+        return false;
+    }
+    arg.starts_with(|c: char| {
+        c.is_alphanumeric() || matches!(c, '_' | '$' | '"' | '\'' | '[' | '{')
+    })
+}
+
 fn rewrite_if_expression(expr: &str) -> Option<String> {
     let trimmed = expr.trim();
     let lowered = trimmed.to_ascii_lowercase();
@@ -637,3 +895,51 @@ fn strip_inline_comment(line: &str) -> &str {
     }
     line
 }
+
+#[cfg(test)]
+mod tests {
+    use super::{
+        prepare_groovy_source, script_class_name, should_treat_as_expression, tidy_version_line,
+    };
+
+    #[test]
+    fn script_class_names_are_java_identifiers() {
+        assert_eq!(script_class_name("Hello"), "Hello");
+        assert_eq!(script_class_name("my-script"), "my_script");
+        assert_eq!(script_class_name("2fast.v2"), "_2fast_v2");
+        assert_eq!(script_class_name(""), "_");
+    }
+
+    #[test]
+    fn command_calls_are_statements() {
+        assert!(!should_treat_as_expression("println \"hi\""));
+        assert!(!should_treat_as_expression("sleep 100"));
+        assert!(!should_treat_as_expression("log.info 'x'"));
+        assert!(!should_treat_as_expression("def x = 5"));
+        assert!(!should_treat_as_expression("assert x == 1"));
+        assert!(should_treat_as_expression("x + 1"));
+        assert!(should_treat_as_expression("x in [1, 2]"));
+        assert!(should_treat_as_expression("new Date()"));
+        assert!(should_treat_as_expression("[1, 2].sum()"));
+    }
+
+    #[test]
+    fn inline_source_does_not_print_command_calls() {
+        assert_eq!(prepare_groovy_source("println \"hi\""), "println \"hi\"");
+        assert!(prepare_groovy_source("def x = 2\nx * 3").ends_with("println(x * 3);\n"));
+    }
+
+    #[test]
+    fn version_line_keeps_groovy_and_jvm() {
+        assert_eq!(
+            tidy_version_line(
+                "Groovy Version: 4.0.21 JVM: 17.0.10 Vendor: Eclipse Adoptium OS: Linux"
+            ),
+            "Groovy 4.0.21 (JVM 17.0.10)"
+        );
+        assert_eq!(
+            tidy_version_line("Groovy compiler version 4.0.21"),
+            "Groovy compiler version 4.0.21"
+        );
+    }
+}
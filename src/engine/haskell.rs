@@ -5,14 +5,19 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, SnippetLines,
+    binary_override, build_step_output, cache_store, child_stdin, compile_cache_key, perf_record,
+    run_version_command, run_with_timeout, temp_builder, toolchain_flags, try_cached_execution,
 };
 
+/// Snippets run through `runghc`; files are compiled with `ghc` (and cached)
+/// when it is installed, falling back to `runghc` otherwise.
 pub struct HaskellEngine {
     executable: Option<PathBuf>,
+    compiler: Option<PathBuf>,
 }
 
 impl Default for HaskellEngine {
@@ -23,8 +28,11 @@ impl Default for HaskellEngine {
 
 impl HaskellEngine {
     pub fn new() -> Self {
+        let executable = resolve_runghc_binary();
+        let compiler = resolve_ghc_binary(executable.as_deref());
         Self {
-            executable: resolve_runghc_binary(),
+            executable,
+            compiler,
         }
     }
 
@@ -37,15 +45,12 @@ impl HaskellEngine {
     }
 
     fn write_temp_source(&self, code: &str) -> Result<(TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-haskell")
             .tempdir()
             .context("failed to create temporary directory for Haskell source")?;
         let path = dir.path().join("snippet.hs");
-        let mut contents = code.to_string();
-        if !contents.ends_with('\n') {
-            contents.push('\n');
-        }
+        let contents = prepare_program(code);
         fs::write(&path, contents).with_context(|| {
             format!(
                 "failed to write temporary Haskell source to {}",
@@ -58,15 +63,21 @@ impl HaskellEngine {
     fn execute_path(&self, path: &Path, args: &[String]) -> Result<std::process::Output> {
         let executable = self.ensure_executable()?;
         let mut cmd = Command::new(executable);
-        cmd.arg(path)
-            .args(args)
-            .stdout(Stdio::piped())
-            .stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
+        // runghc takes its own options, so ghc flags go through --ghc-arg.
+        cmd.args(
+            toolchain_flags()
+                .into_iter()
+                .map(|flag| format!("--ghc-arg={flag}")),
+        )
+        .arg(path)
+        .args(args)
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped());
+        cmd.stdin(child_stdin());
         if let Some(parent) = path.parent() {
             cmd.current_dir(parent);
         }
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} with script {}",
                 executable.display(),
@@ -74,6 +85,94 @@ impl HaskellEngine {
             )
         })
     }
+
+    /// Compile `path` with `ghc` and run the binary. The cache key covers
+    /// every `.hs` file next to it, since local modules are compiled in.
+    fn execute_compiled(
+        &self,
+        compiler: &Path,
+        path: &Path,
+        args: &[String],
+    ) -> Result<ExecutionOutcome> {
+        let start = Instant::now();
+        let key = compile_cache_key(
+            self.id(),
+            compiler,
+            "--numeric-version",
+            &module_sources(path),
+        );
+        if let Some(output) = try_cached_execution("haskell-file", key, args) {
+            perf_record("haskell", "file.cache_hit");
+            return Ok(ExecutionOutcome {
+                language: self.id().to_string(),
+                exit_code: output.status.code(),
+                stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
+                stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
+                duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
+            });
+        }
+        perf_record("haskell", "file.cache_miss");
+
+        let temp_dir = temp_builder()
+            .prefix("run-haskell-build")
+            .tempdir()
+            .context("failed to create temporary directory for Haskell build")?;
+        let binary = temp_dir.path().join("run_haskell_binary");
+        let mut cmd = Command::new(compiler);
+        cmd.arg("-v0")
+            .arg("-O0")
+            .arg("-outputdir")
+            .arg(temp_dir.path().join("build"))
+            .arg("-o")
+            .arg(&binary);
+        if let Some(parent) = path.parent().filter(|p| !p.as_os_str().is_empty()) {
+            cmd.arg(format!("-i{}", parent.display()));
+        }
+        cmd.args(toolchain_flags())
+            .arg(path)
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped());
+        let compile_output = build_step_output(&mut cmd).with_context(|| {
+            format!(
+                "failed to invoke {} to compile {}",
+                compiler.display(),
+                path.display()
+            )
+        })?;
+        let compile_duration = start.elapsed();
+        if !compile_output.status.success() {
+            return Ok(ExecutionOutcome {
+                language: self.id().to_string(),
+                exit_code: compile_output.status.code(),
+                stdout: String::from_utf8_lossy(&compile_output.stdout).into_owned(),
+                stderr: String::new(),
+                duration: start.elapsed(),
+                compile_duration: Some(compile_duration),
+                compile_stderr: Some(String::from_utf8_lossy(&compile_output.stderr).into_owned()),
+            });
+        }
+
+        let binary = cache_store("haskell-file", key, &binary).unwrap_or(binary);
+        let mut run_cmd = Command::new(&binary);
+        run_cmd
+            .args(args)
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .stdin(child_stdin());
+        let output = run_with_timeout(&mut run_cmd)
+            .with_context(|| format!("failed to execute compiled binary {}", binary.display()))?;
+        Ok(ExecutionOutcome {
+            language: self.id().to_string(),
+            exit_code: output.status.code(),
+            stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
+            stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
+            duration: start.elapsed(),
+            compile_duration: Some(compile_duration),
+            compile_stderr: None,
+        })
+    }
 }
 
 impl LanguageEngine for HaskellEngine {
@@ -93,6 +192,10 @@ impl LanguageEngine for HaskellEngine {
         self.executable.is_some()
     }
 
+    fn is_input_complete(&self, buffer: &str) -> bool {
+        !needs_more_input(buffer)
+    }
+
     fn validate(&self) -> Result<()> {
         let executable = self.ensure_executable()?;
         let mut cmd = Command::new(executable);
@@ -107,14 +210,30 @@ impl LanguageEngine for HaskellEngine {
     }
 
     fn toolchain_version(&self) -> Result<Option<String>> {
-        let executable = self.ensure_executable()?;
+        let executable = match &self.compiler {
+            Some(ghc) => ghc.as_path(),
+            None => self.ensure_executable()?,
+        };
         let mut cmd = Command::new(executable);
         cmd.arg("--version");
         let context = format!("{}", executable.display());
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
+    fn snippet_lines(&self, payload: &ExecutionPayload) -> Option<SnippetLines> {
+        let code = payload.as_inline()?;
+        SnippetLines::new("snippet.hs", code, &prepare_program(code))
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
+        if let (ExecutionPayload::File { path, args }, Some(compiler)) = (payload, &self.compiler) {
+            return self.execute_compiled(compiler, path, args);
+        }
+
         let start = Instant::now();
         let (temp_dir, path) = match payload {
             ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
@@ -133,9 +252,15 @@ impl LanguageEngine for HaskellEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("main :: IO ()\nmain = putStrLn \"Hello, world!\"\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let executable = self.ensure_executable()?.to_path_buf();
         Ok(Box::new(HaskellSession::new(executable)?))
@@ -143,7 +268,121 @@ impl LanguageEngine for HaskellEngine {
 }
 
 fn resolve_runghc_binary() -> Option<PathBuf> {
-    which::which("runghc").ok()
+    if let Some(path) = binary_override("haskell") {
+        return Some(path);
+    }
+    which::which("runghc")
+        .or_else(|_| which::which("runhaskell"))
+        .ok()
+}
+
+/// `ghc` from the same installation as `runghc` (ghcup keeps them side by
+/// side), else the first one on PATH.
+fn resolve_ghc_binary(runghc: Option<&Path>) -> Option<PathBuf> {
+    let sibling = runghc
+        .and_then(Path::parent)
+        .map(|dir| dir.join(format!("ghc{}", std::env::consts::EXE_SUFFIX)))
+        .filter(|path| path.is_file());
+    sibling.or_else(|| which::which("ghc").ok())
+}
+
+/// Source text the compiled binary depends on: `path` plus any sibling
+/// modules, in a stable order.
+fn module_sources(path: &Path) -> String {
+    let mut sources = fs::read_to_string(path).unwrap_or_default();
+    let Some(dir) = path.parent().filter(|p| !p.as_os_str().is_empty()) else {
+        return sources;
+    };
+    let mut siblings: Vec<PathBuf> = fs::read_dir(dir)
+        .map(|entries| {
+            entries
+                .filter_map(|entry| entry.ok().map(|entry| entry.path()))
+                .filter(|p| p.extension().is_some_and(|ext| ext == "hs") && p != path)
+                .collect()
+        })
+        .unwrap_or_default();
+    siblings.sort();
+    for sibling in siblings {
+        sources.push('\0');
+        sources.push_str(&sibling.to_string_lossy());
+        sources.push('\0');
+        sources.push_str(&fs::read_to_string(&sibling).unwrap_or_default());
+    }
+    sources
+}
+
+/// Turn a snippet into a program `runghc` accepts. Code that defines `main`
+/// is left alone. Otherwise pragmas, the module header and imports go first,
+/// declarations stay at the top level and everything else becomes the body
+/// of `main`; a trailing pure expression is printed.
+fn prepare_program(code: &str) -> String {
+    if defines_main(code) {
+        return ensure_trailing_newline(code);
+    }
+
+    let mut header = Vec::new();
+    let mut declarations = Vec::new();
+    let mut body = Vec::new();
+    for chunk in top_level_chunks(code) {
+        let trimmed = chunk.trim();
+        if trimmed.starts_with("{-#") || trimmed.starts_with("module ") || is_import(trimmed) {
+            header.push(chunk);
+        } else if is_comment(trimmed) || is_declaration(trimmed) {
+            declarations.push(chunk);
+        } else {
+            body.push(chunk);
+        }
+    }
+
+    let mut source = String::new();
+    for chunk in header.iter().chain(&declarations) {
+        source.push_str(chunk);
+    }
+    source.push_str("\nmain :: IO ()\n");
+    match body.split_last() {
+        None => source.push_str("main = return ()\n"),
+        Some((last, rest)) => {
+            source.push_str("main = do\n");
+            for chunk in rest {
+                source.push_str(&indent_block(chunk.trim_end()));
+            }
+            if should_wrap_expression(last.trim()) {
+                source.push_str(&wrap_expression(last));
+            } else {
+                source.push_str(&indent_block(last.trim_end()));
+            }
+        }
+    }
+    source
+}
+
+fn defines_main(code: &str) -> bool {
+    code.lines().any(|line| {
+        line.strip_prefix("main")
+            .is_some_and(|rest| rest.is_empty() || rest.starts_with([' ', '\t', '=', ':']))
+    })
+}
+
+/// Split code at lines starting in column 0; indented and blank lines belong
+/// to the chunk above them, as Haskell's layout rule reads them.
+fn top_level_chunks(code: &str) -> Vec<String> {
+    let mut chunks: Vec<String> = Vec::new();
+    for line in code.lines() {
+        let continues = line.trim().is_empty() || line.starts_with([' ', '\t']);
+        match chunks.last_mut() {
+            Some(chunk) if continues => {
+                chunk.push_str(line);
+                chunk.push('\n');
+            }
+            _ if line.trim().is_empty() => {}
+            _ => chunks.push(format!("{line}\n")),
+        }
+    }
+    chunks
+}
+
+fn is_comment(code: &str) -> bool {
+    code.lines().all(|line| line.trim_start().starts_with("--"))
 }
 
 #[derive(Default)]
@@ -163,7 +402,7 @@ struct HaskellSession {
 
 impl HaskellSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let workspace = Builder::new()
+        let workspace = temp_builder()
             .prefix("run-haskell-repl")
             .tempdir()
             .context("failed to create temporary directory for Haskell repl")?;
@@ -235,7 +474,7 @@ impl HaskellSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Haskell session",
                 self.executable.display()
@@ -264,6 +503,8 @@ impl HaskellSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -290,6 +531,8 @@ impl HaskellSession {
                     stdout: String::new(),
                     stderr: String::new(),
                     duration: Duration::default(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 },
                 true,
             ));
@@ -342,7 +585,7 @@ impl HaskellSession {
         Ok((outcome, success))
     }
 
-    fn reset(&mut self) -> Result<()> {
+    fn reset_state(&mut self) -> Result<()> {
         self.state.imports.clear();
         self.state.declarations.clear();
         self.state.statements.clear();
@@ -366,17 +609,21 @@ impl LanguageSession for HaskellSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.reset()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: "haskell".to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -388,6 +635,8 @@ impl LanguageSession for HaskellSession {
                     .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -411,6 +660,10 @@ impl LanguageSession for HaskellSession {
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -464,21 +717,17 @@ fn is_declaration(code: &str) -> bool {
         return true;
     }
 
-    if trimmed.contains("::") {
+    if is_type_signature(trimmed) {
         return true;
     }
 
-    if !trimmed.contains('=') {
-        return false;
-    }
-
-    if let Some(lhs) = trimmed.split('=').next() {
-        let lhs = lhs.trim();
+    if let Some(index) = find_binding_equals(trimmed) {
+        let lhs = trimmed[..index].trim();
         if lhs.is_empty() {
             return false;
         }
         let first_token = lhs.split_whitespace().next().unwrap_or("");
-        if first_token.eq_ignore_ascii_case("let") {
+        if first_token.eq_ignore_ascii_case("let") || lhs.contains("<-") {
             return false;
         }
         first_token
@@ -491,6 +740,94 @@ fn is_declaration(code: &str) -> bool {
     }
 }
 
+/// `name :: Type` or `a, b :: Type`, as opposed to an annotated expression
+/// such as `read s :: Int`.
+fn is_type_signature(code: &str) -> bool {
+    let Some((names, _)) = code.split_once("::") else {
+        return false;
+    };
+    let names = names.trim();
+    !names.is_empty()
+        && names.split(',').all(|name| {
+            let name = name.trim();
+            let operator = name.starts_with('(') && name.ends_with(')') && name.len() > 2;
+            let identifier = name
+                .chars()
+                .next()
+                .is_some_and(|c| c.is_lowercase() || c == '_')
+                && name
+                    .chars()
+                    .all(|c| c.is_alphanumeric() || c == '_' || c == '\'');
+            operator || identifier
+        })
+}
+
+/// Byte offset of the first `=` that binds, skipping operators such as `==`,
+/// `/=` or `>=` and anything inside string literals.
+fn find_binding_equals(code: &str) -> Option<usize> {
+    let bytes = code.as_bytes();
+    let is_symbol = |i: Option<usize>| {
+        i.and_then(|i| bytes.get(i))
+            .is_some_and(|b| OPERATOR_SYMBOLS.as_bytes().contains(b))
+    };
+    let mut in_string = false;
+    let mut escape = false;
+    for (index, &byte) in bytes.iter().enumerate() {
+        if in_string {
+            match byte {
+                _ if escape => escape = false,
+                b'\\' => escape = true,
+                b'"' => in_string = false,
+                _ => {}
+            }
+            continue;
+        }
+        match byte {
+            b'"' => in_string = true,
+            b'=' if !is_symbol(index.checked_sub(1)) && !is_symbol(Some(index + 1)) => {
+                return Some(index);
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+const OPERATOR_SYMBOLS: &str = "!#$%&*+./<=>?@\\^|-~:";
+
+/// Expressions that are already `IO` actions run as statements; wrapping
+/// them in `print` would not type-check.
+fn looks_like_io_action(code: &str) -> bool {
+    const IO_FUNCTIONS: [&str; 20] = [
+        "putStrLn",
+        "putStr",
+        "print",
+        "mapM_",
+        "forM_",
+        "mapM",
+        "forM",
+        "sequence_",
+        "traverse_",
+        "when",
+        "unless",
+        "return",
+        "pure",
+        "interact",
+        "getLine",
+        "readFile",
+        "writeFile",
+        "appendFile",
+        "hPutStrLn",
+        "replicateM_",
+    ];
+    let first = code
+        .split(|c: char| c.is_whitespace() || c == '(' || c == '$')
+        .next()
+        .unwrap_or("");
+    let first = first.rsplit('.').next().unwrap_or(first);
+    IO_FUNCTIONS.contains(&first) || code.contains(">>=") || code.contains(">> ")
+}
+
 fn should_wrap_expression(code: &str) -> bool {
     if code.contains('\n') {
         return false;
@@ -523,11 +860,11 @@ fn should_wrap_expression(code: &str) -> bool {
         return false;
     }
 
-    if trimmed.contains('=') || trimmed.contains("->") || trimmed.contains("<-") {
+    if find_binding_equals(trimmed).is_some() || trimmed.contains("<-") {
         return false;
     }
 
-    true
+    !looks_like_io_action(trimmed)
 }
 
 fn ensure_trailing_newline(code: &str) -> String {
@@ -557,6 +894,138 @@ fn wrap_expression(code: &str) -> String {
     indent_block(&format!("print (({}))\n", code.trim()))
 }
 
+/// REPL completeness for Haskell's layout rule: keep reading while brackets,
+/// strings or `{- -}` comments are open, while the last line ends in a token
+/// that needs more (`do`, `where`, `=`, `->`, ...), and while an indented
+/// line continues a `do`/`where`/`of`/`let` block. A blank line ends a block.
+fn needs_more_input(code: &str) -> bool {
+    let Some(cleaned) = strip_literals_and_comments(code) else {
+        return true;
+    };
+    let mut depth = 0i32;
+    for ch in cleaned.chars() {
+        match ch {
+            '(' | '[' | '{' => depth += 1,
+            ')' | ']' | '}' => depth -= 1,
+            _ => {}
+        }
+    }
+    if depth > 0 {
+        return true;
+    }
+
+    let lines: Vec<&str> = cleaned
+        .lines()
+        .filter(|line| !line.trim().is_empty())
+        .collect();
+    let Some(last) = lines.last() else {
+        return false;
+    };
+    const CONTINUATIONS: [&str; 20] = [
+        "do", "mdo", "where", "of", "let", "in", "if", "then", "else", "\\case", "=", "->", "<-",
+        "|", "::", "$", "++", "&&", "||", ",",
+    ];
+    if last
+        .split_whitespace()
+        .last()
+        .is_some_and(|token| CONTINUATIONS.contains(&token) || token.ends_with(">>="))
+    {
+        return true;
+    }
+
+    if code.ends_with("\n\n") {
+        return false;
+    }
+    let opens_block = lines.iter().any(|line| {
+        line.split_whitespace()
+            .any(|token| matches!(token, "do" | "mdo" | "where" | "of" | "let"))
+    });
+    opens_block && last.starts_with([' ', '\t'])
+}
+
+/// Blank out string/char literals and comments so bracket and keyword checks
+/// only see code. `None` while a string or block comment is still open.
+fn strip_literals_and_comments(code: &str) -> Option<String> {
+    let chars: Vec<char> = code.chars().collect();
+    let mut out = String::with_capacity(code.len());
+    let mut comment_depth = 0usize;
+    let mut i = 0;
+    while i < chars.len() {
+        let ch = chars[i];
+        let next = chars.get(i + 1).copied();
+        if comment_depth > 0 {
+            if ch == '-' && next == Some('}') {
+                comment_depth -= 1;
+                i += 1;
+            } else if ch == '{' && next == Some('-') {
+                comment_depth += 1;
+                i += 1;
+            } else if ch == '\n' {
+                out.push('\n');
+            }
+            i += 1;
+            continue;
+        }
+        match ch {
+            '{' if next == Some('-') => {
+                comment_depth += 1;
+                i += 2;
+                continue;
+            }
+            '-' if next == Some('-') => {
+                let mut end = i;
+                while chars.get(end) == Some(&'-') {
+                    end += 1;
+                }
+                // `-->` and friends are operators, not comments.
+                if chars
+                    .get(end)
+                    .is_none_or(|c| !OPERATOR_SYMBOLS.contains(*c))
+                {
+                    while i < chars.len() && chars[i] != '\n' {
+                        i += 1;
+                    }
+                    continue;
+                }
+            }
+            '"' => {
+                i += 1;
+                loop {
+                    match chars.get(i) {
+                        None => return None,
+                        Some('\\') => i += 2,
+                        Some('"') => break,
+                        Some(_) => i += 1,
+                    }
+                }
+                out.push_str("\"\"");
+                i += 1;
+                continue;
+            }
+            // A quote right after an identifier is part of the name (x').
+            '\'' if !out.ends_with(|c: char| c.is_alphanumeric() || c == '_' || c == '\'') => {
+                let end = match (next, chars.get(i + 2)) {
+                    (Some('\\'), _) => chars
+                        .get(i + 3..)
+                        .and_then(|rest| rest.iter().position(|&c| c == '\''))
+                        .map(|offset| i + 3 + offset),
+                    (Some(_), Some('\'')) => Some(i + 2),
+                    _ => None,
+                };
+                if let Some(end) = end {
+                    out.push_str("' '");
+                    i = end + 1;
+                    continue;
+                }
+            }
+            _ => {}
+        }
+        out.push(ch);
+        i += 1;
+    }
+    (comment_depth == 0).then_some(out)
+}
+
 fn diff_output(previous: &str, current: &str) -> String {
     if let Some(stripped) = current.strip_prefix(previous) {
         stripped.to_string()
@@ -570,3 +1039,81 @@ fn normalize_output(bytes: &[u8]) -> String {
         .replace("\r\n", "\n")
         .replace('\r', "")
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::engine::SnippetLines;
+
+    #[test]
+    fn diagnostics_follow_hoisted_declarations() {
+        let code = "let n = 3\nsquare x = x * x\nputStrLn (show (square n))";
+        let lines = SnippetLines::new("snippet.hs", code, &prepare_program(code))
+            .expect("statements move into main");
+        let program = prepare_program(code);
+        let line_of = |needle: &str| {
+            program
+                .lines()
+                .position(|line| line.contains(needle))
+                .unwrap()
+                + 1
+        };
+        assert_eq!(
+            lines.remap(&format!("snippet.hs:{}:1: error", line_of("square x ="))),
+            "snippet.hs:2:1: error"
+        );
+        assert_eq!(
+            lines.remap(&format!("snippet.hs:{}:13: error", line_of("putStrLn"))),
+            "snippet.hs:3:13: error"
+        );
+    }
+
+    #[test]
+    fn bare_expression_is_printed_from_main() {
+        let program = prepare_program("1 + 2");
+        assert!(program.contains("main :: IO ()\nmain = do\n"), "{program}");
+        assert!(program.contains("print ((1 + 2))"), "{program}");
+    }
+
+    #[test]
+    fn snippet_with_main_is_untouched() {
+        let code = "main :: IO ()\nmain = putStrLn \"hi\"\n";
+        assert_eq!(prepare_program(code), code);
+    }
+
+    #[test]
+    fn declarations_stay_outside_main() {
+        let program = prepare_program(
+            "import Data.List (sort)\nsquare :: Int -> Int\nsquare x = x * x\nputStrLn \"go\"\nsort (map square [3, 1, 2])\n",
+        );
+        let main_at = program.find("main = do").expect("main");
+        assert!(program.find("import Data.List").unwrap() < main_at);
+        assert!(program.find("square x = x * x").unwrap() < main_at);
+        assert!(program.contains("    putStrLn \"go\"\n"), "{program}");
+        assert!(
+            program.contains("print ((sort (map square [3, 1, 2])))"),
+            "{program}"
+        );
+    }
+
+    #[test]
+    fn io_actions_and_comparisons_classify_correctly() {
+        assert!(!should_wrap_expression("putStrLn \"x = 1\""));
+        assert!(should_wrap_expression("length xs == 3"));
+        assert!(!is_declaration("read \"5\" :: Int"));
+        assert!(is_declaration("go, stop :: Int"));
+    }
+
+    #[test]
+    fn layout_blocks_wait_for_more_input() {
+        assert!(needs_more_input("main = do\n"));
+        assert!(needs_more_input("main = do\n  putStrLn \"a\"\n"));
+        assert!(!needs_more_input("main = do\n  putStrLn \"a\"\n\n"));
+        assert!(needs_more_input("f x = y\n  where\n"));
+        assert!(needs_more_input("classify n = case n of\n"));
+        assert!(needs_more_input("xs = [1,\n"));
+        assert!(needs_more_input("{- open comment\n"));
+        assert!(!needs_more_input("let x' = 'a'\n"));
+        assert!(!needs_more_input("x = 1 -- ends with do\n"));
+    }
+}
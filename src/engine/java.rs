@@ -6,11 +6,12 @@ use std::thread;
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::Builder;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, hash_source,
-    run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, SnippetLines,
+    apply_run_env, binary_override, build_step_output, child_stdin, compile_cache_dir,
+    compile_cache_enabled, compile_cache_key, run_version_command, run_with_timeout, temp_builder,
+    toolchain_flags,
 };
 
 pub struct JavaEngine {
@@ -42,6 +43,12 @@ impl JavaEngine {
         })
     }
 
+    /// Compile-cache key for `source`, tied to this compiler's version.
+    fn cache_key(&self, source: &str) -> Result<u64> {
+        let compiler = self.ensure_compiler()?;
+        Ok(compile_cache_key(self.id(), compiler, "-version", source))
+    }
+
     fn ensure_runtime(&self) -> Result<&Path> {
         self.runtime.as_deref().ok_or_else(|| {
             anyhow::anyhow!(
@@ -98,12 +105,13 @@ impl JavaEngine {
     fn compile(&self, source: &Path, output_dir: &Path) -> Result<std::process::Output> {
         let compiler = self.ensure_compiler()?;
         let mut cmd = Command::new(compiler);
-        cmd.arg("-d")
+        cmd.args(toolchain_flags())
+            .arg("-d")
             .arg(output_dir)
             .arg(source)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.output().with_context(|| {
+        build_step_output(&mut cmd).with_context(|| {
             format!(
                 "failed to invoke {} to compile {}",
                 compiler.display(),
@@ -126,8 +134,8 @@ impl JavaEngine {
             .args(args)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
-        cmd.output().with_context(|| {
+        cmd.stdin(child_stdin());
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for class {} with classpath {}",
                 runtime.display(),
@@ -207,6 +215,15 @@ impl LanguageEngine for JavaEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.compiler.clone()
+    }
+
+    fn snippet_lines(&self, payload: &ExecutionPayload) -> Option<SnippetLines> {
+        let code = payload.as_inline()?;
+        SnippetLines::new("Main.java", code, &wrap_inline_java(code))
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         // Check class file cache for inline/stdin payloads
         let args = payload.args();
@@ -218,12 +235,10 @@ impl LanguageEngine for JavaEngine {
             _ => None,
         } {
             let wrapped = wrap_inline_java(code);
-            let src_hash = hash_source(&wrapped);
-            let cache_dir = std::env::temp_dir()
-                .join("run-compile-cache")
-                .join(format!("java-{:016x}", src_hash));
+            let src_hash = self.cache_key(&wrapped)?;
+            let cache_dir = compile_cache_dir().join(format!("java-{:016x}", src_hash));
             let class_file = cache_dir.join("Main.class");
-            if class_file.exists() {
+            if compile_cache_enabled() && class_file.exists() {
                 let start = Instant::now();
                 if let Ok(output) = self.run(&cache_dir, "Main", args) {
                     return Ok(ExecutionOutcome {
@@ -232,12 +247,14 @@ impl LanguageEngine for JavaEngine {
                         stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
                         stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
                         duration: start.elapsed(),
+                        compile_duration: None,
+                        compile_stderr: None,
                     });
                 }
             }
         }
 
-        let temp_dir = Builder::new()
+        let temp_dir = temp_builder()
             .prefix("run-java")
             .tempdir()
             .context("failed to create temporary directory for java build")?;
@@ -252,13 +269,16 @@ impl LanguageEngine for JavaEngine {
         let start = Instant::now();
 
         let compile_output = self.compile(&source_path, dir_path)?;
+        let compile_duration = start.elapsed();
         if !compile_output.status.success() {
             return Ok(ExecutionOutcome {
                 language: self.id().to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: String::from_utf8_lossy(&compile_output.stdout).into_owned(),
-                stderr: String::from_utf8_lossy(&compile_output.stderr).into_owned(),
+                stderr: String::new(),
                 duration: start.elapsed(),
+                compile_duration: Some(compile_duration),
+                compile_stderr: Some(String::from_utf8_lossy(&compile_output.stderr).into_owned()),
             });
         }
 
@@ -268,12 +288,11 @@ impl LanguageEngine for JavaEngine {
                 Some(code.as_str())
             }
             _ => None,
-        } {
+        } && compile_cache_enabled()
+        {
             let wrapped = wrap_inline_java(code);
-            let src_hash = hash_source(&wrapped);
-            let cache_dir = std::env::temp_dir()
-                .join("run-compile-cache")
-                .join(format!("java-{:016x}", src_hash));
+            let src_hash = self.cache_key(&wrapped)?;
+            let cache_dir = compile_cache_dir().join(format!("java-{:016x}", src_hash));
             let _ = std::fs::create_dir_all(&cache_dir);
             // Copy all .class files
             if let Ok(entries) = std::fs::read_dir(dir_path) {
@@ -292,9 +311,18 @@ impl LanguageEngine for JavaEngine {
             stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: Some(compile_duration),
+            compile_stderr: None,
         })
     }
 
+    // `run File.java` runs the class named after the file.
+    fn starter_program(&self, name: &str) -> Option<String> {
+        Some(format!(
+            "public class {name} {{\n    public static void main(String[] args) {{\n        System.out.println(\"Hello, world!\");\n    }}\n}}\n"
+        ))
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let jshell = self.ensure_jshell()?;
         let mut cmd = Command::new(jshell);
@@ -305,6 +333,8 @@ impl LanguageEngine for JavaEngine {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
 
+        apply_run_env(&mut cmd);
+
         let mut child = cmd
             .spawn()
             .with_context(|| format!("failed to start {} REPL", jshell.display()))?;
@@ -346,6 +376,9 @@ impl LanguageEngine for JavaEngine {
 }
 
 fn resolve_javac_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("java") {
+        return Some(path);
+    }
     which::which("javac").ok()
 }
 
@@ -487,6 +520,8 @@ impl LanguageSession for JavaSession {
                 stderr: "jshell session already exited. Use :reset to start a new session.\n"
                     .to_string(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -513,9 +548,23 @@ impl LanguageSession for JavaSession {
             stdout,
             stderr,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn reset(&mut self) -> Result<()> {
+        if self.closed {
+            anyhow::bail!("jshell session has already exited");
+        }
+        self.write_code("/reset")?;
+        self.discard_prompt()
+    }
+
+    fn is_alive(&mut self) -> bool {
+        matches!(self.child.try_wait(), Ok(None))
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         if !self.closed
             && let Some(mut stdin) = self.child.stdin.take()
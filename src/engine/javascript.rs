@@ -8,8 +8,9 @@ use std::sync::{Arc, Mutex};
 use std::thread;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, execution_timeout,
-    run_version_command, wait_with_timeout,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, apply_run_env,
+    binary_override, child_stdin, execution_timeout, isolate_process_group, run_version_command,
+    spawn_program, toolchain_flags, wait_with_timeout,
 };
 
 pub struct JavascriptEngine {
@@ -35,6 +36,13 @@ impl JavascriptEngine {
     fn run_command(&self) -> Command {
         Command::new(self.binary())
     }
+
+    /// Node with `--flags` (say `--experimental-strip-types`) in front.
+    fn program_command(&self) -> Command {
+        let mut cmd = self.run_command();
+        cmd.args(toolchain_flags());
+        cmd
+    }
 }
 
 impl LanguageEngine for JavascriptEngine {
@@ -73,44 +81,52 @@ impl LanguageEngine for JavascriptEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        Some(self.executable.clone())
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let timeout = execution_timeout();
         let args = payload.args();
         let output = match payload {
             ExecutionPayload::Inline { code, .. } => {
-                let mut cmd = self.run_command();
-                cmd.arg("-e")
-                    .arg(code)
-                    .args(args)
-                    .stdin(Stdio::inherit())
+                let mut cmd = self.program_command();
+                cmd.arg("-e").arg(code);
+                if !args.is_empty() {
+                    // node -e has no script path in argv[1]; fill it so
+                    // process.argv.slice(2) is the program's arguments.
+                    cmd.arg("--").arg("[eval]").args(args);
+                }
+                cmd.stdin(child_stdin())
                     .stdout(Stdio::piped())
                     .stderr(Stdio::piped());
-                let child = cmd
-                    .spawn()
+                isolate_process_group(&mut cmd);
+                let child = spawn_program(&mut cmd)
                     .with_context(|| format!("failed to start {}", self.binary().display()))?;
                 wait_with_timeout(child, timeout)?
             }
             ExecutionPayload::File { path, .. } => {
-                let mut cmd = self.run_command();
+                let mut cmd = self.program_command();
                 cmd.arg(path)
                     .args(args)
-                    .stdin(Stdio::inherit())
+                    .stdin(child_stdin())
                     .stdout(Stdio::piped())
                     .stderr(Stdio::piped());
-                let child = cmd
-                    .spawn()
+                isolate_process_group(&mut cmd);
+                let child = spawn_program(&mut cmd)
                     .with_context(|| format!("failed to start {}", self.binary().display()))?;
                 wait_with_timeout(child, timeout)?
             }
             ExecutionPayload::Stdin { code, .. } => {
-                let mut cmd = self.run_command();
+                let mut cmd = self.program_command();
                 cmd.arg("-")
                     .args(args)
                     .stdin(Stdio::piped())
                     .stdout(Stdio::piped())
                     .stderr(Stdio::piped());
-                let mut child = cmd.spawn().with_context(|| {
+                isolate_process_group(&mut cmd);
+                let mut child = spawn_program(&mut cmd).with_context(|| {
                     format!(
                         "failed to start {} for stdin execution",
                         self.binary().display()
@@ -133,9 +149,15 @@ impl LanguageEngine for JavascriptEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("console.log(\"Hello, world!\");\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let mut cmd = self.run_command();
         cmd.arg("--interactive")
@@ -145,6 +167,8 @@ impl LanguageEngine for JavascriptEngine {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
 
+        apply_run_env(&mut cmd);
+
         let mut child = cmd
             .spawn()
             .with_context(|| format!("failed to start {} REPL", self.binary().display()))?;
@@ -185,6 +209,9 @@ impl LanguageEngine for JavascriptEngine {
 }
 
 fn resolve_node_binary() -> PathBuf {
+    if let Some(path) = binary_override("javascript") {
+        return path;
+    }
     let candidates = ["node", "nodejs"];
     for name in candidates {
         if let Ok(path) = which::which(name) {
@@ -279,9 +306,21 @@ impl LanguageSession for JavascriptSession {
             stdout,
             stderr,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn reset(&mut self) -> Result<()> {
+        // `node -i` evaluates in the real global context, which `.clear`
+        // does not reset; only a new process forgets top-level bindings.
+        anyhow::bail!("the Node REPL cannot clear its state in place")
+    }
+
+    fn is_alive(&mut self) -> bool {
+        matches!(self.child.try_wait(), Ok(None))
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         if let Some(mut stdin) = self.child.stdin.take() {
             let _ = stdin.write_all(b".exit\n");
@@ -5,10 +5,11 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    child_stdin, run_version_command, run_with_timeout, temp_builder, toolchain_flags,
 };
 
 pub struct JuliaEngine {
@@ -37,7 +38,7 @@ impl JuliaEngine {
     }
 
     fn write_temp_source(&self, code: &str) -> Result<(TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-julia")
             .tempdir()
             .context("failed to create temporary directory for Julia source")?;
@@ -60,15 +61,16 @@ impl JuliaEngine {
         let mut cmd = Command::new(executable);
         cmd.arg("--color=no")
             .arg("--quiet")
+            .args(toolchain_flags())
             .arg(path)
             .args(args)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
         if let Some(parent) = path.parent() {
             cmd.current_dir(parent);
         }
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} with script {}",
                 executable.display(),
@@ -116,6 +118,10 @@ impl LanguageEngine for JuliaEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let (temp_dir, path) = match payload {
@@ -135,9 +141,15 @@ impl LanguageEngine for JuliaEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("println(\"Hello, world!\")\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let executable = self.ensure_executable()?.to_path_buf();
         Ok(Box::new(JuliaSession::new(executable)?))
@@ -145,6 +157,9 @@ impl LanguageEngine for JuliaEngine {
 }
 
 fn resolve_julia_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("julia") {
+        return Some(path);
+    }
     which::which("julia").ok()
 }
 
@@ -165,7 +180,7 @@ struct JuliaSession {
 
 impl JuliaSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let workspace = Builder::new()
+        let workspace = temp_builder()
             .prefix("run-julia-repl")
             .tempdir()
             .context("failed to create temporary directory for Julia repl")?;
@@ -233,7 +248,7 @@ impl JuliaSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Julia session",
                 self.executable.display()
@@ -262,6 +277,8 @@ impl JuliaSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -292,6 +309,8 @@ impl JuliaSession {
                     stdout: String::new(),
                     stderr: String::new(),
                     duration: Duration::default(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 },
                 true,
             ));
@@ -342,7 +361,7 @@ impl JuliaSession {
         Ok((outcome, success))
     }
 
-    fn reset(&mut self) -> Result<()> {
+    fn reset_state(&mut self) -> Result<()> {
         self.state.imports.clear();
         self.state.declarations.clear();
         self.state.statements.clear();
@@ -366,17 +385,21 @@ impl LanguageSession for JuliaSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.reset()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: "julia".to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -389,6 +412,8 @@ impl LanguageSession for JuliaSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -412,6 +437,10 @@ impl LanguageSession for JuliaSession {
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
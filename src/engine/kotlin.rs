@@ -4,11 +4,12 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, hash_source,
-    run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, SnippetLines,
+    binary_override, build_step_output, child_stdin, compile_cache_dir, compile_cache_enabled,
+    compile_cache_key, run_version_command, run_with_timeout, temp_builder, toolchain_flags,
 };
 
 pub struct KotlinEngine {
@@ -38,6 +39,12 @@ impl KotlinEngine {
         })
     }
 
+    /// Compile-cache key for `source`, tied to this compiler's version.
+    fn cache_key(&self, source: &str) -> Result<u64> {
+        let compiler = self.ensure_compiler()?;
+        Ok(compile_cache_key(self.id(), compiler, "-version", source))
+    }
+
     fn ensure_java(&self) -> Result<&Path> {
         self.java.as_deref().ok_or_else(|| {
             anyhow::anyhow!(
@@ -140,6 +147,15 @@ impl LanguageEngine for KotlinEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.compiler.clone()
+    }
+
+    fn snippet_lines(&self, payload: &ExecutionPayload) -> Option<SnippetLines> {
+        let code = payload.as_inline()?;
+        SnippetLines::new("Main.kt", code, &wrap_inline_kotlin(code))
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         // Check jar cache for inline/stdin payloads
         let args = payload.args();
@@ -151,11 +167,9 @@ impl LanguageEngine for KotlinEngine {
             _ => None,
         } {
             let wrapped = wrap_inline_kotlin(code);
-            let src_hash = hash_source(&wrapped);
-            let cached_jar = std::env::temp_dir()
-                .join("run-compile-cache")
-                .join(format!("kotlin-{:016x}.jar", src_hash));
-            if cached_jar.exists() {
+            let src_hash = self.cache_key(&wrapped)?;
+            let cached_jar = compile_cache_dir().join(format!("kotlin-{:016x}.jar", src_hash));
+            if compile_cache_enabled() && cached_jar.exists() {
                 let start = Instant::now();
                 if let Ok(output) = self.run(&cached_jar, args) {
                     return Ok(ExecutionOutcome {
@@ -164,12 +178,14 @@ impl LanguageEngine for KotlinEngine {
                         stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
                         stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
                         duration: start.elapsed(),
+                        compile_duration: None,
+                        compile_stderr: None,
                     });
                 }
             }
         }
 
-        let temp_dir = Builder::new()
+        let temp_dir = temp_builder()
             .prefix("run-kotlin")
             .tempdir()
             .context("failed to create temporary directory for kotlin build")?;
@@ -186,13 +202,16 @@ impl LanguageEngine for KotlinEngine {
         let start = Instant::now();
 
         let compile_output = self.compile(&source_path, &jar_path)?;
+        let compile_duration = start.elapsed();
         if !compile_output.status.success() {
             return Ok(ExecutionOutcome {
                 language: self.id().to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: String::from_utf8_lossy(&compile_output.stdout).into_owned(),
-                stderr: String::from_utf8_lossy(&compile_output.stderr).into_owned(),
+                stderr: String::new(),
                 duration: start.elapsed(),
+                compile_duration: Some(compile_duration),
+                compile_stderr: Some(String::from_utf8_lossy(&compile_output.stderr).into_owned()),
             });
         }
 
@@ -202,10 +221,11 @@ impl LanguageEngine for KotlinEngine {
                 Some(code.as_str())
             }
             _ => None,
-        } {
+        } && compile_cache_enabled()
+        {
             let wrapped = wrap_inline_kotlin(code);
-            let src_hash = hash_source(&wrapped);
-            let cache_dir = std::env::temp_dir().join("run-compile-cache");
+            let src_hash = self.cache_key(&wrapped)?;
+            let cache_dir = compile_cache_dir();
             let _ = std::fs::create_dir_all(&cache_dir);
             let cached_jar = cache_dir.join(format!("kotlin-{:016x}.jar", src_hash));
             let _ = std::fs::copy(&jar_path, &cached_jar);
@@ -218,14 +238,20 @@ impl LanguageEngine for KotlinEngine {
             stdout: String::from_utf8_lossy(&run_output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&run_output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: Some(compile_duration),
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some(wrap_inline_kotlin("println(\"Hello, world!\")"))
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let compiler = self.ensure_compiler()?.to_path_buf();
         let java = self.ensure_java()?.to_path_buf();
 
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-kotlin-repl")
             .tempdir()
             .context("failed to create temporary directory for kotlin repl")?;
@@ -255,6 +281,9 @@ impl LanguageEngine for KotlinEngine {
 }
 
 fn resolve_kotlinc_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("kotlin") {
+        return Some(path);
+    }
     which::which("kotlinc").ok()
 }
 
@@ -456,13 +485,14 @@ fn invoke_kotlin_compiler(
     jar: &Path,
 ) -> Result<std::process::Output> {
     let mut cmd = Command::new(compiler);
-    cmd.arg(source)
+    cmd.args(toolchain_flags())
+        .arg(source)
         .arg("-include-runtime")
         .arg("-d")
         .arg(jar)
         .stdout(Stdio::piped())
         .stderr(Stdio::piped());
-    cmd.output().with_context(|| {
+    build_step_output(&mut cmd).with_context(|| {
         format!(
             "failed to invoke {} to compile {}",
             compiler.display(),
@@ -478,8 +508,8 @@ fn run_kotlin_jar(java: &Path, jar: &Path, args: &[String]) -> Result<std::proce
         .args(args)
         .stdout(Stdio::piped())
         .stderr(Stdio::piped());
-    cmd.stdin(Stdio::inherit());
-    cmd.output().with_context(|| {
+    cmd.stdin(child_stdin());
+    run_with_timeout(&mut cmd).with_context(|| {
         format!(
             "failed to execute {} -jar {}",
             java.display(),
@@ -573,6 +603,8 @@ impl KotlinSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration,
+            compile_duration: None,
+            compile_stderr: None,
         }
     }
 
@@ -631,8 +663,10 @@ impl KotlinSession {
                 language: "kotlin".to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: normalize_output(&compile_output.stdout),
-                stderr: normalize_output(&compile_output.stderr),
+                stderr: String::new(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: Some(normalize_output(&compile_output.stderr)),
             });
         }
 
@@ -643,6 +677,8 @@ impl KotlinSession {
             stdout: normalize_output(&run_output.stdout),
             stderr: normalize_output(&run_output.stderr),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 }
@@ -661,6 +697,8 @@ impl LanguageSession for KotlinSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -672,6 +710,8 @@ impl LanguageSession for KotlinSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -684,6 +724,8 @@ impl LanguageSession for KotlinSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -722,6 +764,10 @@ impl LanguageSession for KotlinSession {
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -4,10 +4,12 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    child_stdin, line_looks_incomplete, run_version_command, run_with_timeout, temp_builder,
+    toolchain_flags,
 };
 
 pub struct LuaEngine {
@@ -36,12 +38,12 @@ impl LuaEngine {
     }
 
     fn write_temp_script(&self, code: &str) -> Result<(tempfile::TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-lua")
             .tempdir()
             .context("failed to create temporary directory for lua source")?;
         let path = dir.path().join("snippet.lua");
-        let mut contents = code.to_string();
+        let mut contents = prepare_snippet(code);
         if !contents.ends_with('\n') {
             contents.push('\n');
         }
@@ -51,18 +53,32 @@ impl LuaEngine {
         Ok((dir, path))
     }
 
+    fn execute_chunk(&self, code: &str) -> Result<std::process::Output> {
+        let interpreter = self.ensure_interpreter()?;
+        let mut cmd = Command::new(interpreter);
+        cmd.args(toolchain_flags())
+            .arg("-e")
+            .arg(prepare_snippet(code))
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped());
+        cmd.stdin(child_stdin());
+        run_with_timeout(&mut cmd)
+            .with_context(|| format!("failed to invoke {} -e", interpreter.display()))
+    }
+
     fn execute_script(&self, script: &Path, args: &[String]) -> Result<std::process::Output> {
         let interpreter = self.ensure_interpreter()?;
         let mut cmd = Command::new(interpreter);
-        cmd.arg(script)
+        cmd.args(toolchain_flags())
+            .arg(script)
             .args(args)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
         if let Some(dir) = script.parent() {
             cmd.current_dir(dir);
         }
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} with script {}",
                 interpreter.display(),
@@ -89,6 +105,10 @@ impl LanguageEngine for LuaEngine {
         self.interpreter.is_some()
     }
 
+    fn is_input_complete(&self, buffer: &str) -> bool {
+        !needs_more_input(buffer)
+    }
+
     fn validate(&self) -> Result<()> {
         let interpreter = self.ensure_interpreter()?;
         let mut cmd = Command::new(interpreter);
@@ -108,9 +128,29 @@ impl LanguageEngine for LuaEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.interpreter.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let (temp_dir, script_path) = match payload {
+            // `lua -e` has no way to pass script arguments, so snippets with
+            // arguments are written to a file instead.
+            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. }
+                if payload.args().is_empty() =>
+            {
+                let output = self.execute_chunk(code)?;
+                return Ok(ExecutionOutcome {
+                    language: self.id().to_string(),
+                    exit_code: output.status.code(),
+                    stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
+                    stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
+                    duration: start.elapsed(),
+                    compile_duration: None,
+                    compile_stderr: None,
+                });
+            }
             ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
                 let (dir, path) = self.write_temp_script(code)?;
                 (Some(dir), path)
@@ -128,9 +168,15 @@ impl LanguageEngine for LuaEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("print(\"Hello, world!\")\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let interpreter = self.ensure_interpreter()?.to_path_buf();
         let session = LuaSession::new(interpreter)?;
@@ -139,6 +185,9 @@ impl LanguageEngine for LuaEngine {
 }
 
 fn resolve_lua_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("lua") {
+        return Some(path);
+    }
     which::which("lua").ok()
 }
 
@@ -154,7 +203,9 @@ struct LuaSession {
 
 impl LuaSession {
     fn new(interpreter: PathBuf) -> Result<Self> {
-        let workspace = TempDir::new().context("failed to create Lua session workspace")?;
+        let workspace = temp_builder()
+            .tempdir()
+            .context("failed to create Lua session workspace")?;
         let session = Self {
             interpreter,
             workspace,
@@ -174,6 +225,13 @@ impl LuaSession {
         self.workspace.path().join(SESSION_MAIN_FILE)
     }
 
+    fn reset_state(&mut self) -> Result<()> {
+        self.statements.clear();
+        self.last_stdout.clear();
+        self.last_stderr.clear();
+        self.persist_source()
+    }
+
     fn persist_source(&self) -> Result<()> {
         let path = self.source_path();
         let mut source = String::new();
@@ -197,7 +255,7 @@ impl LuaSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Lua session",
                 self.interpreter.display()
@@ -275,13 +333,141 @@ fn has_assignment_operator(code: &str) -> bool {
     false
 }
 
+/// Print every value `code` evaluates to, tab-separated like the `lua`
+/// prompt does, and nothing for a call that returns no values. Uses `select`
+/// rather than `table.pack` so Lua 5.1 and LuaJIT work too, and opens with
+/// `do` so it never reads as a call on the previous statement.
 fn wrap_expression_snippet(code: &str) -> String {
-    let trimmed = code.trim();
     format!(
-        "do\n    local __run_pack = table.pack(({expr}))\n    local __run_n = __run_pack.n or #__run_pack\n    if __run_n > 0 then\n        for __run_i = 1, __run_n do\n            if __run_i > 1 then io.write(\"\\t\") end\n            local __run_val = __run_pack[__run_i]\n            if __run_val == nil then\n                io.write(\"nil\")\n            else\n                io.write(tostring(__run_val))\n            end\n        end\n        io.write(\"\\n\")\n    end\nend\n",
-        expr = trimmed
+        "do local function __run_show(...) if select('#', ...) > 0 then print(...) end end __run_show({}) end\n",
+        code.trim()
     )
 }
+
+/// One-shot snippets that are a single expression (`10 + 5`) get printed;
+/// anything else runs as written.
+fn prepare_snippet(code: &str) -> String {
+    if looks_like_expression_snippet(code.trim()) {
+        wrap_expression_snippet(code)
+    } else {
+        code.to_string()
+    }
+}
+
+/// REPL completeness: more input is needed while a string, long bracket or
+/// comment is open, while brackets are unbalanced, while a
+/// `function`/`do`/`if`/`repeat` block lacks its `end`/`until`, or while the
+/// last line ends in an operator.
+fn needs_more_input(code: &str) -> bool {
+    let Some(cleaned) = strip_strings_and_comments(code) else {
+        return true;
+    };
+
+    let mut brackets = 0i32;
+    let mut blocks = 0i32;
+    let mut word = String::new();
+    for ch in cleaned.chars().chain(std::iter::once(' ')) {
+        if ch.is_alphanumeric() || ch == '_' {
+            word.push(ch);
+            continue;
+        }
+        match word.as_str() {
+            "function" | "do" | "if" | "repeat" => blocks += 1,
+            "end" | "until" => blocks -= 1,
+            _ => {}
+        }
+        word.clear();
+        match ch {
+            '(' | '[' | '{' => brackets += 1,
+            ')' | ']' | '}' => brackets -= 1,
+            _ => {}
+        }
+    }
+
+    brackets > 0
+        || blocks > 0
+        || line_looks_incomplete(&cleaned)
+        || ends_with_operator_word(&cleaned)
+}
+
+fn ends_with_operator_word(code: &str) -> bool {
+    code.split_whitespace()
+        .last()
+        .is_some_and(|token| matches!(token, "and" | "or" | "not" | "local" | "then" | "else"))
+}
+
+/// Blank out strings, long brackets (`[[...]]`, `[==[...]==]`) and comments
+/// so keyword and bracket counting only sees code. `None` while one of them
+/// is still open at the end of `code`.
+fn strip_strings_and_comments(code: &str) -> Option<String> {
+    let chars: Vec<char> = code.chars().collect();
+    let mut out = String::with_capacity(code.len());
+    let mut i = 0;
+    while i < chars.len() {
+        let ch = chars[i];
+        if ch == '-' && chars.get(i + 1) == Some(&'-') {
+            i += 2;
+            if let Some(level) = long_bracket_level(&chars, i) {
+                i = skip_long_bracket(&chars, i, level)?;
+            } else {
+                while i < chars.len() && chars[i] != '\n' {
+                    i += 1;
+                }
+            }
+            continue;
+        }
+        if let Some(level) = long_bracket_level(&chars, i) {
+            i = skip_long_bracket(&chars, i, level)?;
+            out.push_str("\"\"");
+            continue;
+        }
+        if ch == '"' || ch == '\'' {
+            i += 1;
+            loop {
+                match chars.get(i) {
+                    None | Some('\n') => return None,
+                    Some('\\') => i += 2,
+                    Some(&c) if c == ch => break,
+                    Some(_) => i += 1,
+                }
+            }
+            out.push_str("\"\"");
+            i += 1;
+            continue;
+        }
+        out.push(ch);
+        i += 1;
+    }
+    Some(out)
+}
+
+/// Level of a long bracket opening at `start` (`[[` is 0, `[==[` is 2).
+fn long_bracket_level(chars: &[char], start: usize) -> Option<usize> {
+    if chars.get(start) != Some(&'[') {
+        return None;
+    }
+    let mut level = 0;
+    while chars.get(start + 1 + level) == Some(&'=') {
+        level += 1;
+    }
+    (chars.get(start + 1 + level) == Some(&'[')).then_some(level)
+}
+
+/// Index just past the long bracket opened at `start`, or `None` if it is not
+/// closed.
+fn skip_long_bracket(chars: &[char], start: usize, level: usize) -> Option<usize> {
+    let mut i = start + level + 2;
+    while i < chars.len() {
+        if chars[i] == ']'
+            && (1..=level).all(|offset| chars.get(i + offset) == Some(&'='))
+            && chars.get(i + level + 1) == Some(&']')
+        {
+            return Some(i + level + 2);
+        }
+        i += 1;
+    }
+    None
+}
 impl LanguageSession for LuaSession {
     fn language_id(&self) -> &str {
         self.language_id()
@@ -291,16 +477,15 @@ impl LanguageSession for LuaSession {
         let trimmed = code.trim();
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.statements.clear();
-            self.last_stdout.clear();
-            self.last_stderr.clear();
-            self.persist_source()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: self.language_id().to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -313,6 +498,8 @@ impl LanguageSession for LuaSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -323,6 +510,8 @@ impl LanguageSession for LuaSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -369,6 +558,8 @@ impl LanguageSession for LuaSession {
                 stdout,
                 stderr,
                 duration,
+                compile_duration: None,
+                compile_stderr: None,
             })
         } else {
             self.statements.pop();
@@ -381,10 +572,16 @@ impl LanguageSession for LuaSession {
                 stdout,
                 stderr,
                 duration,
+                compile_duration: None,
+                compile_stderr: None,
             })
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -392,7 +589,10 @@ impl LanguageSession for LuaSession {
 
 #[cfg(test)]
 mod tests {
-    use super::{LuaSession, looks_like_expression_snippet, wrap_expression_snippet};
+    use super::{
+        LuaSession, looks_like_expression_snippet, needs_more_input, prepare_snippet,
+        wrap_expression_snippet,
+    };
 
     #[test]
     fn diff_outputs_appends_only_suffix() {
@@ -416,7 +616,40 @@ mod tests {
     #[test]
     fn wraps_expression_with_print_block() {
         let wrapped = wrap_expression_snippet("a");
-        assert!(wrapped.contains("table.pack((a))"));
-        assert!(wrapped.contains("io.write(\"\\n\")"));
+        assert!(wrapped.contains("print(...)"));
+        assert!(wrapped.starts_with("do "));
+        assert!(wrapped.ends_with("__run_show(a) end\n"));
+    }
+
+    #[test]
+    fn one_shot_expressions_are_printed() {
+        assert!(prepare_snippet("10 + 5").contains("__run_show(10 + 5)"));
+        assert_eq!(prepare_snippet("local x = 1"), "local x = 1");
+        assert_eq!(
+            prepare_snippet("for i = 1, 2 do print(i) end"),
+            "for i = 1, 2 do print(i) end"
+        );
+    }
+
+    #[test]
+    fn blocks_buffer_until_closed() {
+        assert!(needs_more_input("function add(a, b)\n"));
+        assert!(needs_more_input("function add(a, b)\n  return a + b\n"));
+        assert!(!needs_more_input(
+            "function add(a, b)\n  return a + b\nend\n"
+        ));
+        assert!(needs_more_input(
+            "if x > 1 then\n  print(x)\nelseif x < 0 then\n"
+        ));
+        assert!(!needs_more_input(
+            "if x > 1 then print(x) elseif x < 0 then print(-x) end\n"
+        ));
+        assert!(needs_more_input("while true do\n"));
+        assert!(!needs_more_input("for i = 1, 3 do print(i) end\n"));
+        assert!(needs_more_input("repeat\n  i = i + 1\n"));
+        assert!(!needs_more_input("repeat i = i + 1 until i > 3\n"));
+        assert!(!needs_more_input("print(\"end of do\") -- if then\n"));
+        assert!(needs_more_input("s = [[long\n"));
+        assert!(needs_more_input("x = 1 and\n"));
     }
 }
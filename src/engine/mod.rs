@@ -20,16 +20,19 @@ mod python;
 mod r;
 mod ruby;
 mod rust;
+mod sql;
 mod swift;
 mod typescript;
 mod zig;
 
 use std::borrow::Cow;
+use std::cell::RefCell;
 use std::collections::HashMap;
+use std::io::Write;
 use std::path::{Path, PathBuf};
 use std::process::{Child, Command, Output, Stdio};
-use std::sync::atomic::{AtomicBool, Ordering};
-use std::sync::{LazyLock, Mutex, OnceLock};
+use std::sync::atomic::{AtomicBool, AtomicI32, AtomicU8, Ordering};
+use std::sync::{Arc, LazyLock, Mutex, OnceLock};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result, bail};
@@ -45,6 +48,16 @@ static COMPILE_CACHE: LazyLock<Mutex<CompileCache>> =
     LazyLock::new(|| Mutex::new(CompileCache::new()));
 static SCCACHE_INIT: OnceLock<()> = OnceLock::new();
 static SCCACHE_READY: AtomicBool = AtomicBool::new(false);
+static STDIN_PASSTHROUGH: AtomicBool = AtomicBool::new(true);
+static INTERRUPTED: AtomicBool = AtomicBool::new(false);
+static INTERRUPT_SIGNAL: AtomicI32 = AtomicI32::new(0);
+static TERMINATED: AtomicBool = AtomicBool::new(false);
+static INTERRUPT_MODE: AtomicU8 = AtomicU8::new(InterruptMode::Exit as u8);
+#[cfg(unix)]
+static SIGNAL_PIPE: AtomicI32 = AtomicI32::new(-1);
+static TEMP_ROOT: Mutex<Option<PathBuf>> = Mutex::new(None);
+#[cfg(unix)]
+static TERMINAL_MODE: OnceLock<Option<libc::termios>> = OnceLock::new();
 static PERF_COUNTERS: LazyLock<Mutex<HashMap<String, u64>>> =
     LazyLock::new(|| Mutex::new(HashMap::new()));
 
@@ -55,7 +68,7 @@ struct CompileCache {
 
 impl CompileCache {
     fn new() -> Self {
-        let dir = std::env::temp_dir().join("run-compile-cache");
+        let dir = compile_cache_dir();
         let _ = std::fs::create_dir_all(&dir);
         Self {
             dir,
@@ -76,6 +89,423 @@ impl CompileCache {
     }
 }
 
+/// Directory holding cached compiled programs: `$XDG_CACHE_HOME/run/compile`,
+/// falling back to `~/.cache/run/compile` and then the system temp directory.
+pub fn compile_cache_dir() -> PathBuf {
+    let base = std::env::var_os("XDG_CACHE_HOME")
+        .filter(|dir| !dir.is_empty())
+        .map(PathBuf::from)
+        .or_else(|| {
+            std::env::var_os(if cfg!(windows) {
+                "LOCALAPPDATA"
+            } else {
+                "HOME"
+            })
+            .filter(|dir| !dir.is_empty())
+            .map(|home| {
+                if cfg!(windows) {
+                    PathBuf::from(home)
+                } else {
+                    PathBuf::from(home).join(".cache")
+                }
+            })
+        });
+    match base {
+        Some(base) => base.join("run").join("compile"),
+        None => std::env::temp_dir().join("run-compile-cache"),
+    }
+}
+
+/// Whether compiled programs may be reused. `--no-cache` (RUN_NO_CACHE=1)
+/// turns this off so every run compiles from scratch.
+pub fn compile_cache_enabled() -> bool {
+    !std::env::var("RUN_NO_CACHE").is_ok_and(|v| v == "1" || v == "true")
+}
+
+/// Whether the sources, build directories and binaries written for a run
+/// are left on disk afterwards. `--keep-temp` (RUN_KEEP_TEMP=1) turns this
+/// on for debugging compile failures.
+pub fn keep_temp() -> bool {
+    std::env::var("RUN_KEEP_TEMP").is_ok_and(|v| v == "1" || v == "true")
+}
+
+/// `tempfile::Builder` for an engine's scratch files. What it creates is
+/// removed when dropped, unless [`keep_temp`] is set.
+pub fn temp_builder<'a, 'b>() -> tempfile::Builder<'a, 'b> {
+    let mut builder = tempfile::Builder::new();
+    builder.disable_cleanup(keep_temp());
+    builder
+}
+
+/// The directory of one `run` invocation, `run-<pid>-XXXXXX` under the OS
+/// temp dir. Once installed it is `tempfile`'s default location, so every
+/// scratch directory the engines make lands inside it. Two runs started at
+/// the same time never share a directory. Whatever a killed or panicking
+/// run leaves behind is removed together with it when it is dropped, or
+/// by [`install_interrupt_handler`]'s fallback when a signal stops `run`
+/// before that. With [`keep_temp`] it is kept instead and its contents are
+/// listed on stderr.
+#[derive(Debug)]
+pub struct RunTempRoot {
+    path: PathBuf,
+}
+
+impl RunTempRoot {
+    /// Create the directory and redirect `tempfile` into it. Only one root
+    /// can be installed per process.
+    pub fn install() -> Result<Self> {
+        let path = tempfile::Builder::new()
+            .prefix(&format!("run-{}-", std::process::id()))
+            .tempdir()
+            .context("failed to create the temporary directory for this run")?
+            .keep();
+        if let Err(current) = tempfile::env::override_temp_dir(&path) {
+            let _ = std::fs::remove_dir(&path);
+            bail!(
+                "temporary files already go to {}; only one run directory per process",
+                current.display()
+            );
+        }
+        if let Ok(mut root) = TEMP_ROOT.lock() {
+            *root = Some(path.clone());
+        }
+        Ok(Self { path })
+    }
+
+    pub fn path(&self) -> &Path {
+        &self.path
+    }
+}
+
+impl Drop for RunTempRoot {
+    fn drop(&mut self) {
+        remove_temp_root();
+    }
+}
+
+/// Remove the installed [`RunTempRoot`], or list it with [`keep_temp`]. Only
+/// the first call does anything, so the drop and a signal arriving at the
+/// same time cannot both clean up.
+fn remove_temp_root() {
+    let Ok(mut root) = TEMP_ROOT.lock() else {
+        return;
+    };
+    let Some(dir) = root.take() else {
+        return;
+    };
+    if !keep_temp() {
+        let _ = std::fs::remove_dir_all(&dir);
+        return;
+    }
+    let mut kept: Vec<PathBuf> = std::fs::read_dir(&dir)
+        .map(|entries| entries.flatten().map(|entry| entry.path()).collect())
+        .unwrap_or_default();
+    if kept.is_empty() {
+        let _ = std::fs::remove_dir(&dir);
+        return;
+    }
+    kept.sort();
+    eprintln!("[keep-temp] kept {}", dir.display());
+    for path in kept {
+        eprintln!("  {}", path.display());
+    }
+}
+
+/// Environment variable that overrides the executable an engine runs, e.g.
+/// `RUN_BINARY_PYTHON`. `--interpreter` and the `[binaries]` table in
+/// `run.toml` set it.
+pub fn binary_override_key(language: &str) -> String {
+    format!(
+        "RUN_BINARY_{}",
+        canonical_language_id(language).to_ascii_uppercase()
+    )
+}
+
+/// Executable configured for `language` (its interpreter, or the compiler for
+/// compiled languages), looked up on `PATH` when it is a bare name. Engines
+/// consult this before their own lookup.
+pub fn binary_override(language: &str) -> Option<PathBuf> {
+    let value = std::env::var_os(binary_override_key(language)).filter(|v| !v.is_empty())?;
+    Some(which::which(&value).unwrap_or_else(|_| PathBuf::from(value)))
+}
+
+/// Fails if an override for `language` names an executable that does not
+/// exist, so the user sees that instead of a spawn error from the engine.
+pub fn ensure_binary_override(language: &str) -> Result<()> {
+    let key = binary_override_key(language);
+    let Some(value) = std::env::var_os(&key).filter(|v| !v.is_empty()) else {
+        return Ok(());
+    };
+    if which::which(&value).is_err() {
+        bail!(
+            "interpreter override '{}' not found: it is neither an executable path nor on PATH (from --interpreter, {key} or [binaries] in run.toml)",
+            Path::new(&value).display()
+        );
+    }
+    Ok(())
+}
+
+/// Exit code for "the language's toolchain is not installed", kept apart from
+/// a program's own failure so scripts can tell the two apart. Same as a shell's
+/// "command not found".
+pub const TOOLCHAIN_MISSING_EXIT_CODE: i32 = 127;
+
+/// Language id, the binary its engine looks for (space-separated when it tries
+/// several, in order), and where to get it. Used for the message when the
+/// toolchain is missing.
+pub const TOOLCHAIN_HINTS: &[(&str, &str, &str)] = &[
+    ("python", "python3", "https://www.python.org/downloads/"),
+    ("bash", "bash", "https://www.gnu.org/software/bash/"),
+    ("javascript", "node", "https://nodejs.org/"),
+    ("ruby", "ruby", "https://www.ruby-lang.org/en/downloads/"),
+    ("rust", "rustc", "https://rustup.rs/"),
+    ("go", "go", "https://go.dev/dl/"),
+    ("csharp", "dotnet", "https://dotnet.microsoft.com/download"),
+    ("typescript", "bun deno ts-node", "https://bun.sh/"),
+    ("lua", "lua", "https://www.lua.org/download.html"),
+    ("java", "javac", "https://adoptium.net/"),
+    (
+        "groovy",
+        "groovy",
+        "https://groovy.apache.org/download.html",
+    ),
+    ("php", "php", "https://www.php.net/downloads"),
+    (
+        "kotlin",
+        "kotlinc",
+        "https://kotlinlang.org/docs/command-line.html",
+    ),
+    ("c", "cc", "https://gcc.gnu.org/install/"),
+    ("cpp", "c++", "https://gcc.gnu.org/install/"),
+    ("r", "Rscript", "https://cran.r-project.org/"),
+    ("dart", "dart", "https://dart.dev/get-dart"),
+    ("swift", "swift", "https://www.swift.org/install/"),
+    ("perl", "perl", "https://www.perl.org/get.html"),
+    ("julia", "julia", "https://julialang.org/downloads/"),
+    ("haskell", "runghc", "https://www.haskell.org/ghcup/"),
+    ("elixir", "elixir", "https://elixir-lang.org/install.html"),
+    ("crystal", "crystal", "https://crystal-lang.org/install/"),
+    ("zig", "zig", "https://ziglang.org/download/"),
+    ("nim", "nim", "https://nim-lang.org/install.html"),
+    ("sql", "sqlite3", "https://sqlite.org/download.html"),
+];
+
+/// The toolchain for a language is not installed (or does not run). Returned
+/// by [`preflight`]; the CLI exits with [`TOOLCHAIN_MISSING_EXIT_CODE`].
+#[derive(Debug)]
+pub struct ToolchainMissing {
+    pub language: String,
+    message: String,
+}
+
+impl std::fmt::Display for ToolchainMissing {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&self.message)
+    }
+}
+
+impl std::error::Error for ToolchainMissing {}
+
+/// Check that `engine` can run before handing it any code: a binary override
+/// must exist and the engine's own `validate` must pass.
+pub fn preflight(engine: &dyn LanguageEngine) -> std::result::Result<(), ToolchainMissing> {
+    let name = engine.display_name();
+    let hint = TOOLCHAIN_HINTS.iter().find(|(id, _, _)| *id == engine.id());
+    let missing = |message: String| ToolchainMissing {
+        language: engine.id().to_string(),
+        message,
+    };
+    // An override that does not exist needs fixing, not installing.
+    ensure_binary_override(engine.id()).map_err(|err| missing(format!("{err:#}")))?;
+    let Err(err) = engine.validate() else {
+        return Ok(());
+    };
+    let mut message = match engine.binary_path().filter(|path| path.is_file()) {
+        Some(path) => format!(
+            "{name} toolchain at {} does not work: {err:#}",
+            path.display()
+        ),
+        None => {
+            let binary = hint.map_or(engine.id(), |(_, binary, _)| *binary);
+            if binary.contains(' ') {
+                let searched: Vec<String> = binary.split(' ').map(|b| format!("`{b}`")).collect();
+                format!(
+                    "no {name} runtime found: looked for {} on PATH",
+                    searched.join(", ")
+                )
+            } else {
+                format!("{name} is not installed: `{binary}` was not found on PATH")
+            }
+        }
+    };
+    let key = binary_override_key(engine.id());
+    match hint {
+        Some((_, _, url)) => message.push_str(&format!(
+            "\nInstall it from {url}, or point run at an existing binary with --interpreter or {key}."
+        )),
+        None => message.push_str(&format!(
+            "\nPoint run at an existing binary with --interpreter or {key}."
+        )),
+    }
+    Err(missing(message))
+}
+
+/// Cache key for a compiled program: language, compiler version, source and
+/// any `--flags`, so upgrading or switching the toolchain never reuses a stale
+/// binary. `version_arg` is the compiler's version flag (`--version`,
+/// `version`).
+pub fn compile_cache_key(language: &str, compiler: &Path, version_arg: &str, source: &str) -> u64 {
+    let fingerprint = toolchain_fingerprint(compiler, version_arg);
+    let mut key = format!("{language}\0{fingerprint}\0{source}");
+    for flag in toolchain_flags() {
+        key.push('\0');
+        key.push_str(&flag);
+    }
+    hash_source(&key)
+}
+
+/// [`compile_cache_key`] without the `--flags`, for what they do not change,
+/// such as Python's `--pip` virtualenvs.
+pub fn toolchain_cache_key(
+    language: &str,
+    compiler: &Path,
+    version_arg: &str,
+    source: &str,
+) -> u64 {
+    let fingerprint = toolchain_fingerprint(compiler, version_arg);
+    hash_source(&format!("{language}\0{fingerprint}\0{source}"))
+}
+
+static TOOLCHAIN_FINGERPRINTS: LazyLock<Mutex<HashMap<PathBuf, String>>> =
+    LazyLock::new(|| Mutex::new(HashMap::new()));
+
+/// The compiler's version output. Asking the compiler costs a process spawn
+/// (tens of ms behind rustup), so the answer is remembered on disk next to the
+/// cache, keyed by the binary's path, size and mtime.
+fn toolchain_fingerprint(compiler: &Path, version_arg: &str) -> String {
+    if let Some(known) = TOOLCHAIN_FINGERPRINTS
+        .lock()
+        .ok()
+        .and_then(|known| known.get(compiler).cloned())
+    {
+        return known;
+    }
+
+    let stamp_file = toolchain_stamp(compiler).map(|stamp| {
+        compile_cache_dir()
+            .join("toolchains")
+            .join(format!("{:016x}", hash_source(&stamp)))
+    });
+    let fingerprint = stamp_file
+        .as_ref()
+        .and_then(|file| std::fs::read_to_string(file).ok())
+        .unwrap_or_else(|| {
+            let version = Command::new(compiler)
+                .arg(version_arg)
+                .stdin(Stdio::null())
+                .output()
+                .map(|out| {
+                    let mut text = String::from_utf8_lossy(&out.stdout).into_owned();
+                    text.push_str(&String::from_utf8_lossy(&out.stderr));
+                    text.trim().to_string()
+                })
+                .unwrap_or_default();
+            if let Some(file) = &stamp_file
+                && !version.is_empty()
+                && let Some(dir) = file.parent()
+                && std::fs::create_dir_all(dir).is_ok()
+            {
+                let _ = std::fs::write(file, &version);
+            }
+            version
+        });
+
+    if let Ok(mut known) = TOOLCHAIN_FINGERPRINTS.lock() {
+        known.insert(compiler.to_path_buf(), fingerprint.clone());
+    }
+    fingerprint
+}
+
+/// Identity of the installed compiler binary. rustup proxies stay the same
+/// file across toolchain updates, so for them the active toolchain settings
+/// and the working directory (for `rust-toolchain.toml`) are included too.
+fn toolchain_stamp(compiler: &Path) -> Option<String> {
+    let resolved = std::fs::canonicalize(compiler).ok()?;
+    let mtime_of = |path: &Path| {
+        std::fs::metadata(path)
+            .and_then(|meta| meta.modified())
+            .ok()
+            .and_then(|time| time.duration_since(std::time::UNIX_EPOCH).ok())
+            .map(|since| since.as_nanos())
+            .unwrap_or(0)
+    };
+    let meta = std::fs::metadata(&resolved).ok()?;
+    let mut stamp = format!(
+        "{}|{}|{}",
+        resolved.display(),
+        meta.len(),
+        mtime_of(&resolved)
+    );
+
+    let is_rustup_proxy = resolved
+        .file_stem()
+        .is_some_and(|stem| stem.eq_ignore_ascii_case("rustup"));
+    if is_rustup_proxy {
+        let rustup_home = std::env::var_os("RUSTUP_HOME")
+            .map(PathBuf::from)
+            .or_else(|| std::env::var_os("HOME").map(|home| PathBuf::from(home).join(".rustup")))?;
+        stamp.push_str(&format!(
+            "|{}|{}|{}|{}",
+            std::env::var("RUSTUP_TOOLCHAIN").unwrap_or_default(),
+            mtime_of(&rustup_home.join("settings.toml")),
+            mtime_of(&rustup_home.join("toolchains")),
+            std::env::current_dir().unwrap_or_default().display()
+        ));
+    }
+    Some(stamp)
+}
+
+/// Remove every cached compiled program, including the per-file incremental
+/// build workspaces. Returns how many entries were removed.
+pub fn clear_compile_cache() -> Result<usize> {
+    let mut removed = 0;
+    let dir = compile_cache_dir();
+    if dir.exists() {
+        for entry in std::fs::read_dir(&dir)
+            .with_context(|| format!("failed to read cache directory {}", dir.display()))?
+            .flatten()
+        {
+            let path = entry.path();
+            let result = if path.is_dir() {
+                std::fs::remove_dir_all(&path)
+            } else {
+                std::fs::remove_file(&path)
+            };
+            result.with_context(|| format!("failed to remove {}", path.display()))?;
+            removed += 1;
+        }
+    }
+
+    if let Ok(entries) = std::fs::read_dir(std::env::temp_dir()) {
+        for entry in entries.flatten() {
+            let name = entry.file_name();
+            let name = name.to_string_lossy();
+            if name.starts_with("run-") && name.contains("-inc-") {
+                let _ = std::fs::remove_dir_all(entry.path());
+                removed += 1;
+            }
+        }
+    }
+
+    if let Ok(mut cache) = COMPILE_CACHE.lock() {
+        cache.entries.clear();
+    }
+    if let Ok(mut known) = TOOLCHAIN_FINGERPRINTS.lock() {
+        known.clear();
+    }
+    Ok(removed)
+}
+
 /// Hash source code for cache lookup.
 pub fn hash_source(source: &str) -> u64 {
     // Simple FNV-1a hash — fast and good enough for cache keys.
@@ -133,6 +563,9 @@ fn write_perf_file(map: &HashMap<String, u64>) {
 /// Look up a cached binary for the given language namespace + source hash.
 /// Returns Some(path) if a valid cached binary exists.
 pub fn cache_lookup(namespace: &str, source_hash: u64) -> Option<PathBuf> {
+    if !compile_cache_enabled() {
+        return None;
+    }
     let mut cache = COMPILE_CACHE.lock().ok()?;
     let id = cache_id(namespace, source_hash);
 
@@ -151,6 +584,9 @@ pub fn cache_lookup(namespace: &str, source_hash: u64) -> Option<PathBuf> {
 
 /// Store a compiled binary in the cache. Copies the binary to the cache directory.
 pub fn cache_store(namespace: &str, source_hash: u64, binary: &Path) -> Option<PathBuf> {
+    if !compile_cache_enabled() {
+        return None;
+    }
     let mut cache = COMPILE_CACHE.lock().ok()?;
     let cached_path = cache_path(cache.cache_dir(), namespace, source_hash);
     if std::fs::copy(binary, &cached_path).is_ok() {
@@ -167,14 +603,17 @@ pub fn cache_store(namespace: &str, source_hash: u64, binary: &Path) -> Option<P
     }
 }
 
-/// Execute a cached binary, returning the Output. Returns None if no cache entry.
-pub fn try_cached_execution(namespace: &str, source_hash: u64) -> Option<std::process::Output> {
+/// Execute a cached binary with `args`, returning the Output. Returns None if
+/// there is no cache entry.
+pub fn try_cached_execution(
+    namespace: &str,
+    source_hash: u64,
+    args: &[String],
+) -> Option<std::process::Output> {
     let cached = cache_lookup(namespace, source_hash)?;
     let mut cmd = std::process::Command::new(&cached);
-    cmd.stdout(std::process::Stdio::piped())
-        .stderr(std::process::Stdio::piped())
-        .stdin(std::process::Stdio::inherit());
-    cmd.output().ok()
+    cmd.args(args).stdin(child_stdin());
+    run_with_timeout(&mut cmd).ok()
 }
 
 /// Build a compiler command with optional daemon/cache wrappers.
@@ -277,45 +716,1087 @@ pub fn perf_reset() {
     let _ = std::fs::remove_file(perf_file_path());
 }
 
+/// Control whether programs read this process's stdin. Enabled for one-shot
+/// runs so `echo 1 2 | run ... --file sum.py` works; the REPL turns it off
+/// when its own input is piped so snippets cannot swallow the script.
+pub fn set_stdin_passthrough(enabled: bool) {
+    STDIN_PASSTHROUGH.store(enabled, Ordering::SeqCst);
+}
+
+/// What `run` does when SIGINT, SIGTERM or SIGHUP arrives, set by each mode
+/// with [`set_interrupt_mode`].
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+#[repr(u8)]
+pub enum InterruptMode {
+    /// Nothing checks [`interrupted`]: clean up and exit straight away.
+    Exit,
+    /// The running program is killed and the mode returns on its own once it
+    /// sees [`interrupted`].
+    Return,
+    /// As `Return`, except that Ctrl-C only stops the current entry; the
+    /// interactive REPL.
+    Resume,
+}
+
+pub fn set_interrupt_mode(mode: InterruptMode) {
+    INTERRUPT_MODE.store(mode as u8, Ordering::SeqCst);
+}
+
+#[cfg(unix)]
+fn interrupt_mode() -> InterruptMode {
+    match INTERRUPT_MODE.load(Ordering::SeqCst) {
+        1 => InterruptMode::Return,
+        2 => InterruptMode::Resume,
+        _ => InterruptMode::Exit,
+    }
+}
+
+/// How long a mode gets to return after a signal before `run` exits anyway,
+/// e.g. when it is blocked reading the terminal.
+#[cfg(unix)]
+const INTERRUPT_GRACE: Duration = Duration::from_secs(2);
+
+/// Catch SIGINT, SIGTERM and SIGHUP instead of dying on them, so the run
+/// directory and the terminal settings are put back. Programs run in their
+/// own process group and never see the terminal's SIGINT, so the running one
+/// is killed from [`wait_with_timeout`] and callers check [`interrupted`] to
+/// return normally. The handler does not restart system calls, which lets a
+/// blocking read of piped input notice too. Whatever has not returned after
+/// [`INTERRUPT_GRACE`], or runs in [`InterruptMode::Exit`], is ended from a
+/// watcher thread that cleans up and exits with 128 + the signal.
+pub fn install_interrupt_handler() {
+    #[cfg(unix)]
+    {
+        static INSTALLED: OnceLock<()> = OnceLock::new();
+        INSTALLED.get_or_init(|| {
+            let mut fds = [0; 2];
+            // SAFETY: pipe fills in the two descriptors it is given.
+            if unsafe { libc::pipe(fds.as_mut_ptr()) } != 0 {
+                return;
+            }
+            for fd in fds {
+                // SAFETY: `fd` was just returned by pipe.
+                unsafe {
+                    libc::fcntl(fd, libc::F_SETFD, libc::FD_CLOEXEC);
+                }
+            }
+            SIGNAL_PIPE.store(fds[1], Ordering::SeqCst);
+            let signals = [libc::SIGINT, libc::SIGTERM, libc::SIGHUP];
+            // SAFETY: the sigset and sigaction structs are plain data filled
+            // in by libc; the handler only stores to atomics and writes to a
+            // pipe, both async-signal-safe.
+            unsafe {
+                // The watcher starts with these signals blocked, so they
+                // interrupt the main thread's reads rather than its own.
+                let mut blocked: libc::sigset_t = std::mem::zeroed();
+                let mut previous: libc::sigset_t = std::mem::zeroed();
+                libc::sigemptyset(&mut blocked);
+                for signal in signals {
+                    libc::sigaddset(&mut blocked, signal);
+                }
+                libc::pthread_sigmask(libc::SIG_BLOCK, &blocked, &mut previous);
+                let reader = fds[0];
+                let _ = std::thread::Builder::new()
+                    .name("run-signals".to_string())
+                    .spawn(move || watch_signals(reader));
+                libc::pthread_sigmask(libc::SIG_SETMASK, &previous, std::ptr::null_mut());
+
+                for signal in signals {
+                    let mut action: libc::sigaction = std::mem::zeroed();
+                    action.sa_sigaction = on_signal as extern "C" fn(libc::c_int) as usize;
+                    libc::sigemptyset(&mut action.sa_mask);
+                    libc::sigaction(signal, &action, std::ptr::null_mut());
+                }
+            }
+        });
+    }
+}
+
+#[cfg(unix)]
+extern "C" fn on_signal(signal: libc::c_int) {
+    INTERRUPT_SIGNAL.store(signal, Ordering::SeqCst);
+    if signal != libc::SIGINT {
+        TERMINATED.store(true, Ordering::SeqCst);
+    }
+    INTERRUPTED.store(true, Ordering::SeqCst);
+    let byte = signal as u8;
+    // SAFETY: write is async-signal-safe and `byte` outlives the call.
+    unsafe {
+        libc::write(
+            SIGNAL_PIPE.load(Ordering::SeqCst),
+            (&byte as *const u8).cast(),
+            1,
+        );
+    }
+}
+
+#[cfg(unix)]
+fn watch_signals(reader: libc::c_int) {
+    loop {
+        let mut byte = 0u8;
+        // SAFETY: reads at most one byte into `byte`.
+        let read = unsafe { libc::read(reader, (&mut byte as *mut u8).cast(), 1) };
+        if read != 1 {
+            if read < 0 && std::io::Error::last_os_error().kind() == std::io::ErrorKind::Interrupted
+            {
+                continue;
+            }
+            return;
+        }
+        let signal = libc::c_int::from(byte);
+        match interrupt_mode() {
+            InterruptMode::Exit => {}
+            InterruptMode::Resume if signal == libc::SIGINT => continue,
+            InterruptMode::Return | InterruptMode::Resume => std::thread::sleep(INTERRUPT_GRACE),
+        }
+        remove_temp_root();
+        restore_terminal_mode();
+        std::process::exit(SIGNAL_EXIT_BASE + signal);
+    }
+}
+
+/// Whether SIGINT, SIGTERM or SIGHUP arrived since the last
+/// [`clear_interrupt`].
+pub fn interrupted() -> bool {
+    INTERRUPTED.load(Ordering::SeqCst)
+}
+
+/// Whether SIGTERM or SIGHUP arrived. Unlike Ctrl-C these always end `run`,
+/// even in the interactive REPL, and are never cleared.
+pub fn terminated() -> bool {
+    TERMINATED.load(Ordering::SeqCst)
+}
+
+/// Exit code after a signal stopped `run`: 128 + the last signal, 130 for
+/// Ctrl-C.
+pub fn interrupt_exit_code() -> i32 {
+    match INTERRUPT_SIGNAL.load(Ordering::SeqCst) {
+        0 => SIGNAL_EXIT_BASE + 2,
+        signal => SIGNAL_EXIT_BASE + signal,
+    }
+}
+
+pub fn clear_interrupt() {
+    INTERRUPTED.store(false, Ordering::SeqCst);
+}
+
+/// Remember the terminal settings `run` was started with, so they can be
+/// handed to programs that read it and put back after them.
+/// Call it before anything changes the terminal, such as the line editor;
+/// otherwise the settings are taken on the first handoff.
+pub fn save_terminal_mode() {
+    #[cfg(unix)]
+    TERMINAL_MODE.get_or_init(|| {
+        // SAFETY: tcgetattr only fills in the struct it is given.
+        unsafe {
+            let mut mode: libc::termios = std::mem::zeroed();
+            (libc::tcgetattr(libc::STDIN_FILENO, &mut mode) == 0).then_some(mode)
+        }
+    });
+}
+
+/// Give the terminal to a program that reads it and take it back when the
+/// guard is dropped, on every return path. The program starts with the
+/// settings `run` was started with (canonical input, echo and line editing
+/// on), and they are applied again afterwards: a program that is killed, or
+/// exits, while its password prompt has echo off or while it reads in raw
+/// mode would otherwise leave the REPL typing blind. Does nothing when the
+/// program does not read the terminal.
+struct TerminalHandoff {
+    active: bool,
+}
+
+impl TerminalHandoff {
+    fn begin() -> Self {
+        Self {
+            active: hand_over_terminal(),
+        }
+    }
+}
+
+impl Drop for TerminalHandoff {
+    fn drop(&mut self) {
+        if self.active {
+            restore_terminal_mode();
+        }
+    }
+}
+
+fn hand_over_terminal() -> bool {
+    let reads = reads_terminal();
+    if reads {
+        save_terminal_mode();
+        restore_terminal_mode();
+    }
+    reads
+}
+
+fn restore_terminal_mode() {
+    #[cfg(unix)]
+    if let Some(Some(mode)) = TERMINAL_MODE.get() {
+        // TCSADRAIN lets output the program wrote reach the screen first;
+        // typed-ahead input is kept for whoever reads next.
+        // SAFETY: `mode` came from tcgetattr on the same descriptor.
+        unsafe {
+            libc::tcsetattr(libc::STDIN_FILENO, libc::TCSADRAIN, mode);
+        }
+    }
+}
+
+/// Settings for a single run that apply to programs spawned on the current
+/// thread, installed with [`with_run_overrides`]. This is how the library API
+/// gives each request its own environment, stdin and timeout.
+#[derive(Debug, Clone, Default)]
+pub struct RunOverrides {
+    /// Extra environment variables for the program.
+    pub env: Vec<(String, String)>,
+    /// Start from an empty environment (keeping only `PATH`) instead of
+    /// inheriting this process's environment.
+    pub clean_env: bool,
+    /// File whose contents become the program's stdin.
+    pub stdin: Option<PathBuf>,
+    /// Replaces `RUN_TIMEOUT_SECS` for this run.
+    pub timeout: Option<Duration>,
+    /// Working directory for every command spawned for the run, replacing
+    /// whatever directory the engine picked.
+    pub cwd: Option<PathBuf>,
+    /// Resource limits for the program's processes (`--sandbox`).
+    pub sandbox: Option<SandboxLimits>,
+    /// Where the program's output is copied while it runs.
+    pub stream: Option<OutputStream>,
+    /// Record commands instead of running them (`--dry-run`).
+    pub dry_run: Option<CommandLog>,
+    /// Extra compiler or interpreter arguments (`--flags`); see
+    /// [`toolchain_flags`].
+    pub flags: Vec<String>,
+    /// Set by the caller to stop the run: running processes are killed and
+    /// no new ones are started.
+    pub cancel: Option<Arc<AtomicBool>>,
+}
+
+/// A command an engine would have run, as recorded by a dry run.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct LoggedCommand {
+    /// `compile` for build steps, `run` for the program itself.
+    pub stage: &'static str,
+    /// Shell-quoted command line, with its directory and environment
+    /// (`cd DIR && KEY=VALUE program args`), ready to paste.
+    pub command_line: String,
+    /// Source files the engine generated for the command (those under the
+    /// temp dir), read before the engine removes them.
+    pub sources: Vec<(PathBuf, String)>,
+}
+
+/// Commands recorded by a dry run, in order. Clones share the log.
+#[derive(Debug, Clone, Default)]
+pub struct CommandLog(Arc<Mutex<Vec<LoggedCommand>>>);
+
+impl CommandLog {
+    pub fn commands(&self) -> Vec<LoggedCommand> {
+        self.0.lock().map(|log| log.clone()).unwrap_or_default()
+    }
+
+    fn record(&self, stage: &'static str, cmd: &Command) {
+        let mut words: Vec<String> = cmd
+            .get_envs()
+            .filter_map(|(key, value)| {
+                let value = value?;
+                Some(format!(
+                    "{}={}",
+                    key.to_string_lossy(),
+                    shell_quote(&value.to_string_lossy())
+                ))
+            })
+            .collect();
+        words.push(shell_quote(&cmd.get_program().to_string_lossy()));
+        words.extend(
+            cmd.get_args()
+                .map(|arg| shell_quote(&arg.to_string_lossy())),
+        );
+        let mut command_line = words.join(" ");
+        if let Some(dir) = cmd.get_current_dir() {
+            let dir = shell_quote(&dir.to_string_lossy());
+            command_line = format!("cd {dir} && {command_line}");
+        }
+
+        let temp = std::env::temp_dir();
+        let sources = cmd
+            .get_args()
+            .map(Path::new)
+            .filter(|path| path.starts_with(&temp) && path.is_file())
+            .filter_map(|path| Some((path.to_path_buf(), std::fs::read_to_string(path).ok()?)))
+            .collect();
+        if let Ok(mut log) = self.0.lock() {
+            log.push(LoggedCommand {
+                stage,
+                command_line,
+                sources,
+            });
+        }
+    }
+}
+
+/// `word` as a POSIX shell word: as is when it only has characters no shell
+/// treats specially, otherwise single-quoted.
+fn shell_quote(word: &str) -> String {
+    let plain = |c: char| c.is_ascii_alphanumeric() || "_@%+=:,./-".contains(c);
+    if !word.is_empty() && word.chars().all(plain) {
+        word.to_string()
+    } else {
+        format!("'{}'", word.replace('\'', "'\\''"))
+    }
+}
+
+/// Message of the error [`spawn_program`] returns during a dry run, which
+/// ends the engine's run there.
+pub const DRY_RUN_STOP: &str = "dry run: program not started";
+
+/// Record `cmd` if this is a dry run. `true` means it must not be run.
+fn dry_run_records(stage: &'static str, cmd: &Command) -> bool {
+    match run_override(|o| o.dry_run.clone()) {
+        Some(log) => {
+            log.record(stage, cmd);
+            true
+        }
+        None => false,
+    }
+}
+
+/// Whether the run's caller has asked to stop it (see [`RunOverrides::cancel`]).
+fn cancelled() -> bool {
+    run_override(|o| o.cancel.as_ref().map(|flag| flag.load(Ordering::SeqCst))).unwrap_or(false)
+}
+
+/// The error a cancelled run gets instead of starting another process.
+fn cancelled_error() -> std::io::Error {
+    std::io::Error::new(std::io::ErrorKind::Interrupted, "run cancelled")
+}
+
+/// Run a compile (or other build) step of a one-shot run and collect its
+/// output. A dry run records it and reports success without running it.
+pub fn build_step_output(cmd: &mut Command) -> std::io::Result<Output> {
+    if cancelled() {
+        return Err(cancelled_error());
+    }
+    if dry_run_records("compile", cmd) {
+        return Ok(Output {
+            status: std::process::ExitStatus::default(),
+            stdout: Vec::new(),
+            stderr: Vec::new(),
+        });
+    }
+    cmd.output()
+}
+
+/// [`build_step_output`] for build steps that should be bound by the run's
+/// timeout, like [`run_with_timeout`]. The run's environment and sandbox
+/// limits are for the program only, so they are not applied here.
+pub fn build_step_with_timeout(cmd: &mut Command) -> std::io::Result<Output> {
+    if dry_run_records("compile", cmd) {
+        return Ok(Output {
+            status: std::process::ExitStatus::default(),
+            stdout: Vec::new(),
+            stderr: Vec::new(),
+        });
+    }
+    if cancelled() {
+        return Err(cancelled_error());
+    }
+    cmd.stdout(Stdio::piped()).stderr(Stdio::piped());
+    isolate_process_group(cmd);
+    let child = cmd.spawn()?;
+    collect_with_timeout(child, execution_timeout(), OutputStream::default())
+}
+
+/// Start the program of a one-shot run with the run's settings applied (see
+/// [`apply_run_env`]), for engines that feed it stdin or wait on it
+/// themselves. During a dry run nothing is started and the error says so.
+pub fn spawn_program(cmd: &mut Command) -> std::io::Result<Child> {
+    apply_run_env(cmd);
+    if dry_run_records("run", cmd) {
+        return Err(std::io::Error::other(DRY_RUN_STOP));
+    }
+    if cancelled() {
+        return Err(cancelled_error());
+    }
+    hand_over_terminal();
+    cmd.spawn()
+}
+
+struct Sink {
+    writer: Mutex<Box<dyn Write + Send>>,
+    used: AtomicBool,
+}
+
+type SharedWriter = Arc<Sink>;
+
+fn sink(writer: impl Write + Send + 'static) -> SharedWriter {
+    Arc::new(Sink {
+        writer: Mutex::new(Box::new(writer)),
+        used: AtomicBool::new(false),
+    })
+}
+
+/// Writers that receive a program's stdout and stderr as it produces them.
+/// The output is still captured in full, so the [`ExecutionOutcome`] of a
+/// streamed run is the same as that of a buffered one. Only the program's
+/// own processes are streamed; compiler output arrives with the outcome, in
+/// [`ExecutionOutcome::compile_stderr`].
+#[derive(Clone, Default)]
+pub struct OutputStream {
+    stdout: Option<SharedWriter>,
+    stderr: Option<SharedWriter>,
+}
+
+impl OutputStream {
+    /// Copy both streams to this process's stdout and stderr.
+    pub fn terminal() -> Self {
+        Self::default()
+            .stdout(std::io::stdout())
+            .stderr(std::io::stderr())
+    }
+
+    /// Copy the program's stdout to `writer`.
+    pub fn stdout(mut self, writer: impl Write + Send + 'static) -> Self {
+        self.stdout = Some(sink(writer));
+        self
+    }
+
+    /// Copy the program's stderr to `writer`.
+    pub fn stderr(mut self, writer: impl Write + Send + 'static) -> Self {
+        self.stderr = Some(sink(writer));
+        self
+    }
+
+    /// The same stream with stderr left buffered.
+    pub fn without_stderr(mut self) -> Self {
+        self.stderr = None;
+        self
+    }
+
+    /// Whether any stdout was copied to this stream (or a clone of it).
+    pub fn wrote_stdout(&self) -> bool {
+        self.stdout
+            .as_ref()
+            .is_some_and(|sink| sink.used.load(Ordering::SeqCst))
+    }
+
+    /// Whether any stderr was copied to this stream (or a clone of it).
+    pub fn wrote_stderr(&self) -> bool {
+        self.stderr
+            .as_ref()
+            .is_some_and(|sink| sink.used.load(Ordering::SeqCst))
+    }
+}
+
+impl std::fmt::Debug for OutputStream {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("OutputStream")
+            .field("stdout", &self.stdout.is_some())
+            .field("stderr", &self.stderr.is_some())
+            .finish()
+    }
+}
+
+/// Write `bytes` to a stream's writer. A writer that fails (a closed pipe)
+/// only stops the copy; the output is still captured.
+fn forward(sink: Option<&SharedWriter>, bytes: &[u8]) {
+    let Some(sink) = sink else { return };
+    sink.used.store(true, Ordering::SeqCst);
+    if let Ok(mut writer) = sink.writer.lock() {
+        writer.write_all(bytes).and_then(|()| writer.flush()).ok();
+    }
+}
+
+/// Resource limits `--sandbox` sets with `setrlimit` on each program process
+/// (not on compilers), inherited by everything the program starts. A
+/// best-effort guard against runaway snippets, not isolation: the program can
+/// still read and write whatever the user can. Only enforced on Linux. A
+/// limit of `0` leaves that resource alone.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct SandboxLimits {
+    /// `RLIMIT_CPU`: seconds of CPU time per process.
+    pub cpu_secs: u64,
+    /// `RLIMIT_AS`: bytes of address space per process.
+    pub memory_bytes: u64,
+    /// `RLIMIT_NOFILE`: open file descriptors per process.
+    pub open_files: u64,
+    /// `RLIMIT_NPROC`: processes and threads for the whole user, so a fork
+    /// bomb fails instead of filling the process table.
+    pub processes: u64,
+}
+
+impl Default for SandboxLimits {
+    fn default() -> Self {
+        Self {
+            cpu_secs: 30,
+            memory_bytes: 1024 * 1024 * 1024,
+            open_files: 256,
+            processes: 4096,
+        }
+    }
+}
+
+impl SandboxLimits {
+    /// Whether this platform enforces the limits.
+    pub const SUPPORTED: bool = cfg!(target_os = "linux");
+
+    /// The defaults, each overridable with `RUN_SANDBOX_CPU_SECS`,
+    /// `RUN_SANDBOX_MEMORY_MB`, `RUN_SANDBOX_FILES` or `RUN_SANDBOX_PROCS`.
+    pub fn from_env() -> Self {
+        let read = |key: &str| std::env::var(key).ok()?.trim().parse::<u64>().ok();
+        let defaults = Self::default();
+        Self {
+            cpu_secs: read("RUN_SANDBOX_CPU_SECS").unwrap_or(defaults.cpu_secs),
+            memory_bytes: read("RUN_SANDBOX_MEMORY_MB")
+                .map(|mb| mb.saturating_mul(1024 * 1024))
+                .unwrap_or(defaults.memory_bytes),
+            open_files: read("RUN_SANDBOX_FILES").unwrap_or(defaults.open_files),
+            processes: read("RUN_SANDBOX_PROCS").unwrap_or(defaults.processes),
+        }
+    }
+
+    #[cfg(target_os = "linux")]
+    fn apply(self, cmd: &mut Command) {
+        use std::os::unix::process::CommandExt;
+        // SAFETY: the hook runs between fork and exec and only calls
+        // getrlimit/setrlimit, which are async-signal-safe.
+        unsafe {
+            cmd.pre_exec(move || self.set_rlimits());
+        }
+    }
+
+    #[cfg(target_os = "linux")]
+    fn set_rlimits(&self) -> std::io::Result<()> {
+        let limits = [
+            (libc::RLIMIT_CPU, self.cpu_secs),
+            (libc::RLIMIT_AS, self.memory_bytes),
+            (libc::RLIMIT_NOFILE, self.open_files),
+            (libc::RLIMIT_NPROC, self.processes),
+        ];
+        for (resource, value) in limits {
+            if value == 0 {
+                continue;
+            }
+            let mut current = libc::rlimit {
+                rlim_cur: 0,
+                rlim_max: 0,
+            };
+            // SAFETY: `current` is a valid rlimit for getrlimit to fill in.
+            if unsafe { libc::getrlimit(resource, &mut current) } != 0 {
+                return Err(std::io::Error::last_os_error());
+            }
+            // Lowering the hard limit as well keeps the program from raising
+            // it back; a hard limit already below ours is kept. CPU gets one
+            // more second of hard limit so the program is stopped by SIGXCPU
+            // (exit 152) rather than an anonymous SIGKILL.
+            let soft = (value as libc::rlim_t).min(current.rlim_max);
+            let hard = match resource {
+                libc::RLIMIT_CPU => soft.saturating_add(1).min(current.rlim_max),
+                _ => soft,
+            };
+            let limit = libc::rlimit {
+                rlim_cur: soft,
+                rlim_max: hard,
+            };
+            // SAFETY: `limit` is a valid rlimit.
+            if unsafe { libc::setrlimit(resource, &limit) } != 0 {
+                return Err(std::io::Error::last_os_error());
+            }
+        }
+        Ok(())
+    }
+}
+
+thread_local! {
+    static RUN_OVERRIDES: RefCell<Option<RunOverrides>> = const { RefCell::new(None) };
+}
+
+/// Run `f` with `overrides` active on this thread, restoring the previous
+/// overrides afterwards (also when `f` panics).
+pub fn with_run_overrides<T>(overrides: RunOverrides, f: impl FnOnce() -> T) -> T {
+    struct Restore(Option<RunOverrides>);
+    impl Drop for Restore {
+        fn drop(&mut self) {
+            let previous = self.0.take();
+            RUN_OVERRIDES.with(|cell| *cell.borrow_mut() = previous);
+        }
+    }
+
+    let _restore = Restore(RUN_OVERRIDES.with(|cell| cell.replace(Some(overrides))));
+    f()
+}
+
+fn run_override<T>(get: impl FnOnce(&RunOverrides) -> Option<T>) -> Option<T> {
+    RUN_OVERRIDES.with(|cell| cell.borrow().as_ref().and_then(get))
+}
+
+/// Variables a clean environment still carries so programs can be found and,
+/// on Windows, started at all.
+const CLEAN_ENV_KEEP: &[&str] = if cfg!(windows) {
+    &["PATH", "SYSTEMROOT", "PATHEXT"]
+} else {
+    &["PATH"]
+};
+
+/// Apply the current run's environment settings (and working directory) to a
+/// program command. Engines call this last, so arguments must not be paths
+/// relative to a directory the engine set itself.
+pub fn apply_run_env(cmd: &mut Command) {
+    RUN_OVERRIDES.with(|cell| {
+        let Some(overrides) = cell.borrow().as_ref().cloned() else {
+            return;
+        };
+        if overrides.clean_env {
+            cmd.env_clear();
+            for key in CLEAN_ENV_KEEP {
+                if let Some(value) = std::env::var_os(key) {
+                    cmd.env(key, value);
+                }
+            }
+        }
+        cmd.envs(overrides.env.iter().map(|(k, v)| (k, v)));
+        if let Some(dir) = &overrides.cwd {
+            cmd.current_dir(dir);
+        }
+        #[cfg(target_os = "linux")]
+        if let Some(limits) = overrides.sandbox {
+            limits.apply(cmd);
+        }
+    });
+}
+
+/// Extra toolchain arguments for the current run (`--flags`). Each engine
+/// puts them where its toolchain expects options: in the compile command for
+/// compiled languages, before the script for interpreters. Sessions ignore
+/// them, except SQL's, which starts the shell again for every entry.
+pub fn toolchain_flags() -> Vec<String> {
+    run_override(|o| Some(o.flags.clone())).unwrap_or_default()
+}
+
+/// Split a `--flags` value into arguments the way a shell would (quotes and
+/// backslashes group words), rejecting unquoted `|`, `;`, `&`, `<`, `>`,
+/// `` ` `` and `$`: the toolchain is run directly, so pipes, redirections and
+/// substitutions would reach it as literal arguments.
+pub fn parse_toolchain_flags(raw: &str) -> Result<Vec<String>> {
+    let mut quote = None;
+    let mut escaped = false;
+    for ch in raw.chars() {
+        match (quote, ch) {
+            _ if escaped => escaped = false,
+            (Some('\''), '\'') | (Some('"'), '"') => quote = None,
+            (Some('\''), _) => {}
+            (_, '\\') => escaped = true,
+            (None, '\'' | '"') => quote = Some(ch),
+            (Some('"'), '$' | '`') | (None, '|' | ';' | '&' | '<' | '>' | '`' | '$') => bail!(
+                "--flags '{raw}' contains '{ch}', but flags are passed to the toolchain directly, not through a shell; quote it with '...' if it is meant literally"
+            ),
+            _ => {}
+        }
+    }
+    shell_words::split(raw).with_context(|| format!("could not split --flags '{raw}'"))
+}
+
+/// Parse a `KEY=VALUE` assignment as given to `--env` or found in an env file.
+pub fn parse_env_assignment(raw: &str) -> Result<(String, String)> {
+    let (key, value) = raw
+        .split_once('=')
+        .ok_or_else(|| anyhow::anyhow!("expected KEY=VALUE, got '{raw}'"))?;
+    let key = key.trim();
+    if key.is_empty() || key.contains(char::is_whitespace) {
+        bail!("invalid environment variable name in '{raw}'");
+    }
+    Ok((key.to_string(), value.to_string()))
+}
+
+/// Read a dotenv-style file: one `KEY=VALUE` per line, `#` comments, blank
+/// lines ignored, an optional `export ` prefix, and matching single or double
+/// quotes around the value stripped.
+pub fn load_env_file(path: &Path) -> Result<Vec<(String, String)>> {
+    let contents = std::fs::read_to_string(path)
+        .with_context(|| format!("failed to read env file {}", path.display()))?;
+    let mut vars = Vec::new();
+    for (index, line) in contents.lines().enumerate() {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with('#') {
+            continue;
+        }
+        let line = line.strip_prefix("export ").unwrap_or(line);
+        let (key, value) = parse_env_assignment(line)
+            .with_context(|| format!("{}:{}", path.display(), index + 1))?;
+        let value = value.trim();
+        let value = ['"', '\'']
+            .iter()
+            .find_map(|quote| {
+                value
+                    .strip_prefix(*quote)
+                    .and_then(|rest| rest.strip_suffix(*quote))
+            })
+            .unwrap_or(value);
+        vars.push((key, value.to_string()));
+    }
+    Ok(vars)
+}
+
+/// Stdin handle for a spawned program: the parent's stdin (EOF included) when
+/// passthrough is on, otherwise an empty stream. A run with its own stdin
+/// (see [`RunOverrides`]) reads that instead.
+pub fn child_stdin() -> Stdio {
+    if let Some(path) = run_override(|o| o.stdin.clone()) {
+        return std::fs::File::open(path)
+            .map(Stdio::from)
+            .unwrap_or_else(|_| Stdio::null());
+    }
+    if STDIN_PASSTHROUGH.load(Ordering::SeqCst) {
+        Stdio::inherit()
+    } else {
+        Stdio::null()
+    }
+}
+
 /// Default execution timeout: 60 seconds.
-/// Override with RUN_TIMEOUT_SECS env var.
+/// Override with RUN_TIMEOUT_SECS (plain seconds or a duration such as `500ms`).
 pub fn execution_timeout() -> Duration {
-    let secs = std::env::var("RUN_TIMEOUT_SECS")
+    if let Some(timeout) = run_override(|o| o.timeout) {
+        return timeout;
+    }
+    std::env::var("RUN_TIMEOUT_SECS")
         .ok()
-        .and_then(|v| v.parse::<u64>().ok())
-        .unwrap_or(60);
-    Duration::from_secs(secs)
+        .and_then(|v| parse_duration(&v).ok())
+        .unwrap_or(Duration::from_secs(60))
 }
 
-/// Wait for a child process with a timeout. Kills the process if it exceeds the limit.
-/// Returns the Output on success, or an error on timeout.
-pub fn wait_with_timeout(mut child: Child, timeout: Duration) -> Result<std::process::Output> {
-    let start = Instant::now();
-    let poll_interval = Duration::from_millis(50);
+/// Parse a duration like `5s`, `500ms`, `1.5m` or `2h`. A bare number is seconds.
+pub fn parse_duration(raw: &str) -> Result<Duration> {
+    let trimmed = raw.trim();
+    let split = trimmed
+        .find(|c: char| !(c.is_ascii_digit() || c == '.'))
+        .unwrap_or(trimmed.len());
+    let (number, unit) = trimmed.split_at(split);
+    let value: f64 = number
+        .parse()
+        .map_err(|_| anyhow::anyhow!("invalid duration '{raw}' (expected e.g. 5s, 500ms, 2m)"))?;
+    let secs = match unit.trim() {
+        "" | "s" | "sec" | "secs" => value,
+        "ms" => value / 1000.0,
+        "us" | "µs" => value / 1_000_000.0,
+        "m" | "min" => value * 60.0,
+        "h" => value * 3600.0,
+        other => bail!("invalid duration unit '{other}' in '{raw}' (use ms, s, m or h)"),
+    };
+    if !secs.is_finite() || secs <= 0.0 {
+        bail!("duration must be greater than zero: '{raw}'");
+    }
+    Duration::try_from_secs_f64(secs).map_err(|_| anyhow::anyhow!("duration '{raw}' is too large"))
+}
 
-    loop {
-        match child.try_wait() {
-            Ok(Some(_status)) => {
-                return child.wait_with_output().map_err(Into::into);
-            }
-            Ok(None) => {
-                if start.elapsed() > timeout {
-                    let _ = child.kill();
-                    let _ = child.wait(); // reap
-                    bail!(
-                        "Execution timed out after {:.1}s (limit: {}s). \
-                         Set RUN_TIMEOUT_SECS to increase.",
-                        start.elapsed().as_secs_f64(),
-                        timeout.as_secs()
-                    );
+/// Cap on the bytes a program may write to stdout and stderr together before
+/// it is killed, from RUN_MAX_OUTPUT (`--max-output`). Unset means unlimited.
+pub fn max_output() -> Option<usize> {
+    std::env::var("RUN_MAX_OUTPUT")
+        .ok()
+        .and_then(|v| parse_byte_size(&v).ok())
+}
+
+/// Parse a size like `4096`, `64k` or `1M` (binary multiples). A bare number
+/// is bytes.
+pub fn parse_byte_size(raw: &str) -> Result<usize> {
+    let trimmed = raw.trim();
+    let split = trimmed
+        .find(|c: char| !c.is_ascii_digit())
+        .unwrap_or(trimmed.len());
+    let (number, unit) = trimmed.split_at(split);
+    let value: usize = number
+        .parse()
+        .map_err(|_| anyhow::anyhow!("invalid size '{raw}' (expected e.g. 4096, 64k, 1M)"))?;
+    let multiplier: usize = match unit.trim().to_ascii_lowercase().as_str() {
+        "" | "b" => 1,
+        "k" | "kb" | "kib" => 1 << 10,
+        "m" | "mb" | "mib" => 1 << 20,
+        "g" | "gb" | "gib" => 1 << 30,
+        other => bail!("invalid size unit '{other}' in '{raw}' (use k, M or G)"),
+    };
+    let bytes = value
+        .checked_mul(multiplier)
+        .ok_or_else(|| anyhow::anyhow!("size '{raw}' is too large"))?;
+    if bytes == 0 {
+        bail!("size must be greater than zero: '{raw}'");
+    }
+    Ok(bytes)
+}
+
+/// Render a duration the way it is usually written on the command line.
+pub fn format_duration(duration: Duration) -> String {
+    let millis = duration.as_millis();
+    if millis == 0 {
+        format!("{}us", duration.as_micros())
+    } else if millis < 1000 {
+        format!("{millis}ms")
+    } else if millis.is_multiple_of(1000) {
+        format!("{}s", millis / 1000)
+    } else {
+        format!("{:.1}s", duration.as_secs_f64())
+    }
+}
+
+/// Put the program in its own process group so a timeout can kill everything
+/// it forked. Skipped when the child reads an inherited terminal, because a
+/// background group would be stopped by the kernel on its first read.
+pub fn isolate_process_group(cmd: &mut Command) {
+    #[cfg(unix)]
+    {
+        use std::os::unix::process::CommandExt;
+        if !reads_terminal() {
+            cmd.process_group(0);
+        }
+    }
+    #[cfg(not(unix))]
+    let _ = cmd;
+}
+
+/// Whether programs spawned now get this process's stdin and it is a terminal.
+fn reads_terminal() -> bool {
+    use std::io::IsTerminal;
+    STDIN_PASSTHROUGH.load(Ordering::SeqCst)
+        && run_override(|o| o.stdin.as_ref().map(|_| ())).is_none()
+        && std::io::stdin().is_terminal()
+}
+
+/// Drop-in replacement for `Command::output()` that honours the execution
+/// timeout and kills the whole process tree when it expires.
+pub fn run_with_timeout(cmd: &mut Command) -> std::io::Result<Output> {
+    cmd.stdout(Stdio::piped()).stderr(Stdio::piped());
+    isolate_process_group(cmd);
+    apply_run_env(cmd);
+    if dry_run_records("run", cmd) {
+        return Ok(Output {
+            status: std::process::ExitStatus::default(),
+            stdout: Vec::new(),
+            stderr: Vec::new(),
+        });
+    }
+    if cancelled() {
+        return Err(cancelled_error());
+    }
+    let _terminal = TerminalHandoff::begin();
+    let child = cmd.spawn()?;
+    collect_with_timeout(child, execution_timeout(), program_stream())
+}
+
+/// Wait for a child process with a timeout. On expiry the process tree is
+/// killed and the returned output carries exit code 124 and a
+/// "execution timed out" note on stderr, so callers treat it as a failed run.
+/// The same happens, with an "output truncated" note, once the program has
+/// written more than [`max_output`] bytes. Ctrl-C and a cancelled run (see
+/// [`RunOverrides::cancel`]) kill it without a note.
+pub fn wait_with_timeout(child: Child, timeout: Duration) -> Result<std::process::Output> {
+    // Already running, so only take the terminal back; setting it now could
+    // undo a mode the program has just chosen. `spawn_program` handed it over.
+    let _terminal = TerminalHandoff {
+        active: reads_terminal(),
+    };
+    collect_with_timeout(child, timeout, program_stream()).map_err(Into::into)
+}
+
+/// The run's output stream. Only program output goes there; build steps
+/// collect theirs without one.
+fn program_stream() -> OutputStream {
+    run_override(|o| o.stream.clone()).unwrap_or_default()
+}
+
+fn collect_with_timeout(
+    mut child: Child,
+    timeout: Duration,
+    stream: OutputStream,
+) -> std::io::Result<Output> {
+    use std::io::Read;
+    use std::sync::atomic::AtomicUsize;
+
+    let limit = max_output();
+    let written = Arc::new(AtomicUsize::new(0));
+
+    // Drain the pipes on threads so a chatty program cannot block on a full
+    // pipe while we are polling for its exit. Both pipes count against one
+    // output budget; past it, the rest is read and thrown away until the
+    // program has been killed. What is kept is also copied to the run's
+    // output stream, if it has one.
+    let drain = |pipe: Option<Box<dyn Read + Send>>, sink: Option<SharedWriter>| {
+        let written = Arc::clone(&written);
+        std::thread::spawn(move || {
+            let mut buf = Vec::new();
+            let Some(mut pipe) = pipe else {
+                return buf;
+            };
+            let mut chunk = [0u8; 8192];
+            loop {
+                let read = match pipe.read(&mut chunk) {
+                    Ok(0) => break,
+                    Ok(read) => read,
+                    Err(err) if err.kind() == std::io::ErrorKind::Interrupted => continue,
+                    Err(_) => break,
+                };
+                let before = written.fetch_add(read, Ordering::SeqCst);
+                let keep = match limit {
+                    Some(limit) => read.min(limit.saturating_sub(before)),
+                    None => read,
+                };
+                buf.extend_from_slice(&chunk[..keep]);
+                if keep > 0 {
+                    forward(sink.as_ref(), &chunk[..keep]);
                 }
-                std::thread::sleep(poll_interval);
             }
-            Err(e) => {
-                return Err(e.into());
+            buf
+        })
+    };
+    let stdout = drain(
+        child
+            .stdout
+            .take()
+            .map(|pipe| Box::new(pipe) as Box<dyn Read + Send>),
+        stream.stdout.clone(),
+    );
+    let stderr = drain(
+        child
+            .stderr
+            .take()
+            .map(|pipe| Box::new(pipe) as Box<dyn Read + Send>),
+        stream.stderr.clone(),
+    );
+    let over_limit = || limit.is_some_and(|limit| written.load(Ordering::SeqCst) > limit);
+
+    let start = Instant::now();
+    let poll_interval = Duration::from_millis(10);
+    let (status, timed_out) = loop {
+        if let Some(status) = child.try_wait()? {
+            break (status, false);
+        }
+        if over_limit() || interrupted() || cancelled() {
+            kill_process_tree(&mut child);
+            break (child.wait()?, false);
+        }
+        if start.elapsed() > timeout {
+            kill_process_tree(&mut child);
+            break (child.wait()?, true);
+        }
+        std::thread::sleep(poll_interval);
+    };
+
+    let stdout = stdout.join().unwrap_or_default();
+    let mut stderr = stderr.join().unwrap_or_default();
+    let notice = if timed_out {
+        format!(
+            "execution timed out after {} (raise it with --timeout)\n",
+            format_duration(timeout)
+        )
+    } else if let Some(limit) = limit.filter(|_| over_limit()) {
+        format!("[output truncated at {limit} bytes]\n")
+    } else {
+        return Ok(Output {
+            status: signal_as_exit_code(status),
+            stdout,
+            stderr,
+        });
+    };
+
+    let notice = if !stderr.is_empty() && !stderr.ends_with(b"\n") {
+        format!("\n{notice}")
+    } else {
+        notice
+    };
+    stderr.extend_from_slice(notice.as_bytes());
+    forward(stream.stderr.as_ref(), notice.as_bytes());
+    Ok(Output {
+        status: if timed_out {
+            timed_out_status()
+        } else {
+            signal_as_exit_code(status)
+        },
+        stdout,
+        stderr,
+    })
+}
+
+/// Exit code used for timed out runs, matching coreutils `timeout`.
+pub const TIMEOUT_EXIT_CODE: i32 = 124;
+
+/// Programs killed by signal N report exit code 128 + N, as shells do, so a
+/// crash or kill still has a code to propagate.
+pub const SIGNAL_EXIT_BASE: i32 = 128;
+
+fn signal_as_exit_code(status: std::process::ExitStatus) -> std::process::ExitStatus {
+    #[cfg(unix)]
+    {
+        use std::os::unix::process::ExitStatusExt;
+        match status.signal() {
+            Some(signal) => std::process::ExitStatus::from_raw((SIGNAL_EXIT_BASE + signal) << 8),
+            None => status,
+        }
+    }
+    #[cfg(not(unix))]
+    {
+        status
+    }
+}
+
+fn timed_out_status() -> std::process::ExitStatus {
+    #[cfg(unix)]
+    {
+        use std::os::unix::process::ExitStatusExt;
+        std::process::ExitStatus::from_raw(TIMEOUT_EXIT_CODE << 8)
+    }
+    #[cfg(windows)]
+    {
+        use std::os::windows::process::ExitStatusExt;
+        std::process::ExitStatus::from_raw(TIMEOUT_EXIT_CODE as u32)
+    }
+}
+
+fn kill_process_tree(child: &mut Child) {
+    #[cfg(unix)]
+    {
+        let pid = child.id() as libc::pid_t;
+        // SAFETY: plain syscalls on a pid we spawned and have not reaped yet.
+        unsafe {
+            if libc::getpgid(pid) == pid {
+                libc::kill(-pid, libc::SIGKILL);
+            } else {
+                for descendant in descendant_pids(pid) {
+                    libc::kill(descendant, libc::SIGKILL);
+                }
             }
         }
     }
+    let _ = child.kill();
+}
+
+/// Children of `root`, deepest first, as reported by `ps`.
+#[cfg(unix)]
+fn descendant_pids(root: libc::pid_t) -> Vec<libc::pid_t> {
+    let Ok(output) = Command::new("ps")
+        .args(["-A", "-o", "pid=", "-o", "ppid="])
+        .stdin(Stdio::null())
+        .stderr(Stdio::null())
+        .output()
+    else {
+        return Vec::new();
+    };
+    let pairs: Vec<(libc::pid_t, libc::pid_t)> = String::from_utf8_lossy(&output.stdout)
+        .lines()
+        .filter_map(|line| {
+            let mut parts = line.split_whitespace();
+            Some((parts.next()?.parse().ok()?, parts.next()?.parse().ok()?))
+        })
+        .collect();
+    let mut found = Vec::new();
+    let mut frontier = vec![root];
+    while let Some(parent) = frontier.pop() {
+        for (pid, _) in pairs.iter().filter(|(_, ppid)| *ppid == parent) {
+            found.push(*pid);
+            frontier.push(*pid);
+        }
+    }
+    found.reverse();
+    found
 }
 
 pub use bash::BashEngine;
@@ -336,10 +1817,12 @@ pub use lua::LuaEngine;
 pub use nim::NimEngine;
 pub use perl::PerlEngine;
 pub use php::PhpEngine;
-pub use python::PythonEngine;
+pub(crate) use python::is_python_block_header;
+pub use python::{PythonEngine, pip_packages};
 pub use r::REngine;
 pub use ruby::RubyEngine;
 pub use rust::RustEngine;
+pub use sql::SqlEngine;
 pub use swift::SwiftEngine;
 pub use typescript::TypeScriptEngine;
 pub use zig::ZigEngine;
@@ -347,6 +1830,20 @@ pub use zig::ZigEngine;
 pub trait LanguageSession {
     fn language_id(&self) -> &str;
     fn eval(&mut self, code: &str) -> Result<ExecutionOutcome>;
+    /// Forget everything earlier entries defined (REPL `:reset`) and keep the
+    /// session usable. The default does nothing, for sessions that carry no
+    /// state between entries. An error tells the caller to start a fresh
+    /// session instead.
+    fn reset(&mut self) -> Result<()> {
+        Ok(())
+    }
+    /// Whether the session can take another entry. Sessions backed by a
+    /// long-lived interpreter return false once it has exited (after Ctrl-C,
+    /// say), so the REPL starts a new one; the default suits sessions that
+    /// spawn a fresh process per entry.
+    fn is_alive(&mut self) -> bool {
+        true
+    }
     fn shutdown(&mut self) -> Result<()>;
 }
 
@@ -361,16 +1858,205 @@ pub trait LanguageEngine {
     fn supports_sessions(&self) -> bool {
         false
     }
+    /// File extensions (without the dot) that select this engine, from
+    /// [`EXTENSION_LANGUAGES`].
+    fn extensions(&self) -> Vec<&'static str> {
+        EXTENSION_LANGUAGES
+            .iter()
+            .filter(|(_, lang)| *lang == self.id())
+            .map(|(ext, _)| *ext)
+            .collect()
+    }
     fn validate(&self) -> Result<()> {
         Ok(())
     }
+    /// Whether the toolchain is installed; [`LanguageEngine::validate`] says
+    /// what is missing when it is not.
+    fn available(&self) -> bool {
+        self.validate().is_ok()
+    }
     fn toolchain_version(&self) -> Result<Option<String>> {
         Ok(None)
     }
+    /// Executable the engine runs: the interpreter, or the compiler for
+    /// compiled languages. `None` when it was not found.
+    fn binary_path(&self) -> Option<PathBuf> {
+        None
+    }
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome>;
+    /// How the source generated for an inline or stdin snippet lines up with
+    /// the snippet. `None` (the default) when snippets run as written.
+    fn snippet_lines(&self, _payload: &ExecutionPayload) -> Option<SnippetLines> {
+        None
+    }
+    /// A hello-world program that runs as a file, for `run init`. `name` is
+    /// the stem of the file it will be saved as, for languages where a type
+    /// has to match it. `None` (the default) when the engine has none.
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        None
+    }
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         bail!("{} does not support interactive sessions yet", self.id())
     }
+    /// Whether a buffered REPL entry is ready to evaluate. Returning false makes
+    /// the REPL keep reading continuation lines.
+    fn is_input_complete(&self, buffer: &str) -> bool {
+        !has_unclosed_delimiters(buffer) && !line_looks_incomplete(buffer)
+    }
+}
+
+/// Heuristic shared by most engines: true while the last non-empty line ends
+/// with a continuation token (binary operator, `\\`, `=>`, ...) or a keyword
+/// that needs an operand.
+pub fn line_looks_incomplete(code: &str) -> bool {
+    let mut last: Option<&str> = None;
+    for line in code.lines().rev() {
+        let trimmed = line.trim_end();
+        if trimmed.trim().is_empty() {
+            continue;
+        }
+        last = Some(trimmed);
+        break;
+    }
+    let Some(line) = last else { return false };
+    let line = line.trim();
+    if line.is_empty() {
+        return false;
+    }
+    if line.starts_with('#') {
+        return false;
+    }
+
+    if line.ends_with('\\') {
+        return true;
+    }
+
+    const TAILS: [&str; 24] = [
+        "=", "+", "-", "*", "/", "%", "&", "|", "^", "!", "<", ">", "&&", "||", "??", "?:", "?",
+        ":", ".", ",", "=>", "->", "::", "..",
+    ];
+    if TAILS.iter().any(|tok| line.ends_with(tok)) {
+        return true;
+    }
+
+    const PREFIXES: [&str; 9] = [
+        "return", "throw", "yield", "await", "import", "from", "export", "case", "else",
+    ];
+    let lowered = line.to_ascii_lowercase();
+    if PREFIXES
+        .iter()
+        .any(|kw| lowered == *kw || lowered.ends_with(&format!(" {kw}")))
+    {
+        return true;
+    }
+
+    false
+}
+
+/// True while `code` has unbalanced parens, brackets or braces, or an open
+/// block comment. String literals and line comments are skipped.
+pub fn has_unclosed_delimiters(code: &str) -> bool {
+    let mut paren = 0i32;
+    let mut bracket = 0i32;
+    let mut brace = 0i32;
+
+    let mut in_single = false;
+    let mut in_double = false;
+    let mut in_backtick = false;
+    let mut in_block_comment = false;
+    let mut escape = false;
+
+    let chars: Vec<char> = code.chars().collect();
+    let len = chars.len();
+    let mut i = 0;
+
+    while i < len {
+        let ch = chars[i];
+
+        if escape {
+            escape = false;
+            i += 1;
+            continue;
+        }
+
+        // Inside block comment /* ... */
+        if in_block_comment {
+            if ch == '*' && i + 1 < len && chars[i + 1] == '/' {
+                in_block_comment = false;
+                i += 2;
+                continue;
+            }
+            i += 1;
+            continue;
+        }
+
+        if in_single {
+            if ch == '\\' {
+                escape = true;
+            } else if ch == '\'' {
+                in_single = false;
+            }
+            i += 1;
+            continue;
+        }
+        if in_double {
+            if ch == '\\' {
+                escape = true;
+            } else if ch == '"' {
+                in_double = false;
+            }
+            i += 1;
+            continue;
+        }
+        if in_backtick {
+            if ch == '\\' {
+                escape = true;
+            } else if ch == '`' {
+                in_backtick = false;
+            }
+            i += 1;
+            continue;
+        }
+
+        // Check for line comments (// and #)
+        if ch == '/' && i + 1 < len && chars[i + 1] == '/' {
+            // Skip rest of line
+            while i < len && chars[i] != '\n' {
+                i += 1;
+            }
+            continue;
+        }
+        if ch == '#' {
+            // Python/Ruby/etc. line comment - skip rest of line
+            while i < len && chars[i] != '\n' {
+                i += 1;
+            }
+            continue;
+        }
+        // Check for block comments /* ... */
+        if ch == '/' && i + 1 < len && chars[i + 1] == '*' {
+            in_block_comment = true;
+            i += 2;
+            continue;
+        }
+
+        match ch {
+            '\'' => in_single = true,
+            '"' => in_double = true,
+            '`' => in_backtick = true,
+            '(' => paren += 1,
+            ')' => paren -= 1,
+            '[' => bracket += 1,
+            ']' => bracket -= 1,
+            '{' => brace += 1,
+            '}' => brace -= 1,
+            _ => {}
+        }
+
+        i += 1;
+    }
+
+    paren > 0 || bracket > 0 || brace > 0 || in_block_comment
 }
 
 pub(crate) fn version_line_from_output(output: &Output) -> Option<String> {
@@ -535,6 +2221,12 @@ pub struct ExecutionOutcome {
     pub stdout: String,
     pub stderr: String,
     pub duration: Duration,
+    /// Time spent compiling, when this run compiled anything. `None` for
+    /// interpreted languages and for binaries served from the compile cache.
+    pub compile_duration: Option<Duration>,
+    /// Compiler diagnostics when the build step failed, kept apart from the
+    /// program's own stderr.
+    pub compile_stderr: Option<String>,
 }
 
 impl ExecutionOutcome {
@@ -544,14 +2236,163 @@ impl ExecutionOutcome {
             None => self.stderr.trim().is_empty(),
         }
     }
+
+    /// Time spent running the program, i.e. `duration` minus the compile step.
+    pub fn run_duration(&self) -> Duration {
+        self.duration
+            .saturating_sub(self.compile_duration.unwrap_or_default())
+    }
+
+    /// Compiler diagnostics followed by the program's stderr, for display.
+    pub fn combined_stderr(&self) -> Cow<'_, str> {
+        match self.compile_stderr.as_deref() {
+            Some(compile) if !compile.is_empty() && !self.stderr.is_empty() => {
+                Cow::Owned(format!("{compile}{}", self.stderr))
+            }
+            Some(compile) if !compile.is_empty() => Cow::Borrowed(compile),
+            _ => Cow::Borrowed(&self.stderr),
+        }
+    }
+}
+
+/// Where a snippet's lines ended up in the source an engine generated for it
+/// (a synthesized `main`, hoisted imports), so compiler and runtime messages
+/// that cite that source can be pointed back at the lines the user wrote.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SnippetLines {
+    file_name: String,
+    /// Snippet line (1-based) for each generated line, `None` for wrapper code.
+    origins: Vec<Option<usize>>,
 }
 
+impl SnippetLines {
+    /// Match the lines of `generated`, written to `file_name`, against
+    /// `snippet` by content, so wrappers may indent, reorder or add `;`.
+    /// Lines without any word characters (`}`, blank lines) only match when
+    /// they directly follow a matched line. `None` when every snippet line
+    /// kept its number and there is nothing to rewrite.
+    pub fn new(file_name: &str, snippet: &str, generated: &str) -> Option<Self> {
+        fn key(line: &str) -> &str {
+            line.trim().trim_end_matches(';').trim_end()
+        }
+        let significant = |line: &str| line.chars().any(|c| c.is_alphanumeric() || c == '_');
+        let user: Vec<&str> = snippet.lines().map(key).collect();
+        let lines: Vec<&str> = generated.lines().map(key).collect();
+        let mut used = vec![false; user.len()];
+        let mut origins = vec![None; lines.len()];
+
+        // Prefer the next unused match after the previous one, so repeated
+        // lines pair up in order, then any unused match for reordered code.
+        let mut next = 0;
+        for (index, line) in lines.iter().enumerate() {
+            if !significant(line) {
+                continue;
+            }
+            let found = (next..user.len())
+                .chain(0..next.min(user.len()))
+                .find(|&i| !used[i] && user[i] == *line);
+            if let Some(i) = found {
+                used[i] = true;
+                origins[index] = Some(i + 1);
+                next = i + 1;
+            }
+        }
+        for index in 1..lines.len() {
+            if origins[index].is_some() || significant(lines[index]) {
+                continue;
+            }
+            if let Some(previous) = origins[index - 1]
+                && previous < user.len()
+                && !used[previous]
+                && user[previous] == lines[index]
+            {
+                used[previous] = true;
+                origins[index] = Some(previous + 1);
+            }
+        }
+
+        let unchanged = origins
+            .iter()
+            .enumerate()
+            .all(|(index, origin)| origin.is_none_or(|line| line == index + 1));
+        (!unchanged).then(|| Self {
+            file_name: file_name.to_string(),
+            origins,
+        })
+    }
+
+    /// Rewrite `file:LINE` and `file(LINE` references to snippet line
+    /// numbers. A reference into wrapper code is left as is, followed by a
+    /// note with the offset so it can still be traced.
+    pub fn remap(&self, text: &str) -> String {
+        if !text.contains(&self.file_name) {
+            return text.to_string();
+        }
+        let pattern =
+            regex::Regex::new(&format!(r"\b{}([:(])(\d+)", regex::escape(&self.file_name)))
+                .expect("escaped file name is a valid pattern");
+        let origin = |number: &str| {
+            let line = number.parse::<usize>().ok()?.checked_sub(1)?;
+            self.origins.get(line).copied().flatten()
+        };
+        let mut unmapped = false;
+        let remapped = pattern.replace_all(text, |caps: &regex::Captures| match origin(&caps[2]) {
+            Some(line) => format!("{}{}{line}", self.file_name, &caps[1]),
+            None => {
+                unmapped = true;
+                caps[0].to_string()
+            }
+        });
+        // The `  6 |     code` excerpts gcc and clang print under an error.
+        let gutter = regex::Regex::new(r"(?m)^( *)(\d+)( \| )").expect("valid gutter pattern");
+        let mut remapped = gutter
+            .replace_all(&remapped, |caps: &regex::Captures| match origin(&caps[2]) {
+                Some(line) => {
+                    let width = caps[1].len() + caps[2].len();
+                    format!("{line:>width$}{}", &caps[3])
+                }
+                None => caps[0].to_string(),
+            })
+            .into_owned();
+        // The first line that moved gives the offset to subtract.
+        let shifted = self.origins.iter().enumerate().find_map(|(index, origin)| {
+            origin
+                .filter(|line| *line != index + 1)
+                .map(|line| (line, index + 1))
+        });
+        if unmapped && let Some((line, generated)) = shifted {
+            if !remapped.ends_with('\n') {
+                remapped.push('\n');
+            }
+            remapped.push_str(&format!(
+                "note: run wrapped your snippet; other {} line numbers refer to the generated source, where your line {line} is line {generated}\n",
+                self.file_name
+            ));
+        }
+        remapped
+    }
+
+    /// [`SnippetLines::remap`] the program's stderr and compiler output.
+    pub fn apply(&self, outcome: &mut ExecutionOutcome) {
+        outcome.stderr = self.remap(&outcome.stderr);
+        if let Some(compile) = outcome.compile_stderr.as_mut() {
+            *compile = self.remap(compile);
+        }
+    }
+}
+
+/// The engines run knows, keyed by language id, plus the aliases that lead
+/// to them. A registry is filled once and then only read, so a shared one
+/// (like the one behind [`crate::engines`]) can be used from any number of
+/// threads; engines are `Send + Sync` for that reason.
 pub struct LanguageRegistry {
     engines: HashMap<String, Box<dyn LanguageEngine + Send + Sync>>, // keyed by canonical id
     alias_lookup: HashMap<String, String>,
 }
 
 impl LanguageRegistry {
+    /// Every built-in engine. Panics if two of them claim the same id or
+    /// alias, which is a bug in run rather than in the caller.
     pub fn bootstrap() -> Self {
         let mut registry = Self {
             engines: HashMap::new(),
@@ -583,22 +2424,55 @@ impl LanguageRegistry {
         registry.register_language(CrystalEngine::new());
         registry.register_language(ZigEngine::new());
         registry.register_language(NimEngine::new());
+        registry.register_language(SqlEngine::new());
 
         registry
     }
 
+    /// Add `engine` under its id and aliases.
+    ///
+    /// # Panics
+    ///
+    /// If an engine already registered claims the same id or alias; use
+    /// [`try_register_language`](Self::try_register_language) to handle that.
     pub fn register_language<E>(&mut self, engine: E)
+    where
+        E: LanguageEngine + Send + Sync + 'static,
+    {
+        if let Err(err) = self.try_register_language(engine) {
+            panic!("{err:#}");
+        }
+    }
+
+    /// Add `engine` unless its id, or one of its aliases, already leads to
+    /// another engine. On error the registry is left as it was.
+    pub fn try_register_language<E>(&mut self, engine: E) -> Result<()>
     where
         E: LanguageEngine + Send + Sync + 'static,
     {
         let id = engine.id().to_string();
-        for alias in engine.aliases() {
-            self.alias_lookup
-                .insert(canonical_language_id(alias), id.clone());
+        if self.engines.contains_key(&id) {
+            bail!("two engines are registered for the language id '{id}'");
+        }
+        let mut names = vec![canonical_language_id(&id)];
+        names.extend(
+            engine
+                .aliases()
+                .iter()
+                .map(|alias| canonical_language_id(alias)),
+        );
+        for name in &names {
+            if let Some(owner) = self.alias_lookup.get(name).filter(|owner| **owner != id) {
+                bail!(
+                    "engine '{id}' claims the language name '{name}', which already belongs to engine '{owner}'"
+                );
+            }
+        }
+        for name in names {
+            self.alias_lookup.insert(name, id.clone());
         }
-        self.alias_lookup
-            .insert(canonical_language_id(&id), id.clone());
         self.engines.insert(id, Box::new(engine));
+        Ok(())
     }
 
     pub fn resolve(&self, spec: &LanguageSpec) -> Option<&(dyn LanguageEngine + Send + Sync)> {
@@ -750,11 +2624,19 @@ pub fn detect_language_for_source(
     source: &ExecutionPayload,
     registry: &LanguageRegistry,
 ) -> Option<LanguageSpec> {
-    if let Some(path) = source.as_file_path()
-        && let Some(ext) = path.extension().and_then(|e| e.to_str())
-    {
-        let ext_lower = ext.to_ascii_lowercase();
-        if let Some(lang) = extension_to_language(&ext_lower) {
+    if let Some(path) = source.as_file_path() {
+        let by_extension = path
+            .extension()
+            .and_then(|e| e.to_str())
+            .and_then(extension_to_language);
+        let lang = by_extension.or_else(|| {
+            let file = std::fs::File::open(path).ok()?;
+            let mut first_line = String::new();
+            std::io::BufRead::read_line(&mut std::io::BufReader::new(file), &mut first_line)
+                .ok()?;
+            shebang_language(first_line.trim_end())
+        });
+        if let Some(lang) = lang {
             let spec = LanguageSpec::new(lang);
             if registry.resolve(&spec).is_some() {
                 return Some(spec);
@@ -774,33 +2656,130 @@ pub fn detect_language_for_source(
     None
 }
 
-fn extension_to_language(ext: &str) -> Option<&'static str> {
-    match ext {
-        "py" | "pyw" => Some("python"),
-        "rs" => Some("rust"),
-        "go" => Some("go"),
-        "cs" => Some("csharp"),
-        "ts" | "tsx" => Some("typescript"),
-        "js" | "mjs" | "cjs" | "jsx" => Some("javascript"),
-        "rb" => Some("ruby"),
-        "lua" => Some("lua"),
-        "java" => Some("java"),
-        "groovy" => Some("groovy"),
-        "php" => Some("php"),
-        "kt" | "kts" => Some("kotlin"),
-        "c" => Some("c"),
-        "cpp" | "cc" | "cxx" | "hpp" | "hxx" => Some("cpp"),
-        "sh" | "bash" | "zsh" => Some("bash"),
-        "r" => Some("r"),
-        "dart" => Some("dart"),
-        "swift" => Some("swift"),
-        "perl" | "pl" | "pm" => Some("perl"),
-        "julia" | "jl" => Some("julia"),
-        "hs" => Some("haskell"),
-        "ex" | "exs" => Some("elixir"),
-        "cr" => Some("crystal"),
-        "zig" => Some("zig"),
-        "nim" => Some("nim"),
-        _ => None,
+/// File extension (lower-case, without the dot) to canonical language id. This
+/// is the single table used for `--file` inference and path heuristics; keep new
+/// languages here rather than growing ad-hoc matches elsewhere.
+pub const EXTENSION_LANGUAGES: &[(&str, &str)] = &[
+    ("py", "python"),
+    ("pyw", "python"),
+    ("rs", "rust"),
+    ("go", "go"),
+    ("cs", "csharp"),
+    ("csx", "csharp"),
+    ("ts", "typescript"),
+    ("tsx", "typescript"),
+    ("js", "javascript"),
+    ("mjs", "javascript"),
+    ("cjs", "javascript"),
+    ("jsx", "javascript"),
+    ("rb", "ruby"),
+    ("lua", "lua"),
+    ("java", "java"),
+    ("groovy", "groovy"),
+    ("php", "php"),
+    ("kt", "kotlin"),
+    ("kts", "kotlin"),
+    ("c", "c"),
+    ("cpp", "cpp"),
+    ("cc", "cpp"),
+    ("cxx", "cpp"),
+    ("hpp", "cpp"),
+    ("hxx", "cpp"),
+    ("sh", "bash"),
+    ("bash", "bash"),
+    ("zsh", "bash"),
+    ("r", "r"),
+    ("dart", "dart"),
+    ("swift", "swift"),
+    ("perl", "perl"),
+    ("pl", "perl"),
+    ("pm", "perl"),
+    ("julia", "julia"),
+    ("jl", "julia"),
+    ("hs", "haskell"),
+    ("ex", "elixir"),
+    ("exs", "elixir"),
+    ("cr", "crystal"),
+    ("zig", "zig"),
+    ("nim", "nim"),
+    ("sql", "sql"),
+];
+
+/// Shebang interpreter basename to canonical language id, consulted when a
+/// file has no recognised extension. Versioned names such as `python3.12`
+/// fall back to their unversioned entry.
+pub const INTERPRETER_LANGUAGES: &[(&str, &str)] = &[
+    ("python", "python"),
+    ("python3", "python"),
+    ("python2", "python"),
+    ("pypy", "python"),
+    ("pypy3", "python"),
+    ("node", "javascript"),
+    ("nodejs", "javascript"),
+    ("deno", "typescript"),
+    ("ts-node", "typescript"),
+    ("ruby", "ruby"),
+    ("bash", "bash"),
+    ("sh", "bash"),
+    ("zsh", "bash"),
+    ("dash", "bash"),
+    ("ksh", "bash"),
+    ("perl", "perl"),
+    ("php", "php"),
+    ("lua", "lua"),
+    ("luajit", "lua"),
+    ("rscript", "r"),
+    ("julia", "julia"),
+    ("elixir", "elixir"),
+    ("groovy", "groovy"),
+    ("runghc", "haskell"),
+    ("runhaskell", "haskell"),
+    ("dart", "dart"),
+    ("swift", "swift"),
+    ("crystal", "crystal"),
+    ("dotnet-script", "csharp"),
+];
+
+/// Language named by a `#!` line, e.g. `#!/usr/bin/env python3` or
+/// `#!/bin/bash -e`. The `env` wrapper (including `env -S`) is skipped.
+pub fn shebang_language(first_line: &str) -> Option<&'static str> {
+    let rest = first_line.strip_prefix("#!")?;
+    let mut tokens = rest.split_whitespace();
+    let mut program = tokens.next()?;
+    if basename(program) == "env" {
+        program = tokens.find(|token| !token.starts_with('-') && !token.contains('='))?;
     }
+    interpreter_to_language(basename(program))
+}
+
+fn basename(program: &str) -> &str {
+    program.rsplit(['/', '\\']).next().unwrap_or(program)
+}
+
+fn interpreter_to_language(name: &str) -> Option<&'static str> {
+    let name = name.to_ascii_lowercase();
+    let lookup = |candidate: &str| {
+        INTERPRETER_LANGUAGES
+            .iter()
+            .find(|(interp, _)| *interp == candidate)
+            .map(|(_, lang)| *lang)
+    };
+    lookup(&name)
+        .or_else(|| lookup(name.trim_end_matches(|c: char| c.is_ascii_digit() || c == '.')))
+}
+
+pub fn extension_to_language(ext: &str) -> Option<&'static str> {
+    let ext = ext.trim_start_matches('.');
+    EXTENSION_LANGUAGES
+        .iter()
+        .find(|(candidate, _)| candidate.eq_ignore_ascii_case(ext))
+        .map(|(_, lang)| *lang)
+}
+
+/// Recognised extensions as `.ext` strings, in table order, for error messages.
+pub fn known_extensions() -> Vec<String> {
+    EXTENSION_LANGUAGES
+        .iter()
+        .map(|(ext, _)| format!(".{ext}"))
+        .collect()
 }
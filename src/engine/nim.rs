@@ -5,10 +5,11 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    child_stdin, run_version_command, run_with_timeout, temp_builder, toolchain_flags,
 };
 
 pub struct NimEngine {
@@ -37,7 +38,7 @@ impl NimEngine {
     }
 
     fn write_temp_source(&self, code: &str) -> Result<(TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-nim")
             .tempdir()
             .context("failed to create temporary directory for Nim source")?;
@@ -56,6 +57,7 @@ impl NimEngine {
         let executable = self.ensure_executable()?;
         let mut cmd = Command::new(executable);
         cmd.arg("r")
+            .args(toolchain_flags())
             .arg(source)
             .arg("--colors:off")
             .arg("--hints:off")
@@ -65,11 +67,11 @@ impl NimEngine {
         if !args.is_empty() {
             cmd.arg("--").args(args);
         }
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
         if let Some(dir) = source.parent() {
             cmd.current_dir(dir);
         }
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} with source {}",
                 executable.display(),
@@ -117,6 +119,10 @@ impl LanguageEngine for NimEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let (temp_dir, source_path) = match payload {
@@ -144,9 +150,15 @@ impl LanguageEngine for NimEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("echo \"Hello, world!\"\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let executable = self.ensure_executable()?.to_path_buf();
         Ok(Box::new(NimSession::new(executable)?))
@@ -154,6 +166,9 @@ impl LanguageEngine for NimEngine {
 }
 
 fn resolve_nim_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("nim") {
+        return Some(path);
+    }
     which::which("nim").ok()
 }
 
@@ -167,7 +182,9 @@ struct NimSession {
 
 impl NimSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let workspace = TempDir::new().context("failed to create Nim session workspace")?;
+        let workspace = temp_builder()
+            .tempdir()
+            .context("failed to create Nim session workspace")?;
         let session = Self {
             executable,
             workspace,
@@ -215,7 +232,7 @@ impl NimSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Nim session",
                 self.executable.display()
@@ -247,6 +264,8 @@ impl NimSession {
             stdout,
             stderr,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -263,7 +282,7 @@ impl NimSession {
         Ok((outcome, success))
     }
 
-    fn reset(&mut self) -> Result<()> {
+    fn reset_state(&mut self) -> Result<()> {
         self.snippets.clear();
         self.last_stdout.clear();
         self.last_stderr.clear();
@@ -298,17 +317,21 @@ impl LanguageSession for NimSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.reset()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: "nim".to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -321,6 +344,8 @@ impl LanguageSession for NimSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -333,6 +358,10 @@ impl LanguageSession for NimSession {
         Ok(outcome)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -5,10 +5,11 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    child_stdin, run_version_command, run_with_timeout, temp_builder, toolchain_flags,
 };
 
 pub struct PerlEngine {
@@ -37,7 +38,7 @@ impl PerlEngine {
     }
 
     fn write_temp_script(&self, code: &str) -> Result<(TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-perl")
             .tempdir()
             .context("failed to create temporary directory for Perl source")?;
@@ -58,15 +59,16 @@ impl PerlEngine {
     fn execute_path(&self, path: &Path, args: &[String]) -> Result<std::process::Output> {
         let executable = self.ensure_executable()?;
         let mut cmd = Command::new(executable);
-        cmd.arg(path)
+        cmd.args(toolchain_flags())
+            .arg(path)
             .args(args)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
         if let Some(parent) = path.parent() {
             cmd.current_dir(parent);
         }
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} with script {}",
                 executable.display(),
@@ -112,6 +114,10 @@ impl LanguageEngine for PerlEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let (temp_dir, path) = match payload {
@@ -131,9 +137,15 @@ impl LanguageEngine for PerlEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("use strict;\nuse warnings;\n\nprint \"Hello, world!\\n\";\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let executable = self.ensure_executable()?.to_path_buf();
         Ok(Box::new(PerlSession::new(executable)?))
@@ -141,6 +153,9 @@ impl LanguageEngine for PerlEngine {
 }
 
 fn resolve_perl_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("perl") {
+        return Some(path);
+    }
     which::which("perl").ok()
 }
 
@@ -161,7 +176,7 @@ struct PerlSession {
 
 impl PerlSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let workspace = Builder::new()
+        let workspace = temp_builder()
             .prefix("run-perl-repl")
             .tempdir()
             .context("failed to create temporary directory for Perl repl")?;
@@ -228,7 +243,7 @@ impl PerlSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Perl session",
                 self.executable.display()
@@ -257,6 +272,8 @@ impl PerlSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -287,6 +304,8 @@ impl PerlSession {
                     stdout: String::new(),
                     stderr: String::new(),
                     duration: Duration::default(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 },
                 true,
             ));
@@ -337,7 +356,7 @@ impl PerlSession {
         Ok((outcome, success))
     }
 
-    fn reset(&mut self) -> Result<()> {
+    fn reset_state(&mut self) -> Result<()> {
         self.state.pragmas.clear();
         self.state.declarations.clear();
         self.state.statements.clear();
@@ -364,17 +383,21 @@ impl LanguageSession for PerlSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.reset()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: "perl".to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -387,6 +410,8 @@ impl LanguageSession for PerlSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -410,6 +435,10 @@ impl LanguageSession for PerlSession {
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -4,10 +4,11 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    child_stdin, run_version_command, run_with_timeout, temp_builder, toolchain_flags,
 };
 
 pub struct PhpEngine {
@@ -36,7 +37,7 @@ impl PhpEngine {
     }
 
     fn write_temp_script(&self, code: &str) -> Result<(tempfile::TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-php")
             .tempdir()
             .context("failed to create temporary directory for php source")?;
@@ -57,15 +58,16 @@ impl PhpEngine {
     fn run_script(&self, script: &Path, args: &[String]) -> Result<std::process::Output> {
         let interpreter = self.ensure_interpreter()?;
         let mut cmd = Command::new(interpreter);
-        cmd.arg(script)
+        cmd.args(toolchain_flags())
+            .arg(script)
             .args(args)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
         if let Some(dir) = script.parent() {
             cmd.current_dir(dir);
         }
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} with script {}",
                 interpreter.display(),
@@ -111,6 +113,10 @@ impl LanguageEngine for PhpEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.interpreter.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let (temp_dir, script_path) = match payload {
@@ -130,9 +136,15 @@ impl LanguageEngine for PhpEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("<?php\n\necho \"Hello, world!\\n\";\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let interpreter = self.ensure_interpreter()?.to_path_buf();
         let session = PhpSession::new(interpreter)?;
@@ -141,6 +153,9 @@ impl LanguageEngine for PhpEngine {
 }
 
 fn resolve_php_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("php") {
+        return Some(path);
+    }
     which::which("php").ok()
 }
 
@@ -157,7 +172,9 @@ struct PhpSession {
 
 impl PhpSession {
     fn new(interpreter: PathBuf) -> Result<Self> {
-        let workspace = TempDir::new().context("failed to create PHP session workspace")?;
+        let workspace = temp_builder()
+            .tempdir()
+            .context("failed to create PHP session workspace")?;
         let session = Self {
             interpreter,
             workspace,
@@ -177,6 +194,13 @@ impl PhpSession {
         self.workspace.path().join(SESSION_MAIN_FILE)
     }
 
+    fn reset_state(&mut self) -> Result<()> {
+        self.statements.clear();
+        self.last_stdout.clear();
+        self.last_stderr.clear();
+        self.persist_source()
+    }
+
     fn persist_source(&self) -> Result<()> {
         let path = self.source_path();
         let source = self.render_source();
@@ -205,7 +229,7 @@ impl PhpSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for PHP session",
                 self.interpreter.display()
@@ -237,16 +261,15 @@ impl LanguageSession for PhpSession {
         let trimmed = code.trim();
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.statements.clear();
-            self.last_stdout.clear();
-            self.last_stderr.clear();
-            self.persist_source()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: self.language_id().to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -257,6 +280,8 @@ impl LanguageSession for PhpSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -268,6 +293,8 @@ impl LanguageSession for PhpSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -295,6 +322,8 @@ impl LanguageSession for PhpSession {
                 stdout,
                 stderr,
                 duration,
+                compile_duration: None,
+                compile_stderr: None,
             })
         } else {
             self.statements.pop();
@@ -305,10 +334,16 @@ impl LanguageSession for PhpSession {
                 stdout,
                 stderr,
                 duration,
+                compile_duration: None,
+                compile_stderr: None,
             })
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -35,6 +35,8 @@ impl LanguageEngine for PlaceholderEngine {
                 self.id
             ),
             duration: Default::default(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 }
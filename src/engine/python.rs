@@ -4,12 +4,15 @@ use std::path::{Path, PathBuf};
 use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
-use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use anyhow::{Context, Result, bail};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, execution_timeout,
-    run_version_command, wait_with_timeout,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    build_step_with_timeout, child_stdin, compile_cache_dir, compile_cache_enabled,
+    execution_timeout, has_unclosed_delimiters, isolate_process_group, run_version_command,
+    run_with_timeout, spawn_program, temp_builder, toolchain_cache_key, toolchain_flags,
+    wait_with_timeout,
 };
 
 pub struct PythonEngine {
@@ -35,6 +38,123 @@ impl PythonEngine {
     fn run_command(&self) -> Command {
         Command::new(self.binary())
     }
+
+    /// Interpreter that runs programs: the virtualenv holding the `--pip`
+    /// packages when any were requested, otherwise the resolved binary. The
+    /// directory, when there is one, holds a private venv and has to outlive
+    /// the programs.
+    fn program_interpreter(&self) -> Result<(PathBuf, Option<TempDir>)> {
+        let packages = pip_packages();
+        if packages.is_empty() {
+            return Ok((self.executable.clone(), None));
+        }
+        ensure_venv(self.binary(), &packages)
+    }
+}
+
+/// Packages requested with `--pip`, one per line in RUN_PIP_PACKAGES.
+pub fn pip_packages() -> Vec<String> {
+    std::env::var("RUN_PIP_PACKAGES")
+        .map(|raw| {
+            raw.lines()
+                .map(str::trim)
+                .filter(|pkg| !pkg.is_empty())
+                .map(str::to_string)
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// A virtualenv with `packages` installed, built on first use and kept in
+/// the compile cache keyed by the package set and the base interpreter, so
+/// later runs with the same `--pip` list start immediately. With `--no-cache`
+/// a private one is built in a temp dir, returned so the caller keeps it
+/// alive, and the cached venv is left to any run still using it.
+fn ensure_venv(python: &Path, packages: &[String]) -> Result<(PathBuf, Option<TempDir>)> {
+    let mut sorted = packages.to_vec();
+    sorted.sort();
+    sorted.dedup();
+    if !compile_cache_enabled() {
+        let dir = temp_builder()
+            .prefix("run-python-venv")
+            .tempdir()
+            .context("failed to create temporary directory for the --pip virtualenv")?;
+        let venv = dir.path().join("venv");
+        build_venv(python, &sorted, &venv)?;
+        return Ok((venv_interpreter(&venv), Some(dir)));
+    }
+
+    let key = toolchain_cache_key("python-venv", python, "--version", &sorted.join("\n"));
+    let venv = compile_cache_dir().join(format!("python-venv-{key:016x}"));
+    let venv_python = venv_interpreter(&venv);
+    if venv_python.is_file() {
+        return Ok((venv_python, None));
+    }
+
+    // Build next to the final location and rename it into place, so an
+    // interrupted install never leaves a venv that looks ready.
+    let staging = venv.with_extension(format!("tmp{}", std::process::id()));
+    let _ = fs::remove_dir_all(&staging);
+    fs::create_dir_all(compile_cache_dir()).context("failed to create the run cache directory")?;
+    if let Err(err) = build_venv(python, &sorted, &staging) {
+        let _ = fs::remove_dir_all(&staging);
+        return Err(err);
+    }
+
+    if venv_python.is_file() {
+        // Another run finished the same venv first and may already be running
+        // programs from it; keep that one.
+        let _ = fs::remove_dir_all(&staging);
+        return Ok((venv_python, None));
+    }
+    // Without an interpreter in it, whatever is there is a broken leftover
+    // that nothing can be running from.
+    let _ = fs::remove_dir_all(&venv);
+    if fs::rename(&staging, &venv).is_err() {
+        // Lost the race to another run's rename; theirs is just as good.
+        let _ = fs::remove_dir_all(&staging);
+    }
+    Ok((venv_python, None))
+}
+
+/// `python -m venv venv` followed by `pip install packages` into it, as
+/// build steps: bound by the run's timeout and stopped by Ctrl-C.
+fn build_venv(python: &Path, packages: &[String], venv: &Path) -> Result<()> {
+    let mut create = Command::new(python);
+    create.arg("-m").arg("venv").arg(venv).stdin(Stdio::null());
+    let created = build_step_with_timeout(&mut create)
+        .with_context(|| format!("failed to run {} -m venv", python.display()))?;
+    if !created.status.success() {
+        bail!(
+            "failed to create a virtualenv for --pip with {}:\n{}",
+            python.display(),
+            String::from_utf8_lossy(&created.stderr).trim_end()
+        );
+    }
+
+    let mut install = Command::new(venv_interpreter(venv));
+    install
+        .args(["-m", "pip", "install", "--disable-pip-version-check", "-q"])
+        .args(packages)
+        .stdin(Stdio::null());
+    let installed =
+        build_step_with_timeout(&mut install).context("failed to run pip install for --pip")?;
+    if !installed.status.success() {
+        bail!(
+            "pip could not install {}:\n{}",
+            packages.join(", "),
+            String::from_utf8_lossy(&installed.stderr).trim_end()
+        );
+    }
+    Ok(())
+}
+
+fn venv_interpreter(venv: &Path) -> PathBuf {
+    if cfg!(windows) {
+        venv.join("Scripts").join("python.exe")
+    } else {
+        venv.join("bin").join("python")
+    }
 }
 
 impl LanguageEngine for PythonEngine {
@@ -54,6 +174,10 @@ impl LanguageEngine for PythonEngine {
         true
     }
 
+    fn is_input_complete(&self, buffer: &str) -> bool {
+        !needs_more_input(buffer)
+    }
+
     fn validate(&self) -> Result<()> {
         let mut cmd = self.run_command();
         cmd.arg("--version")
@@ -73,33 +197,39 @@ impl LanguageEngine for PythonEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        Some(self.executable.clone())
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
+        let (interpreter, _venv) = self.program_interpreter()?;
         let start = Instant::now();
         let timeout = execution_timeout();
-        let mut cmd = self.run_command();
+        let mut cmd = Command::new(&interpreter);
+        cmd.args(toolchain_flags());
         let args = payload.args();
         let output = match payload {
             ExecutionPayload::Inline { code, .. } => {
                 cmd.arg("-c")
                     .arg(code)
                     .args(args)
-                    .stdin(Stdio::inherit())
+                    .stdin(child_stdin())
                     .stdout(Stdio::piped())
                     .stderr(Stdio::piped());
-                let child = cmd
-                    .spawn()
-                    .with_context(|| format!("failed to start {}", self.binary().display()))?;
+                isolate_process_group(&mut cmd);
+                let child = spawn_program(&mut cmd)
+                    .with_context(|| format!("failed to start {}", interpreter.display()))?;
                 wait_with_timeout(child, timeout)?
             }
             ExecutionPayload::File { path, .. } => {
                 cmd.arg(path)
                     .args(args)
-                    .stdin(Stdio::inherit())
+                    .stdin(child_stdin())
                     .stdout(Stdio::piped())
                     .stderr(Stdio::piped());
-                let child = cmd
-                    .spawn()
-                    .with_context(|| format!("failed to start {}", self.binary().display()))?;
+                isolate_process_group(&mut cmd);
+                let child = spawn_program(&mut cmd)
+                    .with_context(|| format!("failed to start {}", interpreter.display()))?;
                 wait_with_timeout(child, timeout)?
             }
             ExecutionPayload::Stdin { code, .. } => {
@@ -108,10 +238,11 @@ impl LanguageEngine for PythonEngine {
                     .stdin(Stdio::piped())
                     .stdout(Stdio::piped())
                     .stderr(Stdio::piped());
-                let mut child = cmd.spawn().with_context(|| {
+                isolate_process_group(&mut cmd);
+                let mut child = spawn_program(&mut cmd).with_context(|| {
                     format!(
                         "failed to start {} for stdin execution",
-                        self.binary().display()
+                        interpreter.display()
                     )
                 })?;
                 if let Some(mut stdin) = child.stdin.take() {
@@ -127,16 +258,24 @@ impl LanguageEngine for PythonEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("print(\"Hello, world!\")\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
-        Ok(Box::new(PythonSession::new(self.executable.clone())?))
+        let (interpreter, venv) = self.program_interpreter()?;
+        Ok(Box::new(PythonSession::new(interpreter, venv)?))
     }
 }
 
 struct PythonSession {
     executable: PathBuf,
+    _venv: Option<TempDir>,
     dir: TempDir,
     source_path: PathBuf,
     statements: Vec<String>,
@@ -145,8 +284,8 @@ struct PythonSession {
 }
 
 impl PythonSession {
-    fn new(executable: PathBuf) -> Result<Self> {
-        let dir = Builder::new()
+    fn new(executable: PathBuf, venv: Option<TempDir>) -> Result<Self> {
+        let dir = temp_builder()
             .prefix("run-python-repl")
             .tempdir()
             .context("failed to create temporary directory for python repl")?;
@@ -156,6 +295,7 @@ impl PythonSession {
 
         Ok(Self {
             executable,
+            _venv: venv,
             dir,
             source_path,
             statements: Vec::new(),
@@ -207,6 +347,8 @@ impl PythonSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -218,7 +360,7 @@ impl PythonSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.dir.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to run python session script {} with {}",
                 self.source_path.display(),
@@ -262,6 +404,8 @@ impl LanguageSession for PythonSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -273,6 +417,8 @@ impl LanguageSession for PythonSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -285,6 +431,8 @@ impl LanguageSession for PythonSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -300,12 +448,19 @@ impl LanguageSession for PythonSession {
         self.run_snippet(snippet)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
 }
 
 pub(super) fn resolve_python_binary() -> PathBuf {
+    if let Some(path) = binary_override("python") {
+        return path;
+    }
     let candidates = ["python3", "python", "py"]; // windows py launcher
     for name in candidates {
         if let Ok(path) = which::which(name) {
@@ -407,3 +562,76 @@ fn should_treat_as_expression(code: &str) -> bool {
 
     true
 }
+
+fn needs_more_input(code: &str) -> bool {
+    if has_unclosed_delimiters(code) {
+        return true;
+    }
+
+    let mut last_nonempty: Option<&str> = None;
+    let mut saw_block_header = false;
+    let mut has_body_after_header = false;
+
+    for line in code.lines() {
+        let trimmed = line.trim_end();
+        if trimmed.trim().is_empty() {
+            continue;
+        }
+        last_nonempty = Some(trimmed);
+        if is_python_block_header(trimmed.trim()) {
+            saw_block_header = true;
+            has_body_after_header = false;
+        } else if saw_block_header {
+            has_body_after_header = true;
+        }
+    }
+
+    if !saw_block_header {
+        return false;
+    }
+
+    // A blank line terminates a block
+    if code.ends_with("\n\n") {
+        return false;
+    }
+
+    // If we have a header but no body yet, we need more input
+    if !has_body_after_header {
+        return true;
+    }
+
+    // If the last line is still indented, we're still inside the block
+    if let Some(last) = last_nonempty
+        && (last.starts_with(' ') || last.starts_with('\t'))
+    {
+        return true;
+    }
+
+    false
+}
+
+/// Check if a trimmed Python line is a block header (def, class, if, for, etc.)
+/// rather than a line that just happens to end with `:` (dict literal, slice, etc.)
+pub(crate) fn is_python_block_header(line: &str) -> bool {
+    if !line.ends_with(':') {
+        return false;
+    }
+    let lowered = line.to_ascii_lowercase();
+    const BLOCK_KEYWORDS: &[&str] = &[
+        "def ",
+        "class ",
+        "if ",
+        "elif ",
+        "else:",
+        "for ",
+        "while ",
+        "try:",
+        "except",
+        "finally:",
+        "with ",
+        "async def ",
+        "async for ",
+        "async with ",
+    ];
+    BLOCK_KEYWORDS.iter().any(|kw| lowered.starts_with(kw))
+}
@@ -4,10 +4,12 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    child_stdin, has_unclosed_delimiters, line_looks_incomplete, run_version_command,
+    run_with_timeout, temp_builder, toolchain_flags,
 };
 
 pub struct REngine {
@@ -36,7 +38,7 @@ impl REngine {
     }
 
     fn write_temp_source(&self, code: &str) -> Result<(TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-r")
             .tempdir()
             .context("failed to create temporary directory for R source")?;
@@ -50,16 +52,31 @@ impl REngine {
         Ok((dir, path))
     }
 
+    fn execute_expression(&self, code: &str) -> Result<std::process::Output> {
+        let executable = self.ensure_executable()?;
+        let mut cmd = Command::new(executable);
+        cmd.arg("--vanilla")
+            .args(toolchain_flags())
+            .arg("-e")
+            .arg(code)
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped());
+        cmd.stdin(child_stdin());
+        run_with_timeout(&mut cmd)
+            .with_context(|| format!("failed to invoke {} -e", executable.display()))
+    }
+
     fn execute_with_path(&self, source: &Path, args: &[String]) -> Result<std::process::Output> {
         let executable = self.ensure_executable()?;
         let mut cmd = Command::new(executable);
         cmd.arg("--vanilla")
+            .args(toolchain_flags())
             .arg(source)
             .args(args)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
-        cmd.output().with_context(|| {
+        cmd.stdin(child_stdin());
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to invoke {} to run {}",
                 executable.display(),
@@ -101,27 +118,48 @@ impl LanguageEngine for REngine {
 
     fn toolchain_version(&self) -> Result<Option<String>> {
         let executable = self.ensure_executable()?;
-        let mut cmd = Command::new(executable);
+        // `R --version` names the release ("R version 4.4.1 (2024-06-14)");
+        // older `Rscript --version` only prints its own front-end version.
+        let r = executable.with_file_name(if cfg!(windows) { "R.exe" } else { "R" });
+        let binary = if r.is_file() { r.as_path() } else { executable };
+        let mut cmd = Command::new(binary);
         cmd.arg("--version");
-        let context = format!("{}", executable.display());
+        let context = format!("{}", binary.display());
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
+        let args = payload.args();
         let (temp_dir, path) = match payload {
-            ExecutionPayload::Inline { code, .. } => {
-                let (dir, path) = self.write_temp_source(code)?;
-                (Some(dir), path)
+            // Rscript stops reading its own options at the script path, but not
+            // after `-e`, so snippets with arguments still go through a file.
+            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. }
+                if args.is_empty() =>
+            {
+                let output = self.execute_expression(code)?;
+                return Ok(ExecutionOutcome {
+                    language: self.id().to_string(),
+                    exit_code: output.status.code(),
+                    stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
+                    stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
+                    duration: start.elapsed(),
+                    compile_duration: None,
+                    compile_stderr: None,
+                });
             }
-            ExecutionPayload::Stdin { code, .. } => {
+            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
                 let (dir, path) = self.write_temp_source(code)?;
                 (Some(dir), path)
             }
             ExecutionPayload::File { path, .. } => (None, path.clone()),
         };
 
-        let output = self.execute_with_path(&path, payload.args())?;
+        let output = self.execute_with_path(&path, args)?;
         drop(temp_dir);
 
         Ok(ExecutionOutcome {
@@ -130,16 +168,84 @@ impl LanguageEngine for REngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("cat(\"Hello, world!\\n\")\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let executable = self.ensure_executable()?.to_path_buf();
         Ok(Box::new(RSession::new(executable)?))
     }
+
+    fn is_input_complete(&self, buffer: &str) -> bool {
+        !has_unclosed_delimiters(buffer)
+            && !line_looks_incomplete(buffer)
+            && !ends_with_open_header(buffer)
+    }
+}
+
+/// True when the last line is a `for`/`while`/`if`/`function` header (or
+/// `repeat`) whose body has not been typed yet, e.g. `for (i in 1:3)`. R keeps
+/// reading the next line for those, so the REPL does too.
+fn ends_with_open_header(code: &str) -> bool {
+    let Some(line) = code.lines().map(str::trim).rfind(|line| !line.is_empty()) else {
+        return false;
+    };
+    if line == "repeat" || line.ends_with(" repeat") {
+        return true;
+    }
+    let Some(before_close) = line.strip_suffix(')') else {
+        return false;
+    };
+
+    // Find the `(` matching the final `)`, skipping strings and comments.
+    let mut opens = Vec::new();
+    let mut quote = None;
+    let mut escape = false;
+    for (index, ch) in before_close.char_indices() {
+        if let Some(q) = quote {
+            if escape {
+                escape = false;
+            } else if ch == '\\' {
+                escape = true;
+            } else if ch == q {
+                quote = None;
+            }
+            continue;
+        }
+        match ch {
+            '"' | '\'' | '`' => quote = Some(ch),
+            '#' => return false,
+            '(' => opens.push(index),
+            ')' => {
+                opens.pop();
+            }
+            _ => {}
+        }
+    }
+    let Some(open) = opens.pop() else {
+        return false;
+    };
+    let head = before_close[..open].trim_end();
+    if head.ends_with('\\') {
+        return true;
+    }
+    let keyword = head
+        .rsplit(|c: char| !(c.is_alphanumeric() || c == '_' || c == '.'))
+        .next()
+        .unwrap_or("");
+    matches!(keyword, "for" | "while" | "if" | "function")
 }
 
 fn resolve_r_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("r") {
+        return Some(path);
+    }
     which::which("Rscript").ok()
 }
 
@@ -154,7 +260,7 @@ struct RSession {
 
 impl RSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-r-repl")
             .tempdir()
             .context("failed to create temporary directory for R repl")?;
@@ -202,7 +308,7 @@ impl RSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.dir.path());
-        let output = cmd.output().with_context(|| {
+        let output = run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute R session script {} with {}",
                 self.script_path.display(),
@@ -228,6 +334,8 @@ impl RSession {
             stdout: stdout_delta,
             stderr: stderr_delta,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -268,6 +376,8 @@ impl LanguageSession for RSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -279,6 +389,8 @@ impl LanguageSession for RSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -291,16 +403,19 @@ impl LanguageSession for RSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
-        let snippet = if should_wrap_expression(trimmed) {
-            wrap_expression(trimmed)
-        } else {
-            ensure_trailing_newline(code)
-        };
+        // Rscript auto-prints visible top-level values the way the R console
+        // does, so `mean(x)` shows its result while `x <- 1` and `invisible()`
+        // stay quiet; wrapping snippets in print() would lose that distinction.
+        self.run_snippet(ensure_trailing_newline(code))
+    }
 
-        self.run_snippet(snippet)
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
     }
 
     fn shutdown(&mut self) -> Result<()> {
@@ -308,34 +423,6 @@ impl LanguageSession for RSession {
     }
 }
 
-fn should_wrap_expression(code: &str) -> bool {
-    if code.contains('\n') {
-        return false;
-    }
-
-    let lowered = code.trim_start().to_ascii_lowercase();
-    const STATEMENT_PREFIXES: [&str; 12] = [
-        "if ", "for ", "while ", "repeat", "function", "library", "require", "print", "cat",
-        "source", "options", "setwd",
-    ];
-    if STATEMENT_PREFIXES
-        .iter()
-        .any(|prefix| lowered.starts_with(prefix))
-    {
-        return false;
-    }
-
-    if code.contains("<-") || code.contains("=") {
-        return false;
-    }
-
-    true
-}
-
-fn wrap_expression(code: &str) -> String {
-    format!("print(({}))\n", code)
-}
-
 fn ensure_trailing_newline(code: &str) -> String {
     let mut owned = code.to_string();
     if !owned.ends_with('\n') {
@@ -357,3 +444,25 @@ fn normalize_output(bytes: &[u8]) -> String {
         .replace("\r\n", "\n")
         .replace('\r', "")
 }
+
+#[cfg(test)]
+mod tests {
+    use super::REngine;
+    use crate::engine::LanguageEngine;
+
+    #[test]
+    fn waits_for_loop_and_function_bodies() {
+        let engine = REngine::new();
+        assert!(!engine.is_input_complete("for (i in 1:3) {"));
+        assert!(!engine.is_input_complete("for (i in 1:3) {\n  print(i)"));
+        assert!(engine.is_input_complete("for (i in 1:3) {\n  print(i)\n}"));
+        assert!(!engine.is_input_complete("for (i in seq_len(n))"));
+        assert!(!engine.is_input_complete("square <- function(x)"));
+        assert!(!engine.is_input_complete("inc <- \\(x)"));
+        assert!(!engine.is_input_complete("x <- c(1, 2) |>"));
+        assert!(!engine.is_input_complete("df %>%"));
+        assert!(engine.is_input_complete("mean(c(1, 2, 3))"));
+        assert!(engine.is_input_complete("print(\"for (\")"));
+        assert!(engine.is_input_complete("if (x) y"));
+    }
+}
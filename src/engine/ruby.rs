@@ -8,7 +8,9 @@ use std::sync::{Arc, Mutex};
 use std::thread;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, apply_run_env,
+    binary_override, child_stdin, run_version_command, run_with_timeout, spawn_program,
+    toolchain_flags,
 };
 
 pub struct RubyEngine {
@@ -37,6 +39,13 @@ impl RubyEngine {
         Command::new(self.binary())
     }
 
+    /// Command for running a program: the interpreter followed by any `--flags`.
+    fn program_command(&self) -> Command {
+        let mut cmd = self.run_command();
+        cmd.args(toolchain_flags());
+        cmd
+    }
+
     fn ensure_irb(&self) -> Result<&Path> {
         self.irb.as_deref().ok_or_else(|| {
             anyhow::anyhow!(
@@ -82,30 +91,34 @@ impl LanguageEngine for RubyEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        Some(self.executable.clone())
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let args = payload.args();
         let output = match payload {
             ExecutionPayload::Inline { code, .. } => {
-                let mut cmd = self.run_command();
+                let mut cmd = self.program_command();
                 cmd.arg("-e").arg(code).args(args);
-                cmd.stdin(Stdio::inherit());
-                cmd.output()
+                cmd.stdin(child_stdin());
+                run_with_timeout(&mut cmd)
             }
             ExecutionPayload::File { path, .. } => {
-                let mut cmd = self.run_command();
+                let mut cmd = self.program_command();
                 cmd.arg(path).args(args);
-                cmd.stdin(Stdio::inherit());
-                cmd.output()
+                cmd.stdin(child_stdin());
+                run_with_timeout(&mut cmd)
             }
             ExecutionPayload::Stdin { code, .. } => {
-                let mut cmd = self.run_command();
+                let mut cmd = self.program_command();
                 cmd.arg("-")
                     .args(args)
                     .stdin(Stdio::piped())
                     .stdout(Stdio::piped())
                     .stderr(Stdio::piped());
-                let mut child = cmd.spawn().with_context(|| {
+                let mut child = spawn_program(&mut cmd).with_context(|| {
                     format!(
                         "failed to start {} for stdin execution",
                         self.binary().display()
@@ -128,9 +141,15 @@ impl LanguageEngine for RubyEngine {
             stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("puts \"Hello, world!\"\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let irb = self.ensure_irb()?;
         let mut cmd = Command::new(irb);
@@ -139,6 +158,8 @@ impl LanguageEngine for RubyEngine {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
 
+        apply_run_env(&mut cmd);
+
         let mut child = cmd
             .spawn()
             .with_context(|| format!("failed to start {} REPL", irb.display()))?;
@@ -177,6 +198,9 @@ impl LanguageEngine for RubyEngine {
 }
 
 fn resolve_ruby_binary() -> PathBuf {
+    if let Some(path) = binary_override("ruby") {
+        return path;
+    }
     let candidates = ["ruby"];
     for name in candidates {
         if let Ok(path) = which::which(name) {
@@ -270,9 +294,19 @@ impl LanguageSession for RubySession {
             stdout,
             stderr,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn reset(&mut self) -> Result<()> {
+        anyhow::bail!("irb cannot clear its state in place")
+    }
+
+    fn is_alive(&mut self) -> bool {
+        matches!(self.child.try_wait(), Ok(None))
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         if let Some(mut stdin) = self.child.stdin.take() {
             let _ = stdin.write_all(b"exit\n");
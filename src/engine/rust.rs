@@ -4,14 +4,37 @@ use std::process::{Command, Stdio};
 use std::time::Instant;
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, cache_lookup, cache_store,
-    compiler_command, execution_timeout, hash_source, perf_record, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    build_step_output, cache_lookup, cache_store, child_stdin, compile_cache_enabled,
+    compile_cache_key, compiler_command, execution_timeout, isolate_process_group, perf_record,
+    run_version_command, run_with_timeout, spawn_program, temp_builder, toolchain_flags,
     try_cached_execution, wait_with_timeout,
 };
 
+/// rustc options for one-shot builds, which favor compile turnaround, minus
+/// those `flags` (from `--flags`) set themselves: rustc rejects a second
+/// `--edition`, and `-O` must not be undone by the default `opt-level=0`.
+fn rustc_default_args(flags: &[String]) -> Vec<&'static str> {
+    let sets = |needle: &str| flags.iter().any(|flag| flag.contains(needle));
+    let mut args = vec!["--color=never"];
+    if !sets("--edition") {
+        args.push("--edition=2021");
+    }
+    if !sets("debuginfo") && !flags.iter().any(|flag| flag == "-g") {
+        args.extend(["-C", "debuginfo=0"]);
+    }
+    if !sets("opt-level") && !flags.iter().any(|flag| flag == "-O") {
+        args.extend(["-C", "opt-level=0"]);
+    }
+    if !sets("codegen-units") {
+        args.extend(["-C", "codegen-units=16"]);
+    }
+    args
+}
+
 pub struct RustEngine {
     compiler: Option<PathBuf>,
 }
@@ -37,31 +60,31 @@ impl RustEngine {
         })
     }
 
+    /// Compile-cache key for `source`, tied to this compiler's version.
+    fn cache_key(&self, source: &str) -> Result<u64> {
+        let compiler = self.ensure_compiler()?;
+        Ok(compile_cache_key(self.id(), compiler, "--version", source))
+    }
+
     fn compile(&self, source: &Path, output: &Path) -> Result<std::process::Output> {
         let compiler = self.ensure_compiler()?;
         let mut cmd = compiler_command(compiler);
-        cmd.arg("--color=never")
-            .arg("--edition=2021")
-            // Favor faster compile turnaround for REPL/runner scenarios.
-            .arg("-C")
-            .arg("debuginfo=0")
-            .arg("-C")
-            .arg("opt-level=0")
-            .arg("-C")
-            .arg("codegen-units=16")
+        let flags = toolchain_flags();
+        cmd.args(rustc_default_args(&flags))
+            .args(&flags)
             .arg("--crate-name")
             .arg("run_snippet")
             .arg(source)
             .arg("-o")
             .arg(output);
-        cmd.output()
+        build_step_output(&mut cmd)
             .with_context(|| format!("failed to invoke rustc at {}", compiler.display()))
     }
 
     fn execute_file_incremental(&self, source: &Path, args: &[String]) -> Result<ExecutionOutcome> {
         let start = Instant::now();
         let source_text = fs::read_to_string(source).unwrap_or_default();
-        let source_hash = hash_source(&source_text);
+        let source_hash = self.cache_key(&source_text)?;
 
         let compiler = self.ensure_compiler()?;
         let source_key = source
@@ -69,7 +92,7 @@ impl RustEngine {
             .unwrap_or_else(|_| source.to_path_buf());
         let workspace = std::env::temp_dir().join(format!(
             "run-rust-inc-{:016x}",
-            hash_source(&source_key.to_string_lossy())
+            self.cache_key(&source_key.to_string_lossy())?
         ));
         fs::create_dir_all(&workspace).with_context(|| {
             format!(
@@ -81,7 +104,7 @@ impl RustEngine {
         let incremental_dir = workspace.join("incremental");
         let _ = fs::create_dir_all(&incremental_dir);
 
-        let needs_compile = if !binary_path.exists() {
+        let needs_compile = if !compile_cache_enabled() || !binary_path.exists() {
             true
         } else {
             let src = source.metadata().and_then(|m| m.modified()).ok();
@@ -102,6 +125,8 @@ impl RustEngine {
                 stdout: String::from_utf8_lossy(&runtime_output.stdout).into_owned(),
                 stderr: String::from_utf8_lossy(&runtime_output.stderr).into_owned(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -115,21 +140,20 @@ impl RustEngine {
                 stdout: String::from_utf8_lossy(&runtime_output.stdout).into_owned(),
                 stderr: String::from_utf8_lossy(&runtime_output.stderr).into_owned(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
         perf_record("rust", "file.cache_miss");
 
+        let mut compile_duration = None;
         if needs_compile {
             perf_record("rust", "file.compile");
+            let compile_start = Instant::now();
             let mut cmd = compiler_command(compiler);
-            cmd.arg("--color=never")
-                .arg("--edition=2021")
-                .arg("-C")
-                .arg("debuginfo=0")
-                .arg("-C")
-                .arg("opt-level=0")
-                .arg("-C")
-                .arg("codegen-units=16")
+            let flags = toolchain_flags();
+            cmd.args(rustc_default_args(&flags))
+                .args(&flags)
                 .arg("-C")
                 .arg(format!("incremental={}", incremental_dir.display()))
                 .arg("--crate-name")
@@ -139,8 +163,7 @@ impl RustEngine {
                 .arg(&binary_path)
                 .stdout(Stdio::piped())
                 .stderr(Stdio::piped());
-            let compile_output = cmd
-                .output()
+            let compile_output = build_step_output(&mut cmd)
                 .with_context(|| format!("failed to invoke rustc at {}", compiler.display()))?;
             if !compile_output.status.success() {
                 perf_record("rust", "file.compile_fail");
@@ -148,10 +171,15 @@ impl RustEngine {
                     language: self.id().to_string(),
                     exit_code: compile_output.status.code(),
                     stdout: String::from_utf8_lossy(&compile_output.stdout).into_owned(),
-                    stderr: String::from_utf8_lossy(&compile_output.stderr).into_owned(),
+                    stderr: String::new(),
                     duration: start.elapsed(),
+                    compile_duration: Some(compile_start.elapsed()),
+                    compile_stderr: Some(
+                        String::from_utf8_lossy(&compile_output.stderr).into_owned(),
+                    ),
                 });
             }
+            compile_duration = Some(compile_start.elapsed());
             cache_store("rust-file", source_hash, &binary_path);
         } else {
             // Rehydrate persistent cache even when incremental workspace is already up-to-date.
@@ -166,15 +194,17 @@ impl RustEngine {
             stdout: String::from_utf8_lossy(&runtime_output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&runtime_output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration,
+            compile_stderr: None,
         })
     }
 
     fn run_binary(&self, binary: &Path, args: &[String]) -> Result<std::process::Output> {
         let mut cmd = Command::new(binary);
         cmd.args(args).stdout(Stdio::piped()).stderr(Stdio::piped());
-        cmd.stdin(Stdio::inherit());
-        let child = cmd
-            .spawn()
+        cmd.stdin(child_stdin());
+        isolate_process_group(&mut cmd);
+        let child = spawn_program(&mut cmd)
             .with_context(|| format!("failed to execute compiled binary {}", binary.display()))?;
         wait_with_timeout(child, execution_timeout())
     }
@@ -245,6 +275,10 @@ impl LanguageEngine for RustEngine {
         run_version_command(cmd, &context)
     }
 
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.compiler.clone()
+    }
+
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         // Try cache for inline/stdin payloads
         let args = payload.args();
@@ -258,22 +292,24 @@ impl LanguageEngine for RustEngine {
             }
             _ => None,
         } {
-            let src_hash = hash_source(code);
-            if let Some(output) = try_cached_execution("rust", src_hash) {
+            let src_hash = self.cache_key(code)?;
+            let start = Instant::now();
+            if let Some(output) = try_cached_execution("rust", src_hash, args) {
                 perf_record("rust", "inline.cache_hit");
-                let start = Instant::now();
                 return Ok(ExecutionOutcome {
                     language: self.id().to_string(),
                     exit_code: output.status.code(),
                     stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
                     stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
                     duration: start.elapsed(),
+                    compile_duration: None,
+                    compile_stderr: None,
                 });
             }
             perf_record("rust", "inline.cache_miss");
         }
 
-        let temp_dir = Builder::new()
+        let temp_dir = temp_builder()
             .prefix("run-rust")
             .tempdir()
             .context("failed to create temporary directory for rust build")?;
@@ -281,11 +317,11 @@ impl LanguageEngine for RustEngine {
 
         let (source_path, cleanup_source, cache_key): (PathBuf, bool, Option<u64>) = match payload {
             ExecutionPayload::Inline { code, .. } => {
-                let h = hash_source(code);
+                let h = self.cache_key(code)?;
                 (self.write_inline_source(code, dir_path)?, true, Some(h))
             }
             ExecutionPayload::Stdin { code, .. } => {
-                let h = hash_source(code);
+                let h = self.cache_key(code)?;
                 (self.write_inline_source(code, dir_path)?, true, Some(h))
             }
             ExecutionPayload::File { path, .. } => (path.clone(), false, None),
@@ -295,6 +331,7 @@ impl LanguageEngine for RustEngine {
         let start = Instant::now();
 
         let compile_output = self.compile(&source_path, &binary_path)?;
+        let compile_duration = start.elapsed();
         if !compile_output.status.success() {
             let stdout = String::from_utf8_lossy(&compile_output.stdout).into_owned();
             let stderr = String::from_utf8_lossy(&compile_output.stderr).into_owned();
@@ -304,6 +341,8 @@ impl LanguageEngine for RustEngine {
                 stdout,
                 stderr,
                 duration: start.elapsed(),
+                compile_duration: Some(compile_duration),
+                compile_stderr: None,
             });
         }
 
@@ -319,6 +358,8 @@ impl LanguageEngine for RustEngine {
             stdout: String::from_utf8_lossy(&runtime_output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&runtime_output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: Some(compile_duration),
+            compile_stderr: None,
         };
 
         if cleanup_source {
@@ -329,6 +370,10 @@ impl LanguageEngine for RustEngine {
         Ok(outcome)
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("fn main() {\n    println!(\"Hello, world!\");\n}\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         let compiler = self.ensure_compiler()?.to_path_buf();
         let session = RustSession::new(compiler)?;
@@ -346,13 +391,18 @@ struct RustSession {
 }
 
 enum RustSnippetKind {
-    Item,
+    /// A new item, plus the earlier definition of the same name it replaced
+    /// and, for a type, that definition's `impl` blocks, each with where it
+    /// sat (in order), so a failed compile can put them back.
+    Item(Vec<(usize, String)>),
     Statement,
 }
 
 impl RustSession {
     fn new(compiler: PathBuf) -> Result<Self> {
-        let workspace = TempDir::new().context("failed to create Rust session workspace")?;
+        let workspace = temp_builder()
+            .tempdir()
+            .context("failed to create Rust session workspace")?;
         let session = Self {
             compiler,
             workspace,
@@ -377,6 +427,14 @@ impl RustSession {
         RustEngine::tmp_binary_path(self.workspace.path())
     }
 
+    fn reset_state(&mut self) -> Result<()> {
+        self.items.clear();
+        self.statements.clear();
+        self.last_stdout.clear();
+        self.last_stderr.clear();
+        self.persist_source()
+    }
+
     fn persist_source(&self) -> Result<()> {
         let source = self.render_source();
         fs::write(self.source_path(), source)
@@ -442,7 +500,7 @@ fn __print<T: Debug>(value: T) {
     fn run_binary(&self, binary: &Path) -> Result<std::process::Output> {
         let mut cmd = Command::new(binary);
         cmd.stdout(Stdio::piped()).stderr(Stdio::piped());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute compiled Rust session binary {}",
                 binary.display()
@@ -463,8 +521,10 @@ fn __print<T: Debug>(value: T) {
                 language: self.language_id().to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: String::from_utf8_lossy(&compile_output.stdout).into_owned(),
-                stderr: String::from_utf8_lossy(&compile_output.stderr).into_owned(),
+                stderr: String::new(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: Some(String::from_utf8_lossy(&compile_output.stderr).into_owned()),
             };
             let _ = fs::remove_file(&source_path);
             let _ = fs::remove_file(&binary_path);
@@ -478,6 +538,8 @@ fn __print<T: Debug>(value: T) {
             stdout: String::from_utf8_lossy(&runtime_output.stdout).into_owned(),
             stderr: String::from_utf8_lossy(&runtime_output.stderr).into_owned(),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         let _ = fs::remove_file(&source_path);
@@ -497,17 +559,34 @@ fn __print<T: Debug>(value: T) {
             if !snippet.ends_with('\n') {
                 snippet.push('\n');
             }
+            // Redefining a function or type replaces the earlier definition
+            // instead of clashing with it. A type's `impl` blocks were
+            // written against the old definition, so they go with it.
+            let mut replaced = Vec::new();
+            if let Some(key) = item_key(trimmed)
+                && self
+                    .items
+                    .iter()
+                    .any(|item| item_key(item).as_ref() == Some(&key))
+            {
+                let impls_of = (key.0 == "type").then_some(key.1.as_str());
+                for index in (0..self.items.len()).rev() {
+                    let item = &self.items[index];
+                    if item_key(item).as_ref() == Some(&key)
+                        || impls_of.is_some() && impl_target(item).as_deref() == impls_of
+                    {
+                        replaced.push((index, self.items.remove(index)));
+                    }
+                }
+                replaced.reverse();
+            }
             self.items.push(snippet);
-            RustSnippetKind::Item
+            RustSnippetKind::Item(replaced)
         } else {
             let stored = if should_treat_as_expression(trimmed) {
                 wrap_expression(trimmed)
             } else {
-                let mut snippet = code.to_string();
-                if !snippet.ends_with('\n') {
-                    snippet.push('\n');
-                }
-                snippet
+                terminate_statement(code)
             };
             self.statements.push(stored);
             RustSnippetKind::Statement
@@ -516,8 +595,11 @@ fn __print<T: Debug>(value: T) {
 
     fn rollback(&mut self, kind: RustSnippetKind) -> Result<()> {
         match kind {
-            RustSnippetKind::Item => {
+            RustSnippetKind::Item(replaced) => {
                 self.items.pop();
+                for (index, item) in replaced {
+                    self.items.insert(index, item);
+                }
             }
             RustSnippetKind::Statement => {
                 self.statements.pop();
@@ -555,8 +637,10 @@ fn __print<T: Debug>(value: T) {
                 language: self.language_id().to_string(),
                 exit_code: compile_output.status.code(),
                 stdout: String::from_utf8_lossy(&compile_output.stdout).into_owned(),
-                stderr: String::from_utf8_lossy(&compile_output.stderr).into_owned(),
+                stderr: String::new(),
                 duration: start.elapsed(),
+                compile_duration: None,
+                compile_stderr: Some(String::from_utf8_lossy(&compile_output.stderr).into_owned()),
             };
             let _ = fs::remove_file(&binary_path);
             return Ok((outcome, false));
@@ -583,6 +667,8 @@ fn __print<T: Debug>(value: T) {
             stdout,
             stderr,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         let _ = fs::remove_file(&binary_path);
@@ -605,6 +691,8 @@ impl LanguageSession for RustSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Instant::now().elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -616,12 +704,19 @@ impl LanguageSession for RustSession {
         Ok(outcome)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
 }
 
 fn resolve_rustc_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("rust") {
+        return Some(path);
+    }
     which::which("rustc").ok()
 }
 
@@ -666,6 +761,120 @@ fn is_item_snippet(code: &str) -> bool {
     false
 }
 
+/// First line of an item that is not blank, an attribute or a comment.
+fn item_head(code: &str) -> Option<&str> {
+    code.lines()
+        .map(str::trim_start)
+        .find(|line| !line.is_empty() && !line.starts_with("#[") && !line.starts_with("//"))
+}
+
+/// Namespace and name an item defines, e.g. `("value", "square")` for
+/// `fn square`. `impl` blocks and `use` declarations have none.
+fn item_key(code: &str) -> Option<(&'static str, String)> {
+    let line = item_head(code)?;
+    let mut rest = line;
+    if let Some(stripped) = rest.strip_prefix("pub(") {
+        rest = &stripped[stripped.find(')')? + 1..];
+    } else if let Some(stripped) = rest.strip_prefix("pub ") {
+        rest = stripped;
+    }
+    let mut tokens = rest.split_whitespace();
+    let mut keyword = tokens.next()?;
+    while matches!(keyword, "async" | "unsafe" | "const" | "extern")
+        && let Some(next) = tokens.clone().next()
+        && matches!(next, "fn" | "unsafe" | "extern" | "trait" | "impl")
+    {
+        keyword = tokens.next()?;
+    }
+    let namespace = match keyword {
+        "fn" | "const" | "static" => "value",
+        "struct" | "enum" | "union" | "trait" | "type" | "mod" => "type",
+        "macro_rules!" => "macro",
+        _ => return None,
+    };
+    let mut name = tokens.next()?;
+    if name == "mut" {
+        name = tokens.next()?;
+    }
+    let name: String = name
+        .chars()
+        .take_while(|ch| ch.is_alphanumeric() || *ch == '_')
+        .collect();
+    (!name.is_empty()).then_some((namespace, name))
+}
+
+/// The type an `impl` block is for: `Shape` for `impl Shape`,
+/// `impl<T> Display for Shape<T>` or `unsafe impl Send for crate::Shape`.
+fn impl_target(code: &str) -> Option<String> {
+    let line = item_head(code)?;
+    let rest = line.strip_prefix("unsafe ").unwrap_or(line);
+    let rest = rest.strip_prefix("impl")?;
+    let rest = match rest.strip_prefix('<') {
+        Some(generics) => {
+            let mut depth = 1;
+            let end = generics.find(|ch| {
+                match ch {
+                    '<' => depth += 1,
+                    '>' => depth -= 1,
+                    _ => {}
+                }
+                depth == 0
+            })?;
+            &generics[end + 1..]
+        }
+        None if rest.starts_with(char::is_whitespace) => rest,
+        None => return None,
+    };
+    let target = match rest.split_once(" for ") {
+        Some((_, target)) => target,
+        None => rest,
+    };
+    let path: String = target
+        .trim_start()
+        .chars()
+        .take_while(|ch| ch.is_alphanumeric() || *ch == '_' || *ch == ':')
+        .collect();
+    let name = path.rsplit("::").next()?;
+    (!name.is_empty()).then(|| name.to_string())
+}
+
+/// Single-line statements may leave off the trailing `;` at the prompt
+/// (`let x = 5`, `x += 1`, `println!("{x}")`).
+fn terminate_statement(code: &str) -> String {
+    let trimmed = code.trim_end();
+    let mut snippet = trimmed.to_string();
+    if !trimmed.contains('\n') && !trimmed.ends_with(';') && !trimmed.ends_with('}') {
+        snippet.push(';');
+    }
+    snippet.push('\n');
+    snippet
+}
+
+/// `x = 1` or `total += n`: evaluating these would print `()`, so they run
+/// as statements.
+fn is_assignment(code: &str) -> bool {
+    let Some(index) = code.find('=') else {
+        return false;
+    };
+    let (before, after) = (&code[..index], &code[index + 1..]);
+    if after.starts_with('=') {
+        return false;
+    }
+    let target = before
+        .strip_suffix(['+', '-', '*', '/', '%', '&', '|', '^'])
+        .or_else(|| before.strip_suffix("<<"))
+        .or_else(|| before.strip_suffix(">>"))
+        .unwrap_or(before);
+    if target.ends_with(['!', '<', '>', '=']) {
+        return false;
+    }
+    let target = target.trim();
+    !target.is_empty()
+        && target
+            .chars()
+            .all(|ch| ch.is_alphanumeric() || matches!(ch, '_' | '.' | '[' | ']' | '*' | ' '))
+}
+
 fn should_treat_as_expression(code: &str) -> bool {
     let trimmed = code.trim();
     if trimmed.is_empty() {
@@ -690,6 +899,10 @@ fn should_treat_as_expression(code: &str) -> bool {
     if trimmed.starts_with("return ") {
         return false;
     }
+    const PRINT_MACROS: [&str; 4] = ["println!", "print!", "eprintln!", "eprint!"];
+    if PRINT_MACROS.iter().any(|mac| trimmed.starts_with(mac)) || is_assignment(trimmed) {
+        return false;
+    }
     true
 }
 
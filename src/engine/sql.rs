@@ -0,0 +1,402 @@
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::process::{Command, Stdio};
+use std::time::Instant;
+
+use anyhow::{Context, Result, bail};
+use tempfile::TempDir;
+
+use super::{
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, binary_override,
+    run_version_command, run_with_timeout, temp_builder, toolchain_flags,
+};
+
+/// SQL against a throwaway SQLite database, through the `sqlite3` shell.
+/// One-shot runs use `:memory:`; a REPL session keeps a database file in its
+/// workspace so tables and rows survive from one entry to the next.
+pub struct SqlEngine {
+    executable: Option<PathBuf>,
+}
+
+impl Default for SqlEngine {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl SqlEngine {
+    pub fn new() -> Self {
+        Self {
+            executable: resolve_sqlite_binary(),
+        }
+    }
+
+    fn ensure_executable(&self) -> Result<&Path> {
+        self.executable.as_deref().ok_or_else(|| {
+            anyhow::anyhow!(
+                "SQL support requires the `sqlite3` command-line shell. Install it from https://sqlite.org/download.html (or your package manager) and ensure `sqlite3` is on your PATH."
+            )
+        })
+    }
+
+    fn write_temp_script(&self, code: &str) -> Result<(TempDir, PathBuf)> {
+        let dir = temp_builder()
+            .prefix("run-sql")
+            .tempdir()
+            .context("failed to create temporary directory for SQL source")?;
+        let path = dir.path().join("snippet.sql");
+        fs::write(&path, ensure_trailing_newline(code)).with_context(|| {
+            format!("failed to write temporary SQL source to {}", path.display())
+        })?;
+        Ok((dir, path))
+    }
+}
+
+impl LanguageEngine for SqlEngine {
+    fn id(&self) -> &'static str {
+        "sql"
+    }
+
+    fn display_name(&self) -> &'static str {
+        "SQL"
+    }
+
+    fn aliases(&self) -> &[&'static str] {
+        &["sqlite", "sqlite3"]
+    }
+
+    fn supports_sessions(&self) -> bool {
+        self.executable.is_some()
+    }
+
+    fn is_input_complete(&self, buffer: &str) -> bool {
+        !needs_more_input(buffer)
+    }
+
+    fn validate(&self) -> Result<()> {
+        let executable = self.ensure_executable()?;
+        let mut cmd = Command::new(executable);
+        cmd.arg("-version")
+            .stdout(Stdio::null())
+            .stderr(Stdio::null());
+        cmd.status()
+            .with_context(|| format!("failed to invoke {}", executable.display()))?
+            .success()
+            .then_some(())
+            .ok_or_else(|| anyhow::anyhow!("{} is not executable", executable.display()))
+    }
+
+    fn toolchain_version(&self) -> Result<Option<String>> {
+        let executable = self.ensure_executable()?;
+        let mut cmd = Command::new(executable);
+        cmd.arg("-version");
+        let context = format!("{}", executable.display());
+        // `3.45.1 2024-01-30 ... (64-bit)`: keep the version, drop the hash.
+        Ok(run_version_command(cmd, &context)?.map(|version| {
+            let number = version.split_whitespace().next().unwrap_or(&version);
+            format!("SQLite {number}")
+        }))
+    }
+
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
+    }
+
+    fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
+        if !payload.args().is_empty() {
+            bail!("SQL runs take no program arguments; put the values in the statements instead");
+        }
+        let executable = self.ensure_executable()?;
+        let start = Instant::now();
+        let (temp_dir, path) = match payload {
+            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
+                let (dir, path) = self.write_temp_script(code)?;
+                (Some(dir), path)
+            }
+            ExecutionPayload::File { path, .. } => (None, path.clone()),
+        };
+
+        // Results print as a table; `--flags -json` or `--flags "-csv -header"`
+        // come later on the command line and switch the mode.
+        let mut cmd = Command::new(executable);
+        cmd.args(["-bail", "-batch", "-table"])
+            .args(toolchain_flags())
+            .arg(":memory:")
+            .arg(read_command(&path))
+            .stdin(Stdio::null());
+        let output = run_with_timeout(&mut cmd).with_context(|| {
+            format!(
+                "failed to execute {} with script {}",
+                executable.display(),
+                path.display()
+            )
+        })?;
+        drop(temp_dir);
+
+        Ok(ExecutionOutcome {
+            language: self.id().to_string(),
+            exit_code: output.status.code(),
+            stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
+            stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
+            duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
+        })
+    }
+
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some(
+            "CREATE TABLE greetings (message TEXT);\nINSERT INTO greetings VALUES ('Hello, world!');\nSELECT message FROM greetings;\n"
+                .to_string(),
+        )
+    }
+
+    fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
+        let executable = self.ensure_executable()?.to_path_buf();
+        Ok(Box::new(SqlSession::new(executable)?))
+    }
+}
+
+fn resolve_sqlite_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("sql") {
+        return Some(path);
+    }
+    which::which("sqlite3").ok()
+}
+
+/// `.read` dot-command for `path`, quoted so spaces and quotes in the path
+/// survive the shell's own argument parsing.
+fn read_command(path: &Path) -> String {
+    let path = path.to_string_lossy();
+    if path.contains('\'') {
+        let escaped = path.replace('\\', "\\\\").replace('"', "\\\"");
+        format!(".read \"{escaped}\"")
+    } else {
+        format!(".read '{path}'")
+    }
+}
+
+fn ensure_trailing_newline(code: &str) -> String {
+    let mut owned = code.to_string();
+    if !owned.ends_with('\n') {
+        owned.push('\n');
+    }
+    owned
+}
+
+/// REPL completeness: an entry runs once its last statement ends in `;`
+/// (outside strings and comments), or when it is a dot-command such as
+/// `.tables`. A `CREATE TRIGGER` body has `;` inside it, so that waits for
+/// its `END;`.
+fn needs_more_input(code: &str) -> bool {
+    let trimmed = code.trim();
+    if trimmed.is_empty() || trimmed.starts_with('.') {
+        return false;
+    }
+    let Some(cleaned) = strip_strings_and_comments(trimmed) else {
+        return true;
+    };
+    let cleaned = cleaned.trim_end();
+    if !cleaned.ends_with(';') {
+        return true;
+    }
+    let upper = cleaned.to_ascii_uppercase();
+    let words: Vec<&str> = upper
+        .split(|c: char| !(c.is_ascii_alphanumeric() || c == '_'))
+        .filter(|word| !word.is_empty())
+        .collect();
+    let opens_trigger = words
+        .windows(2)
+        .any(|pair| pair[0] == "CREATE" && pair[1] == "TRIGGER")
+        || words
+            .windows(3)
+            .any(|w| w[0] == "CREATE" && matches!(w[1], "TEMP" | "TEMPORARY") && w[2] == "TRIGGER");
+    opens_trigger && words.last() != Some(&"END")
+}
+
+/// Blank out string literals, quoted identifiers and comments so only SQL
+/// punctuation is left to inspect. `None` while one of them is still open.
+fn strip_strings_and_comments(code: &str) -> Option<String> {
+    let chars: Vec<char> = code.chars().collect();
+    let mut out = String::with_capacity(code.len());
+    let mut i = 0;
+    while i < chars.len() {
+        let ch = chars[i];
+        match ch {
+            '-' if chars.get(i + 1) == Some(&'-') => {
+                while i < chars.len() && chars[i] != '\n' {
+                    i += 1;
+                }
+            }
+            '/' if chars.get(i + 1) == Some(&'*') => {
+                i += 2;
+                loop {
+                    match chars.get(i) {
+                        None => return None,
+                        Some('*') if chars.get(i + 1) == Some(&'/') => break,
+                        Some(_) => i += 1,
+                    }
+                }
+                i += 2;
+                out.push(' ');
+            }
+            '\'' | '"' | '`' => {
+                // Quotes inside are doubled (`'it''s'`), which reads as two
+                // literals back to back.
+                i += 1;
+                while chars.get(i) != Some(&ch) {
+                    if i >= chars.len() {
+                        return None;
+                    }
+                    i += 1;
+                }
+                i += 1;
+                out.push('x');
+            }
+            '[' => {
+                while chars.get(i) != Some(&']') {
+                    if i >= chars.len() {
+                        return None;
+                    }
+                    i += 1;
+                }
+                i += 1;
+                out.push('x');
+            }
+            _ => {
+                out.push(ch);
+                i += 1;
+            }
+        }
+    }
+    Some(out)
+}
+
+struct SqlSession {
+    executable: PathBuf,
+    workspace: TempDir,
+}
+
+impl SqlSession {
+    fn new(executable: PathBuf) -> Result<Self> {
+        let workspace = temp_builder()
+            .prefix("run-sql-repl")
+            .tempdir()
+            .context("failed to create temporary directory for SQL repl")?;
+        Ok(Self {
+            executable,
+            workspace,
+        })
+    }
+
+    fn database_path(&self) -> PathBuf {
+        self.workspace.path().join("session.db")
+    }
+}
+
+impl LanguageSession for SqlSession {
+    fn language_id(&self) -> &str {
+        "sql"
+    }
+
+    fn eval(&mut self, code: &str) -> Result<ExecutionOutcome> {
+        let start = Instant::now();
+        let entry = self.workspace.path().join("entry.sql");
+        fs::write(&entry, ensure_trailing_newline(code))
+            .context("failed to write SQL session entry")?;
+
+        // Each entry is its own sqlite3 process against the session's
+        // database file, so what earlier entries created is still there.
+        // Output-mode flags apply as in a one-shot run.
+        let mut cmd = Command::new(&self.executable);
+        cmd.args(["-bail", "-batch", "-table"])
+            .args(toolchain_flags())
+            .arg(self.database_path())
+            .arg(read_command(&entry))
+            .stdin(Stdio::null())
+            .current_dir(self.workspace.path());
+        let output = run_with_timeout(&mut cmd).with_context(|| {
+            format!(
+                "failed to execute {} for SQL session",
+                self.executable.display()
+            )
+        })?;
+
+        Ok(ExecutionOutcome {
+            language: "sql".to_string(),
+            exit_code: output.status.code(),
+            stdout: String::from_utf8_lossy(&output.stdout).into_owned(),
+            stderr: String::from_utf8_lossy(&output.stderr).into_owned(),
+            duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
+        })
+    }
+
+    fn reset(&mut self) -> Result<()> {
+        let database = self.database_path();
+        if database.exists() {
+            fs::remove_file(&database)
+                .with_context(|| format!("failed to remove {}", database.display()))?;
+        }
+        Ok(())
+    }
+
+    fn shutdown(&mut self) -> Result<()> {
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::path::Path;
+
+    use super::{needs_more_input, read_command, strip_strings_and_comments};
+
+    #[test]
+    fn statements_run_once_they_end_in_a_semicolon() {
+        assert!(needs_more_input("SELECT *\n"));
+        assert!(needs_more_input("SELECT *\nFROM t\n"));
+        assert!(!needs_more_input("SELECT *\nFROM t;\n"));
+        assert!(!needs_more_input("select 1; -- done\n"));
+        assert!(needs_more_input("SELECT 'a;b'\n"));
+        assert!(needs_more_input("INSERT INTO t VALUES ('it''s\n"));
+        assert!(!needs_more_input("INSERT INTO t VALUES ('it''s');\n"));
+        assert!(needs_more_input("/* comment; */ SELECT 1\n"));
+        assert!(!needs_more_input(".tables\n"));
+        assert!(!needs_more_input("\n"));
+    }
+
+    #[test]
+    fn triggers_wait_for_their_end() {
+        let open =
+            "CREATE TRIGGER log AFTER INSERT ON t BEGIN\n  INSERT INTO audit VALUES (new.a);\n";
+        assert!(needs_more_input(open));
+        assert!(!needs_more_input(&format!("{open}END;\n")));
+        assert!(needs_more_input(
+            "create temp trigger x before delete on t begin select 1;\n"
+        ));
+        assert!(!needs_more_input("BEGIN;\n"));
+    }
+
+    #[test]
+    fn strings_and_identifiers_are_blanked() {
+        assert_eq!(
+            strip_strings_and_comments("SELECT \"a;\", [b;] FROM t -- x;").as_deref(),
+            Some("SELECT x, x FROM t ")
+        );
+        assert_eq!(strip_strings_and_comments("SELECT 'open"), None);
+    }
+
+    #[test]
+    fn read_command_quotes_the_path() {
+        assert_eq!(
+            read_command(Path::new("/tmp/a b.sql")),
+            ".read '/tmp/a b.sql'"
+        );
+        assert_eq!(
+            read_command(Path::new("/tmp/it's.sql")),
+            ".read \"/tmp/it's.sql\""
+        );
+    }
+}
@@ -1,18 +1,94 @@
 use std::fs;
-use std::io::{ErrorKind, Write};
+use std::io::ErrorKind;
 use std::path::{Path, PathBuf};
 use std::process::{Command, Stdio};
 use std::time::Instant;
 
 use anyhow::{Context, Result, bail};
-use tempfile::{NamedTempFile, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, run_version_command,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, SnippetLines,
+    binary_override, child_stdin, has_unclosed_delimiters, line_looks_incomplete,
+    run_version_command, run_with_timeout, temp_builder, toolchain_flags,
 };
 
+/// A program that runs TypeScript directly.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Runtime {
+    Bun,
+    Deno,
+    TsNode,
+}
+
+impl Runtime {
+    /// Searched in this order, fastest start-up first.
+    const ALL: [Runtime; 3] = [Runtime::Bun, Runtime::Deno, Runtime::TsNode];
+
+    fn binary_name(self) -> &'static str {
+        match self {
+            Runtime::Bun => "bun",
+            Runtime::Deno => "deno",
+            Runtime::TsNode => "ts-node",
+        }
+    }
+
+    /// The runtime an override points at, judged by its file name. Unknown
+    /// names are assumed to be Deno, which is what run has always used.
+    fn for_binary(path: &Path) -> Runtime {
+        let stem = path
+            .file_stem()
+            .and_then(|stem| stem.to_str())
+            .unwrap_or_default();
+        Runtime::ALL
+            .into_iter()
+            .find(|runtime| stem == runtime.binary_name())
+            .unwrap_or(Runtime::Deno)
+    }
+
+    /// Command that runs `script`, a `.ts` or `.tsx` file (or anything else,
+    /// treated as `.ts`), without type checking. `flags` go after the
+    /// runtime's own options and before the script.
+    fn run_command(self, binary: &Path, script: &Path, flags: &[String]) -> Command {
+        let mut cmd = Command::new(binary);
+        match self {
+            Runtime::Bun => {
+                cmd.arg("run");
+            }
+            Runtime::Deno => {
+                cmd.args(["run", "--quiet", "--no-check"]);
+                if !has_typescript_extension(script) {
+                    cmd.args(["--ext", "ts"]);
+                }
+            }
+            Runtime::TsNode => {
+                cmd.arg("--transpile-only");
+            }
+        }
+        cmd.args(flags).arg(script).env("NO_COLOR", "1");
+        cmd
+    }
+
+    /// Version string as reported by `--version`, prefixed with the runtime's
+    /// name where the runtime leaves it out (`1.1.8` from bun, `v10.9.2` from
+    /// ts-node), so it is clear which runtime run picked.
+    fn label_version(self, version: String) -> String {
+        if version.starts_with(self.binary_name()) {
+            version
+        } else {
+            format!("{} {version}", self.binary_name())
+        }
+    }
+}
+
+fn has_typescript_extension(path: &Path) -> bool {
+    path.extension()
+        .and_then(|ext| ext.to_str())
+        .is_some_and(|ext| matches!(ext, "ts" | "tsx" | "mts" | "cts"))
+}
+
 pub struct TypeScriptEngine {
-    executable: PathBuf,
+    runtime: Option<(Runtime, PathBuf)>,
 }
 
 impl Default for TypeScriptEngine {
@@ -23,16 +99,23 @@ impl Default for TypeScriptEngine {
 
 impl TypeScriptEngine {
     pub fn new() -> Self {
-        let executable = resolve_deno_binary();
-        Self { executable }
+        Self {
+            runtime: resolve_runtime(),
+        }
     }
 
-    fn binary(&self) -> &Path {
-        &self.executable
+    fn ensure_runtime(&self) -> Result<(Runtime, &Path)> {
+        match &self.runtime {
+            Some((runtime, binary)) => Ok((*runtime, binary)),
+            None => bail!("{}", no_runtime_message()),
+        }
     }
 
-    fn run_command(&self) -> Command {
-        Command::new(self.binary())
+    fn run_script(&self, script: &Path, args: &[String]) -> Result<std::process::Output> {
+        let (runtime, binary) = self.ensure_runtime()?;
+        let mut cmd = runtime.run_command(binary, script, &toolchain_flags());
+        cmd.args(args).stdin(child_stdin());
+        handle_runtime_io(run_with_timeout(&mut cmd), binary, "run TypeScript")
     }
 }
 
@@ -54,89 +137,53 @@ impl LanguageEngine for TypeScriptEngine {
     }
 
     fn validate(&self) -> Result<()> {
-        let mut cmd = self.run_command();
+        let (_, binary) = self.ensure_runtime()?;
+        let mut cmd = Command::new(binary);
         cmd.arg("--version")
             .stdout(Stdio::null())
             .stderr(Stdio::null());
-        let status = handle_deno_io(
-            cmd.status(),
-            self.binary(),
-            "invoke Deno to check its version",
-        )?;
+        let status = handle_runtime_io(cmd.status(), binary, "check its version")?;
 
         if status.success() {
             Ok(())
         } else {
-            bail!("{} is not executable", self.binary().display());
+            bail!("{} is not executable", binary.display());
         }
     }
 
     fn toolchain_version(&self) -> Result<Option<String>> {
-        let mut cmd = self.run_command();
+        let (runtime, binary) = self.ensure_runtime()?;
+        let mut cmd = Command::new(binary);
         cmd.arg("--version");
-        let context = format!("{}", self.binary().display());
-        run_version_command(cmd, &context)
+        let context = format!("{}", binary.display());
+        Ok(run_version_command(cmd, &context)?.map(|version| runtime.label_version(version)))
+    }
+
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.runtime.as_ref().map(|(_, binary)| binary.clone())
+    }
+
+    fn snippet_lines(&self, payload: &ExecutionPayload) -> Option<SnippetLines> {
+        let code = payload.as_inline()?;
+        let (runtime, _) = self.ensure_runtime().ok()?;
+        SnippetLines::new("snippet.ts", code, &prepare_snippet(code, runtime))
     }
 
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let start = Instant::now();
+        let (runtime, _) = self.ensure_runtime()?;
         let args = payload.args();
         let output = match payload {
-            ExecutionPayload::Inline { code, .. } => {
-                let mut script =
-                    NamedTempFile::new().context("failed to create temporary TypeScript file")?;
-                script.write_all(code.as_bytes())?;
-                if !code.ends_with('\n') {
-                    script.write_all(b"\n")?;
-                }
-                script.flush()?;
-
-                let mut cmd = self.run_command();
-                cmd.arg("run")
-                    .args(["--quiet", "--no-check", "--ext", "ts"])
-                    .arg(script.path())
-                    .args(args)
-                    .env("NO_COLOR", "1");
-                cmd.stdin(Stdio::inherit());
-                handle_deno_io(cmd.output(), self.binary(), "run Deno for inline execution")?
-            }
-            ExecutionPayload::File { path, .. } => {
-                let mut cmd = self.run_command();
-                cmd.arg("run")
-                    .args(["--quiet", "--no-check", "--ext", "ts"])
-                    .arg(path)
-                    .args(args)
-                    .env("NO_COLOR", "1");
-                cmd.stdin(Stdio::inherit());
-                handle_deno_io(cmd.output(), self.binary(), "run Deno for file execution")?
-            }
-            ExecutionPayload::Stdin { code, .. } => {
-                let mut cmd = self.run_command();
-                cmd.arg("run")
-                    .args(["--quiet", "--no-check", "--ext", "ts", "-"])
-                    .args(args)
-                    .stdin(Stdio::piped())
-                    .stdout(Stdio::piped())
-                    .stderr(Stdio::piped())
-                    .env("NO_COLOR", "1");
-
-                let mut child =
-                    handle_deno_io(cmd.spawn(), self.binary(), "start Deno for stdin execution")?;
-
-                if let Some(mut stdin) = child.stdin.take() {
-                    stdin.write_all(code.as_bytes())?;
-                    if !code.ends_with('\n') {
-                        stdin.write_all(b"\n")?;
-                    }
-                    stdin.flush()?;
-                }
-
-                handle_deno_io(
-                    child.wait_with_output(),
-                    self.binary(),
-                    "read output from Deno stdin execution",
-                )?
+            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
+                let dir = temp_builder()
+                    .tempdir()
+                    .context("failed to create temporary TypeScript dir")?;
+                let script = dir.path().join("snippet.ts");
+                fs::write(&script, prepare_snippet(code, runtime))
+                    .context("failed to write temporary TypeScript file")?;
+                self.run_script(&script, args)?
             }
+            ExecutionPayload::File { path, .. } => self.run_script(path, args)?,
         };
 
         Ok(ExecutionOutcome {
@@ -145,18 +192,142 @@ impl LanguageEngine for TypeScriptEngine {
             stdout: strip_ansi_codes(&String::from_utf8_lossy(&output.stdout)).replace('\r', ""),
             stderr: strip_ansi_codes(&String::from_utf8_lossy(&output.stderr)).replace('\r', ""),
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         })
     }
 
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some("const greeting: string = \"Hello, world!\";\nconsole.log(greeting);\n".to_string())
+    }
+
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
         self.validate()?;
-        let session = TypeScriptSession::new(self.binary().to_path_buf())?;
+        let (runtime, binary) = self.ensure_runtime()?;
+        let session = TypeScriptSession::new(runtime, binary.to_path_buf())?;
         Ok(Box::new(session))
     }
+
+    fn is_input_complete(&self, buffer: &str) -> bool {
+        !needs_more_input(buffer)
+    }
+}
+
+/// The override if there is one, otherwise the first runtime on `PATH`.
+fn resolve_runtime() -> Option<(Runtime, PathBuf)> {
+    if let Some(path) = binary_override("typescript") {
+        return Some((Runtime::for_binary(&path), path));
+    }
+    Runtime::ALL.into_iter().find_map(|runtime| {
+        which::which(runtime.binary_name())
+            .ok()
+            .map(|path| (runtime, path))
+    })
 }
 
-fn resolve_deno_binary() -> PathBuf {
-    which::which("deno").unwrap_or_else(|_| PathBuf::from("deno"))
+fn no_runtime_message() -> String {
+    let searched: Vec<&str> = Runtime::ALL.iter().map(|r| r.binary_name()).collect();
+    format!(
+        "no TypeScript runtime found: looked for {} on PATH",
+        searched.join(", ")
+    )
+}
+
+/// Prints a value the way the session shows expression results.
+const PRINT_HELPER: &str = r#"const __print = (value: unknown): void => {
+    if (typeof value === "string") {
+        console.log(value);
+        return;
+    }
+    try {
+        const serialized = JSON.stringify(value, null, 2);
+        if (serialized !== undefined) {
+            console.log(serialized);
+            return;
+        }
+    } catch (_) {
+    }
+    console.log(String(value));
+};
+
+"#;
+
+/// Source to run for a one-shot snippet: a lone expression (`1 + 2`,
+/// `await fetchCount()`) has its value printed unless it is `undefined`, so
+/// `console.log(...)` snippets print once. ts-node compiles to CommonJS, where
+/// top-level `await` is an error, so for it the snippet is made async.
+fn prepare_snippet(code: &str, runtime: Runtime) -> String {
+    let source = if should_treat_as_expression(code) {
+        let expr = code.trim().trim_end_matches(';').trim_end();
+        format!(
+            "{PRINT_HELPER}{{\n    const __value = await ({expr});\n    if (__value !== undefined) __print(__value);\n}}\n"
+        )
+    } else {
+        prepare_statement(code)
+    };
+    if runtime == Runtime::TsNode {
+        wrap_top_level_await(&source)
+    } else {
+        source
+    }
+}
+
+/// Move `source` into an async function so `await` works at the top level,
+/// keeping its imports at module scope. Source without `await` is returned
+/// as is.
+fn wrap_top_level_await(source: &str) -> String {
+    if !source.contains("await") {
+        return source.to_string();
+    }
+    let mut imports = String::new();
+    let mut body = String::new();
+    for line in source.lines() {
+        let target = if line.starts_with("import ") {
+            &mut imports
+        } else {
+            &mut body
+        };
+        target.push_str(line);
+        target.push('\n');
+    }
+    format!(
+        "{imports}(async () => {{\n{body}}})().catch((err) => {{\n    console.error(err);\n    process.exit(1);\n}});\n"
+    )
+}
+
+/// REPL completeness on top of the shared bracket and operator checks: a
+/// declaration whose type runs onto further lines (`type Shape =` followed by
+/// `| Circle` lines, `interface A extends`, `x as`), or a decorator waiting
+/// for the class it decorates, needs more input. A blank line still forces
+/// evaluation.
+fn needs_more_input(code: &str) -> bool {
+    if has_unclosed_delimiters(code) || line_looks_incomplete(code) {
+        return true;
+    }
+    let Some(last) = code.lines().rev().map(str::trim).find(|l| !l.is_empty()) else {
+        return false;
+    };
+    if last.starts_with('@') {
+        return true;
+    }
+    // A union or intersection member; the one ending in `;` is the last.
+    if (last.starts_with('|') || last.starts_with('&')) && !last.ends_with(';') {
+        return true;
+    }
+    const TYPE_KEYWORDS: [&str; 9] = [
+        "extends",
+        "implements",
+        "keyof",
+        "typeof",
+        "as",
+        "satisfies",
+        "is",
+        "infer",
+        "readonly",
+    ];
+    last.split_whitespace()
+        .last()
+        .is_some_and(|word| TYPE_KEYWORDS.contains(&word))
 }
 
 fn strip_ansi_codes(text: &str) -> String {
@@ -180,11 +351,11 @@ fn strip_ansi_codes(text: &str) -> String {
     result
 }
 
-fn handle_deno_io<T>(result: std::io::Result<T>, binary: &Path, action: &str) -> Result<T> {
+fn handle_runtime_io<T>(result: std::io::Result<T>, binary: &Path, action: &str) -> Result<T> {
     match result {
         Ok(value) => Ok(value),
         Err(err) if err.kind() == ErrorKind::NotFound => bail!(
-            "failed to {} because '{}' was not found in PATH. Install Deno from https://deno.land/manual/getting_started/installation or ensure the binary is available on your PATH.",
+            "failed to {} because '{}' was not found in PATH. Install Bun, Deno or ts-node, or ensure the binary is available on your PATH.",
             action,
             binary.display()
         ),
@@ -195,7 +366,8 @@ fn handle_deno_io<T>(result: std::io::Result<T>, binary: &Path, action: &str) ->
 }
 
 struct TypeScriptSession {
-    deno_path: PathBuf,
+    runtime: Runtime,
+    binary: PathBuf,
     _workspace: TempDir,
     entrypoint: PathBuf,
     snippets: Vec<String>,
@@ -204,11 +376,14 @@ struct TypeScriptSession {
 }
 
 impl TypeScriptSession {
-    fn new(deno_path: PathBuf) -> Result<Self> {
-        let workspace = TempDir::new().context("failed to create TypeScript session workspace")?;
+    fn new(runtime: Runtime, binary: PathBuf) -> Result<Self> {
+        let workspace = temp_builder()
+            .tempdir()
+            .context("failed to create TypeScript session workspace")?;
         let entrypoint = workspace.path().join("session.ts");
         let session = Self {
-            deno_path,
+            runtime,
+            binary,
             _workspace: workspace,
             entrypoint,
             snippets: Vec::new(),
@@ -223,6 +398,13 @@ impl TypeScriptSession {
         "typescript"
     }
 
+    fn reset_state(&mut self) -> Result<()> {
+        self.snippets.clear();
+        self.last_stdout.clear();
+        self.last_stderr.clear();
+        self.persist_source()
+    }
+
     fn persist_source(&self) -> Result<()> {
         let source = self.render_source();
         fs::write(&self.entrypoint, source)
@@ -230,25 +412,7 @@ impl TypeScriptSession {
     }
 
     fn render_source(&self) -> String {
-        let mut source = String::from(
-            r#"const __print = (value: unknown): void => {
-    if (typeof value === "string") {
-        console.log(value);
-        return;
-    }
-    try {
-        const serialized = JSON.stringify(value, null, 2);
-        if (serialized !== undefined) {
-            console.log(serialized);
-            return;
-        }
-    } catch (_) {
-    }
-    console.log(String(value));
-};
-
-"#,
-        );
+        let mut source = String::from(PRINT_HELPER);
 
         for snippet in &self.snippets {
             source.push_str(snippet);
@@ -257,19 +421,21 @@ impl TypeScriptSession {
             }
         }
 
-        source
+        if self.runtime == Runtime::TsNode {
+            wrap_top_level_await(&source)
+        } else {
+            source
+        }
     }
 
     fn compile_and_run(&self) -> Result<std::process::Output> {
-        let mut cmd = Command::new(&self.deno_path);
-        cmd.arg("run")
-            .args(["--quiet", "--no-check", "--ext", "ts"])
-            .arg(&self.entrypoint)
-            .env("NO_COLOR", "1");
-        handle_deno_io(
-            cmd.output(),
-            &self.deno_path,
-            "run Deno for the TypeScript session",
+        let mut cmd = self
+            .runtime
+            .run_command(&self.binary, &self.entrypoint, &[]);
+        handle_runtime_io(
+            run_with_timeout(&mut cmd),
+            &self.binary,
+            "run the TypeScript session",
         )
     }
 
@@ -312,6 +478,8 @@ impl TypeScriptSession {
             stdout,
             stderr,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -332,6 +500,8 @@ impl LanguageSession for TypeScriptSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Instant::now().elapsed(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -348,6 +518,10 @@ impl LanguageSession for TypeScriptSession {
         Ok(outcome)
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -410,3 +584,67 @@ fn should_treat_as_expression(code: &str) -> bool {
     }
     true
 }
+
+#[cfg(test)]
+mod tests {
+    use std::path::Path;
+
+    use super::{Runtime, needs_more_input, prepare_snippet, wrap_top_level_await};
+
+    #[test]
+    fn override_names_pick_the_runtime() {
+        assert_eq!(
+            Runtime::for_binary(Path::new("/opt/bun/bin/bun")),
+            Runtime::Bun
+        );
+        assert_eq!(
+            Runtime::for_binary(Path::new("ts-node.cmd")),
+            Runtime::TsNode
+        );
+        assert_eq!(
+            Runtime::for_binary(Path::new("/usr/bin/deno")),
+            Runtime::Deno
+        );
+        assert_eq!(
+            Runtime::for_binary(Path::new("my-deno-wrapper")),
+            Runtime::Deno
+        );
+        assert_eq!(Runtime::Bun.label_version("1.1.8".into()), "bun 1.1.8");
+        assert_eq!(
+            Runtime::Deno.label_version("deno 2.0.0 (stable)".into()),
+            "deno 2.0.0 (stable)"
+        );
+    }
+
+    #[test]
+    fn one_shot_expressions_print_their_value() {
+        let source = prepare_snippet("await Promise.resolve(42)", Runtime::Deno);
+        assert!(source.contains("const __value = await (await Promise.resolve(42));"));
+        assert!(source.contains("if (__value !== undefined) __print(__value);"));
+
+        let statement = "const x: number = 1;\nconsole.log(x);\n";
+        assert_eq!(prepare_snippet(statement, Runtime::Bun), statement);
+    }
+
+    #[test]
+    fn ts_node_gets_an_async_wrapper_for_await() {
+        let source = wrap_top_level_await("import fs from \"fs\";\nawait tick();\n");
+        assert!(source.starts_with("import fs from \"fs\";\n(async () => {\nawait tick();\n})()"));
+        assert_eq!(wrap_top_level_await("let a = 1;\n"), "let a = 1;\n");
+        assert!(prepare_snippet("1 + 1", Runtime::TsNode).contains("(async () => {"));
+    }
+
+    #[test]
+    fn multi_line_types_keep_reading() {
+        assert!(needs_more_input("type Shape =\n"));
+        assert!(needs_more_input("type Shape =\n  | { kind: \"circle\" }\n"));
+        assert!(needs_more_input("interface Named extends\n"));
+        assert!(needs_more_input("function f<T>(x: T): x is\n"));
+        assert!(needs_more_input("@sealed\n"));
+        assert!(needs_more_input("let v: Array<{\n  id: number;\n"));
+        assert!(!needs_more_input("type Id = string | number;\n"));
+        assert!(!needs_more_input("type Shape =\n  | Circle\n  | Square;\n"));
+        assert!(!needs_more_input("type Both =\n  & A\n  & B;\n"));
+        assert!(!needs_more_input("const total = items.length;\n"));
+    }
+}
@@ -4,11 +4,12 @@ use std::process::{Command, Stdio};
 use std::time::{Duration, Instant};
 
 use anyhow::{Context, Result};
-use tempfile::{Builder, TempDir};
+use tempfile::TempDir;
 
 use super::{
-    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, cache_store, hash_source,
-    run_version_command, try_cached_execution,
+    ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageSession, SnippetLines,
+    binary_override, build_step_with_timeout, cache_store, child_stdin, compile_cache_key,
+    run_version_command, run_with_timeout, temp_builder, toolchain_flags, try_cached_execution,
 };
 
 pub struct ZigEngine {
@@ -36,8 +37,14 @@ impl ZigEngine {
         })
     }
 
+    /// Compile-cache key for `source`, tied to this compiler's version.
+    fn cache_key(&self, source: &str) -> Result<u64> {
+        let compiler = self.ensure_executable()?;
+        Ok(compile_cache_key(self.id(), compiler, "version", source))
+    }
+
     fn write_temp_source(&self, code: &str) -> Result<(TempDir, PathBuf)> {
-        let dir = Builder::new()
+        let dir = temp_builder()
             .prefix("run-zig")
             .tempdir()
             .context("failed to create temporary directory for Zig source")?;
@@ -56,17 +63,18 @@ impl ZigEngine {
         let executable = self.ensure_executable()?;
         let mut cmd = Command::new(executable);
         cmd.arg("run")
+            .args(toolchain_flags())
             .arg(source)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
         if !args.is_empty() {
             cmd.arg("--").args(args);
         }
-        cmd.stdin(Stdio::inherit());
+        cmd.stdin(child_stdin());
         if let Some(dir) = source.parent() {
             cmd.current_dir(dir);
         }
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} with source {}",
                 executable.display(),
@@ -74,6 +82,25 @@ impl ZigEngine {
             )
         })
     }
+
+    fn build_binary(&self, source: &Path) -> Result<std::process::Output> {
+        let executable = self.ensure_executable()?;
+        let dir = source.parent().unwrap_or(Path::new("."));
+        let mut cmd = Command::new(executable);
+        cmd.arg("build-exe")
+            .args(toolchain_flags())
+            .arg(source)
+            .arg(format!("-femit-bin={}", dir.join("snippet").display()))
+            .stdin(Stdio::null())
+            .current_dir(dir);
+        build_step_with_timeout(&mut cmd).with_context(|| {
+            format!(
+                "failed to invoke {} build-exe for {}",
+                executable.display(),
+                source.display()
+            )
+        })
+    }
 }
 
 impl LanguageEngine for ZigEngine {
@@ -111,142 +138,117 @@ impl LanguageEngine for ZigEngine {
         let mut cmd = Command::new(executable);
         cmd.arg("version");
         let context = format!("{}", executable.display());
-        run_version_command(cmd, &context)
+        let version = run_version_command(cmd, &context)?;
+        Ok(version.map(|line| {
+            if line.starts_with(|c: char| c.is_ascii_digit()) {
+                format!("zig {line}")
+            } else {
+                line
+            }
+        }))
+    }
+
+    fn binary_path(&self) -> Option<PathBuf> {
+        self.executable.clone()
     }
 
     fn execute(&self, payload: &ExecutionPayload) -> Result<ExecutionOutcome> {
         let args = payload.args();
-
-        // Try cache for inline/stdin payloads
-        if let Some(code) = match payload {
-            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
-                Some(code.as_str())
-            }
-            _ => None,
-        } {
-            let snippet = wrap_inline_snippet(code);
-            let src_hash = hash_source(&snippet);
-            if let Some(output) = try_cached_execution("zig", src_hash) {
-                let start = Instant::now();
-                let mut stdout = String::from_utf8_lossy(&output.stdout).into_owned();
-                let stderr = String::from_utf8_lossy(&output.stderr).into_owned();
-                if output.status.success() && !stderr.contains("error:") {
-                    if stdout.is_empty() {
-                        stdout = stderr.clone();
-                    } else if !stderr.is_empty() {
-                        stdout.push_str(&stderr);
-                    }
-                }
-                return Ok(ExecutionOutcome {
-                    language: self.id().to_string(),
-                    exit_code: output.status.code(),
-                    stdout,
-                    stderr: if output.status.success() && !stderr.contains("error:") {
-                        String::new()
-                    } else {
-                        stderr
-                    },
-                    duration: start.elapsed(),
-                });
-            }
-        }
-
         let start = Instant::now();
-        let (temp_dir, source_path, cache_key) = match payload {
-            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => {
-                let snippet = wrap_inline_snippet(code);
-                let h = hash_source(&snippet);
-                let (dir, path) = self.write_temp_source(&snippet)?;
-                (Some(dir), path, Some(h))
-            }
+
+        let code = match payload {
+            ExecutionPayload::Inline { code, .. } | ExecutionPayload::Stdin { code, .. } => code,
             ExecutionPayload::File { path, .. } => {
-                if path.extension().and_then(|e| e.to_str()) != Some("zig") {
-                    let code = std::fs::read_to_string(path)?;
-                    let (dir, new_path) = self.write_temp_source(&code)?;
-                    (Some(dir), new_path, None)
-                } else {
-                    (None, path.clone(), None)
+                if path.extension().and_then(|e| e.to_str()) == Some("zig") {
+                    let output = self.run_source(path, args)?;
+                    return Ok(outcome_from_output(self.id(), &output, start));
                 }
+                let code = std::fs::read_to_string(path)?;
+                let (_dir, source_path) = self.write_temp_source(&code)?;
+                let output = self.run_source(&source_path, args)?;
+                return Ok(outcome_from_output(self.id(), &output, start));
             }
         };
 
-        // For cacheable code, try zig build-exe + cache
-        if let Some(h) = cache_key {
-            let executable = self.ensure_executable()?;
-            let dir = source_path.parent().unwrap_or(std::path::Path::new("."));
-            let bin_path = dir.join("snippet");
-            let mut build_cmd = Command::new(executable);
-            build_cmd
-                .arg("build-exe")
-                .arg(&source_path)
-                .arg("-femit-bin=snippet")
-                .stdout(Stdio::piped())
-                .stderr(Stdio::piped())
-                .current_dir(dir);
-
-            if let Ok(build_output) = build_cmd.output()
-                && build_output.status.success()
-                && bin_path.exists()
-            {
-                cache_store("zig", h, &bin_path);
-                let mut run_cmd = Command::new(&bin_path);
-                run_cmd
-                    .args(args)
-                    .stdout(Stdio::piped())
-                    .stderr(Stdio::piped())
-                    .stdin(Stdio::inherit());
-                if let Ok(output) = run_cmd.output() {
-                    drop(temp_dir);
-                    let mut stdout = String::from_utf8_lossy(&output.stdout).into_owned();
-                    let stderr = String::from_utf8_lossy(&output.stderr).into_owned();
-                    if output.status.success() && !stderr.contains("error:") {
-                        if stdout.is_empty() {
-                            stdout = stderr.clone();
-                        } else if !stderr.is_empty() {
-                            stdout.push_str(&stderr);
-                        }
-                    }
-                    return Ok(ExecutionOutcome {
-                        language: self.id().to_string(),
-                        exit_code: output.status.code(),
-                        stdout,
-                        stderr: if output.status.success() && !stderr.contains("error:") {
-                            String::new()
-                        } else {
-                            stderr
-                        },
-                        duration: start.elapsed(),
-                    });
-                }
-            }
+        // Snippets are compiled with build-exe so the binary can be cached. The
+        // expression-aware wrapper is tried first; if it does not compile the
+        // snippet is retried as plain statements inside main.
+        let mut candidates = vec![wrap_inline_snippet(code)];
+        let plain = wrap_plain_snippet(code);
+        if plain != candidates[0] {
+            candidates.push(plain);
         }
+        // Line numbers are mapped here rather than through `snippet_lines`,
+        // since only this loop knows which candidate produced the output.
+        let remap = |snippet: &str, mut outcome: ExecutionOutcome| {
+            if let Some(lines) = SnippetLines::new("snippet.zig", code, snippet) {
+                lines.apply(&mut outcome);
+            }
+            outcome
+        };
 
-        // Fallback to zig run
-        let output = self.run_source(&source_path, args)?;
-        drop(temp_dir);
-
-        let mut combined_stdout = String::from_utf8_lossy(&output.stdout).into_owned();
-        let stderr_str = String::from_utf8_lossy(&output.stderr).into_owned();
+        let mut compile_failure = None;
+        let mut compile_duration = Duration::ZERO;
+        for snippet in &candidates {
+            let src_hash = self.cache_key(snippet)?;
+            if let Some(output) = try_cached_execution("zig", src_hash, args) {
+                return Ok(remap(
+                    snippet,
+                    outcome_from_output(self.id(), &output, start),
+                ));
+            }
 
-        if output.status.success() && !stderr_str.contains("error:") {
-            if !combined_stdout.is_empty() && !stderr_str.is_empty() {
-                combined_stdout.push_str(&stderr_str);
-            } else if combined_stdout.is_empty() {
-                combined_stdout = stderr_str.clone();
+            let (temp_dir, source_path) = self.write_temp_source(snippet)?;
+            let build_start = Instant::now();
+            let build_output = match self.build_binary(&source_path) {
+                Ok(output) => output,
+                Err(_) => {
+                    // build-exe could not be spawned; let `zig run` report it.
+                    let output = self.run_source(&source_path, args)?;
+                    return Ok(remap(
+                        snippet,
+                        outcome_from_output(self.id(), &output, start),
+                    ));
+                }
+            };
+            compile_duration += build_start.elapsed();
+            let bin_path = temp_dir.path().join("snippet");
+            if !build_output.status.success() || !bin_path.exists() {
+                compile_failure.get_or_insert((snippet, build_output));
+                continue;
             }
+
+            cache_store("zig", src_hash, &bin_path);
+            let mut run_cmd = Command::new(&bin_path);
+            run_cmd.args(args).stdin(child_stdin());
+            let output = run_with_timeout(&mut run_cmd)
+                .with_context(|| format!("failed to execute {}", bin_path.display()))?;
+            let mut outcome = outcome_from_output(self.id(), &output, start);
+            outcome.compile_duration = Some(compile_duration);
+            return Ok(remap(snippet, outcome));
         }
 
-        Ok(ExecutionOutcome {
+        // Neither shape compiled. The first candidate's errors are reported:
+        // the plain wrapper is only a fallback, and its errors (a missing `;`
+        // after a bare expression, say) are about code the user did not write.
+        let (snippet, build_output) =
+            compile_failure.context("Zig snippet produced no build output")?;
+        let outcome = ExecutionOutcome {
             language: self.id().to_string(),
-            exit_code: output.status.code(),
-            stdout: combined_stdout,
-            stderr: if output.status.success() && !stderr_str.contains("error:") {
-                String::new()
-            } else {
-                stderr_str
-            },
+            exit_code: build_output.status.code(),
+            stdout: String::new(),
+            stderr: String::new(),
             duration: start.elapsed(),
-        })
+            compile_duration: Some(compile_duration),
+            compile_stderr: Some(String::from_utf8_lossy(&build_output.stderr).into_owned()),
+        };
+        Ok(remap(snippet, outcome))
+    }
+
+    fn starter_program(&self, _name: &str) -> Option<String> {
+        Some(wrap_plain_snippet(
+            "std.debug.print(\"Hello, world!\\n\", .{});",
+        ))
     }
 
     fn start_session(&self) -> Result<Box<dyn LanguageSession>> {
@@ -256,6 +258,9 @@ impl LanguageEngine for ZigEngine {
 }
 
 fn resolve_zig_binary() -> Option<PathBuf> {
+    if let Some(path) = binary_override("zig") {
+        return Some(path);
+    }
     which::which("zig").ok()
 }
 
@@ -264,29 +269,161 @@ const ZIG_NUMERIC_SUFFIXES: [&str; 17] = [
     "u16", "i16", "f16", "u8", "i8",
 ];
 
+/// Zig's `std.debug.print` writes to stderr, so a successful run's stderr is
+/// folded into stdout; stderr is only kept when the run failed or the
+/// compiler reported an error.
+fn outcome_from_output(
+    language: &str,
+    output: &std::process::Output,
+    start: Instant,
+) -> ExecutionOutcome {
+    let mut stdout = String::from_utf8_lossy(&output.stdout).into_owned();
+    let stderr = String::from_utf8_lossy(&output.stderr).into_owned();
+    let clean = output.status.success() && !stderr.contains("error:");
+    if clean {
+        stdout.push_str(&stderr);
+    }
+    ExecutionOutcome {
+        language: language.to_string(),
+        exit_code: output.status.code(),
+        stdout,
+        stderr: if clean { String::new() } else { stderr },
+        duration: start.elapsed(),
+        compile_duration: None,
+        compile_stderr: None,
+    }
+}
+
+/// Builds a program from a snippet without `pub fn main`: function, type and
+/// import declarations stay at container level, everything else goes into a
+/// synthesized `main`, and a trailing expression has its value printed.
 fn wrap_inline_snippet(code: &str) -> String {
     let trimmed = code.trim();
     if trimmed.is_empty() || trimmed.contains("pub fn main") {
-        let mut owned = code.to_string();
-        if !owned.ends_with('\n') {
-            owned.push('\n');
+        return ensure_trailing_newline(code);
+    }
+
+    let normalized = normalize_snippet(code);
+    let mut items = String::new();
+    let mut body: Vec<String> = Vec::new();
+    for chunk in split_top_level_chunks(&normalized) {
+        if is_container_item(&chunk) {
+            items.push_str(&chunk);
+            items.push('\n');
+        } else {
+            body.push(chunk);
         }
-        return owned;
     }
 
-    let mut body = String::new();
+    if let Some(last) = body.last_mut()
+        && matches!(classify_snippet(last.trim()), ZigSnippetKind::Expression)
+    {
+        *last = wrap_expression(last.trim());
+    }
+
+    let mut source = String::new();
+    if !declares_std(&items) {
+        source.push_str("const std = @import(\"std\");\n\n");
+    }
+    source.push_str(&items);
+    source.push_str("pub fn main() !void {\n");
+    push_indented(&mut source, &body.join("\n"));
+    source.push_str("}\n");
+    source
+}
+
+/// The raw fallback: the snippet's lines placed verbatim inside `main`.
+fn wrap_plain_snippet(code: &str) -> String {
+    let trimmed = code.trim();
+    if trimmed.is_empty() || trimmed.contains("pub fn main") {
+        return ensure_trailing_newline(code);
+    }
+
+    let mut source = String::from("const std = @import(\"std\");\n\npub fn main() !void {\n");
+    push_indented(&mut source, code);
+    source.push_str("}\n");
+    source
+}
+
+fn push_indented(source: &mut String, code: &str) {
     for line in code.lines() {
-        body.push_str("    ");
-        body.push_str(line);
-        if !line.ends_with('\n') {
-            body.push('\n');
+        source.push_str("    ");
+        source.push_str(line);
+        source.push('\n');
+    }
+}
+
+/// Splits source into chunks that each start at brace depth zero, so a
+/// multi-line `fn` or `struct` stays together with its body.
+fn split_top_level_chunks(code: &str) -> Vec<String> {
+    let mut chunks: Vec<String> = Vec::new();
+    let mut current = String::new();
+    let mut depth = 0i32;
+    for line in code.lines() {
+        if depth <= 0 && !current.trim().is_empty() && !line.trim().is_empty() {
+            chunks.push(std::mem::take(&mut current));
+        }
+        if !current.is_empty() {
+            current.push('\n');
         }
+        current.push_str(line);
+        depth += brace_delta(line);
     }
-    if body.is_empty() {
-        body.push_str("    const stdout = std.io.getStdOut().writer(); _ = stdout.print(\"\\n\", .{}) catch {};\n");
+    if !current.trim().is_empty() {
+        chunks.push(current);
     }
+    chunks
+}
+
+fn brace_delta(line: &str) -> i32 {
+    let code = line.split("//").next().unwrap_or("");
+    let mut delta = 0;
+    let mut in_string = false;
+    let mut escape = false;
+    for ch in code.chars() {
+        if escape {
+            escape = false;
+            continue;
+        }
+        match ch {
+            '\\' if in_string => escape = true,
+            '"' => in_string = !in_string,
+            '{' if !in_string => delta += 1,
+            '}' if !in_string => delta -= 1,
+            _ => {}
+        }
+    }
+    delta
+}
 
-    format!("const std = @import(\"std\");\n\npub fn main() !void {{\n{body}}}\n")
+fn is_container_item(chunk: &str) -> bool {
+    let t = chunk.trim_start();
+    if t.starts_with("fn ")
+        || t.starts_with("pub ")
+        || t.starts_with("test ")
+        || t.starts_with("usingnamespace ")
+        || t.starts_with("extern ")
+    {
+        return true;
+    }
+    let Some(rest) = t.strip_prefix("const ") else {
+        return false;
+    };
+    let Some((_, value)) = rest.split_once('=') else {
+        return false;
+    };
+    let value = value.trim_start();
+    [
+        "struct", "enum", "union", "opaque", "error{", "error {", "@import(",
+    ]
+    .iter()
+    .any(|prefix| value.starts_with(prefix))
+}
+
+fn declares_std(items: &str) -> bool {
+    items
+        .lines()
+        .any(|line| line.trim_start().starts_with("const std ="))
 }
 
 struct ZigSession {
@@ -306,7 +443,9 @@ enum ZigSnippetKind {
 
 impl ZigSession {
     fn new(executable: PathBuf) -> Result<Self> {
-        let workspace = TempDir::new().context("failed to create Zig session workspace")?;
+        let workspace = temp_builder()
+            .tempdir()
+            .context("failed to create Zig session workspace")?;
         let session = Self {
             executable,
             workspace,
@@ -364,7 +503,7 @@ impl ZigSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        cmd.output().with_context(|| {
+        run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Zig session",
                 self.executable.display()
@@ -388,35 +527,14 @@ impl ZigSession {
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
             .current_dir(self.workspace.path());
-        let output = cmd.output().with_context(|| {
+        let output = run_with_timeout(&mut cmd).with_context(|| {
             format!(
                 "failed to execute {} for Zig standalone snippet",
                 self.executable.display()
             )
         })?;
 
-        let mut stdout = Self::normalize_output(&output.stdout);
-        let stderr = Self::normalize_output(&output.stderr);
-
-        if output.status.success() && !stderr.contains("error:") {
-            if stdout.is_empty() {
-                stdout = stderr.clone();
-            } else {
-                stdout.push_str(&stderr);
-            }
-        }
-
-        Ok(ExecutionOutcome {
-            language: self.language_id().to_string(),
-            exit_code: output.status.code(),
-            stdout,
-            stderr: if output.status.success() && !stderr.contains("error:") {
-                String::new()
-            } else {
-                stderr
-            },
-            duration: start.elapsed(),
-        })
+        Ok(outcome_from_output(self.language_id(), &output, start))
     }
 
     fn run_current(&mut self, start: Instant) -> Result<(ExecutionOutcome, bool)> {
@@ -456,6 +574,8 @@ impl ZigSession {
             stdout,
             stderr,
             duration: start.elapsed(),
+            compile_duration: None,
+            compile_stderr: None,
         };
 
         Ok((outcome, success))
@@ -503,7 +623,7 @@ impl ZigSession {
         Ok((outcome, success))
     }
 
-    fn reset(&mut self) -> Result<()> {
+    fn reset_state(&mut self) -> Result<()> {
         self.items.clear();
         self.statements.clear();
         self.last_stdout.clear();
@@ -539,17 +659,21 @@ impl LanguageSession for ZigSession {
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
         if trimmed.eq_ignore_ascii_case(":reset") {
-            self.reset()?;
+            self.reset_state()?;
             return Ok(ExecutionOutcome {
                 language: self.language_id().to_string(),
                 exit_code: None,
                 stdout: String::new(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -562,6 +686,8 @@ impl LanguageSession for ZigSession {
                         .to_string(),
                 stderr: String::new(),
                 duration: Duration::default(),
+                compile_duration: None,
+                compile_stderr: None,
             });
         }
 
@@ -585,6 +711,10 @@ impl LanguageSession for ZigSession {
         }
     }
 
+    fn reset(&mut self) -> Result<()> {
+        self.reset_state()
+    }
+
     fn shutdown(&mut self) -> Result<()> {
         Ok(())
     }
@@ -634,7 +764,12 @@ fn ensure_trailing_newline(code: &str) -> String {
 }
 
 fn wrap_expression(code: &str) -> String {
-    format!("std.debug.print(\"{{any}}\\n\", .{{ {} }});", code)
+    let spec = if code.starts_with('"') && code.ends_with('"') {
+        "s"
+    } else {
+        "any"
+    };
+    format!("std.debug.print(\"{{{spec}}}\\n\", .{{ {code} }});")
 }
 
 fn normalize_snippet(code: &str) -> String {
@@ -863,3 +998,49 @@ fn is_identifier_char(bytes: &[u8], index: usize) -> bool {
     let ch = bytes[index] as char;
     ch.is_ascii_alphanumeric() || ch == '_'
 }
+
+#[cfg(test)]
+mod tests {
+    use super::{SnippetLines, wrap_inline_snippet, wrap_plain_snippet};
+
+    #[test]
+    fn trailing_expression_is_printed() {
+        let source = wrap_inline_snippet("const x: i32 = 2;\nx * 21");
+        assert!(source.starts_with("const std = @import(\"std\");"));
+        assert!(source.contains("    const x: i32 = 2;\n"));
+        assert!(source.contains("std.debug.print(\"{any}\\n\", .{ x * 21 });"));
+    }
+
+    #[test]
+    fn functions_are_hoisted_out_of_main() {
+        let source = wrap_inline_snippet(
+            "const std = @import(\"std\");\nfn add(a: i32, b: i32) i32 {\n    return a + b;\n}\nadd(1, 2)",
+        );
+        assert_eq!(source.matches("@import(\"std\")").count(), 1);
+        let main_at = source.find("pub fn main").unwrap();
+        assert!(source.find("fn add").unwrap() < main_at);
+        assert!(source[main_at..].contains(".{ add(1, 2) }"));
+    }
+
+    #[test]
+    fn programs_with_main_are_left_alone() {
+        let program = "const std = @import(\"std\");\npub fn main() void {}\n";
+        assert_eq!(wrap_inline_snippet(program), program);
+        assert_eq!(wrap_plain_snippet(program), program);
+    }
+
+    #[test]
+    fn diagnostics_point_at_snippet_lines() {
+        let code = "var x: i32 = 1;\nx += undefined_name;";
+        let lines = SnippetLines::new("snippet.zig", code, &wrap_plain_snippet(code))
+            .expect("wrapped snippet moves lines");
+        assert_eq!(
+            lines.remap("/tmp/run-zig/snippet.zig:5:10: error: use of undeclared identifier\n"),
+            "/tmp/run-zig/snippet.zig:2:10: error: use of undeclared identifier\n"
+        );
+
+        let wrapper = lines.remap("snippet.zig:6:1: error: expected '}'\n");
+        assert!(wrapper.starts_with("snippet.zig:6:1: error"), "{wrapper}");
+        assert!(wrapper.contains("your line 1 is line 4"), "{wrapper}");
+    }
+}
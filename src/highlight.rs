@@ -41,6 +41,7 @@ fn language_to_syntax_name(language_id: &str) -> &str {
         "crystal" | "cr" => "Crystal",
         "zig" => "Zig",
         "nim" => "Nim",
+        "sql" | "sqlite" => "SQL",
         _ => "Plain Text",
     }
 }
@@ -109,6 +109,9 @@ static ALIASES: Lazy<HashMap<&'static str, &'static str>> = Lazy::new(|| {
         ("crystal-lang", "crystal"),
         ("nim", "nim"),
         ("nimlang", "nim"),
+        ("sql", "sql"),
+        ("sqlite", "sql"),
+        ("sqlite3", "sql"),
     ];
     pairs.iter().cloned().collect()
 });
@@ -1,3 +1,4 @@
+pub mod api;
 pub mod app;
 pub mod cli;
 pub mod config;
@@ -9,5 +10,8 @@ pub mod output;
 pub mod repl;
 pub mod version;
 
+pub use api::{Engine, Request, engine_for, engines, run};
+pub use engine::{ExecutionOutcome, OutputStream};
+
 #[cfg(feature = "v2")]
 pub mod v2;
@@ -369,8 +369,20 @@ fn main() -> Result<()> {
     let config = run::config::RunConfig::discover();
     config.apply_env();
 
-    let command = run::cli::parse()?;
-    let exit_code = run::app::run(command)?;
+    let command = match run::cli::parse() {
+        Ok(command) => command,
+        Err(err) => {
+            eprintln!("Error: {err:?}");
+            std::process::exit(run::app::USAGE_EXIT_CODE);
+        }
+    };
+    let exit_code = match run::app::run(command) {
+        Ok(code) => code,
+        Err(err) => {
+            eprintln!("Error: {err:?}");
+            std::process::exit(run::app::exit_code_for_error(&err));
+        }
+    };
     if exit_code != 0 {
         std::process::exit(exit_code);
     }
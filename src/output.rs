@@ -1,5 +1,74 @@
+use std::io::{IsTerminal, Write};
+
 use regex::Regex;
 
+/// `--color` setting, passed to the rest of the process as `RUN_COLOR`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, clap::ValueEnum, serde::Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum ColorChoice {
+    /// Color a stream only when it is a terminal and `NO_COLOR` is unset.
+    #[default]
+    Auto,
+    Always,
+    Never,
+}
+
+impl ColorChoice {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            ColorChoice::Auto => "auto",
+            ColorChoice::Always => "always",
+            ColorChoice::Never => "never",
+        }
+    }
+
+    fn from_env() -> Self {
+        match std::env::var("RUN_COLOR").as_deref() {
+            Ok("always") => ColorChoice::Always,
+            Ok("never") => ColorChoice::Never,
+            _ => ColorChoice::Auto,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Stream {
+    Stdout,
+    Stderr,
+}
+
+/// Whether escape codes should be written to `stream`.
+pub fn color_enabled(stream: Stream) -> bool {
+    match ColorChoice::from_env() {
+        ColorChoice::Always => true,
+        ColorChoice::Never => false,
+        ColorChoice::Auto => {
+            if std::env::var_os("NO_COLOR").is_some_and(|value| !value.is_empty()) {
+                return false;
+            }
+            match stream {
+                Stream::Stdout => std::io::stdout().is_terminal(),
+                Stream::Stderr => std::io::stderr().is_terminal(),
+            }
+        }
+    }
+}
+
+/// Wrap `text` in the SGR `style` (e.g. `"31"` for red) when `stream` is
+/// colored. Each line is wrapped on its own so the color does not bleed
+/// into output the other stream writes in between.
+pub fn paint(stream: Stream, style: &str, text: &str) -> String {
+    if !color_enabled(stream) || text.is_empty() {
+        return text.to_string();
+    }
+    text.split_inclusive('\n')
+        .map(|line| match line.strip_suffix('\n') {
+            Some(body) => format!("\x1b[{style}m{body}\x1b[0m\n"),
+            None => format!("\x1b[{style}m{line}\x1b[0m"),
+        })
+        .collect()
+}
+
 pub fn format_stderr(language: &str, stderr: &str, success: bool) -> String {
     if stderr.trim().is_empty() {
         return String::new();
@@ -32,7 +101,9 @@ fn scrub_temp_paths(stderr: &str) -> (String, bool) {
         }
     }
 
-    let re = Regex::new(r"<temp>/?(?:run-[^/]+|\.tmp[^/]+|run-[^/]+[^/]*)/").unwrap();
+    // Scratch directories sit inside the invocation's `run-<pid>-*` directory.
+    let re =
+        Regex::new(r"<temp>/?(?:run-\d+-[^/]+/)?(?:run-[^/]+|\.tmp[^/]+|run-[^/]+[^/]*)/").unwrap();
     if re.is_match(&output) {
         output = re.replace_all(&output, "<snippet>/").to_string();
         changed = true;
@@ -46,3 +117,31 @@ fn scrub_temp_paths(stderr: &str) -> (String, bool) {
 
     (output, changed)
 }
+
+/// Stderr writer for a program's streamed stderr: each chunk is scrubbed
+/// and painted like [`format_stderr`] output (without its "failed" header,
+/// since the run has not finished). A UTF-8 sequence split across chunks is
+/// held back until the rest of it arrives.
+#[derive(Debug, Default)]
+pub struct ProgramStderr {
+    pending: Vec<u8>,
+}
+
+impl Write for ProgramStderr {
+    fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+        self.pending.extend_from_slice(buf);
+        let complete = match std::str::from_utf8(&self.pending) {
+            Ok(_) => self.pending.len(),
+            Err(err) if err.error_len().is_none() => err.valid_up_to(),
+            Err(_) => self.pending.len(),
+        };
+        let chunk: Vec<u8> = self.pending.drain(..complete).collect();
+        let (scrubbed, _) = scrub_temp_paths(&String::from_utf8_lossy(&chunk));
+        std::io::stderr().write_all(paint(Stream::Stderr, "31", &scrubbed).as_bytes())?;
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> std::io::Result<()> {
+        std::io::stderr().flush()
+    }
+}
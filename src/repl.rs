@@ -1,6 +1,6 @@
 use std::borrow::Cow;
 use std::collections::{BTreeMap, HashMap, HashSet};
-use std::io::Write;
+use std::io::{self, BufRead, IsTerminal, Write};
 use std::path::{Path, PathBuf};
 use std::process::{Command, Stdio};
 use std::time::Instant;
@@ -15,16 +15,23 @@ use rustyline::validate::Validator;
 use rustyline::{Editor, Helper};
 
 use crate::engine::{
-    ExecutionOutcome, ExecutionPayload, LanguageRegistry, LanguageSession, build_install_command,
+    ExecutionOutcome, ExecutionPayload, InterruptMode, LanguageEngine, LanguageRegistry,
+    LanguageSession, build_install_command, clear_interrupt, interrupt_exit_code, interrupted,
+    is_python_block_header, set_interrupt_mode, terminated,
 };
 use crate::highlight;
 use crate::language::LanguageSpec;
-use crate::output;
+use crate::output::{self, Stream};
 
-const HISTORY_FILE: &str = ".run_history";
 const BOOKMARKS_FILE: &str = ".run_bookmarks";
 const REPL_CONFIG_FILE: &str = ".run_repl_config";
 const MAX_DIR_STACK: usize = 20;
+/// Default line that forces evaluation of a pending multi-line entry.
+const DEFAULT_TERMINATOR: &str = ";;";
+/// Entries kept per history file unless `RUN_HISTORY_SIZE` says otherwise.
+const DEFAULT_HISTORY_SIZE: usize = 1000;
+/// History file used by every language when `RUN_HISTORY_SHARED` is set.
+const SHARED_HISTORY: &str = "shared";
 
 /// Exception/stderr display mode for the REPL.
 #[derive(Clone, Copy, PartialEq, Eq)]
@@ -84,6 +91,7 @@ const META_COMMANDS: &[&str] = &[
     ":env",
     ":last",
     ":load ",
+    ":reload",
     ":edit",
     ":edit ",
     ":run ",
@@ -136,6 +144,7 @@ const CMD_HELP: &[(&str, &str)] = &[
     ("bookmark", "Save bookmark; -l list, -d <name> delete"),
     ("env", "List env, get VAR, or set VAR=val"),
     ("load", "Load and execute a file or http(s) URL"),
+    ("reload", "Load the last :load target again"),
     ("last", "Print last execution stdout"),
     ("edit", "Open $EDITOR; on save, execute in current session"),
     ("run", "Load file/URL or run macro by name"),
@@ -699,30 +708,57 @@ pub fn run_repl(
     detect_enabled: bool,
 ) -> Result<i32> {
     let helper = ReplHelper::new(initial_language.canonical_id().to_string());
-    let mut editor = Editor::<ReplHelper, DefaultHistory>::new()?;
+    let config = rustyline::Config::builder()
+        .max_history_size(history_size())?
+        .build();
+    let mut editor = Editor::<ReplHelper, DefaultHistory>::with_config(config)?;
     editor.set_helper(Some(helper));
-
-    if let Some(path) = history_path() {
-        let _ = editor.load_history(&path);
-    }
+    // Piped input (a heredoc in CI, say) is read line by line without the
+    // line editor: no banner, prompts or timings, so the output is only what
+    // the entries print, and scripted entries stay out of the history file.
+    let interactive = io::stdin().is_terminal();
+    let mut history = if interactive {
+        HistoryStore::from_env()
+    } else {
+        None
+    };
 
     let lang_count = registry.known_languages().len();
     let mut state = ReplState::new(initial_language, registry, detect_enabled)?;
-
-    println!(
-        "\x1b[1mrun\x1b[0m \x1b[2mv{} — {}+ languages. Type :help for commands.\x1b[0m",
-        env!("CARGO_PKG_VERSION"),
-        lang_count
-    );
+    state.interactive = interactive;
+
+    // Ctrl-C while an interactive entry runs stops that entry instead of run
+    // itself; at the prompt the line editor reports it as `Interrupted`.
+    // Piped input is a script, so there it ends the session, as SIGTERM and
+    // SIGHUP always do. Either way the loop returns and run cleans up.
+    set_interrupt_mode(if interactive {
+        InterruptMode::Resume
+    } else {
+        InterruptMode::Return
+    });
+    let stopped = || terminated() || (!interactive && interrupted());
+    if interactive {
+        println!(
+            "\x1b[1mrun\x1b[0m \x1b[2mv{} — {}+ languages. Type :help for commands.\x1b[0m",
+            env!("CARGO_PKG_VERSION"),
+            lang_count
+        );
+    }
     let mut pending: Option<PendingInput> = None;
+    // Set by a Ctrl-C at an empty prompt; a second one in a row exits.
+    let mut exit_armed = false;
 
     loop {
+        if stopped() {
+            break;
+        }
         let prompt = match &pending {
             Some(p) => p.prompt(),
             None => state.prompt(),
         };
         let mut pending_indent: Option<String> = None;
         if let Some(p) = pending.as_ref()
+            && interactive
             && state.current_language().canonical_id() == "python"
         {
             let indent = python_prompt_indent(p.buffer());
@@ -734,12 +770,22 @@ pub fn run_repl(
         if let Some(helper) = editor.helper_mut() {
             helper.update_language(state.current_language().canonical_id().to_string());
         }
+        if let Some(history) = history.as_mut() {
+            history.switch_to(&mut editor, state.current_language().canonical_id());
+        }
 
-        let line_result = match pending_indent.as_deref() {
-            Some(indent) => editor.readline_with_initial(&prompt, (indent, "")),
-            None => editor.readline(&prompt),
+        let line_result = if !interactive {
+            read_piped_line()
+        } else {
+            match pending_indent.as_deref() {
+                Some(indent) => editor.readline_with_initial(&prompt, (indent, "")),
+                None => editor.readline(&prompt),
+            }
         };
 
+        if !matches!(line_result, Err(ReadlineError::Interrupted)) {
+            exit_armed = false;
+        }
         match line_result {
             Ok(line) => {
                 let raw = line.trim_end_matches(['\r', '\n']);
@@ -750,12 +796,20 @@ pub fn run_repl(
                         continue;
                     }
 
-                    p.push_line_auto_with_indent(
-                        state.current_language().canonical_id(),
-                        raw,
-                        pending_indent.as_deref(),
-                    );
-                    if p.needs_more_input(state.current_language().canonical_id()) {
+                    // A blank line or the configured terminator evaluates the
+                    // buffer even when the engine thinks it is still open.
+                    let is_terminator = raw.trim() == state.terminator;
+                    let forced = is_terminator || raw.trim().is_empty();
+                    if !is_terminator && !interactive {
+                        p.push_line(raw);
+                    } else if !is_terminator {
+                        p.push_line_auto_with_indent(
+                            state.current_language().canonical_id(),
+                            raw,
+                            pending_indent.as_deref(),
+                        );
+                    }
+                    if !forced && state.needs_more_input(p) {
                         continue;
                     }
 
@@ -763,10 +817,12 @@ pub fn run_repl(
                     pending = None;
                     let trimmed = code.trim_end();
                     if !trimmed.is_empty() {
-                        let _ = editor.add_history_entry(trimmed);
+                        remember(&mut editor, history.as_ref(), trimmed);
                         state.history_entries.push(trimmed.to_string());
                         state.log_input(trimmed);
-                        state.execute_snippet(trimmed)?;
+                        if let Err(e) = state.execute_snippet(trimmed) {
+                            println!("\x1b[31m[run]\x1b[0m {e}");
+                        }
                         if let Some(helper) = editor.helper_mut() {
                             helper.update_session_vars(state.session_var_names());
                         }
@@ -783,7 +839,7 @@ pub fn run_repl(
                         let lines = state.paste_buffer.take().unwrap();
                         let code = strip_paste_prompts(&lines);
                         if !code.trim().is_empty() {
-                            let _ = editor.add_history_entry(code.trim());
+                            remember(&mut editor, history.as_ref(), code.trim());
                             state.history_entries.push(code.trim().to_string());
                             state.log_input(code.trim());
                             if let Err(e) = state.execute_snippet(code.trim()) {
@@ -802,7 +858,7 @@ pub fn run_repl(
 
                 if raw.trim_start().starts_with(':') {
                     let trimmed = raw.trim();
-                    let _ = editor.add_history_entry(trimmed);
+                    remember(&mut editor, history.as_ref(), trimmed);
                     state.log_input(trimmed);
                     if state.handle_meta(trimmed)? {
                         break;
@@ -812,30 +868,40 @@ pub fn run_repl(
 
                 let mut p = PendingInput::new();
                 p.push_line(raw);
-                if p.needs_more_input(state.current_language().canonical_id()) {
+                if state.needs_more_input(&p) {
                     pending = Some(p);
                     continue;
                 }
 
                 let trimmed = raw.trim_end();
-                let _ = editor.add_history_entry(trimmed);
+                remember(&mut editor, history.as_ref(), trimmed);
                 state.history_entries.push(trimmed.to_string());
                 state.log_input(trimmed);
-                state.execute_snippet(trimmed)?;
+                if let Err(e) = state.execute_snippet(trimmed) {
+                    println!("\x1b[31m[run]\x1b[0m {e}");
+                }
                 if let Some(helper) = editor.helper_mut() {
                     helper.update_session_vars(state.session_var_names());
                 }
             }
+            Err(_) if stopped() => break,
             Err(ReadlineError::Interrupted) => {
-                println!("^C");
-                pending = None;
+                if pending.take().is_some() {
+                    println!("^C");
+                } else if exit_armed {
+                    println!("bye");
+                    break;
+                } else {
+                    println!("^C \x1b[2m(press Ctrl-C again or type :quit to exit)\x1b[0m");
+                    exit_armed = true;
+                }
                 continue;
             }
             Err(ReadlineError::Eof) => {
                 if let Some(lines) = state.paste_buffer.take() {
                     let code = strip_paste_prompts(&lines);
                     if !code.trim().is_empty() {
-                        let _ = editor.add_history_entry(code.trim());
+                        remember(&mut editor, history.as_ref(), code.trim());
                         state.history_entries.push(code.trim().to_string());
                         state.log_input(code.trim());
                         if let Err(e) = state.execute_snippet(code.trim()) {
@@ -844,7 +910,23 @@ pub fn run_repl(
                     }
                     println!("\x1b[2m[paste done]\x1b[0m");
                 }
-                println!("bye");
+                if interactive {
+                    println!("bye");
+                    break;
+                }
+                // A script may end inside an entry (no blank line after a
+                // block); run what was buffered instead of dropping it.
+                if let Some(mut p) = pending.take() {
+                    let code = p.take();
+                    let trimmed = code.trim_end();
+                    if !trimmed.is_empty() {
+                        state.history_entries.push(trimmed.to_string());
+                        state.log_input(trimmed);
+                        if let Err(e) = state.execute_snippet(trimmed) {
+                            println!("\x1b[31m[run]\x1b[0m {e}");
+                        }
+                    }
+                }
                 break;
             }
             Err(err) => {
@@ -853,11 +935,10 @@ pub fn run_repl(
         }
     }
 
-    if let Some(path) = history_path() {
-        let _ = editor.save_history(&path);
-    }
-
     state.shutdown();
+    if stopped() {
+        return Ok(interrupt_exit_code());
+    }
     Ok(0)
 }
 
@@ -868,6 +949,8 @@ struct ReplState {
     detect_enabled: bool,
     defined_names: HashSet<String>,
     history_entries: Vec<String>,
+    /// Target of the last `:load`, re-run by `:reload`.
+    last_loaded: Option<String>,
     dir_stack: Vec<PathBuf>,
     bookmarks: HashMap<String, PathBuf>,
     log_path: Option<PathBuf>,
@@ -883,6 +966,10 @@ struct ReplState {
     last_stdout: Option<String>,
     /// Whether to show [n] in prompt (config: numbered_prompts).
     numbered_prompts: bool,
+    /// Line that forces evaluation of a multi-line entry (config: terminator).
+    terminator: String,
+    /// False when stdin is piped; durations are then left out of the output.
+    interactive: bool,
 }
 
 struct PendingInput {
@@ -931,135 +1018,9 @@ impl PendingInput {
         std::mem::take(&mut self.buf)
     }
 
-    fn needs_more_input(&self, language_id: &str) -> bool {
-        needs_more_input(language_id, &self.buf)
-    }
-}
-
-fn needs_more_input(language_id: &str, code: &str) -> bool {
-    match language_id {
-        "python" | "py" | "python3" | "py3" => needs_more_input_python(code),
-
-        _ => has_unclosed_delimiters(code) || generic_line_looks_incomplete(code),
-    }
-}
-
-fn generic_line_looks_incomplete(code: &str) -> bool {
-    let mut last: Option<&str> = None;
-    for line in code.lines().rev() {
-        let trimmed = line.trim_end();
-        if trimmed.trim().is_empty() {
-            continue;
-        }
-        last = Some(trimmed);
-        break;
-    }
-    let Some(line) = last else { return false };
-    let line = line.trim();
-    if line.is_empty() {
-        return false;
-    }
-    if line.starts_with('#') {
-        return false;
-    }
-
-    if line.ends_with('\\') {
-        return true;
-    }
-
-    const TAILS: [&str; 24] = [
-        "=", "+", "-", "*", "/", "%", "&", "|", "^", "!", "<", ">", "&&", "||", "??", "?:", "?",
-        ":", ".", ",", "=>", "->", "::", "..",
-    ];
-    if TAILS.iter().any(|tok| line.ends_with(tok)) {
-        return true;
-    }
-
-    const PREFIXES: [&str; 9] = [
-        "return", "throw", "yield", "await", "import", "from", "export", "case", "else",
-    ];
-    let lowered = line.to_ascii_lowercase();
-    if PREFIXES
-        .iter()
-        .any(|kw| lowered == *kw || lowered.ends_with(&format!(" {kw}")))
-    {
-        return true;
-    }
-
-    false
-}
-
-fn needs_more_input_python(code: &str) -> bool {
-    if has_unclosed_delimiters(code) {
-        return true;
-    }
-
-    let mut last_nonempty: Option<&str> = None;
-    let mut saw_block_header = false;
-    let mut has_body_after_header = false;
-
-    for line in code.lines() {
-        let trimmed = line.trim_end();
-        if trimmed.trim().is_empty() {
-            continue;
-        }
-        last_nonempty = Some(trimmed);
-        if is_python_block_header(trimmed.trim()) {
-            saw_block_header = true;
-            has_body_after_header = false;
-        } else if saw_block_header {
-            has_body_after_header = true;
-        }
-    }
-
-    if !saw_block_header {
-        return false;
-    }
-
-    // A blank line terminates a block
-    if code.ends_with("\n\n") {
-        return false;
-    }
-
-    // If we have a header but no body yet, we need more input
-    if !has_body_after_header {
-        return true;
+    fn needs_more_input(&self, engine: &dyn LanguageEngine) -> bool {
+        !engine.is_input_complete(&self.buf)
     }
-
-    // If the last line is still indented, we're still inside the block
-    if let Some(last) = last_nonempty
-        && (last.starts_with(' ') || last.starts_with('\t'))
-    {
-        return true;
-    }
-
-    false
-}
-
-/// Check if a trimmed Python line is a block header (def, class, if, for, etc.)
-/// rather than a line that just happens to end with `:` (dict literal, slice, etc.)
-fn is_python_block_header(line: &str) -> bool {
-    if !line.ends_with(':') {
-        return false;
-    }
-    let lowered = line.to_ascii_lowercase();
-    const BLOCK_KEYWORDS: &[&str] = &[
-        "def ",
-        "class ",
-        "if ",
-        "elif ",
-        "else:",
-        "for ",
-        "while ",
-        "try:",
-        "except",
-        "finally:",
-        "with ",
-        "async def ",
-        "async for ",
-        "async with ",
-    ];
-    BLOCK_KEYWORDS.iter().any(|kw| lowered.starts_with(kw))
 }
 
 fn python_auto_indent(line: &str, existing: &str) -> String {
@@ -1205,110 +1166,6 @@ fn split_indent(line: &str) -> (String, &str) {
     (line[..idx].to_string(), &line[idx..])
 }
 
-fn has_unclosed_delimiters(code: &str) -> bool {
-    let mut paren = 0i32;
-    let mut bracket = 0i32;
-    let mut brace = 0i32;
-
-    let mut in_single = false;
-    let mut in_double = false;
-    let mut in_backtick = false;
-    let mut in_block_comment = false;
-    let mut escape = false;
-
-    let chars: Vec<char> = code.chars().collect();
-    let len = chars.len();
-    let mut i = 0;
-
-    while i < len {
-        let ch = chars[i];
-
-        if escape {
-            escape = false;
-            i += 1;
-            continue;
-        }
-
-        // Inside block comment /* ... */
-        if in_block_comment {
-            if ch == '*' && i + 1 < len && chars[i + 1] == '/' {
-                in_block_comment = false;
-                i += 2;
-                continue;
-            }
-            i += 1;
-            continue;
-        }
-
-        if in_single {
-            if ch == '\\' {
-                escape = true;
-            } else if ch == '\'' {
-                in_single = false;
-            }
-            i += 1;
-            continue;
-        }
-        if in_double {
-            if ch == '\\' {
-                escape = true;
-            } else if ch == '"' {
-                in_double = false;
-            }
-            i += 1;
-            continue;
-        }
-        if in_backtick {
-            if ch == '\\' {
-                escape = true;
-            } else if ch == '`' {
-                in_backtick = false;
-            }
-            i += 1;
-            continue;
-        }
-
-        // Check for line comments (// and #)
-        if ch == '/' && i + 1 < len && chars[i + 1] == '/' {
-            // Skip rest of line
-            while i < len && chars[i] != '\n' {
-                i += 1;
-            }
-            continue;
-        }
-        if ch == '#' {
-            // Python/Ruby/etc. line comment - skip rest of line
-            while i < len && chars[i] != '\n' {
-                i += 1;
-            }
-            continue;
-        }
-        // Check for block comments /* ... */
-        if ch == '/' && i + 1 < len && chars[i + 1] == '*' {
-            in_block_comment = true;
-            i += 2;
-            continue;
-        }
-
-        match ch {
-            '\'' => in_single = true,
-            '"' => in_double = true,
-            '`' => in_backtick = true,
-            '(' => paren += 1,
-            ')' => paren -= 1,
-            '[' => bracket += 1,
-            ']' => bracket -= 1,
-            '{' => brace += 1,
-            '}' => brace -= 1,
-            _ => {}
-        }
-
-        i += 1;
-    }
-
-    paren > 0 || bracket > 0 || brace > 0 || in_block_comment
-}
-
 impl ReplState {
     fn new(
         initial_language: LanguageSpec,
@@ -1323,6 +1180,7 @@ impl ReplState {
             detect_enabled,
             defined_names: HashSet::new(),
             history_entries: Vec::new(),
+            last_loaded: None,
             dir_stack: Vec::new(),
             bookmarks,
             log_path: None,
@@ -1333,6 +1191,8 @@ impl ReplState {
             in_count: 0,
             last_stdout: None,
             numbered_prompts: false,
+            terminator: DEFAULT_TERMINATOR.to_string(),
+            interactive: true,
         };
         if let Ok(cfg) = load_repl_config() {
             if let Some(v) = cfg.get("detect") {
@@ -1353,6 +1213,11 @@ impl ReplState {
             if let Some(v) = cfg.get("numbered_prompts") {
                 state.numbered_prompts = matches!(v.to_lowercase().as_str(), "on" | "true" | "1");
             }
+            if let Some(v) = cfg.get("terminator")
+                && !v.trim().is_empty()
+            {
+                state.terminator = v.trim().to_string();
+            }
         }
         state.ensure_current_language()?;
         Ok(state)
@@ -1362,6 +1227,13 @@ impl ReplState {
         &self.current_language
     }
 
+    fn needs_more_input(&self, pending: &PendingInput) -> bool {
+        match self.registry.resolve(&self.current_language) {
+            Some(engine) => pending.needs_more_input(engine),
+            None => false,
+        }
+    }
+
     fn prompt(&self) -> String {
         if self.numbered_prompts {
             format!(
@@ -1701,32 +1573,24 @@ impl ReplState {
                 return Ok(false);
             }
             "load" | "run" => {
-                if let Some(token) = parts.next() {
-                    if let Some(code) = self.macros.get(token) {
-                        self.execute_payload(ExecutionPayload::Inline {
-                            code: code.clone(),
-                            args: Vec::new(),
-                        })?;
-                    } else {
-                        let path = if token.starts_with("http://") || token.starts_with("https://")
-                        {
-                            match fetch_url_to_temp(token) {
-                                Ok(p) => p,
-                                Err(e) => {
-                                    println!("\x1b[31m[run]\x1b[0m fetch failed: {e}");
-                                    return Ok(false);
-                                }
-                            }
-                        } else {
-                            PathBuf::from(token)
-                        };
-                        self.execute_payload(ExecutionPayload::File {
-                            path,
-                            args: Vec::new(),
-                        })?;
-                    }
-                } else {
+                // The rest of the line, so paths with spaces work.
+                let target = command[head.len()..].trim();
+                if target.is_empty() {
                     println!("usage: :load <path|url>  or  :run <macro|path|url>");
+                } else if let Some(code) = self.macros.get(target) {
+                    self.execute_payload(ExecutionPayload::Inline {
+                        code: code.clone(),
+                        args: Vec::new(),
+                    })?;
+                } else {
+                    self.load_source(target);
+                }
+                return Ok(false);
+            }
+            "reload" => {
+                match self.last_loaded.clone() {
+                    Some(target) => self.load_source(&target),
+                    None => println!("\x1b[2m(nothing loaded yet; use :load <path>)\x1b[0m"),
                 }
                 return Ok(false);
             }
@@ -1951,6 +1815,7 @@ impl ReplState {
                         println!("\x1b[2mxmode\t{xmode}\x1b[0m");
                         println!("\x1b[2mprecision\t{precision}\x1b[0m");
                         println!("\x1b[2mnumbered_prompts\t{numbered}\x1b[0m");
+                        println!("\x1b[2mterminator\t{}\x1b[0m", self.terminator);
                     }
                     (Some(k), None) => {
                         let v: Option<String> = match k {
@@ -1973,6 +1838,7 @@ impl ReplState {
                             "numbered_prompts" => {
                                 Some(if self.numbered_prompts { "on" } else { "off" }.to_string())
                             }
+                            "terminator" => Some(self.terminator.clone()),
                             _ => None,
                         };
                         if let Some(v) = v {
@@ -2011,6 +1877,14 @@ impl ReplState {
                                     matches!(v.to_lowercase().as_str(), "on" | "true" | "1");
                                 cfg.insert("numbered_prompts".to_string(), v.to_string());
                             }
+                            "terminator" => {
+                                if v.trim().is_empty() {
+                                    println!("\x1b[31m[run]\x1b[0m terminator cannot be empty");
+                                    return Ok(false);
+                                }
+                                self.terminator = v.trim().to_string();
+                                cfg.insert("terminator".to_string(), self.terminator.clone());
+                            }
                             _ => {
                                 println!("\x1b[31m[run]\x1b[0m unknown config key: {k}");
                                 return Ok(false);
@@ -2201,9 +2075,39 @@ impl ReplState {
         Ok(())
     }
 
+    /// Evaluate a file or http(s) URL in the current session and remember it
+    /// for `:reload`. Failures are reported and leave the session running.
+    fn load_source(&mut self, target: &str) {
+        self.last_loaded = Some(target.to_string());
+        let path = if target.starts_with("http://") || target.starts_with("https://") {
+            match fetch_url_to_temp(target) {
+                Ok(p) => p,
+                Err(e) => {
+                    println!("\x1b[31m[run]\x1b[0m fetch failed: {e}");
+                    return;
+                }
+            }
+        } else {
+            PathBuf::from(target)
+        };
+        if let Err(e) = self.execute_payload(ExecutionPayload::File {
+            path,
+            args: Vec::new(),
+        }) {
+            println!("\x1b[31m[run]\x1b[0m load failed: {e:#}");
+        }
+    }
+
+    /// Clear the current language's accumulated state. Sessions that cannot
+    /// reset in place are shut down and restarted on the next entry.
     fn reset_current_session(&mut self) {
         let key = self.current_language.canonical_id().to_string();
-        if let Some(mut session) = self.sessions.remove(&key) {
+        let Some(session) = self.sessions.get_mut(&key) else {
+            return;
+        };
+        if session.reset().is_err()
+            && let Some(mut session) = self.sessions.remove(&key)
+        {
             let _ = session.shutdown();
         }
     }
@@ -2243,14 +2147,55 @@ impl ReplState {
 
     fn execute_payload(&mut self, payload: ExecutionPayload) -> Result<()> {
         let language = self.current_language.clone();
+        clear_interrupt();
+        let result = self.payload_outcome(&language, payload);
+        if interrupted() {
+            // Only the interactive REPL goes on; otherwise the loop stops.
+            if self.interactive && !terminated() {
+                clear_interrupt();
+            }
+            self.finish_interrupted(&language);
+            return Ok(());
+        }
+        let outcome = result?;
+        render_outcome(&outcome, self.xmode, self.interactive);
+        self.last_stdout = Some(outcome.stdout.clone());
+        self.in_count += 1;
+        Ok(())
+    }
+
+    /// After Ctrl-C stopped an entry. Sessions that replay their entries
+    /// dropped the interrupted one and keep everything before it; one backed
+    /// by a live interpreter may have exited with it, and is started afresh
+    /// on the next entry.
+    fn finish_interrupted(&mut self, language: &LanguageSpec) {
+        println!("\r^C interrupted");
+        let key = language.canonical_id();
+        if let Some(session) = self.sessions.get_mut(key)
+            && !session.is_alive()
+        {
+            if let Some(mut session) = self.sessions.remove(key) {
+                let _ = session.shutdown();
+            }
+            println!(
+                "\x1b[2m[the {key} session ended with the entry; earlier definitions are gone]\x1b[0m"
+            );
+        }
+    }
+
+    fn payload_outcome(
+        &mut self,
+        language: &LanguageSpec,
+        payload: ExecutionPayload,
+    ) -> Result<ExecutionOutcome> {
         let outcome = match payload {
             ExecutionPayload::Inline { code, .. } => {
-                if self.engine_supports_sessions(&language)? {
-                    self.eval_in_session(&language, &code)?
+                if self.engine_supports_sessions(language)? {
+                    self.eval_in_session(language, &code)?
                 } else {
                     let engine = self
                         .registry
-                        .resolve(&language)
+                        .resolve(language)
                         .context("language engine not found")?;
                     engine.execute(&ExecutionPayload::Inline {
                         code,
@@ -2260,26 +2205,26 @@ impl ReplState {
             }
             ExecutionPayload::File { ref path, .. } => {
                 // Read the file and feed it through the session so variables persist
-                if self.engine_supports_sessions(&language)? {
+                if self.engine_supports_sessions(language)? {
                     let code = std::fs::read_to_string(path)
                         .with_context(|| format!("failed to read file: {}", path.display()))?;
                     println!("\x1b[2m[loaded {}]\x1b[0m", path.display());
-                    self.eval_in_session(&language, &code)?
+                    self.eval_in_session(language, &code)?
                 } else {
                     let engine = self
                         .registry
-                        .resolve(&language)
+                        .resolve(language)
                         .context("language engine not found")?;
                     engine.execute(&payload)?
                 }
             }
             ExecutionPayload::Stdin { code, .. } => {
-                if self.engine_supports_sessions(&language)? {
-                    self.eval_in_session(&language, &code)?
+                if self.engine_supports_sessions(language)? {
+                    self.eval_in_session(language, &code)?
                 } else {
                     let engine = self
                         .registry
-                        .resolve(&language)
+                        .resolve(language)
                         .context("language engine not found")?;
                     engine.execute(&ExecutionPayload::Stdin {
                         code,
@@ -2288,10 +2233,7 @@ impl ReplState {
                 }
             }
         };
-        render_outcome(&outcome, self.xmode);
-        self.last_stdout = Some(outcome.stdout.clone());
-        self.in_count += 1;
-        Ok(())
+        Ok(outcome)
     }
 
     fn engine_supports_sessions(&self, language: &LanguageSpec) -> Result<bool> {
@@ -2329,7 +2271,7 @@ impl ReplState {
         if lang == "python" {
             let code = format!("help({expr})");
             let outcome = self.eval_in_session(&language, &code)?;
-            render_outcome(&outcome, self.xmode);
+            render_outcome(&outcome, self.xmode, self.interactive);
             Ok(())
         } else {
             println!(
@@ -2488,8 +2430,9 @@ impl ReplState {
         let warmup = self.eval_in_session(&language, code)?;
         if !warmup.success() {
             println!("\x1b[31mError:\x1b[0m Code failed during warmup");
-            if !warmup.stderr.is_empty() {
-                print!("{}", warmup.stderr);
+            let stderr = warmup.combined_stderr();
+            if !stderr.is_empty() {
+                print!("{stderr}");
             }
             return Ok(());
         }
@@ -2643,16 +2586,17 @@ fn apply_xmode(stderr: &str, xmode: XMode) -> String {
 }
 
 /// Render execution outcome. Stdout is passed through unchanged so engine ANSI/rich output is preserved.
-fn render_outcome(outcome: &ExecutionOutcome, xmode: XMode) {
+fn render_outcome(outcome: &ExecutionOutcome, xmode: XMode, show_duration: bool) {
     if !outcome.stdout.is_empty() {
         print!("{}", ensure_trailing_newline(&outcome.stdout));
     }
-    if !outcome.stderr.is_empty() {
-        let formatted =
-            output::format_stderr(&outcome.language, &outcome.stderr, outcome.success());
+    let stderr = outcome.combined_stderr();
+    if !stderr.is_empty() {
+        let formatted = output::format_stderr(&outcome.language, &stderr, outcome.success());
         let trimmed = apply_xmode(&formatted, xmode);
         if !trimmed.is_empty() {
-            eprint!("\x1b[31m{}\x1b[0m", ensure_trailing_newline(&trimmed));
+            let text = ensure_trailing_newline(&trimmed);
+            eprint!("{}", output::paint(Stream::Stderr, "31", &text));
         }
     }
 
@@ -2660,14 +2604,60 @@ fn render_outcome(outcome: &ExecutionOutcome, xmode: XMode) {
     if let Some(code) = outcome.exit_code
         && code != 0
     {
-        println!("\x1b[2m[exit {code}] {}\x1b[0m", format_duration(millis));
+        let line = if show_duration {
+            format!("[exit {code}] {}", format_duration(millis))
+        } else {
+            format!("[exit {code}]")
+        };
+        println!("{}", output::paint(Stream::Stdout, "2", &line));
         return;
     }
 
     // Show execution timing
-    if millis > 0 {
-        println!("\x1b[2m{}\x1b[0m", format_duration(millis));
+    if show_duration && millis > 0 {
+        println!(
+            "{}",
+            output::paint(Stream::Stdout, "2", &format_duration(millis))
+        );
+    }
+}
+
+/// Next line of piped REPL input, in the shape the line editor returns. A
+/// signal that stops run cuts a blocked read short as `Interrupted`;
+/// `read_line` would retry it and wait for input that may never come.
+fn read_piped_line() -> rustyline::Result<String> {
+    let mut stdin = io::stdin().lock();
+    let mut line = Vec::new();
+    loop {
+        let available = match stdin.fill_buf() {
+            Ok(available) => available,
+            Err(err) if err.kind() == io::ErrorKind::Interrupted => {
+                if interrupted() {
+                    return Err(ReadlineError::Interrupted);
+                }
+                continue;
+            }
+            Err(err) => return Err(ReadlineError::Io(err)),
+        };
+        if available.is_empty() {
+            break;
+        }
+        let (taken, done) = match available.iter().position(|&byte| byte == b'\n') {
+            Some(end) => (end + 1, true),
+            None => (available.len(), false),
+        };
+        line.extend_from_slice(&available[..taken]);
+        stdin.consume(taken);
+        if done {
+            break;
+        }
+    }
+    if line.is_empty() {
+        return Err(ReadlineError::Eof);
     }
+    let line = String::from_utf8(line)
+        .map_err(|err| ReadlineError::Io(io::Error::new(io::ErrorKind::InvalidData, err)))?;
+    Ok(line.trim_end_matches(['\r', '\n']).to_string())
 }
 
 fn format_duration(millis: u128) -> String {
@@ -2843,15 +2833,88 @@ fn parse_history_range(s: &str, len: usize) -> (usize, usize) {
     (len.saturating_sub(25), len)
 }
 
-fn history_path() -> Option<PathBuf> {
-    if let Ok(home) = std::env::var("HOME") {
-        return Some(Path::new(&home).join(HISTORY_FILE));
+/// Persistent input history under `~/.config/run/history/`, one file per
+/// language so arrow-up in Python never surfaces Rust lines. With
+/// `RUN_HISTORY_SHARED=1` every language uses the same file.
+struct HistoryStore {
+    dir: PathBuf,
+    shared: bool,
+    /// File name of the history currently loaded into the editor.
+    loaded: Option<String>,
+}
+
+impl HistoryStore {
+    /// `None` when history is switched off (`--no-history`) or there is no
+    /// config directory to keep it in.
+    fn from_env() -> Option<Self> {
+        if env_flag("RUN_NO_HISTORY") {
+            return None;
+        }
+        Some(Self::new(
+            crate::config::config_dir()?.join("history"),
+            env_flag("RUN_HISTORY_SHARED"),
+        ))
     }
-    #[cfg(windows)]
-    if let Ok(home) = std::env::var("USERPROFILE") {
-        return Some(Path::new(&home).join(HISTORY_FILE));
+
+    fn new(dir: PathBuf, shared: bool) -> Self {
+        Self {
+            dir,
+            shared,
+            loaded: None,
+        }
     }
-    None
+
+    fn file_name<'a>(&self, language: &'a str) -> &'a str {
+        if self.shared {
+            SHARED_HISTORY
+        } else {
+            language
+        }
+    }
+
+    /// Load `language`'s history into the editor unless it is already there.
+    fn switch_to(&mut self, editor: &mut Editor<ReplHelper, DefaultHistory>, language: &str) {
+        let name = self.file_name(language);
+        if self.loaded.as_deref() == Some(name) {
+            return;
+        }
+        let _ = editor.clear_history();
+        let _ = editor.load_history(&self.dir.join(name));
+        self.loaded = Some(name.to_string());
+    }
+
+    /// Append the entries added since the last call to the loaded file.
+    fn append(&self, editor: &mut Editor<ReplHelper, DefaultHistory>) {
+        let Some(name) = self.loaded.as_deref() else {
+            return;
+        };
+        let _ = std::fs::create_dir_all(&self.dir);
+        let _ = editor.append_history(&self.dir.join(name));
+    }
+}
+
+/// Add an accepted entry to the editor and write it to disk right away, so a
+/// crashed or killed session keeps its history.
+fn remember(
+    editor: &mut Editor<ReplHelper, DefaultHistory>,
+    history: Option<&HistoryStore>,
+    entry: &str,
+) {
+    let _ = editor.add_history_entry(entry);
+    if let Some(history) = history {
+        history.append(editor);
+    }
+}
+
+fn history_size() -> usize {
+    std::env::var("RUN_HISTORY_SIZE")
+        .ok()
+        .and_then(|value| value.trim().parse().ok())
+        .unwrap_or(DEFAULT_HISTORY_SIZE)
+}
+
+fn env_flag(name: &str) -> bool {
+    std::env::var(name).is_ok_and(|v| v == "1" || v == "true")
 }
 
 fn bookmarks_path() -> Option<PathBuf> {
@@ -3077,6 +3140,26 @@ fn extract_defined_names(code: &str, language_id: &str) -> Vec<String> {
 mod tests {
     use super::*;
 
+    fn needs_more(p: &PendingInput, language_id: &str) -> bool {
+        let registry = LanguageRegistry::bootstrap();
+        let engine = registry
+            .resolve_by_id(language_id)
+            .expect("engine is registered");
+        p.needs_more_input(engine)
+    }
+
+    #[test]
+    fn history_is_per_language_unless_shared() {
+        let dir = PathBuf::from("history");
+        let separate = HistoryStore::new(dir.clone(), false);
+        assert_eq!(separate.file_name("python"), "python");
+        assert_eq!(separate.file_name("rust"), "rust");
+
+        let shared = HistoryStore::new(dir, true);
+        assert_eq!(shared.file_name("python"), SHARED_HISTORY);
+        assert_eq!(shared.file_name("rust"), SHARED_HISTORY);
+    }
+
     #[test]
     fn language_aliases_resolve_in_registry() {
         let registry = LanguageRegistry::bootstrap();
@@ -3130,11 +3213,11 @@ mod tests {
     fn python_multiline_def_requires_blank_line_to_execute() {
         let mut p = PendingInput::new();
         p.push_line("def fib(n):");
-        assert!(p.needs_more_input("python"));
+        assert!(needs_more(&p, "python"));
         p.push_line("    return n");
-        assert!(p.needs_more_input("python"));
+        assert!(needs_more(&p, "python"));
         p.push_line(""); // blank line ends block
-        assert!(!p.needs_more_input("python"));
+        assert!(!needs_more(&p, "python"));
     }
 
     #[test]
@@ -3142,7 +3225,7 @@ mod tests {
         let mut p = PendingInput::new();
         p.push_line("x = {'key': 'value'}");
         assert!(
-            !p.needs_more_input("python"),
+            !needs_more(&p, "python"),
             "dict literal should not trigger multi-line"
         );
     }
@@ -3151,22 +3234,22 @@ mod tests {
     fn python_class_block_needs_body() {
         let mut p = PendingInput::new();
         p.push_line("class Foo:");
-        assert!(p.needs_more_input("python"));
+        assert!(needs_more(&p, "python"));
         p.push_line("    pass");
-        assert!(p.needs_more_input("python")); // still indented
+        assert!(needs_more(&p, "python")); // still indented
         p.push_line(""); // blank line ends
-        assert!(!p.needs_more_input("python"));
+        assert!(!needs_more(&p, "python"));
     }
 
     #[test]
     fn python_if_block_with_dedented_body_is_complete() {
         let mut p = PendingInput::new();
         p.push_line("if True:");
-        assert!(p.needs_more_input("python"));
+        assert!(needs_more(&p, "python"));
         p.push_line("    print('yes')");
-        assert!(p.needs_more_input("python"));
+        assert!(needs_more(&p, "python"));
         p.push_line(""); // blank line terminates
-        assert!(!p.needs_more_input("python"));
+        assert!(!needs_more(&p, "python"));
     }
 
     #[test]
@@ -3247,27 +3330,43 @@ mod tests {
     fn generic_multiline_tracks_unclosed_delimiters() {
         let mut p = PendingInput::new();
         p.push_line("func(");
-        assert!(p.needs_more_input("csharp"));
+        assert!(needs_more(&p, "csharp"));
         p.push_line(")");
-        assert!(!p.needs_more_input("csharp"));
+        assert!(!needs_more(&p, "csharp"));
     }
 
     #[test]
     fn generic_multiline_tracks_trailing_equals() {
         let mut p = PendingInput::new();
         p.push_line("let x =");
-        assert!(p.needs_more_input("rust"));
+        assert!(needs_more(&p, "rust"));
         p.push_line("10;");
-        assert!(!p.needs_more_input("rust"));
+        assert!(!needs_more(&p, "rust"));
     }
 
     #[test]
     fn generic_multiline_tracks_trailing_dot() {
         let mut p = PendingInput::new();
         p.push_line("foo.");
-        assert!(p.needs_more_input("csharp"));
+        assert!(needs_more(&p, "csharp"));
         p.push_line("Bar()");
-        assert!(!p.needs_more_input("csharp"));
+        assert!(!needs_more(&p, "csharp"));
+    }
+
+    #[test]
+    fn generic_multiline_tracks_nested_brackets() {
+        let mut p = PendingInput::new();
+        p.push_line("const xs = [{ a: 1 },");
+        assert!(needs_more(&p, "javascript"));
+        p.push_line("{ b: [2, 3] }];");
+        assert!(!needs_more(&p, "javascript"));
+    }
+
+    #[test]
+    fn generic_multiline_ignores_delimiters_in_strings() {
+        let mut p = PendingInput::new();
+        p.push_line("console.log(\"{[(\");");
+        assert!(!needs_more(&p, "javascript"));
     }
 
     #[test]
@@ -3275,7 +3374,7 @@ mod tests {
         let mut p = PendingInput::new();
         p.push_line("#include <stdio.h>");
         assert!(
-            !p.needs_more_input("c"),
+            !needs_more(&p, "c"),
             "preprocessor lines should not force continuation"
         );
     }
@@ -68,6 +68,38 @@ fn cache_store_and_retrieve() {
     assert!(lookup.unwrap().exists(), "cached path should exist on disk");
 }
 
+#[cfg(unix)]
+#[test]
+fn compile_cache_key_tracks_language_and_compiler_version() {
+    use std::os::unix::fs::PermissionsExt;
+
+    let dir = tempfile::tempdir().expect("temp dir");
+    let fake_compiler = |name: &str, version: &str| {
+        let path = dir.path().join(name);
+        std::fs::write(&path, format!("#!/bin/sh\necho {version}\n")).expect("write compiler");
+        std::fs::set_permissions(&path, std::fs::Permissions::from_mode(0o755))
+            .expect("chmod compiler");
+        path
+    };
+    let old = fake_compiler("cc-old", "cc 1.0");
+    let new = fake_compiler("cc-new", "cc 2.0");
+
+    let key = |language: &str, compiler: &std::path::Path| {
+        run::engine::compile_cache_key(language, compiler, "--version", "int main(){}")
+    };
+    assert_eq!(key("c", &old), key("c", &old));
+    assert_ne!(
+        key("c", &old),
+        key("c", &new),
+        "compiler upgrade must change the key"
+    );
+    assert_ne!(
+        key("c", &old),
+        key("cpp", &old),
+        "language must be part of the key"
+    );
+}
+
 // ---------------------------------------------------------------------------
 // Rust compilation cache integration
 // ---------------------------------------------------------------------------
@@ -140,8 +172,71 @@ fn bench_flag_requires_positive_iterations() {
         .stderr(predicate::str::contains("Results (1 runs)"));
 }
 
+#[test]
+fn bench_subcommand_reports_statistics_as_json() {
+    if !python_available() {
+        eprintln!("skipping: python not available");
+        return;
+    }
+
+    let output = assert_cmd::Command::cargo_bin("run")
+        .expect("binary")
+        .args([
+            "bench",
+            "--lang",
+            "python",
+            "--code",
+            "print('ok')",
+            "--runs",
+            "4",
+            "--json",
+        ])
+        .assert()
+        .success();
+    let report: serde_json::Value =
+        serde_json::from_slice(&output.get_output().stdout).expect("stdout is a JSON object");
+    assert_eq!(report["language"], "python");
+    assert_eq!(report["runs"], 4);
+    assert_eq!(report["samples_ms"].as_array().map(Vec::len), Some(4));
+    let min = report["min_ms"].as_f64().expect("min_ms");
+    let median = report["median_ms"].as_f64().expect("median_ms");
+    let max = report["max_ms"].as_f64().expect("max_ms");
+    assert!(min <= median && median <= max, "report: {report}");
+    assert!(report["stddev_ms"].as_f64().is_some_and(|s| s >= 0.0));
+}
+
+#[test]
+fn bench_subcommand_table_and_runs_validation() {
+    if !python_available() {
+        eprintln!("skipping: python not available");
+        return;
+    }
+
+    assert_cmd::Command::cargo_bin("run")
+        .expect("binary")
+        .args(["bench", "--runs", "2", "python", "-c", "print('table')"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("table"))
+        .stderr(
+            predicate::str::contains("Results (2 runs)")
+                .and(predicate::str::contains("median"))
+                .and(predicate::str::contains("stddev"))
+                .and(predicate::str::contains("\x1b[").not()),
+        );
+
+    assert_cmd::Command::cargo_bin("run")
+        .expect("binary")
+        .args(["--runs", "2", "python", "-c", "print('x')"])
+        .assert()
+        .code(2)
+        .stderr(predicate::str::contains(
+            "--runs only applies to 'run bench'",
+        ));
+}
+
 // ---------------------------------------------------------------------------
-// --watch CLI flag (just validate parsing, not the loop)
+// --watch CLI flag
 // ---------------------------------------------------------------------------
 
 #[test]
@@ -155,6 +250,67 @@ fn watch_flag_requires_file() {
         .stderr(predicate::str::contains("--watch requires a file path"));
 }
 
+#[cfg(unix)]
+#[test]
+fn watch_reruns_on_neighbour_change_and_stops_on_ctrl_c() {
+    use std::process::Stdio;
+    use std::time::{Duration, Instant};
+
+    if !python_available() {
+        eprintln!("skipping watch test: python interpreter not available");
+        return;
+    }
+
+    let dir = tempfile::tempdir().expect("temp dir");
+    let main = dir.path().join("main.py");
+    let helper = dir.path().join("helper.py");
+    std::fs::write(&main, "from helper import X\nprint('value', X)\n").expect("write main");
+    std::fs::write(&helper, "X = 1\n").expect("write helper");
+
+    let output_path = dir.path().join("watch.out");
+    let child = std::process::Command::new(env!("CARGO_BIN_EXE_run"))
+        .arg("--watch")
+        .arg(&main)
+        .stdin(Stdio::null())
+        .stdout(std::fs::File::create(&output_path).expect("output file"))
+        .stderr(Stdio::piped())
+        .spawn()
+        .expect("start run --watch");
+
+    let wait_for = |needle: &str| {
+        let deadline = Instant::now() + Duration::from_secs(15);
+        while Instant::now() < deadline {
+            if std::fs::read_to_string(&output_path).is_ok_and(|out| out.contains(needle)) {
+                return true;
+            }
+            std::thread::sleep(Duration::from_millis(50));
+        }
+        false
+    };
+
+    assert!(wait_for("value 1"), "first run did not happen");
+    std::fs::write(&helper, "X = 2\n").expect("update helper");
+    assert!(
+        wait_for("value 2"),
+        "change to helper.py did not trigger a run"
+    );
+
+    let status = std::process::Command::new("kill")
+        .args(["-INT", &child.id().to_string()])
+        .status()
+        .expect("send SIGINT");
+    assert!(status.success());
+    let output = child.wait_with_output().expect("wait for run --watch");
+    assert!(output.status.success(), "status: {:?}", output.status);
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("--- run #2 at "), "stderr: {stderr}");
+    assert!(stderr.contains("[watch] stopped"), "stderr: {stderr}");
+    assert!(
+        !stderr.contains('\x1b'),
+        "escapes on a piped stderr: {stderr}"
+    );
+}
+
 // ---------------------------------------------------------------------------
 // Config file loading
 // ---------------------------------------------------------------------------
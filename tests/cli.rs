@@ -137,101 +137,1640 @@ fn nim_available() -> bool {
     run::engine::NimEngine::new().validate().is_ok()
 }
 
+fn sql_available() -> bool {
+    run::engine::SqlEngine::new().validate().is_ok()
+}
+
 #[allow(deprecated)]
 fn run_binary() -> assert_cmd::Command {
     assert_cmd::Command::cargo_bin("run").expect("binary built")
 }
 
-fn norm(s: &str) -> String {
-    s.replace("\r\n", "\n")
+fn norm(s: &str) -> String {
+    s.replace("\r\n", "\n")
+}
+
+fn norm_contains(needle: &str) -> impl predicates::Predicate<str> {
+    let needle = needle.to_string();
+    predicates::function::function(move |s: &str| norm(s).contains(needle.as_str()))
+}
+
+#[test]
+fn inline_python_execution() {
+    if !python_available() {
+        eprintln!("skipping python inline test: python interpreter not available");
+        return;
+    }
+
+    run_binary()
+        .args(["--lang", "python", "--code", "print('hello from inline')"])
+        .assert()
+        .success()
+        .stdout(norm_contains("hello from inline\n"));
+}
+
+#[test]
+fn inline_python_positional_with_backslash() {
+    if !python_available() {
+        eprintln!("skipping python positional inline test: python interpreter not available");
+        return;
+    }
+
+    run_binary()
+        .args(["python", "print('positional\\\\n')"])
+        .assert()
+        .success()
+        .stdout(norm_contains("positional\\n"));
+}
+
+#[test]
+fn inline_python_positional_with_division() {
+    if !python_available() {
+        eprintln!("skipping python positional division test: python interpreter not available");
+        return;
+    }
+
+    run_binary()
+        .args(["python", "print(10 / 2)"])
+        .assert()
+        .success()
+        .stdout(norm_contains("5.0\n"));
+}
+
+#[test]
+fn python_file_arguments() {
+    if !python_available() {
+        eprintln!("skipping python file args test: python interpreter not available");
+        return;
+    }
+
+    let mut script = tempfile::Builder::new()
+        .suffix(".py")
+        .tempfile()
+        .expect("temp file");
+    writeln!(
+        script,
+        "import sys\nprint(\"hello {{}}\".format(\" \".join(sys.argv[1:])))"
+    )
+    .expect("write file");
+
+    run_binary()
+        .args([script.path().to_str().expect("path utf8"), "Alice", "Bob"])
+        .assert()
+        .success()
+        .stdout(norm_contains("hello Alice Bob\n"));
+}
+
+#[test]
+fn python_short_code_flag_reads_stdin() {
+    if !python_available() {
+        eprintln!("skipping python -c stdin test: python interpreter not available");
+        return;
+    }
+
+    let code = r#"import sys, json; data = json.load(sys.stdin); print(f"{data['name']} is {data['age']} years old")"#;
+
+    run_binary()
+        .args(["py", "-c", code])
+        .write_stdin("{\"name\":\"Ada\",\"age\":32}\n")
+        .assert()
+        .success()
+        .stdout(norm_contains("Ada is 32 years old\n"));
+}
+
+#[test]
+fn python_file_reads_piped_stdin_until_eof() {
+    if !python_available() {
+        eprintln!("skipping piped stdin test: python interpreter not available");
+        return;
+    }
+
+    let mut script = tempfile::Builder::new()
+        .suffix(".py")
+        .tempfile()
+        .expect("temp file");
+    writeln!(
+        script,
+        "import sys\ntotal = 0\nfor line in sys.stdin:\n    total += sum(int(x) for x in line.split())\nprint(total)"
+    )
+    .expect("write file");
+
+    run_binary()
+        .args([
+            "--lang",
+            "python",
+            "--file",
+            script.path().to_str().expect("path utf8"),
+        ])
+        .write_stdin("1 2 3\n4\n")
+        .assert()
+        .success()
+        .stdout(norm_contains("10\n"));
+}
+
+#[test]
+fn timeout_accepts_duration_and_exits_non_zero() {
+    if !python_available() {
+        eprintln!("skipping timeout test: python interpreter not available");
+        return;
+    }
+
+    run_binary()
+        .args([
+            "--timeout",
+            "300ms",
+            "--lang",
+            "python",
+            "--code",
+            "import time; time.sleep(10)",
+        ])
+        .assert()
+        .code(124)
+        .stderr(predicate::str::contains("execution timed out after 300ms"));
+}
+
+#[test]
+fn timeout_keeps_sub_millisecond_durations() {
+    if !python_available() {
+        eprintln!("skipping timeout test: python interpreter not available");
+        return;
+    }
+
+    run_binary()
+        .args([
+            "--timeout",
+            "500us",
+            "--lang",
+            "python",
+            "--code",
+            "import time; time.sleep(10)",
+        ])
+        .assert()
+        .code(124)
+        .stderr(predicate::str::contains("execution timed out after 500us"));
+
+    run_binary()
+        .args([
+            "--timeout",
+            "0.0001ms",
+            "--lang",
+            "python",
+            "--code",
+            "pass",
+        ])
+        .assert()
+        .code(2)
+        .stderr(predicate::str::contains("timeout must be at least 1us"));
+}
+
+#[test]
+fn timeout_rejects_durations_too_large_to_represent() {
+    for raw in ["99999999999999999999999", "9999999999999999h"] {
+        run_binary()
+            .args(["--timeout", raw, "--lang", "python", "--code", "pass"])
+            .assert()
+            .code(2)
+            .stderr(predicate::str::contains("is too large"));
+    }
+}
+
+#[test]
+fn max_output_kills_runaway_program() {
+    if !python_available() {
+        eprintln!("skipping max-output test: python interpreter not available");
+        return;
+    }
+
+    let output = run_binary()
+        .args([
+            "--max-output",
+            "1k",
+            "--lang",
+            "python",
+            "--code",
+            "while True: print('y' * 50)",
+        ])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("[output truncated at 1024 bytes]"))
+        .get_output()
+        .clone();
+    assert_eq!(output.stdout.len(), 1024);
+}
+
+#[test]
+fn repl_timeout_keeps_session_alive() {
+    if !python_available() {
+        eprintln!("skipping repl timeout test: python interpreter not available");
+        return;
+    }
+
+    run_binary()
+        .args([
+            "--timeout",
+            "300ms",
+            "--no-detect",
+            "-i",
+            "--lang",
+            "python",
+        ])
+        .write_stdin("x = 41\nimport time; time.sleep(10)\nprint(x + 1)\n:exit\n")
+        .assert()
+        .success()
+        .stdout(norm_contains("42"));
+}
+
+#[test]
+fn piped_repl_prints_only_entry_output() {
+    if !python_available() {
+        eprintln!("skipping piped repl test: python interpreter not available");
+        return;
+    }
+
+    let output = run_binary()
+        .args(["--no-detect", "-i", "--lang", "python"])
+        .write_stdin("x = 2\nx * 21\ndef inc(a):\n    return a + 1\n\ninc(x)\nfor i in range(2):\n    print(i)\n")
+        .assert()
+        .success()
+        .get_output()
+        .clone();
+    assert_eq!(String::from_utf8_lossy(&output.stdout), "42\n3\n0\n1\n");
+}
+
+#[test]
+fn json_output_is_a_single_object() {
+    if !python_available() {
+        eprintln!("skipping --json test: python interpreter not available");
+        return;
+    }
+
+    let output = run_binary()
+        .args([
+            "--json",
+            "--lang",
+            "python",
+            "--code",
+            "import sys; print('out'); print('err', file=sys.stderr); sys.exit(3)",
+        ])
+        .assert()
+        .code(3);
+    let value: serde_json::Value =
+        serde_json::from_slice(&output.get_output().stdout).expect("stdout is a JSON object");
+    assert_eq!(value["stdout"], "out\n");
+    assert_eq!(value["stderr"], "err\n");
+    assert_eq!(value["exit_code"], 3);
+    assert_eq!(value["language"], "python");
+    assert!(value["duration_ms"].is_u64());
+    assert!(value["engine_version"].is_string());
+    assert!(value["compile_stderr"].is_null());
+}
+
+#[test]
+fn exit_code_mirrors_the_program() {
+    if !python_available() {
+        eprintln!("skipping exit code test: python interpreter not available");
+        return;
+    }
+
+    run_binary()
+        .args(["--lang", "python", "--code", "import sys; sys.exit(42)"])
+        .assert()
+        .code(42);
+    #[cfg(unix)]
+    run_binary()
+        .args([
+            "--lang",
+            "python",
+            "--code",
+            "import os, signal; os.kill(os.getpid(), signal.SIGKILL)",
+        ])
+        .assert()
+        .code(run::engine::SIGNAL_EXIT_BASE + 9);
+}
+
+#[test]
+fn usage_errors_exit_with_reserved_code() {
+    run_binary()
+        .args(["--lang", "not-a-language", "--code", "x"])
+        .assert()
+        .code(run::app::USAGE_EXIT_CODE)
+        .stderr(predicate::str::contains("not-a-language"));
+    run_binary()
+        .arg("--no-such-flag")
+        .assert()
+        .code(run::app::USAGE_EXIT_CODE);
+}
+
+#[test]
+fn json_output_separates_compile_errors() {
+    if !c_available() {
+        eprintln!("skipping --json compile test: C compiler not available");
+        return;
+    }
+
+    let output = run_binary()
+        .args([
+            "--json",
+            "--lang",
+            "c",
+            "--code",
+            "int main(void) { return missing; }",
+        ])
+        .assert()
+        .failure();
+    let value: serde_json::Value =
+        serde_json::from_slice(&output.get_output().stdout).expect("stdout is a JSON object");
+    assert_eq!(value["stderr"], "");
+    assert!(
+        value["compile_stderr"]
+            .as_str()
+            .is_some_and(|text| text.contains("missing"))
+    );
+}
+
+#[test]
+fn color_flag_controls_stderr_coloring() {
+    if !python_available() {
+        eprintln!("skipping color test: python interpreter not available");
+        return;
+    }
+
+    let code = "import sys; print('out'); print('err', file=sys.stderr)";
+    run_binary()
+        .args(["--color", "always", "--lang", "python", "--code", code])
+        .assert()
+        .success()
+        .stdout(norm_contains("out\n").and(predicate::str::contains("\x1b[").not()))
+        .stderr(predicate::str::contains("\x1b[31merr\x1b[0m"));
+
+    run_binary()
+        .args(["--color", "never", "--lang", "python", "--code", code])
+        .assert()
+        .success()
+        .stderr(norm_contains("err\n").and(predicate::str::contains("\x1b[").not()));
+
+    // Piped (not a terminal) and NO_COLOR both turn off auto.
+    run_binary()
+        .env("NO_COLOR", "1")
+        .args(["--lang", "python", "--code", code])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("\x1b[").not());
+
+    run_binary()
+        .args([
+            "--json", "--color", "always", "--lang", "python", "--code", code,
+        ])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("\\u001b").not())
+        .stderr(predicate::str::contains("\x1b[").not());
+}
+
+#[test]
+fn output_is_streamed_while_the_program_runs() {
+    if !python_available() {
+        eprintln!("skipping streaming test: python interpreter not available");
+        return;
+    }
+    use std::io::{BufRead, BufReader};
+
+    // The program waits for a file the test only creates once it has read
+    // the first line, which it can only do if that line was streamed.
+    let dir = tempfile::tempdir().expect("temp dir");
+    let marker = dir.path().join("marker");
+    let code = format!(
+        "import os, time\nprint('early', flush=True)\nfor _ in range(100):\n    if os.path.exists({marker:?}): break\n    time.sleep(0.1)\nprint('late', 'seen' if os.path.exists({marker:?}) else 'unseen')",
+        marker = marker.to_str().expect("path utf8")
+    );
+    let mut child = std::process::Command::new(env!("CARGO_BIN_EXE_run"))
+        .args(["--lang", "python", "--code", &code])
+        .stdout(std::process::Stdio::piped())
+        .spawn()
+        .expect("spawn run");
+    let mut stdout = BufReader::new(child.stdout.take().expect("stdout"));
+    let mut line = String::new();
+    stdout.read_line(&mut line).expect("read first line");
+    assert_eq!(line, "early\n");
+    std::fs::write(&marker, "").expect("write marker");
+
+    let mut rest = String::new();
+    std::io::Read::read_to_string(&mut stdout, &mut rest).expect("read rest");
+    assert!(child.wait().expect("wait").success());
+    assert_eq!(
+        rest, "late seen\n",
+        "output is printed once, as it is written"
+    );
+}
+
+#[test]
+fn dry_run_prints_commands_without_running_them() {
+    if !python_available() {
+        eprintln!("skipping dry-run test: python interpreter not available");
+        return;
+    }
+
+    let dir = tempfile::tempdir().expect("temp dir");
+    let marker = dir.path().join("ran");
+    let script = dir.path().join("touch it.py");
+    std::fs::write(&script, format!("open({:?}, 'w')\n", marker)).expect("write script");
+    run_binary()
+        .args(["--dry-run", "--env", "GREETING=hi there"])
+        .arg(&script)
+        .args(["--", "arg"])
+        .assert()
+        .success()
+        .stdout(predicate::str::is_empty())
+        .stderr(
+            predicate::str::contains("run: ")
+                .and(predicate::str::contains("GREETING='hi there' "))
+                .and(predicate::str::contains("touch it.py' arg")),
+        );
+    assert!(!marker.exists(), "the program must not run");
+
+    if c_available() {
+        run_binary()
+            .args(["--dry-run", "--lang", "c", "--code", "printf(\"dry\\n\");"])
+            .assert()
+            .success()
+            .stderr(
+                predicate::str::contains("int main(void)")
+                    .and(predicate::str::contains("compile: "))
+                    .and(predicate::str::contains("main.c"))
+                    .and(predicate::str::contains("run: ")),
+            );
+    }
+}
+
+#[cfg(unix)]
+#[test]
+fn invalid_utf8_code_is_rejected_with_its_offset() {
+    use std::os::unix::ffi::OsStringExt;
+
+    let code = std::ffi::OsString::from_vec(b"print(1)\n\x93hi\x94".to_vec());
+    run_binary()
+        .args(["--lang", "python", "--code"])
+        .arg(&code)
+        .assert()
+        .code(2)
+        .stderr(predicate::str::contains(
+            "--code is not valid UTF-8: byte 0x93 at offset 9 (line 2)",
+        ));
+    run_binary()
+        .args(["python", "-c"])
+        .arg(&code)
+        .assert()
+        .code(2)
+        .stderr(predicate::str::contains("--code is not valid UTF-8"));
+    run_binary()
+        .args(["--lang", "python", "--code", "pass", "--", "ok"])
+        .arg(std::ffi::OsString::from_vec(b"a\xffb".to_vec()))
+        .assert()
+        .code(2)
+        .stderr(predicate::str::contains(
+            "program argument 2 is not valid UTF-8: byte 0xFF at offset 1",
+        ));
+}
+
+#[test]
+fn typographic_quotes_warn_and_normalize() {
+    if !python_available() {
+        eprintln!("skipping --normalize test: python interpreter not available");
+        return;
+    }
+
+    let code = "print(\u{201C}it\u{2019}s fine\u{201D})";
+    run_binary()
+        .args(["--lang", "python", "--code", code])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "warning: inline code contains '\u{201C}' (U+201C) at line 1, column 7",
+        ));
+    run_binary()
+        .args(["--normalize", "--lang", "python", "--code", code])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("it's fine"))
+        .stderr(predicate::str::contains("warning").not());
+}
+
+#[test]
+fn flags_reach_the_compiler_after_the_defaults() {
+    if !c_available() {
+        eprintln!("skipping --flags test: C toolchain not available");
+        return;
+    }
+
+    let code = "#include <stdio.h>\nint main(void) { printf(\"%ld\\n\", (long)__STDC_VERSION__); return 0; }";
+    run_binary()
+        .args([
+            "--no-cache",
+            "--lang",
+            "c",
+            "--flags",
+            "-std=c99 -DUNUSED=1",
+        ])
+        .args(["--code", code])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("199901"));
+}
+
+#[test]
+fn flags_with_shell_metacharacters_are_rejected() {
+    run_binary()
+        .args([
+            "--lang",
+            "c",
+            "--flags",
+            "-O2 | tee log",
+            "--code",
+            "int main(void) { return 0; }",
+        ])
+        .assert()
+        .code(2)
+        .stderr(predicate::str::contains("contains '|'"));
+    run_binary()
+        .args([
+            "--lang",
+            "c",
+            "--flags",
+            "-DNAME='a|b'",
+            "--code",
+            "int main(void) { return 0; }",
+            "--dry-run",
+        ])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("-DNAME="));
+}
+
+/// Directories directly under `dir`; run's own files there (the perf
+/// counters) are not scratch space.
+fn scratch_dirs(dir: &std::path::Path) -> Vec<String> {
+    std::fs::read_dir(dir)
+        .expect("read temp dir")
+        .flatten()
+        .filter(|entry| entry.path().is_dir())
+        .map(|entry| entry.file_name().to_string_lossy().into_owned())
+        .collect()
+}
+
+#[test]
+fn keep_temp_leaves_the_generated_source_behind() {
+    if !c_available() {
+        eprintln!("skipping --keep-temp test: C toolchain not available");
+        return;
+    }
+
+    let tmp = tempfile::tempdir().expect("temp dir");
+    let code = "int main(void) { return 0; }";
+    run_binary()
+        .env("TMPDIR", tmp.path())
+        .args(["--no-cache", "--lang", "c", "--code", code])
+        .assert()
+        .success();
+    assert_eq!(
+        scratch_dirs(tmp.path()),
+        Vec::<String>::new(),
+        "a run without --keep-temp leaves no directories behind"
+    );
+
+    let assert = run_binary()
+        .env("TMPDIR", tmp.path())
+        .args(["--keep-temp", "--no-cache", "--lang", "c", "--code", code])
+        .assert()
+        .success();
+    let stderr = String::from_utf8_lossy(&assert.get_output().stderr).into_owned();
+    let kept = stderr
+        .lines()
+        .find_map(|line| line.strip_prefix("[keep-temp] kept "))
+        .unwrap_or_else(|| panic!("no kept directory reported: {stderr}"));
+    let root = std::path::Path::new(kept);
+    assert!(root.starts_with(tmp.path()), "{kept}");
+    let file_name = root.file_name().unwrap().to_string_lossy().into_owned();
+    assert!(file_name.starts_with("run-"), "{file_name}");
+    let sources: Vec<_> = std::fs::read_dir(root)
+        .expect("read kept dir")
+        .flatten()
+        .filter(|entry| entry.path().join("main.c").is_file())
+        .collect();
+    assert_eq!(sources.len(), 1, "{stderr}");
+    assert!(stderr.contains(&sources[0].path().display().to_string()));
+}
+
+#[cfg(unix)]
+#[test]
+fn ctrl_c_kills_the_program_and_removes_temp_files() {
+    use std::io::{BufRead, BufReader};
+    use std::process::{Command, Stdio};
+
+    if !c_available() {
+        eprintln!("skipping interrupt test: C toolchain not available");
+        return;
+    }
+
+    let tmp = tempfile::tempdir().expect("temp dir");
+    let code = "#include <stdio.h>\n#include <unistd.h>\nint main(void) { puts(\"started\"); fflush(stdout); sleep(30); return 0; }";
+    let mut child = Command::new(env!("CARGO_BIN_EXE_run"))
+        .env("TMPDIR", tmp.path())
+        .args(["--no-cache", "--lang", "c", "--code", code])
+        .stdin(Stdio::null())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::null())
+        .spawn()
+        .expect("start run");
+    let mut line = String::new();
+    BufReader::new(child.stdout.take().unwrap())
+        .read_line(&mut line)
+        .expect("read program output");
+    assert_eq!(line, "started\n");
+
+    let sent = Command::new("kill")
+        .args(["-INT", &child.id().to_string()])
+        .status()
+        .expect("send SIGINT");
+    assert!(sent.success());
+    let status = child.wait().expect("wait for run");
+    assert_eq!(status.code(), Some(130));
+    assert_eq!(
+        scratch_dirs(tmp.path()),
+        Vec::<String>::new(),
+        "directories left after Ctrl-C"
+    );
+}
+
+#[cfg(unix)]
+#[test]
+fn signals_end_a_piped_repl_and_remove_temp_files() {
+    use std::io::{BufRead, BufReader, Write};
+    use std::process::{Command, Stdio};
+
+    if !python_available() {
+        eprintln!("skipping REPL signal test: python not available");
+        return;
+    }
+
+    for (signal, code) in [("-INT", 130), ("-TERM", 143), ("-HUP", 129)] {
+        let tmp = tempfile::tempdir().expect("temp dir");
+        let mut child = Command::new(env!("CARGO_BIN_EXE_run"))
+            .env("TMPDIR", tmp.path())
+            .args(["--no-detect", "-i", "--lang", "python"])
+            .stdin(Stdio::piped())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::null())
+            .spawn()
+            .expect("start run");
+        // Stdin stays open, so the REPL waits for the next line.
+        let mut stdin = child.stdin.take().unwrap();
+        stdin.write_all(b"print('ready')\n").expect("write entry");
+        let mut line = String::new();
+        BufReader::new(child.stdout.take().unwrap())
+            .read_line(&mut line)
+            .expect("read REPL output");
+        assert_eq!(line, "ready\n");
+
+        let sent = Command::new("kill")
+            .args([signal, &child.id().to_string()])
+            .status()
+            .expect("send signal");
+        assert!(sent.success());
+        let status = child.wait().expect("wait for run");
+        assert_eq!(status.code(), Some(code), "{signal}");
+        assert_eq!(
+            scratch_dirs(tmp.path()),
+            Vec::<String>::new(),
+            "directories left after {signal}"
+        );
+        drop(stdin);
+    }
+}
+
+#[cfg(unix)]
+#[test]
+fn terminal_settings_are_restored_after_a_program_turns_echo_off() {
+    use std::os::fd::FromRawFd;
+    use std::process::{Command, Stdio};
+
+    if !python_available() {
+        eprintln!("skipping terminal test: python interpreter not available");
+        return;
+    }
+
+    let (mut leader, mut follower) = (0, 0);
+    // SAFETY: openpty writes the two descriptors it opens; the optional
+    // name, settings and window size are left null.
+    let opened = unsafe {
+        libc::openpty(
+            &mut leader,
+            &mut follower,
+            std::ptr::null_mut(),
+            std::ptr::null_mut(),
+            std::ptr::null_mut(),
+        )
+    };
+    assert_eq!(opened, 0, "openpty failed");
+    let echo_on = || {
+        // SAFETY: tcgetattr only fills in the struct it is given.
+        unsafe {
+            let mut mode: libc::termios = std::mem::zeroed();
+            assert_eq!(libc::tcgetattr(follower, &mut mode), 0);
+            mode.c_lflag & libc::ECHO != 0
+        }
+    };
+    assert!(echo_on());
+
+    // A password prompt that is cut short by the timeout: echo is off when
+    // the program dies.
+    let code = "import termios, time\nmode = termios.tcgetattr(0)\nmode[3] &= ~termios.ECHO\ntermios.tcsetattr(0, termios.TCSANOW, mode)\ntime.sleep(30)";
+    // SAFETY: the duplicate is a fresh descriptor owned by the Stdio alone.
+    let stdin = unsafe { Stdio::from_raw_fd(libc::dup(follower)) };
+    let status = Command::new(env!("CARGO_BIN_EXE_run"))
+        .args(["--timeout", "2s", "--lang", "python", "--code", code])
+        .stdin(stdin)
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .status()
+        .expect("run program");
+    assert_eq!(status.code(), Some(124));
+    assert!(echo_on(), "echo was left off");
+
+    // SAFETY: both descriptors came from openpty and are not used again.
+    unsafe {
+        libc::close(leader);
+        libc::close(follower);
+    }
+}
+
+#[cfg(unix)]
+#[test]
+fn ctrl_c_stops_a_repl_entry_and_keeps_the_session() {
+    use std::io::Read;
+    use std::os::fd::FromRawFd;
+    use std::os::unix::process::CommandExt;
+    use std::process::{Command, Stdio};
+    use std::sync::{Arc, Mutex};
+    use std::time::{Duration, Instant};
+
+    if !python_available() {
+        eprintln!("skipping repl interrupt test: python interpreter not available");
+        return;
+    }
+
+    let (mut leader, mut follower) = (0, 0);
+    // SAFETY: openpty writes the two descriptors it opens; the optional
+    // name, settings and window size are left null.
+    let opened = unsafe {
+        libc::openpty(
+            &mut leader,
+            &mut follower,
+            std::ptr::null_mut(),
+            std::ptr::null_mut(),
+            std::ptr::null_mut(),
+        )
+    };
+    assert_eq!(opened, 0, "openpty failed");
+    // SAFETY: each duplicate is a fresh descriptor owned by its Stdio or
+    // File alone.
+    let (stdin, stdout, mut terminal, mut screen) = unsafe {
+        (
+            Stdio::from_raw_fd(libc::dup(follower)),
+            Stdio::from_raw_fd(libc::dup(follower)),
+            std::fs::File::from_raw_fd(libc::dup(leader)),
+            std::fs::File::from_raw_fd(libc::dup(leader)),
+        )
+    };
+    // Its own process group, so a SIGINT to the group reaches run and the
+    // interpreter it started, like Ctrl-C on a real terminal.
+    let mut child = Command::new(env!("CARGO_BIN_EXE_run"))
+        .args(["--lang", "python"])
+        .env("RUN_NO_HISTORY", "1")
+        .stdin(stdin)
+        .stdout(stdout)
+        .stderr(Stdio::null())
+        .process_group(0)
+        .spawn()
+        .expect("start repl");
+
+    let seen = Arc::new(Mutex::new(String::new()));
+    let reader = {
+        let seen = Arc::clone(&seen);
+        std::thread::spawn(move || {
+            let mut chunk = [0u8; 4096];
+            while let Ok(read @ 1..) = screen.read(&mut chunk) {
+                seen.lock()
+                    .unwrap()
+                    .push_str(&String::from_utf8_lossy(&chunk[..read]));
+            }
+        })
+    };
+    let wait_for = |text: &str| {
+        let deadline = Instant::now() + Duration::from_secs(30);
+        while !seen.lock().unwrap().contains(text) {
+            assert!(
+                Instant::now() < deadline,
+                "no {text:?} in {:?}",
+                seen.lock().unwrap()
+            );
+            std::thread::sleep(Duration::from_millis(20));
+        }
+    };
+
+    terminal.write_all(b"x = 41\r").expect("write to repl");
+    wait_for("[2]>>>");
+    terminal
+        .write_all(b"import time; time.sleep(60)\r")
+        .expect("write to repl");
+    std::thread::sleep(Duration::from_secs(1));
+    // SAFETY: kill only sends a signal to the group the child leads.
+    unsafe {
+        libc::kill(-(child.id() as libc::pid_t), libc::SIGINT);
+    }
+    wait_for("^C interrupted");
+    terminal
+        .write_all(b"print(x + 1)\r")
+        .expect("write to repl");
+    wait_for("\n42");
+
+    terminal.write_all(b":quit\r").expect("write to repl");
+    let status = child.wait().expect("wait for repl");
+    assert!(status.success(), "{status:?}");
+
+    // SAFETY: both descriptors came from openpty and are not used again.
+    unsafe {
+        libc::close(leader);
+        libc::close(follower);
+    }
+    drop(terminal);
+    let _ = reader.join();
+}
+
+#[cfg(target_os = "linux")]
+#[test]
+fn sandbox_limits_resources_and_uses_a_scratch_dir() {
+    if !python_available() {
+        eprintln!("skipping sandbox test: python interpreter not available");
+        return;
+    }
+
+    let code = "import os, resource\nprint(resource.getrlimit(resource.RLIMIT_NOFILE)[0], os.listdir('.'), os.path.basename(os.getcwd()).startswith('run-sandbox'))";
+    run_binary()
+        .env("RUN_SANDBOX_FILES", "64")
+        .args(["--sandbox", "--lang", "python", "--code", code])
+        .assert()
+        .success()
+        .stdout(norm_contains("64 [] True"));
+
+    run_binary()
+        .env("RUN_SANDBOX_CPU_SECS", "1")
+        .args([
+            "--sandbox",
+            "--lang",
+            "python",
+            "--code",
+            "while True: pass",
+        ])
+        .assert()
+        .code(152);
+}
+
+#[cfg(unix)]
+#[test]
+fn interpreter_flag_overrides_engine_binary() {
+    use std::os::unix::fs::PermissionsExt;
+
+    let dir = tempfile::tempdir().expect("temp dir");
+    let fake = dir.path().join("fake-python");
+    std::fs::write(&fake, "#!/bin/sh\necho \"fake python $#\"\n").expect("write interpreter");
+    std::fs::set_permissions(&fake, std::fs::Permissions::from_mode(0o755))
+        .expect("chmod interpreter");
+    let fake_path = fake.to_str().expect("utf-8 path");
+
+    let output = run_binary()
+        .args([
+            "--json",
+            "--interpreter",
+            fake_path,
+            "--lang",
+            "python",
+            "--code",
+            "print(1)",
+        ])
+        .assert()
+        .success();
+    let value: serde_json::Value =
+        serde_json::from_slice(&output.get_output().stdout).expect("stdout is a JSON object");
+    assert!(
+        value["stdout"]
+            .as_str()
+            .unwrap_or("")
+            .starts_with("fake python"),
+        "{value}"
+    );
+    assert_eq!(value["engine_binary"], fake_path, "{value}");
+
+    run_binary()
+        .env("RUN_BINARY_PYTHON", dir.path().join("missing-python"))
+        .args(["--lang", "python", "--code", "print(1)"])
+        .assert()
+        .failure()
+        .stderr(
+            predicate::str::contains("missing-python").and(predicate::str::contains("not found")),
+        );
+
+    run_binary()
+        .args(["--interpreter", fake_path, "--code", "print(1)"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("--interpreter needs --lang"));
+}
+
+#[cfg(unix)]
+#[test]
+fn user_config_sets_defaults_below_env_and_flags() {
+    use std::os::unix::fs::PermissionsExt;
+
+    let dir = tempfile::tempdir().expect("temp dir");
+    let fake = |name: &str| {
+        let path = dir.path().join(name);
+        std::fs::write(&path, format!("#!/bin/sh\necho {name}\n")).expect("write interpreter");
+        std::fs::set_permissions(&path, std::fs::Permissions::from_mode(0o755))
+            .expect("chmod interpreter");
+        path.to_str().expect("utf-8 path").to_string()
+    };
+    let (from_file, from_project, from_env, from_flag) = (
+        fake("from-file"),
+        fake("from-project"),
+        fake("from-env"),
+        fake("from-flag"),
+    );
+    let config_home = dir.path().join("config");
+    std::fs::create_dir_all(config_home.join("run")).expect("config dir");
+    std::fs::write(
+        config_home.join("run/config.toml"),
+        format!("[binaries]\npython = \"{from_file}\"\n"),
+    )
+    .expect("write config");
+    let project = dir.path().join("project");
+    std::fs::create_dir_all(&project).expect("project dir");
+
+    let run_python = |extra: &[&str]| {
+        let mut cmd = run_binary();
+        cmd.current_dir(&project)
+            .env("XDG_CONFIG_HOME", &config_home)
+            .env_remove("RUN_BINARY_PYTHON")
+            .args(extra)
+            .args(["--lang", "python", "--code", "print(1)"]);
+        cmd
+    };
+
+    run_python(&[])
+        .assert()
+        .success()
+        .stdout(norm_contains("from-file"));
+    run_python(&[])
+        .env("RUN_BINARY_PYTHON", &from_env)
+        .assert()
+        .success()
+        .stdout(norm_contains("from-env"));
+    run_python(&["--interpreter", &from_flag])
+        .env("RUN_BINARY_PYTHON", &from_env)
+        .assert()
+        .success()
+        .stdout(norm_contains("from-flag"));
+
+    std::fs::write(
+        project.join("run.toml"),
+        format!("[binaries]\npython = \"{from_project}\"\n"),
+    )
+    .expect("write run.toml");
+    run_python(&[])
+        .assert()
+        .success()
+        .stdout(norm_contains("from-project"));
+
+    run_binary()
+        .env("XDG_CONFIG_HOME", &config_home)
+        .args(["config", "path"])
+        .assert()
+        .success()
+        .stdout(norm_contains(
+            config_home
+                .join("run")
+                .join("config.toml")
+                .to_str()
+                .unwrap(),
+        ));
+}
+
+#[test]
+fn version_subcommand_can_include_engine_versions() {
+    run_binary()
+        .arg("version")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(env!("CARGO_PKG_VERSION")))
+        .stdout(predicate::str::contains("engines:").not());
+
+    let output = run_binary()
+        .args(["version", "--engines"])
+        .assert()
+        .success();
+    let stdout = String::from_utf8_lossy(&output.get_output().stdout).into_owned();
+    assert!(stdout.contains("rustc: "), "{stdout}");
+    let engines = stdout.split("engines:\n").nth(1).expect("engines section");
+    if run::engine::BashEngine::new().validate().is_ok() {
+        assert!(
+            engines
+                .lines()
+                .any(|line| line.trim_start().starts_with("bash ")),
+            "{stdout}"
+        );
+    }
+
+    run_binary()
+        .args(["version", "--json"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "Unexpected arguments after 'run version'",
+        ));
+}
+
+#[test]
+fn list_subcommand_reports_every_engine() {
+    let output = run_binary().args(["list", "--json"]).assert().success();
+    let listings: Vec<serde_json::Value> =
+        serde_json::from_slice(&output.get_output().stdout).expect("stdout is a JSON array");
+    let known = run::engine::LanguageRegistry::bootstrap().known_languages();
+    assert_eq!(listings.len(), known.len());
+
+    let python = listings
+        .iter()
+        .find(|listing| listing["language"] == "python")
+        .expect("python listed");
+    assert_eq!(python["available"], python_available());
+    assert!(
+        python["extensions"]
+            .as_array()
+            .is_some_and(|exts| exts.iter().any(|ext| ext == "py")),
+        "{python}"
+    );
+    if python_available() {
+        assert!(python["version"].is_string(), "{python}");
+    }
+
+    run_binary().arg("list").assert().success().stdout(
+        predicate::str::contains("LANGUAGE")
+            .and(predicate::str::contains("STATUS"))
+            .and(predicate::str::contains("languages available")),
+    );
+}
+
+#[test]
+fn missing_toolchain_has_install_hint_and_own_exit_code() {
+    let empty_path = tempfile::tempdir().expect("empty PATH dir");
+    run_binary()
+        .env("PATH", empty_path.path())
+        .args(["--lang", "lua", "--code", "print(1)"])
+        .assert()
+        .code(run::engine::TOOLCHAIN_MISSING_EXIT_CODE)
+        .stderr(
+            predicate::str::contains("Lua is not installed")
+                .and(predicate::str::contains("`lua`"))
+                .and(predicate::str::contains("https://www.lua.org"))
+                .and(predicate::str::contains("RUN_BINARY_LUA")),
+        );
+    run_binary()
+        .env("PATH", empty_path.path())
+        .args(["--lang", "ts", "--code", "1 + 1"])
+        .assert()
+        .code(run::engine::TOOLCHAIN_MISSING_EXIT_CODE)
+        .stderr(predicate::str::contains(
+            "no TypeScript runtime found: looked for `bun`, `deno`, `ts-node` on PATH",
+        ));
+}
+
+#[test]
+fn cwd_defaults_to_file_directory_and_can_be_overridden() {
+    if !python_available() {
+        eprintln!("skipping cwd test: python interpreter not available");
+        return;
+    }
+
+    let root = tempfile::tempdir().expect("temp dir");
+    let scripts = root.path().join("scripts");
+    std::fs::create_dir(&scripts).expect("scripts dir");
+    std::fs::write(root.path().join("data.txt"), "root\n").expect("write root data");
+    std::fs::write(scripts.join("data.txt"), "scripts\n").expect("write script data");
+    std::fs::write(
+        scripts.join("read.py"),
+        "print(open('data.txt').read().strip())\n",
+    )
+    .expect("write script");
+
+    run_binary()
+        .current_dir(root.path())
+        .args(["scripts/read.py"])
+        .assert()
+        .success()
+        .stdout(norm_contains("scripts\n"));
+
+    run_binary()
+        .current_dir(root.path())
+        .args(["--cwd", ".", "scripts/read.py"])
+        .assert()
+        .success()
+        .stdout(norm_contains("root\n"));
+
+    run_binary()
+        .current_dir(root.path())
+        .args([
+            "--lang",
+            "python",
+            "--code",
+            "print(open('data.txt').read().strip())",
+        ])
+        .assert()
+        .success()
+        .stdout(norm_contains("root\n"));
+
+    run_binary()
+        .args([
+            "--cwd",
+            "/definitely/not/here",
+            "--lang",
+            "python",
+            "--code",
+            "1",
+        ])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("is not a directory"));
+}
+
+#[test]
+fn pip_installs_packages_into_cached_venv() {
+    if !python_available() {
+        eprintln!("skipping --pip test: python interpreter not available");
+        return;
+    }
+
+    // A local wheel keeps the test offline.
+    let dir = tempfile::tempdir().expect("temp dir");
+    let wheel = dir.path().join("runpipdemo-1.0-py3-none-any.whl");
+    let build = std::process::Command::new("python3")
+        .arg("-c")
+        .arg(
+            "import sys, zipfile\n\
+             with zipfile.ZipFile(sys.argv[1], 'w') as z:\n\
+             \x20   z.writestr('runpipdemo.py', 'GREETING = \"hello from pip\"\\n')\n\
+             \x20   z.writestr('runpipdemo-1.0.dist-info/METADATA', 'Metadata-Version: 2.1\\nName: runpipdemo\\nVersion: 1.0\\n')\n\
+             \x20   z.writestr('runpipdemo-1.0.dist-info/WHEEL', 'Wheel-Version: 1.0\\nRoot-Is-Purelib: true\\nTag: py3-none-any\\n')\n\
+             \x20   z.writestr('runpipdemo-1.0.dist-info/RECORD', '')\n",
+        )
+        .arg(&wheel)
+        .status();
+    if !build.is_ok_and(|status| status.success()) {
+        eprintln!("skipping --pip test: could not build test wheel");
+        return;
+    }
+
+    let cache = tempfile::tempdir().expect("cache dir");
+    let wheel = wheel.to_str().expect("utf-8 path");
+    for _ in 0..2 {
+        let assert = run_binary()
+            .env("XDG_CACHE_HOME", cache.path())
+            .args([
+                "--pip",
+                wheel,
+                "--lang",
+                "python",
+                "--code",
+                "import runpipdemo; print(runpipdemo.GREETING)",
+            ])
+            .assert();
+        let output = assert.get_output();
+        if String::from_utf8_lossy(&output.stderr).contains("failed to create a virtualenv") {
+            eprintln!("skipping --pip test: python venv module not available");
+            return;
+        }
+        assert.success().stdout(norm_contains("hello from pip\n"));
+    }
+
+    // --no-cache builds a venv of its own; the cached one, which another run
+    // could be using, stays as it is.
+    let cached: Vec<_> = std::fs::read_dir(cache.path().join("run").join("compile"))
+        .expect("compile cache")
+        .flatten()
+        .map(|entry| entry.path())
+        .filter(|path| {
+            path.file_name()
+                .is_some_and(|name| name.to_string_lossy().starts_with("python-venv-"))
+        })
+        .collect();
+    assert_eq!(cached.len(), 1, "{cached:?}");
+    let marker = cached[0].join("in-use");
+    std::fs::write(&marker, "").expect("write marker");
+    run_binary()
+        .env("XDG_CACHE_HOME", cache.path())
+        .args([
+            "--no-cache",
+            "--pip",
+            wheel,
+            "--lang",
+            "python",
+            "--code",
+            "import runpipdemo, sys; print(runpipdemo.GREETING, 'run-python-venv' in sys.prefix)",
+        ])
+        .assert()
+        .success()
+        .stdout(norm_contains("hello from pip True\n"));
+    assert!(marker.exists(), "--no-cache replaced the cached venv");
+
+    // Interpreter flags are for the program and do not change the venv.
+    run_binary()
+        .env("XDG_CACHE_HOME", cache.path())
+        .args([
+            "--flags",
+            "-B",
+            "--pip",
+            wheel,
+            "--lang",
+            "python",
+            "--code",
+            "import runpipdemo, sys; print(runpipdemo.GREETING, sys.flags.dont_write_bytecode)",
+        ])
+        .assert()
+        .success()
+        .stdout(norm_contains("hello from pip 1\n"));
+    let venvs = std::fs::read_dir(cache.path().join("run").join("compile"))
+        .expect("compile cache")
+        .flatten()
+        .filter(|entry| {
+            entry
+                .file_name()
+                .to_string_lossy()
+                .starts_with("python-venv-")
+        })
+        .count();
+    assert_eq!(venvs, 1, "--flags built another venv");
+
+    run_binary()
+        .args(["--pip", wheel, "--lang", "bash", "--code", "echo hi"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("--pip installs Python packages"));
+}
+
+#[test]
+fn csharp_top_level_statements_and_cached_build() {
+    if !csharp_available() {
+        eprintln!("skipping csharp run test: dotnet not available");
+        return;
+    }
+
+    let cache = tempfile::tempdir().expect("cache dir");
+    let code = "Console.WriteLine(\"hi \" + string.Join(\",\", args));";
+    for _ in 0..2 {
+        run_binary()
+            .env("XDG_CACHE_HOME", cache.path())
+            .args(["--lang", "csharp", "--code", code, "--", "a", "b"])
+            .assert()
+            .success()
+            .stdout(norm_contains("hi a,b\n"));
+    }
+
+    let dir = tempfile::tempdir().expect("temp dir");
+    let script = dir.path().join("answer.csx");
+    std::fs::write(&script, "var x = 21;\nConsole.WriteLine(x * 2);\n").expect("write script");
+    run_binary()
+        .env("XDG_CACHE_HOME", cache.path())
+        .arg(&script)
+        .assert()
+        .success()
+        .stdout(norm_contains("42\n"));
+}
+
+#[test]
+fn timings_split_compile_and_run_time() {
+    if python_available() {
+        let output = run_binary()
+            .args([
+                "--timings",
+                "--json",
+                "--lang",
+                "python",
+                "--code",
+                "print(1)",
+            ])
+            .assert()
+            .success();
+        let value: serde_json::Value =
+            serde_json::from_slice(&output.get_output().stdout).expect("stdout is a JSON object");
+        let timings = &value["timings"];
+        assert!(timings["run_ms"].is_u64(), "{value}");
+        assert!(timings["total_ms"].is_u64(), "{value}");
+        assert!(timings.get("compile_ms").is_none(), "{value}");
+    }
+
+    if c_available() {
+        let cache_home = tempfile::tempdir().expect("cache dir");
+        run_binary()
+            .env("XDG_CACHE_HOME", cache_home.path())
+            .args([
+                "--timings",
+                "--lang",
+                "c",
+                "--code",
+                "#include <stdio.h>\nint main(void) { puts(\"timed\"); return 0; }",
+            ])
+            .assert()
+            .success()
+            .stdout(norm_contains("timed\n"))
+            .stderr(
+                predicate::str::contains("[timings] compile_ms=")
+                    .and(predicate::str::contains("run_ms="))
+                    .and(predicate::str::contains("total_ms=")),
+            );
+    }
+}
+
+#[test]
+fn file_with_unknown_extension_lists_known_extensions() {
+    let mut script = tempfile::Builder::new()
+        .suffix(".xyz")
+        .tempfile()
+        .expect("temp file");
+    writeln!(script, "print('hi')").expect("write file");
+
+    run_binary()
+        .args(["--file", script.path().to_str().expect("path utf8")])
+        .assert()
+        .failure()
+        .stderr(
+            predicate::str::contains("unknown extension '.xyz'")
+                .and(predicate::str::contains(".py"))
+                .and(predicate::str::contains(".rs")),
+        );
+}
+
+#[test]
+fn shebang_selects_engine_for_extensionless_file() {
+    if !python_available() {
+        eprintln!("skipping shebang test: python interpreter not available");
+        return;
+    }
+
+    let mut script = NamedTempFile::new().expect("temp file");
+    writeln!(script, "#!/usr/bin/env python3\nprint('from shebang')").expect("write file");
+
+    run_binary()
+        .args(["--file", script.path().to_str().expect("path utf8")])
+        .assert()
+        .success()
+        .stdout(norm_contains("from shebang\n"));
+}
+
+#[test]
+fn shebang_interpreter_mapping() {
+    use run::engine::shebang_language;
+
+    assert_eq!(shebang_language("#!/usr/bin/env python3"), Some("python"));
+    assert_eq!(shebang_language("#!/usr/bin/python3.12 -u"), Some("python"));
+    assert_eq!(
+        shebang_language("#!/usr/bin/env -S node --no-warnings"),
+        Some("javascript")
+    );
+    assert_eq!(shebang_language("#! /usr/bin/ruby"), Some("ruby"));
+    assert_eq!(shebang_language("#!/bin/bash -e"), Some("bash"));
+    assert_eq!(shebang_language("#!/usr/bin/env unknown-tool"), None);
+    assert_eq!(shebang_language("print('no shebang')"), None);
 }
 
-fn norm_contains(needle: &str) -> impl predicates::Predicate<str> {
-    let needle = needle.to_string();
-    predicates::function::function(move |s: &str| norm(s).contains(needle.as_str()))
+#[test]
+fn file_and_code_are_mutually_exclusive() {
+    run_binary()
+        .args(["--file", "main.py", "--code", "print(1)"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "--code and --file cannot be used together",
+        ));
 }
 
 #[test]
-fn inline_python_execution() {
+fn repeated_code_flags_are_joined_in_order() {
     if !python_available() {
-        eprintln!("skipping python inline test: python interpreter not available");
+        eprintln!("skipping repeated --code test: python interpreter not available");
         return;
     }
 
     run_binary()
-        .args(["--lang", "python", "--code", "print('hello from inline')"])
+        .args([
+            "--lang",
+            "python",
+            "--code",
+            "import math",
+            "-c",
+            "r = 2",
+            "--code",
+            "print(round(math.pi * r * r, 2))",
+        ])
         .assert()
         .success()
-        .stdout(norm_contains("hello from inline\n"));
+        .stdout(norm_contains("12.57\n"));
 }
 
 #[test]
-fn inline_python_positional_with_backslash() {
+fn double_dash_forwards_program_arguments() {
     if !python_available() {
-        eprintln!("skipping python positional inline test: python interpreter not available");
+        eprintln!("skipping argv test: python interpreter not available");
         return;
     }
 
+    let mut script = tempfile::Builder::new()
+        .suffix(".py")
+        .tempfile()
+        .expect("temp file");
+    writeln!(script, "import sys\nprint(sys.argv[1:])").expect("write file");
+
     run_binary()
-        .args(["python", "print('positional\\\\n')"])
+        .args([
+            "--lang",
+            "python",
+            "--file",
+            script.path().to_str().expect("path utf8"),
+            "--",
+            "--verbose",
+            "two words",
+            "--",
+        ])
         .assert()
         .success()
-        .stdout(norm_contains("positional\\n"));
+        .stdout(norm_contains("['--verbose', 'two words', '--']\n"));
 }
 
 #[test]
-fn inline_python_positional_with_division() {
-    if !python_available() {
-        eprintln!("skipping python positional division test: python interpreter not available");
+fn double_dash_arguments_start_at_dollar_one_for_inline_bash() {
+    if !bash_available() {
+        eprintln!("skipping bash argv test: bash not available");
         return;
     }
 
     run_binary()
-        .args(["python", "print(10 / 2)"])
+        .args([
+            "--lang",
+            "bash",
+            "--code",
+            "printf '<%s>' \"$@\"; echo",
+            "--",
+            "a b",
+            "c",
+        ])
         .assert()
         .success()
-        .stdout(norm_contains("5.0\n"));
+        .stdout(norm_contains("<a b><c>\n"));
 }
 
 #[test]
-fn python_file_arguments() {
+fn env_flags_reach_the_program() {
     if !python_available() {
-        eprintln!("skipping python file args test: python interpreter not available");
+        eprintln!("skipping --env test: python interpreter not available");
         return;
     }
 
-    let mut script = tempfile::Builder::new()
-        .suffix(".py")
-        .tempfile()
-        .expect("temp file");
+    let mut env_file = NamedTempFile::new().expect("temp file");
     writeln!(
-        script,
-        "import sys\nprint(\"hello {{}}\".format(\" \".join(sys.argv[1:])))"
+        env_file,
+        "# settings\nexport GREETING=\"from file\"\nLEVEL=file"
     )
-    .expect("write file");
+    .expect("write env file");
 
     run_binary()
-        .args([script.path().to_str().expect("path utf8"), "Alice", "Bob"])
+        .env("RUN_TEST_INHERITED", "parent")
+        .args([
+            "--env-file",
+            env_file.path().to_str().expect("path utf8"),
+            "--env",
+            "LEVEL=flag",
+            "--lang",
+            "python",
+            "--code",
+            "import os; print(os.environ['GREETING'], os.environ['LEVEL'], os.environ.get('RUN_TEST_INHERITED'))",
+        ])
         .assert()
         .success()
-        .stdout(norm_contains("hello Alice Bob\n"));
+        .stdout(norm_contains("from file flag parent\n"));
 }
 
 #[test]
-fn python_short_code_flag_reads_stdin() {
-    if !python_available() {
-        eprintln!("skipping python -c stdin test: python interpreter not available");
+fn clean_env_keeps_only_explicit_variables_and_path() {
+    if !bash_available() {
+        eprintln!("skipping --clean-env test: bash not available");
         return;
     }
 
-    let code = r#"import sys, json; data = json.load(sys.stdin); print(f"{data['name']} is {data['age']} years old")"#;
+    run_binary()
+        .env("RUN_TEST_INHERITED", "parent")
+        .args([
+            "--clean-env",
+            "--env",
+            "ONLY=me",
+            "--lang",
+            "bash",
+            "--code",
+            "echo \"${RUN_TEST_INHERITED:-unset} $ONLY ${PATH:+has-path}\"",
+        ])
+        .assert()
+        .success()
+        .stdout(norm_contains("unset me has-path\n"));
+}
+
+#[test]
+fn compile_cache_lives_under_xdg_cache_home_and_clears() {
+    if !c_available() {
+        eprintln!("skipping compile cache test: C toolchain not available");
+        return;
+    }
+
+    let cache_home = tempfile::tempdir().expect("cache dir");
+    let compile_dir = cache_home.path().join("run").join("compile");
+    let code = "#include <stdio.h>\nint main(int argc, char **argv) { printf(\"cached %d\\n\", argc); return 0; }";
+
+    for _ in 0..2 {
+        run_binary()
+            .env("XDG_CACHE_HOME", cache_home.path())
+            .args(["--lang", "c", "--code", code, "--", "x"])
+            .assert()
+            .success()
+            .stdout(norm_contains("cached 2\n"));
+    }
+    assert!(
+        std::fs::read_dir(&compile_dir).expect("cache dir").count() > 0,
+        "expected cached builds in {}",
+        compile_dir.display()
+    );
 
     run_binary()
-        .args(["py", "-c", code])
-        .write_stdin("{\"name\":\"Ada\",\"age\":32}\n")
+        .env("XDG_CACHE_HOME", cache_home.path())
+        .args(["cache", "clear"])
         .assert()
         .success()
-        .stdout(norm_contains("Ada is 32 years old\n"));
+        .stdout(norm_contains("Removed"));
+    assert_eq!(
+        std::fs::read_dir(&compile_dir)
+            .map(|d| d.count())
+            .unwrap_or(0),
+        0
+    );
+}
+
+#[test]
+fn wrapped_snippet_errors_cite_snippet_lines() {
+    if !c_available() {
+        eprintln!("skipping line mapping test: C toolchain not available");
+        return;
+    }
+
+    run_binary()
+        .args(["--lang", "c", "--code", "int x = 1;\nx = undefined_name;"])
+        .assert()
+        .failure()
+        .stderr(
+            predicate::str::contains("main.c:2:").and(predicate::str::contains("undefined_name")),
+        );
+}
+
+#[test]
+fn no_cache_flag_still_runs_compiled_code() {
+    if !c_available() {
+        eprintln!("skipping --no-cache test: C toolchain not available");
+        return;
+    }
+
+    let cache_home = tempfile::tempdir().expect("cache dir");
+    run_binary()
+        .env("XDG_CACHE_HOME", cache_home.path())
+        .args([
+            "--no-cache",
+            "--lang",
+            "c",
+            "--code",
+            "#include <stdio.h>\nint main(void) { puts(\"fresh\"); return 0; }",
+        ])
+        .assert()
+        .success()
+        .stdout(norm_contains("fresh\n"));
+    let cached_builds = std::fs::read_dir(cache_home.path().join("run").join("compile"))
+        .map(|dir| {
+            dir.flatten()
+                .filter(|entry| entry.file_name().to_string_lossy().starts_with("c-"))
+                .count()
+        })
+        .unwrap_or(0);
+    assert_eq!(cached_builds, 0, "--no-cache must not store builds");
 }
 
 #[test]
@@ -692,6 +2231,57 @@ fn rust_session_interactivity() {
     session.shutdown().expect("shutdown rust session");
 }
 
+#[test]
+fn rust_session_keeps_latest_definitions() {
+    if !rust_available() {
+        eprintln!("skipping rust session test: rustc not available");
+        return;
+    }
+
+    let engine = run::engine::RustEngine::new();
+    let mut session = engine.start_session().expect("start rust session");
+
+    session.eval("let x = 5").expect("define without semicolon");
+    let printed = session
+        .eval("println!(\"{}\", x)")
+        .expect("print earlier binding");
+    assert_eq!(printed.stdout, "5\n");
+
+    session
+        .eval("fn sq(n: i32) -> i32 { n * n }")
+        .expect("define fn");
+    session
+        .eval("fn sq(n: i32) -> i32 { n * n * n }")
+        .expect("redefine fn");
+    let latest = session.eval("sq(2)").expect("call redefined fn");
+    assert!(latest.stdout.contains('8'), "stdout: {}", latest.stdout);
+    assert_eq!(latest.exit_code, Some(0), "stderr: {}", latest.stderr);
+
+    // The old type's impl blocks would not compile against the new fields.
+    session
+        .eval("struct Point { a: i32 }")
+        .expect("define struct");
+    session
+        .eval("impl Point { fn total(&self) -> i32 { self.a } }")
+        .expect("define impl");
+    session
+        .eval("impl std::fmt::Display for Point { fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result { write!(f, \"{}\", self.a) } }")
+        .expect("define trait impl");
+    let redefined = session
+        .eval("struct Point { x: i32, y: i32 }")
+        .expect("redefine struct");
+    assert_eq!(redefined.exit_code, Some(0), "stderr: {:?}", redefined);
+    session
+        .eval("impl Point { fn total(&self) -> i32 { self.x + self.y } }")
+        .expect("define new impl");
+    let total = session
+        .eval("Point { x: 1, y: 2 }.total()")
+        .expect("call new impl");
+    assert!(total.stdout.contains('3'), "stdout: {}", total.stdout);
+
+    session.shutdown().expect("shutdown rust session");
+}
+
 #[test]
 fn javascript_session_interactivity() {
     if !javascript_available() {
@@ -720,6 +2310,38 @@ fn javascript_session_interactivity() {
     session.shutdown().expect("shutdown javascript session");
 }
 
+#[test]
+fn sessions_are_not_alive_after_the_interpreter_exits() {
+    let engines: [(Box<dyn LanguageEngine>, &str); 3] = [
+        (
+            Box::new(run::engine::JavascriptEngine::new()),
+            "process.exit(0)",
+        ),
+        (Box::new(run::engine::RubyEngine::new()), "exit!"),
+        (Box::new(run::engine::JavaEngine::new()), "System.exit(0);"),
+    ];
+    for (engine, exit) in engines {
+        if engine.validate().is_err() || !engine.supports_sessions() {
+            eprintln!("skipping {} session test: not available", engine.id());
+            continue;
+        }
+
+        let mut session = engine.start_session().expect("start session");
+        assert!(session.is_alive(), "{} session is not alive", engine.id());
+        let _ = session.eval(exit);
+        let deadline = std::time::Instant::now() + std::time::Duration::from_secs(10);
+        while session.is_alive() && std::time::Instant::now() < deadline {
+            std::thread::sleep(std::time::Duration::from_millis(20));
+        }
+        assert!(
+            !session.is_alive(),
+            "{} session is still alive",
+            engine.id()
+        );
+        let _ = session.shutdown();
+    }
+}
+
 #[test]
 fn bash_session_interactivity() {
     if !bash_available() {
@@ -1049,6 +2671,40 @@ fn go_session_interactivity() {
     session.shutdown().expect("shutdown go session");
 }
 
+#[test]
+fn go_session_redeclarations() {
+    if !go_available() {
+        eprintln!("skipping go session test: go toolchain not available");
+        return;
+    }
+
+    let engine = run::engine::GoEngine::new();
+    let mut session = engine.start_session().expect("start go session");
+
+    session.eval("x := 5").expect("declare go variable");
+    session.eval("x := 7").expect("redeclare go variable");
+    let value = session.eval("x").expect("read go variable");
+    assert!(value.stdout.contains('7'), "stderr: {}", value.stderr);
+
+    let retyped = session.eval("x := \"seven\"").expect("retype go variable");
+    assert!(
+        retyped.stderr.contains("the variable keeps its type"),
+        "stderr: {}",
+        retyped.stderr
+    );
+
+    session
+        .eval("func sq(n int) int { return n * n }")
+        .expect("define go func");
+    session
+        .eval("func sq(n int) int { return n * n * n }")
+        .expect("redefine go func");
+    let latest = session.eval("sq(2)").expect("call redefined go func");
+    assert!(latest.stdout.contains('8'), "stderr: {}", latest.stderr);
+
+    session.shutdown().expect("shutdown go session");
+}
+
 #[test]
 fn inline_csharp_execution() {
     if !csharp_available() {
@@ -1199,6 +2855,20 @@ fn inline_lua_execution() {
         .stdout(norm_contains("inline-lua\n"));
 }
 
+#[test]
+fn inline_lua_expression_is_printed() {
+    if !lua_available() {
+        eprintln!("skipping lua expression test: lua interpreter not available");
+        return;
+    }
+
+    let output = run_binary()
+        .args(["--lang", "lua", "--code", "10 + 5"])
+        .assert()
+        .success();
+    assert_eq!(String::from_utf8_lossy(&output.get_output().stdout), "15\n");
+}
+
 #[test]
 fn lua_file_execution() {
     if !lua_available() {
@@ -2080,6 +3750,23 @@ fn inline_elixir_execution() {
         .stdout(norm_contains("inline-elixir\n"));
 }
 
+#[test]
+fn inline_elixir_expression_is_inspected() {
+    if !elixir_available() {
+        eprintln!("skipping elixir expression test: elixir executable not available");
+        return;
+    }
+
+    let output = run_binary()
+        .args(["--lang", "elixir", "--code", "Enum.map([1, 2], &(&1 * 2))"])
+        .assert()
+        .success();
+    assert_eq!(
+        String::from_utf8_lossy(&output.get_output().stdout),
+        "[2, 4]\n"
+    );
+}
+
 #[test]
 fn elixir_session_interactivity() {
     if !elixir_available() {
@@ -2209,6 +3896,22 @@ pub fn main() void {
         .stdout(norm_contains("inline-zig\n"));
 }
 
+#[test]
+fn zig_snippet_errors_come_from_the_expression_wrapper() {
+    if !zig_available() {
+        eprintln!("skipping zig error test: zig executable not available");
+        return;
+    }
+
+    // As plain statements the bare expression would also miss its `;`.
+    run_binary()
+        .args(["--no-cache", "--lang", "zig", "--code", "missing_value"])
+        .assert()
+        .failure()
+        .stderr(norm_contains("undeclared identifier 'missing_value'"))
+        .stderr(norm_contains("expected ';'").not());
+}
+
 #[test]
 fn zig_file_execution() {
     if !zig_available() {
@@ -2434,3 +4137,125 @@ fn nim_session_unused_variable_suppressed() {
 
     session.shutdown().expect("shutdown nim session");
 }
+
+#[test]
+fn sql_snippet_prints_a_table() {
+    if !sql_available() {
+        eprintln!("skipping sql test: sqlite3 not available");
+        return;
+    }
+
+    run_binary()
+        .args([
+            "--lang",
+            "sql",
+            "--code",
+            "create table langs(name text, year int); insert into langs values ('C', 1972), ('Rust', 2015); select name from langs where year > 2000;",
+        ])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("| name |"))
+        .stdout(predicate::str::contains("| Rust |"));
+
+    run_binary()
+        .args([
+            "--lang",
+            "sql",
+            "--code",
+            "select * from missing; select 'unreached';",
+        ])
+        .assert()
+        .code(1)
+        .stdout(predicate::str::contains("unreached").not())
+        .stderr(predicate::str::contains("no such table: missing"));
+}
+
+#[test]
+fn sql_repl_keeps_tables_between_entries() {
+    if !sql_available() {
+        eprintln!("skipping sql repl test: sqlite3 not available");
+        return;
+    }
+
+    run_binary()
+        .args(["--no-detect", "-i", "--lang", "sql"])
+        .write_stdin(
+            "create table t(a int);\ninsert into t\nvalues (7);\nselect a * 6 as answer from t;\n",
+        )
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("| 42     |"));
+
+    run_binary()
+        .args(["--no-detect", "-i", "--flags", "-json", "--lang", "sql"])
+        .write_stdin(
+            "create table t(a int);\ninsert into t values (7);\nselect a * 6 as answer from t;\n",
+        )
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(r#"[{"answer":42}]"#));
+}
+
+#[test]
+fn init_writes_a_starter_that_runs() {
+    let dir = tempfile::tempdir().expect("temp dir");
+    let path = dir.path().join("hello.py");
+
+    run_binary()
+        .args(["init", "python"])
+        .arg(&path)
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Wrote a Python starter"));
+    let starter = std::fs::read_to_string(&path).expect("starter written");
+    assert!(starter.contains("Hello, world!"), "{starter}");
+
+    if python_available() {
+        run_binary()
+            .arg(&path)
+            .assert()
+            .success()
+            .stdout(predicate::str::contains("Hello, world!"));
+    }
+}
+
+#[test]
+fn init_refuses_to_overwrite_without_force() {
+    let dir = tempfile::tempdir().expect("temp dir");
+    let path = dir.path().join("main.go");
+    std::fs::write(&path, "keep me\n").expect("write existing file");
+
+    run_binary()
+        .args(["init", "go"])
+        .arg(&path)
+        .assert()
+        .code(2)
+        .stderr(predicate::str::contains("already exists; pass --force"));
+    assert_eq!(std::fs::read_to_string(&path).unwrap(), "keep me\n");
+
+    run_binary()
+        .args(["init", "--force", "go"])
+        .arg(&path)
+        .assert()
+        .success();
+    assert!(
+        std::fs::read_to_string(&path)
+            .unwrap()
+            .contains("func main()")
+    );
+}
+
+#[test]
+fn init_stdout_prints_the_starter() {
+    run_binary()
+        .args(["init", "--stdout", "rust"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("fn main() {"));
+
+    run_binary()
+        .args(["init", "rust"])
+        .assert()
+        .code(2)
+        .stderr(predicate::str::contains("needs a path"));
+}
@@ -0,0 +1,400 @@
+use std::io::Write;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+use run::engine::{ExecutionOutcome, ExecutionPayload, LanguageEngine, LanguageRegistry};
+use run::{OutputStream, Request};
+
+fn python_available() -> bool {
+    run::engine::PythonEngine::new().validate().is_ok()
+}
+
+fn csharp_available() -> bool {
+    run::engine::CSharpEngine::new().validate().is_ok()
+}
+
+#[test]
+fn run_passes_stdin_env_and_args() {
+    if !python_available() {
+        eprintln!("skipping library api test: python not available");
+        return;
+    }
+
+    let code = "import os, sys\nprint(sys.stdin.read().strip(), os.environ['RUN_API_GREETING'], sys.argv[1:])";
+    let outcome = run::run(
+        Request::new(code)
+            .language("python")
+            .stdin("from stdin\n")
+            .env("RUN_API_GREETING", "hi")
+            .args(["a", "b"]),
+    )
+    .expect("python run");
+
+    assert_eq!(outcome.exit_code, Some(0), "stderr: {}", outcome.stderr);
+    assert_eq!(outcome.stdout, "from stdin hi ['a', 'b']\n");
+}
+
+#[test]
+fn run_passes_flags_to_the_interpreter() {
+    if !python_available() {
+        eprintln!("skipping library api test: python not available");
+        return;
+    }
+
+    let outcome = run::run(
+        Request::new("import sys; print(sys.flags.optimize, sys.argv[1:])")
+            .language("python")
+            .flags(["-O"])
+            .args(["-O"]),
+    )
+    .expect("python run");
+    assert_eq!(outcome.stdout, "1 ['-O']\n", "stderr: {}", outcome.stderr);
+}
+
+#[test]
+fn run_uses_requested_working_directory() {
+    if !python_available() {
+        eprintln!("skipping library api test: python not available");
+        return;
+    }
+
+    let dir = tempfile::tempdir().expect("temp dir");
+    std::fs::write(dir.path().join("input.txt"), "from cwd\n").expect("write input");
+    let outcome = run::run(
+        Request::new("print(open('input.txt').read().strip())")
+            .language("python")
+            .cwd(dir.path()),
+    )
+    .expect("python run");
+    assert_eq!(outcome.stdout, "from cwd\n", "stderr: {}", outcome.stderr);
+}
+
+#[test]
+fn run_reports_exit_code_and_timeout() {
+    if !python_available() {
+        eprintln!("skipping library api test: python not available");
+        return;
+    }
+
+    let failed =
+        run::run(Request::new("import sys; sys.exit(3)").language("python")).expect("python run");
+    assert_eq!(failed.exit_code, Some(3));
+
+    let timed_out = run::run(
+        Request::new("import time; time.sleep(30)")
+            .language("python")
+            .timeout(Duration::from_millis(300)),
+    )
+    .expect("python run");
+    assert_eq!(timed_out.exit_code, Some(124));
+    assert!(timed_out.duration < Duration::from_secs(10));
+}
+
+/// Writer that keeps what it is given, readable from the test.
+#[derive(Clone, Default)]
+struct Collected(Arc<Mutex<Vec<u8>>>);
+
+impl Collected {
+    fn text(&self) -> String {
+        String::from_utf8(self.0.lock().unwrap().clone()).unwrap()
+    }
+}
+
+impl Write for Collected {
+    fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+        self.0.lock().unwrap().extend_from_slice(buf);
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> std::io::Result<()> {
+        Ok(())
+    }
+}
+
+#[test]
+fn run_streams_output_and_still_captures_it() {
+    if !python_available() {
+        eprintln!("skipping library api test: python not available");
+        return;
+    }
+
+    let (out, err) = (Collected::default(), Collected::default());
+    let stream = OutputStream::default()
+        .stdout(out.clone())
+        .stderr(err.clone());
+    let outcome = run::run(
+        Request::new("import sys\nprint('to out')\nprint('to err', file=sys.stderr)")
+            .language("python")
+            .stream(stream.clone()),
+    )
+    .expect("python run");
+
+    assert_eq!(outcome.stdout, "to out\n");
+    assert_eq!(outcome.stderr, "to err\n");
+    assert_eq!(out.text(), "to out\n");
+    assert_eq!(err.text(), "to err\n");
+    assert!(stream.wrote_stdout() && stream.wrote_stderr());
+}
+
+#[test]
+fn run_rejects_unknown_language() {
+    let err = run::run(Request::new("x").language("not-a-language")).unwrap_err();
+    assert!(format!("{err:#}").contains("not-a-language"), "{err:#}");
+}
+
+#[test]
+fn engines_can_be_listed_and_looked_up() {
+    let engines = run::engines();
+    let ids: Vec<&str> = engines.iter().map(|engine| engine.id()).collect();
+    let mut sorted = ids.clone();
+    sorted.sort();
+    assert_eq!(ids, sorted);
+    assert!(ids.contains(&"python") && ids.contains(&"rust"), "{ids:?}");
+
+    let python = run::engine_for("py").expect("python by alias");
+    assert_eq!(python.id(), "python");
+    assert!(python.extensions().contains(&"py"));
+    assert_eq!(python.available(), python_available());
+    assert!(run::engine_for("not-a-language").is_none());
+}
+
+#[test]
+fn every_engine_has_a_starter_program() {
+    for engine in run::engines() {
+        let starter = engine
+            .starter_program("Hello")
+            .unwrap_or_else(|| panic!("{} has no starter program", engine.id()));
+        assert!(
+            starter.contains("Hello, world!"),
+            "{}: {starter}",
+            engine.id()
+        );
+    }
+    let java = run::engine_for("java").expect("java engine");
+    assert!(
+        java.starter_program("Hello")
+            .unwrap()
+            .contains("public class Hello ")
+    );
+}
+
+#[test]
+fn engines_can_be_looked_up_from_many_threads() {
+    let handles: Vec<_> = (0..8)
+        .map(|_| {
+            std::thread::spawn(|| {
+                let ids: Vec<&str> = run::engines().iter().map(|engine| engine.id()).collect();
+                let python = run::engine_for("python").map(|engine| engine.id());
+                (ids, python)
+            })
+        })
+        .collect();
+    let results: Vec<_> = handles
+        .into_iter()
+        .map(|handle| handle.join().expect("lookup thread"))
+        .collect();
+    for (ids, python) in &results {
+        assert_eq!(ids, &results[0].0);
+        assert_eq!(*python, Some("python"));
+    }
+}
+
+/// Engine that only has a name, for registry tests.
+struct NamedEngine {
+    id: &'static str,
+    aliases: &'static [&'static str],
+}
+
+impl LanguageEngine for NamedEngine {
+    fn id(&self) -> &'static str {
+        self.id
+    }
+
+    fn aliases(&self) -> &[&'static str] {
+        self.aliases
+    }
+
+    fn execute(&self, _payload: &ExecutionPayload) -> anyhow::Result<ExecutionOutcome> {
+        anyhow::bail!("{} does not run anything", self.id)
+    }
+}
+
+#[test]
+fn registering_a_taken_language_name_is_an_error() {
+    let mut registry = LanguageRegistry::bootstrap();
+    let err = registry
+        .try_register_language(NamedEngine {
+            id: "python",
+            aliases: &[],
+        })
+        .unwrap_err();
+    assert!(
+        format!("{err:#}").contains("language id 'python'"),
+        "{err:#}"
+    );
+
+    let err = registry
+        .try_register_language(NamedEngine {
+            id: "snake",
+            aliases: &["py"],
+        })
+        .unwrap_err();
+    assert_eq!(
+        format!("{err:#}"),
+        "engine 'snake' claims the language name 'python', which already belongs to engine 'python'"
+    );
+    assert!(registry.resolve_by_id("snake").is_none());
+
+    registry
+        .try_register_language(NamedEngine {
+            id: "snake",
+            aliases: &["snk"],
+        })
+        .expect("free name");
+    assert_eq!(
+        registry.resolve_by_id("snk").map(|engine| engine.id()),
+        Some("snake")
+    );
+}
+
+#[test]
+#[should_panic(expected = "already belongs to engine 'bash'")]
+fn register_language_panics_on_a_duplicate_alias() {
+    LanguageRegistry::bootstrap().register_language(NamedEngine {
+        id: "shell",
+        aliases: &["sh"],
+    });
+}
+
+#[cfg(target_os = "linux")]
+#[test]
+fn sandbox_limits_apply_to_the_program_and_not_to_build_steps() {
+    use run::engine::{
+        RunOverrides, SandboxLimits, build_step_with_timeout, run_with_timeout, with_run_overrides,
+    };
+    use std::process::Command;
+
+    let overrides = RunOverrides {
+        sandbox: Some(SandboxLimits {
+            open_files: 64,
+            ..SandboxLimits::default()
+        }),
+        ..RunOverrides::default()
+    };
+    let open_files =
+        |output: std::process::Output| String::from_utf8_lossy(&output.stdout).trim().to_string();
+    let (program, build) = with_run_overrides(overrides, || {
+        let ulimit = || {
+            let mut cmd = Command::new("sh");
+            cmd.args(["-c", "ulimit -n"]);
+            cmd
+        };
+        (
+            run_with_timeout(&mut ulimit()).expect("run sh"),
+            build_step_with_timeout(&mut ulimit()).expect("run sh"),
+        )
+    });
+
+    assert_eq!(open_files(program), "64");
+    assert_ne!(open_files(build), "64");
+}
+
+#[test]
+fn build_steps_are_not_streamed() {
+    use run::engine::{RunOverrides, build_step_with_timeout, with_run_overrides};
+    use std::process::Command;
+
+    let (out, err) = (Collected::default(), Collected::default());
+    let overrides = RunOverrides {
+        stream: Some(
+            OutputStream::default()
+                .stdout(out.clone())
+                .stderr(err.clone()),
+        ),
+        ..RunOverrides::default()
+    };
+    let output = with_run_overrides(overrides, || {
+        build_step_with_timeout(Command::new("sh").args(["-c", "echo built; echo warning >&2"]))
+    })
+    .expect("run sh");
+
+    assert_eq!(String::from_utf8_lossy(&output.stdout), "built\n");
+    assert_eq!(String::from_utf8_lossy(&output.stderr), "warning\n");
+    assert_eq!((out.text(), err.text()), (String::new(), String::new()));
+}
+
+#[test]
+fn streamed_run_leaves_compiler_errors_to_the_outcome() {
+    if !csharp_available() {
+        eprintln!("skipping library api test: dotnet not available");
+        return;
+    }
+
+    let (out, err) = (Collected::default(), Collected::default());
+    let outcome = run::run(
+        Request::new("int x = ;").language("csharp").stream(
+            OutputStream::default()
+                .stdout(out.clone())
+                .stderr(err.clone()),
+        ),
+    )
+    .expect("csharp run");
+
+    let reported = format!(
+        "{}{}",
+        outcome.stdout,
+        outcome.compile_stderr.as_deref().unwrap_or_default()
+    );
+    assert!(reported.contains("CS1525"), "{reported}");
+    assert_eq!((out.text(), err.text()), (String::new(), String::new()));
+}
+
+#[test]
+fn run_can_be_cancelled_while_the_program_runs() {
+    use std::sync::atomic::{AtomicBool, Ordering};
+    use std::time::Instant;
+
+    if !python_available() {
+        eprintln!("skipping library api test: python not available");
+        return;
+    }
+
+    let cancel = Arc::new(AtomicBool::new(false));
+    let trigger = {
+        let cancel = Arc::clone(&cancel);
+        std::thread::spawn(move || {
+            std::thread::sleep(Duration::from_millis(300));
+            cancel.store(true, Ordering::SeqCst);
+        })
+    };
+    let start = Instant::now();
+    let err = run::run(
+        Request::new("import time\ntime.sleep(30)")
+            .language("python")
+            .cancel_on(Arc::clone(&cancel)),
+    )
+    .unwrap_err();
+    trigger.join().unwrap();
+
+    assert!(format!("{err:#}").contains("run cancelled"), "{err:#}");
+    assert!(
+        start.elapsed() < Duration::from_secs(10),
+        "{:?}",
+        start.elapsed()
+    );
+
+    // Already cancelled: the program never starts.
+    let dir = tempfile::tempdir().expect("temp dir");
+    let marker = dir.path().join("ran");
+    let err = run::run(
+        Request::new(format!(
+            "open({:?}, 'w').close()",
+            marker.display().to_string()
+        ))
+        .language("python")
+        .cancel_on(cancel),
+    )
+    .unwrap_err();
+    assert!(format!("{err:#}").contains("run cancelled"), "{err:#}");
+    assert!(!marker.exists());
+}
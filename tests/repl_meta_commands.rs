@@ -352,3 +352,70 @@ fn repl_debug() {
                 .and(norm_contains("javascript")),
         );
 }
+
+#[test]
+fn repl_load_failure_keeps_session_alive() {
+    run_repl()
+        .write_stdin(":load /definitely/not/here.py\n:reload\n:! echo still-running\n:exit\n")
+        .assert()
+        .success()
+        .stdout(norm_contains("load failed").and(norm_contains("still-running")));
+}
+
+#[test]
+fn repl_reload_without_load_explains() {
+    run_repl()
+        .write_stdin(":reload\n:exit\n")
+        .assert()
+        .success()
+        .stdout(norm_contains("nothing loaded yet"));
+}
+
+#[test]
+fn repl_reload_runs_last_loaded_file_again() {
+    if std::process::Command::new("python3")
+        .arg("--version")
+        .output()
+        .is_err()
+    {
+        eprintln!("skipping :reload test: python3 not available");
+        return;
+    }
+    let mut script = tempfile::Builder::new()
+        .suffix(".py")
+        .tempfile()
+        .expect("temp script");
+    std::io::Write::write_all(
+        &mut script,
+        b"counter = globals().get('counter', 0) + 1\nprint('loaded', counter)\n",
+    )
+    .expect("write script");
+    let path = script.path().display().to_string();
+
+    run_repl()
+        .args(["--no-detect", "--lang", "python"])
+        .write_stdin(format!(":load {path}\n:reload\n:exit\n"))
+        .assert()
+        .success()
+        .stdout(norm_contains("loaded 1").and(norm_contains("loaded 2")));
+}
+
+#[test]
+fn repl_reset_forgets_definitions() {
+    if std::process::Command::new("python3")
+        .arg("--version")
+        .output()
+        .is_err()
+    {
+        eprintln!("skipping :reset test: python3 not available");
+        return;
+    }
+
+    run_repl()
+        .args(["--no-detect", "--lang", "python"])
+        .write_stdin("x = 41\n:reset\nprint(x)\nprint('after')\n:exit\n")
+        .assert()
+        .success()
+        .stdout(norm_contains("session for 'python' reset").and(norm_contains("after")))
+        .stderr(norm_contains("NameError"));
+}
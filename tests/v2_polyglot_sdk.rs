@@ -0,0 +1,48 @@
+#[cfg(feature = "v2")]
+mod tests {
+    use std::path::{Path, PathBuf};
+
+    use run::v2::runtime::{CapabilitySet, ComponentValue, RuntimeConfig, RuntimeEngine};
+
+    fn go_sdk_dir() -> PathBuf {
+        Path::new(env!("CARGO_MANIFEST_DIR")).join("examples/v2/polyglot-sdk/go")
+    }
+
+    /// The Go greeter component, if it has been built with `make build` in
+    /// the example directory. Tests only load it; building needs TinyGo and
+    /// the network, and writes generated bindings into the source tree.
+    fn go_greeter_wasm() -> Option<PathBuf> {
+        let wasm = go_sdk_dir().join("greeter.wasm");
+        wasm.exists().then_some(wasm)
+    }
+
+    #[test]
+    fn test_go_greeter_component_round_trip() {
+        let Some(wasm) = go_greeter_wasm() else {
+            eprintln!(
+                "skipping Go greeter test: build greeter.wasm with `make build` in {}",
+                go_sdk_dir().display()
+            );
+            return;
+        };
+
+        let mut engine = RuntimeEngine::new(RuntimeConfig::development()).expect("runtime engine");
+        let component_id = engine.load_component(&wasm).expect("load greeter.wasm");
+        let handle = engine
+            .instantiate(&component_id, CapabilitySet::cli_default())
+            .expect("instantiate greeter");
+        let result = engine
+            .call(
+                &handle,
+                "greet",
+                vec![ComponentValue::String("world".to_string())],
+            )
+            .expect("call greet");
+
+        assert_eq!(result.exit_code, 0);
+        assert_eq!(
+            result.return_value.as_ref().and_then(|v| v.as_string()),
+            Some("hello world from go")
+        );
+    }
+}